@@ -0,0 +1,422 @@
+package learn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+)
+
+// ErrMerkleIndex is returned for a Merkle tree node or bucket that doesn't
+// exist.
+var ErrMerkleIndex = errors.New("Merkle tree index out of range")
+
+// MerkleDepth is the depth of a MerkleTree. Its leaves are the
+// 1<<MerkleDepth buckets that users are spread across by a hash of their id.
+const MerkleDepth = 10
+
+// Repairer is implemented by stores that can be repaired by anti-entropy.
+type Repairer interface {
+	Publisher
+
+	// MatchUsers returns the live and the soft deleted users whose ids
+	// match.
+	MatchUsers(match func(id string) bool) (live, deleted []*User)
+
+	// RepairUsers makes the users whose ids match exactly the given live and
+	// soft deleted users, and returns how many users it changed. Each change
+	// is published with Repair set.
+	RepairUsers(match func(id string) bool, live, deleted []*User) int
+}
+
+func (s *basicService) MatchUsers(match func(id string) bool) (live, deleted []*User) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	for id, user := range s.Users {
+		if match(id) {
+			live = append(live, user)
+		}
+	}
+	for id, user := range s.deleted {
+		if match(id) {
+			deleted = append(deleted, user)
+		}
+	}
+
+	return live, deleted
+}
+
+func (s *basicService) RepairUsers(match func(id string) bool, live, deleted []*User) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.repair(match, live, deleted)
+}
+
+// repair is RepairUsers. It must be called with the mutex held.
+func (s *basicService) repair(match func(id string) bool, live, deleted []*User) int {
+	want := make(map[string]bool, len(live)+len(deleted))
+	n := 0
+	for _, user := range live {
+		want[user.Id] = true
+		if old, ok := s.Users[user.Id]; ok && userDigest(old, false) == userDigest(user, false) {
+			continue
+		}
+		s.Users[user.Id] = user
+		delete(s.deleted, user.Id)
+		s.publish(Change{Type: UserCreated, User: user, Repair: true})
+		n++
+	}
+	for _, user := range deleted {
+		want[user.Id] = true
+		if old, ok := s.deleted[user.Id]; ok && userDigest(old, true) == userDigest(user, true) {
+			continue
+		}
+		delete(s.Users, user.Id)
+		s.deleted[user.Id] = user
+		s.publish(Change{Type: UserExpired, User: user, Repair: true})
+		n++
+	}
+
+	for id, user := range s.Users {
+		if match(id) && !want[id] {
+			delete(s.Users, id)
+			s.publish(Change{Type: UserExpired, User: user, Hard: true, Repair: true})
+			n++
+		}
+	}
+	for id, user := range s.deleted {
+		if match(id) && !want[id] {
+			delete(s.deleted, id)
+			s.publish(Change{Type: UserExpired, User: user, Hard: true, Repair: true})
+			n++
+		}
+	}
+
+	return n
+}
+
+// MerklePeer is a replica whose Merkle tree can be compared with another.
+type MerklePeer interface {
+	// MerkleNodes returns the hashes of the nodes at the given indices of a
+	// level of the tree. Level 0 is the root, and level MerkleDepth the
+	// buckets.
+	MerkleNodes(ctx context.Context, level int, indices []int) ([][]byte, error)
+
+	// MerkleBucket returns the live and the soft deleted users in a bucket.
+	MerkleBucket(ctx context.Context, bucket int) (live, deleted []*User, err error)
+}
+
+// MerkleTree summarizes the users of a store as a hash tree, so that two
+// replicas can find which buckets of users differ between them by exchanging
+// a few hashes. It is kept up to date with the changes published by the
+// store. A bucket hashes to the XOR of the digests of its users, so it can be
+// updated without reading the bucket again.
+type MerkleTree struct {
+	store Repairer
+
+	mtx     sync.Mutex
+	digests map[string][sha256.Size]byte
+	leaves  [][sha256.Size]byte
+
+	// levels caches the hashes of every level of the tree, root first. It is
+	// nil while out of date.
+	levels [][][sha256.Size]byte
+
+	// pending holds the changes made while the tree is being built.
+	pending []Change
+}
+
+// NewMerkleTree returns the tree of the users in s.
+func NewMerkleTree(s Repairer) *MerkleTree {
+	t := &MerkleTree{
+		store:   s,
+		digests: make(map[string][sha256.Size]byte),
+		leaves:  make([][sha256.Size]byte, 1<<MerkleDepth),
+		pending: []Change{},
+	}
+
+	// Changes made while the store is read are held back, and applied once
+	// it has been. Applying a change the read already saw does no harm,
+	// since each change sets the digest of its user rather than toggling it.
+	s.Subscribe(t.update)
+	live, deleted := s.MatchUsers(func(string) bool { return true })
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	for _, user := range live {
+		t.set(user.Id, userDigest(user, false), true)
+	}
+	for _, user := range deleted {
+		t.set(user.Id, userDigest(user, true), true)
+	}
+	for _, c := range t.pending {
+		t.apply(c)
+	}
+	t.pending = nil
+
+	return t
+}
+
+func (t *MerkleTree) update(c Change) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.pending != nil {
+		t.pending = append(t.pending, c)
+		return
+	}
+	t.apply(c)
+}
+
+// apply updates the tree with a change. It must be called with the mutex
+// held.
+func (t *MerkleTree) apply(c Change) {
+	t.set(c.User.Id, userDigest(c.User, c.Type == UserExpired), !c.Hard)
+}
+
+// set replaces the digest of a user, or removes it if ok is false. It must be
+// called with the mutex held.
+func (t *MerkleTree) set(id string, digest [sha256.Size]byte, ok bool) {
+	leaf := &t.leaves[merkleBucket(id)]
+	if old, found := t.digests[id]; found {
+		xorDigest(leaf, old)
+		delete(t.digests, id)
+	}
+	if ok {
+		xorDigest(leaf, digest)
+		t.digests[id] = digest
+	}
+	t.levels = nil
+}
+
+// MerkleNodes implements MerklePeer.
+func (t *MerkleTree) MerkleNodes(_ context.Context, level int, indices []int) ([][]byte, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if level < 0 || level > MerkleDepth {
+		return nil, ErrMerkleIndex
+	}
+	if t.levels == nil {
+		t.levels = merkleLevels(t.leaves)
+	}
+
+	nodes := t.levels[level]
+	hashes := make([][]byte, len(indices))
+	for i, index := range indices {
+		if index < 0 || index >= len(nodes) {
+			return nil, ErrMerkleIndex
+		}
+		hash := nodes[index]
+		hashes[i] = hash[:]
+	}
+
+	return hashes, nil
+}
+
+// MerkleBucket implements MerklePeer.
+func (t *MerkleTree) MerkleBucket(_ context.Context, bucket int) (live, deleted []*User, err error) {
+	if bucket < 0 || bucket >= 1<<MerkleDepth {
+		return nil, nil, ErrMerkleIndex
+	}
+
+	live, deleted = t.store.MatchUsers(inMerkleBucket(bucket))
+	return live, deleted, nil
+}
+
+// repair makes a bucket of the store hold exactly the given users.
+func (t *MerkleTree) repair(bucket int, live, deleted []*User) int {
+	return t.store.RepairUsers(inMerkleBucket(bucket), live, deleted)
+}
+
+// merkleLevels hashes the leaves up to the root, returning every level of
+// the tree, root first.
+func merkleLevels(leaves [][sha256.Size]byte) [][][sha256.Size]byte {
+	levels := make([][][sha256.Size]byte, MerkleDepth+1)
+	levels[MerkleDepth] = append([][sha256.Size]byte(nil), leaves...)
+	for l := MerkleDepth - 1; l >= 0; l-- {
+		below := levels[l+1]
+		level := make([][sha256.Size]byte, len(below)/2)
+		for i := range level {
+			h := sha256.New()
+			h.Write(below[2*i][:])
+			h.Write(below[2*i+1][:])
+			copy(level[i][:], h.Sum(nil))
+		}
+		levels[l] = level
+	}
+
+	return levels
+}
+
+// merkleBucket returns the bucket a user id falls in.
+func merkleBucket(id string) int {
+	sum := sha256.Sum256([]byte(id))
+	return int(binary.BigEndian.Uint16(sum[:2]) >> (16 - MerkleDepth))
+}
+
+func inMerkleBucket(bucket int) func(id string) bool {
+	return func(id string) bool { return merkleBucket(id) == bucket }
+}
+
+// userDigest hashes a user along with whether it is soft deleted. ExpiresAt
+// is hashed at the one second resolution it is replicated with, so that
+// copies of a user hash alike.
+func userDigest(u *User, deleted bool) [sha256.Size]byte {
+	var expires int64
+	if !u.ExpiresAt.IsZero() {
+		expires = u.ExpiresAt.Unix()
+	}
+
+	var buf bytes.Buffer
+	for _, field := range []string{u.Id, u.FirstName, u.LastName, u.Email, u.Username} {
+		binary.Write(&buf, binary.BigEndian, uint32(len(field)))
+		buf.WriteString(field)
+	}
+	binary.Write(&buf, binary.BigEndian, expires)
+	binary.Write(&buf, binary.BigEndian, deleted)
+
+	return sha256.Sum256(buf.Bytes())
+}
+
+func xorDigest(dst *[sha256.Size]byte, d [sha256.Size]byte) {
+	for i := range dst {
+		dst[i] ^= d[i]
+	}
+}
+
+// RepairStats describes a single anti-entropy repair.
+type RepairStats struct {
+	// Buckets is the number of buckets that differed from the peer.
+	Buckets int `json:"buckets"`
+
+	// Users is the number of users that were changed to match the peer.
+	Users int `json:"users"`
+
+	Took time.Duration `json:"took"`
+}
+
+// AntiEntropy periodically repairs a replica by comparing its Merkle tree with
+// that of a peer, taking the users of the peer wherever their buckets differ.
+// The peer is taken to be right, so it should be the replica's upstream.
+type AntiEntropy struct {
+	tree     *MerkleTree
+	peer     MerklePeer
+	interval time.Duration
+	buckets  metrics.Counter
+	users    metrics.Counter
+	duration metrics.TimeHistogram
+	logger   log.Logger
+
+	// mtx serializes repairs.
+	mtx sync.Mutex
+}
+
+// NewAntiEntropy returns an AntiEntropy repairing the store of tree from
+// peer every interval. It counts the buckets that differed in buckets, the
+// users it changed in users, and times each repair with duration.
+func NewAntiEntropy(tree *MerkleTree, peer MerklePeer, interval time.Duration, buckets, users metrics.Counter, duration metrics.TimeHistogram, logger log.Logger) *AntiEntropy {
+	return &AntiEntropy{
+		tree:     tree,
+		peer:     peer,
+		interval: interval,
+		buckets:  buckets,
+		users:    users,
+		duration: duration,
+		logger:   logger,
+	}
+}
+
+// Run repairs every interval until the context is done.
+func (a *AntiEntropy) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Repair(ctx)
+		}
+	}
+}
+
+// Repair compares the tree with the peer and repairs the buckets that
+// differ. It waits for any repair already running to finish first.
+func (a *AntiEntropy) Repair(ctx context.Context) (stats RepairStats, err error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	defer func(begin time.Time) {
+		stats.Took = time.Since(begin)
+		a.duration.Observe(stats.Took)
+		if stats.Buckets > 0 || err != nil {
+			a.logger.Log("buckets", stats.Buckets, "users", stats.Users, "error", err, "took", stats.Took)
+		}
+	}(time.Now())
+
+	buckets, err := a.diff(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, bucket := range buckets {
+		live, deleted, err := a.peer.MerkleBucket(ctx, bucket)
+		if err != nil {
+			return stats, err
+		}
+
+		n := a.tree.repair(bucket, live, deleted)
+		stats.Buckets++
+		stats.Users += n
+		a.buckets.Add(1)
+		a.users.Add(uint64(n))
+	}
+
+	return stats, nil
+}
+
+// diff descends the tree from the root, following only the nodes that
+// differ from the peer, and returns the buckets that differ.
+func (a *AntiEntropy) diff(ctx context.Context) ([]int, error) {
+	indices := []int{0}
+	for level := 0; ; level++ {
+		local, err := a.tree.MerkleNodes(ctx, level, indices)
+		if err != nil {
+			return nil, err
+		}
+		remote, err := a.peer.MerkleNodes(ctx, level, indices)
+		if err != nil {
+			return nil, err
+		}
+		if len(remote) != len(local) {
+			return nil, ErrMerkleIndex
+		}
+
+		var differ []int
+		for i, index := range indices {
+			if !bytes.Equal(local[i], remote[i]) {
+				differ = append(differ, index)
+			}
+		}
+		if level == MerkleDepth || len(differ) == 0 {
+			return differ, nil
+		}
+
+		indices = indices[:0]
+		for _, index := range differ {
+			indices = append(indices, 2*index, 2*index+1)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package learn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+)
+
+// merkleRoot returns the root hash of a tree.
+func merkleRoot(t *testing.T, tree *MerkleTree) []byte {
+	hashes, err := tree.MerkleNodes(context.Background(), 0, []int{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hashes[0]
+}
+
+func TestAntiEntropy(t *testing.T) {
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	a := NewBasicService().(*basicService)
+	b := NewBasicService().(*basicService)
+	for i := 0; i < 500; i++ {
+		a.CreateUser(ctx, &User{Id: fmt.Sprint("u", i), ExpiresAt: expires})
+		if i%50 != 0 {
+			b.CreateUser(ctx, &User{Id: fmt.Sprint("u", i), ExpiresAt: expires})
+		}
+	}
+
+	// The trees scan the users already stored, and follow later changes.
+	ta := NewMerkleTree(a)
+	tb := NewMerkleTree(b)
+	b.CreateUser(ctx, &User{Id: "extra"})
+	b.CreateUser(ctx, &User{Id: "u7", FirstName: "changed"})
+	a.ExpireUsers(ctx, expires.Add(time.Hour), SoftDelete)
+	a.CreateUser(ctx, &User{Id: "fresh"})
+
+	b.Subscribe(func(c Change) {
+		if !c.Repair || c.Seq != 0 {
+			t.Errorf("repair published as %+v", c)
+		}
+	})
+	buckets, users := &testCounter{}, &testCounter{}
+	ae := NewAntiEntropy(tb, ta, time.Hour, buckets, users, testHistogram{}, log.NewNopLogger())
+	stats, err := ae.Repair(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Buckets == 0 || uint64(stats.Users) != users.Value() {
+		t.Errorf("stats = %+v, %d users counted", stats, users.Value())
+	}
+
+	if !bytes.Equal(merkleRoot(t, ta), merkleRoot(t, tb)) {
+		t.Error("roots differ after a repair")
+	}
+	if !bytes.Equal(merkleRoot(t, NewMerkleTree(b)), merkleRoot(t, tb)) {
+		t.Error("the updated tree differs from one built from scratch")
+	}
+	if len(b.Users) != 1 || len(b.deleted) != 500 {
+		t.Errorf("repaired store has %d users and %d soft deleted, want 1 and 500", len(b.Users), len(b.deleted))
+	}
+
+	// Nothing is left to repair. Only admins may ask for a repair over
+	// HTTP.
+	sa, _ := NewServiceAccounts("")
+	sa.CreateAccount("root", []string{AdminRole})
+	sa.CreateAccount("batch", nil)
+	admin, _, _ := sa.IssueKey("root", 0)
+	batch, _, _ := sa.IssueKey("batch", 0)
+	srv := httptest.NewServer(MakeAntiEntropyHTTPHandler(ctx, ae, sa, log.NewNopLogger()))
+	defer srv.Close()
+	for _, tc := range []struct {
+		key  string
+		code int
+	}{
+		{"", http.StatusUnauthorized},
+		{batch, http.StatusForbidden},
+		{admin, http.StatusOK},
+	} {
+		req, _ := http.NewRequest("POST", srv.URL+"/antientropy/repair", nil)
+		if tc.key != "" {
+			req.Header.Set(APIKeyHeader, tc.key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stats RepairStats
+		json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		if resp.StatusCode != tc.code || stats.Buckets != 0 {
+			t.Errorf("repair with key %q = %d, %+v, want %d", tc.key, resp.StatusCode, stats, tc.code)
+		}
+	}
+}
+
+func TestMerkleIndex(t *testing.T) {
+	tree := NewMerkleTree(NewBasicService().(*basicService))
+	if _, err := tree.MerkleNodes(context.Background(), MerkleDepth+1, []int{0}); err != ErrMerkleIndex {
+		t.Errorf("MerkleNodes below the buckets = %v, want %v", err, ErrMerkleIndex)
+	}
+	if _, _, err := tree.MerkleBucket(context.Background(), -1); err != ErrMerkleIndex {
+		t.Errorf("MerkleBucket(-1) = %v, want %v", err, ErrMerkleIndex)
+	}
+}
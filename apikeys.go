@@ -0,0 +1,482 @@
+package learn
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/go-kit/kit/auth/jwt"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+var (
+	// ErrAPIKeyInvalid is returned for an API key that was never issued, or
+	// doesn't match the one that was.
+	ErrAPIKeyInvalid = errors.New("invalid API key")
+
+	// ErrAPIKeyExpired is returned for an API key past its expiry.
+	ErrAPIKeyExpired = errors.New("API key is expired")
+
+	// ErrAPIKeyRevoked is returned for an API key that was revoked.
+	ErrAPIKeyRevoked = errors.New("API key was revoked")
+
+	// ErrServiceAccountExists is returned when creating a service account
+	// with the ID of another one.
+	ErrServiceAccountExists = errors.New("Service account already exists")
+
+	// ErrServiceAccountNotFound is returned for a service account that
+	// doesn't exist.
+	ErrServiceAccountNotFound = errors.New("Service account not found")
+
+	// ErrAPIKeyNotFound is returned when managing an API key that doesn't
+	// exist.
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+const (
+	// APIKeyPrefix starts every API key, so that keys are told apart from
+	// tokens, and leaked ones are easy to scan for.
+	APIKeyPrefix = "lrn_"
+
+	// APIKeyHeader is the HTTP header carrying an API key, as an
+	// alternative to an Authorization header with the ApiKey scheme.
+	APIKeyHeader = "X-Api-Key"
+
+	// apiKeyMetadata is the gRPC metadata key carrying an API key.
+	apiKeyMetadata = "x-api-key"
+
+	// lastUsedResolution is how stale the last use of a key may be, so that
+	// only the first use of a key in a while is written out.
+	lastUsedResolution = time.Minute
+)
+
+// ServiceAccount is a principal for a machine, such as a batch job, which
+// authenticates with API keys rather than tokens.
+type ServiceAccount struct {
+	ID      string    `json:"id"`
+	Roles   []string  `json:"roles,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// APIKey describes a key issued to a service account. Only a hash of the
+// key is kept, so a key can't be shown again once it was issued.
+type APIKey struct {
+	ID       string    `json:"id"`
+	Account  string    `json:"account"`
+	Hash     []byte    `json:"hash,omitempty"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires,omitempty"`
+	Revoked  time.Time `json:"revoked,omitempty"`
+	LastUsed time.Time `json:"last_used,omitempty"`
+}
+
+// ServiceAccounts keeps service accounts and their API keys, and
+// authenticates calls made with the keys. If it has a file, every change is
+// written to it, including the last use of a key to within a minute.
+type ServiceAccounts struct {
+	path string
+
+	mtx      sync.Mutex
+	accounts map[string]*ServiceAccount
+	keys     map[string]*APIKey
+}
+
+type serviceAccountsFile struct {
+	Accounts []*ServiceAccount `json:"accounts"`
+	Keys     []*APIKey         `json:"keys"`
+}
+
+// NewServiceAccounts returns ServiceAccounts kept in the file at path, which
+// is created on the first change if it doesn't exist. With an empty path
+// they are only kept in memory.
+func NewServiceAccounts(path string) (*ServiceAccounts, error) {
+	sa := &ServiceAccounts{
+		path:     path,
+		accounts: map[string]*ServiceAccount{},
+		keys:     map[string]*APIKey{},
+	}
+	if path == "" {
+		return sa, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sa, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f serviceAccountsFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	for _, a := range f.Accounts {
+		sa.accounts[a.ID] = a
+	}
+	for _, k := range f.Keys {
+		sa.keys[k.ID] = k
+	}
+	return sa, nil
+}
+
+// save writes the accounts and keys to the file, replacing it at once so
+// that a crash can't leave it half written. sa.mtx must be held.
+func (sa *ServiceAccounts) save() error {
+	if sa.path == "" {
+		return nil
+	}
+
+	var f serviceAccountsFile
+	for _, a := range sa.accounts {
+		f.Accounts = append(f.Accounts, a)
+	}
+	for _, k := range sa.keys {
+		f.Keys = append(f.Keys, k)
+	}
+	sort.Sort(accountsByID(f.Accounts))
+	sort.Sort(keysByID(f.Keys))
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(sa.path), filepath.Base(sa.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), sa.path)
+}
+
+// CreateAccount creates a service account with roles.
+func (sa *ServiceAccounts) CreateAccount(id string, roles []string) (ServiceAccount, error) {
+	if id == "" {
+		return ServiceAccount{}, ErrMissingID
+	}
+
+	sa.mtx.Lock()
+	defer sa.mtx.Unlock()
+	if _, ok := sa.accounts[id]; ok {
+		return ServiceAccount{}, ErrServiceAccountExists
+	}
+	a := &ServiceAccount{ID: id, Roles: roles, Created: time.Now().UTC()}
+	sa.accounts[id] = a
+	if err := sa.save(); err != nil {
+		delete(sa.accounts, id)
+		return ServiceAccount{}, err
+	}
+	return *a, nil
+}
+
+// DeleteAccount deletes a service account and its keys.
+func (sa *ServiceAccounts) DeleteAccount(id string) error {
+	sa.mtx.Lock()
+	defer sa.mtx.Unlock()
+	if _, ok := sa.accounts[id]; !ok {
+		return ErrServiceAccountNotFound
+	}
+	delete(sa.accounts, id)
+	for kid, k := range sa.keys {
+		if k.Account == id {
+			delete(sa.keys, kid)
+		}
+	}
+	return sa.save()
+}
+
+// Accounts returns the service accounts, ordered by ID.
+func (sa *ServiceAccounts) Accounts() []ServiceAccount {
+	sa.mtx.Lock()
+	defer sa.mtx.Unlock()
+	accounts := make([]*ServiceAccount, 0, len(sa.accounts))
+	for _, a := range sa.accounts {
+		accounts = append(accounts, a)
+	}
+	sort.Sort(accountsByID(accounts))
+
+	list := make([]ServiceAccount, len(accounts))
+	for i, a := range accounts {
+		list[i] = *a
+	}
+	return list
+}
+
+// IssueKey issues a new API key to a service account, which expires after
+// ttl, or never if ttl is 0. The key is returned only this once.
+func (sa *ServiceAccounts) IssueKey(account string, ttl time.Duration) (string, APIKey, error) {
+	sa.mtx.Lock()
+	defer sa.mtx.Unlock()
+	if _, ok := sa.accounts[account]; !ok {
+		return "", APIKey{}, ErrServiceAccountNotFound
+	}
+	return sa.issueKey(account, ttl)
+}
+
+// issueKey issues a key of the form lrn_<id>_<secret>. The ID finds the key
+// without a search, and the secret is random enough that a plain SHA-256
+// hash protects it. sa.mtx must be held.
+func (sa *ServiceAccounts) issueKey(account string, ttl time.Duration) (string, APIKey, error) {
+	id := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", APIKey{}, err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIKey{}, err
+	}
+	s := base64.RawURLEncoding.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(s))
+
+	k := &APIKey{
+		ID:      hex.EncodeToString(id),
+		Account: account,
+		Hash:    hash[:],
+		Created: time.Now().UTC(),
+	}
+	if ttl > 0 {
+		k.Expires = k.Created.Add(ttl)
+	}
+	sa.keys[k.ID] = k
+	if err := sa.save(); err != nil {
+		delete(sa.keys, k.ID)
+		return "", APIKey{}, err
+	}
+	return APIKeyPrefix + k.ID + "_" + s, k.public(), nil
+}
+
+// RotateKey issues a new key to the account of the key with id, expiring
+// after ttl like IssueKey, and lets the old key expire after overlap, so
+// that its users have time to switch over. With no overlap, the old key is
+// revoked.
+func (sa *ServiceAccounts) RotateKey(id string, ttl, overlap time.Duration) (string, APIKey, error) {
+	sa.mtx.Lock()
+	defer sa.mtx.Unlock()
+	old, ok := sa.keys[id]
+	if !ok {
+		return "", APIKey{}, ErrAPIKeyNotFound
+	}
+	prev := *old
+
+	now := time.Now().UTC()
+	if overlap <= 0 {
+		old.Revoked = now
+	} else if end := now.Add(overlap); old.Expires.IsZero() || end.Before(old.Expires) {
+		old.Expires = end
+	}
+	key, k, err := sa.issueKey(old.Account, ttl)
+	if err != nil {
+		*old = prev
+		return "", APIKey{}, err
+	}
+	return key, k, nil
+}
+
+// RevokeKey revokes the key with id at once.
+func (sa *ServiceAccounts) RevokeKey(id string) error {
+	sa.mtx.Lock()
+	defer sa.mtx.Unlock()
+	k, ok := sa.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	if k.Revoked.IsZero() {
+		k.Revoked = time.Now().UTC()
+	}
+	return sa.save()
+}
+
+// Keys returns the keys of a service account, without their hashes, ordered
+// by when they were issued.
+func (sa *ServiceAccounts) Keys(account string) ([]APIKey, error) {
+	sa.mtx.Lock()
+	defer sa.mtx.Unlock()
+	if _, ok := sa.accounts[account]; !ok {
+		return nil, ErrServiceAccountNotFound
+	}
+	var keys []*APIKey
+	for _, k := range sa.keys {
+		if k.Account == account {
+			keys = append(keys, k)
+		}
+	}
+	sort.Sort(keysByCreated(keys))
+
+	list := make([]APIKey, len(keys))
+	for i, k := range keys {
+		list[i] = k.public()
+	}
+	return list, nil
+}
+
+type accountsByID []*ServiceAccount
+
+func (a accountsByID) Len() int           { return len(a) }
+func (a accountsByID) Less(i, j int) bool { return a[i].ID < a[j].ID }
+func (a accountsByID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type keysByID []*APIKey
+
+func (k keysByID) Len() int           { return len(k) }
+func (k keysByID) Less(i, j int) bool { return k[i].ID < k[j].ID }
+func (k keysByID) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+
+type keysByCreated []*APIKey
+
+func (k keysByCreated) Len() int           { return len(k) }
+func (k keysByCreated) Less(i, j int) bool { return k[i].Created.Before(k[j].Created) }
+func (k keysByCreated) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+
+// public returns k without its hash.
+func (k *APIKey) public() APIKey {
+	p := *k
+	p.Hash = nil
+	return p
+}
+
+// Resolve returns the principal of the service account that key was issued
+// to, if the key is valid, and records its use.
+func (sa *ServiceAccounts) Resolve(key string) (*Principal, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, ErrAPIKeyInvalid
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, APIKeyPrefix), "_", 2)
+	if len(parts) != 2 {
+		return nil, ErrAPIKeyInvalid
+	}
+	hash := sha256.Sum256([]byte(parts[1]))
+
+	sa.mtx.Lock()
+	defer sa.mtx.Unlock()
+	k, ok := sa.keys[parts[0]]
+	if !ok || subtle.ConstantTimeCompare(hash[:], k.Hash) != 1 {
+		return nil, ErrAPIKeyInvalid
+	}
+	a, ok := sa.accounts[k.Account]
+	if !ok {
+		return nil, ErrAPIKeyInvalid
+	}
+	now := time.Now().UTC()
+	if !k.Revoked.IsZero() {
+		return nil, ErrAPIKeyRevoked
+	}
+	if !k.Expires.IsZero() && now.After(k.Expires) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	if now.Sub(k.LastUsed) >= lastUsedResolution {
+		k.LastUsed = now
+		// The key was valid either way, so failing to record its use
+		// doesn't fail the call; the next use tries again.
+		if err := sa.save(); err != nil {
+			k.LastUsed = time.Time{}
+		}
+	}
+
+	roles := make([]string, len(a.Roles))
+	copy(roles, a.Roles)
+	return &Principal{ID: a.ID, Kind: ServicePrincipal, Roles: roles}, nil
+}
+
+// AuthenticateContext resolves the API key in ctx, which APIKeyToHTTPContext
+// or APIKeyToGRPCContext put there, or which was sent as a bearer token.
+func (sa *ServiceAccounts) AuthenticateContext(ctx context.Context) (context.Context, error) {
+	key, ok := APIKeyFromContext(ctx)
+	if !ok {
+		return nil, ErrAPIKeyInvalid
+	}
+	p, err := sa.Resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	return WithPrincipal(ctx, p), nil
+}
+
+// APIKeysOr returns an Authenticator that authenticates the calls carrying
+// an API key with sa, and any others with next, so that people can go on
+// using tokens while machines use keys.
+func APIKeysOr(sa *ServiceAccounts, next Authenticator) Authenticator {
+	return apiKeysOr{sa, next}
+}
+
+type apiKeysOr struct {
+	keys *ServiceAccounts
+	next Authenticator
+}
+
+func (a apiKeysOr) AuthenticateContext(ctx context.Context) (context.Context, error) {
+	if _, ok := APIKeyFromContext(ctx); ok {
+		return a.keys.AuthenticateContext(ctx)
+	}
+	if a.next == nil {
+		return nil, jwt.ErrTokenContextMissing
+	}
+	return a.next.AuthenticateContext(ctx)
+}
+
+type apiKeyContextKey struct{}
+
+// APIKeyFromContext returns the API key of a call. A bearer token with the
+// prefix of API keys is one too.
+func APIKeyFromContext(ctx context.Context) (string, bool) {
+	if key, ok := ctx.Value(apiKeyContextKey{}).(string); ok && key != "" {
+		return key, true
+	}
+	if token, ok := ctx.Value(jwt.JWTTokenContextKey).(string); ok && strings.HasPrefix(token, APIKeyPrefix) {
+		return token, true
+	}
+	return "", false
+}
+
+// APIKeyToHTTPContext moves the API key of a request, from its X-Api-Key
+// header or an Authorization header with the ApiKey scheme, to the context.
+// Primarily useful in a server.
+func APIKeyToHTTPContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		key := r.Header.Get(APIKeyHeader)
+		if auth := r.Header.Get("Authorization"); key == "" && len(auth) > 7 && strings.EqualFold(auth[:7], "apikey ") {
+			key = auth[7:]
+		}
+		if key == "" {
+			return ctx
+		}
+		return context.WithValue(ctx, apiKeyContextKey{}, key)
+	}
+}
+
+// APIKeyToGRPCContext moves the API key of a request from its x-api-key
+// metadata to the context. Primarily useful in a server.
+func APIKeyToGRPCContext() grpctransport.RequestFunc {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		if keys := (*md)[apiKeyMetadata]; len(keys) > 0 && keys[0] != "" {
+			return context.WithValue(ctx, apiKeyContextKey{}, keys[0])
+		}
+		return ctx
+	}
+}
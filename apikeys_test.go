@@ -0,0 +1,273 @@
+package learn
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/briankassouf/learn/pb"
+	"github.com/go-kit/kit/log"
+)
+
+func TestServiceAccounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serviceaccounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "accounts.json")
+
+	sa, err := NewServiceAccounts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sa.CreateAccount("batch", []string{"importer"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sa.CreateAccount("batch", nil); err != ErrServiceAccountExists {
+		t.Errorf("second batch account = %v, want ErrServiceAccountExists", err)
+	}
+	if _, _, err := sa.IssueKey("nobody", 0); err != ErrServiceAccountNotFound {
+		t.Errorf("key of a missing account = %v, want ErrServiceAccountNotFound", err)
+	}
+
+	key, k, err := sa.IssueKey("batch", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := APIKeyPrefix + k.ID + "_"
+	if !strings.HasPrefix(key, prefix) || k.Hash != nil {
+		t.Fatalf("issued %q as %+v, want a key starting %q and no hash", key, k, prefix)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(key[len(prefix):])) {
+		t.Error("the secret of the key was written to the file")
+	}
+
+	p, err := sa.Resolve(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != "batch" || p.Kind != ServicePrincipal || !p.HasRole("importer") {
+		t.Errorf("principal = %+v, want the importer batch", p)
+	}
+	if _, err := sa.Resolve(key[:len(key)-1] + "x"); err != ErrAPIKeyInvalid {
+		t.Errorf("altered key = %v, want ErrAPIKeyInvalid", err)
+	}
+	if keys, _ := sa.Keys("batch"); len(keys) != 1 || keys[0].LastUsed.IsZero() {
+		t.Errorf("keys = %+v, want one, used", keys)
+	}
+
+	// Accounts and keys are kept in the file.
+	reloaded, err := NewServiceAccounts(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reloaded.Resolve(key); err != nil {
+		t.Errorf("key after a reload = %v", err)
+	}
+
+	// Rotated keys work until the overlap ends, and revoked ones not at all.
+	key2, k2, err := sa.RotateKey(k.ID, 0, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sa.Resolve(key); err != nil {
+		t.Errorf("old key within the overlap = %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := sa.Resolve(key); err != ErrAPIKeyExpired {
+		t.Errorf("old key after the overlap = %v, want ErrAPIKeyExpired", err)
+	}
+	if _, err := sa.Resolve(key2); err != nil {
+		t.Fatal(err)
+	}
+	if err := sa.RevokeKey(k2.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sa.Resolve(key2); err != ErrAPIKeyRevoked {
+		t.Errorf("revoked key = %v, want ErrAPIKeyRevoked", err)
+	}
+	if err := sa.RevokeKey("nope"); err != ErrAPIKeyNotFound {
+		t.Errorf("revoking a missing key = %v, want ErrAPIKeyNotFound", err)
+	}
+}
+
+func TestAPIKeysOr(t *testing.T) {
+	ctx := context.Background()
+	sa, _ := NewServiceAccounts("")
+	sa.CreateAccount("batch", nil)
+	key, _, err := sa.IssueKey("batch", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, k, _ := sa.IssueKey("batch", 0)
+	sa.RevokeKey(k.ID)
+
+	var who atomic.Value
+	svc := NewBasicService()
+	endpoints := Endpoints{
+		CreateUserEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			if p, ok := PrincipalFromContext(ctx); ok {
+				who.Store(string(p.Kind) + ":" + p.ID)
+			}
+			return MakeCreateUserEndpoint(svc)(ctx, request)
+		},
+	}
+	auth := APIKeysOr(sa, NewVerifier(SharedSecret("secret"), "", "", 0))
+
+	// Over HTTP, keys go in X-Api-Key, or the Authorization header with the
+	// ApiKey or Bearer schemes, and tokens still work, though only those of
+	// admins may create users.
+	srv := httptest.NewServer(MakeHTTPHandler(ctx, endpoints, nil, auth, log.NewNopLogger()))
+	defer srv.Close()
+	token, _ := stdjwt.NewWithClaims(stdjwt.SigningMethodHS256, stdjwt.MapClaims{
+		"sub":   "ann",
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"roles": []string{AdminRole},
+	}).SignedString([]byte("secret"))
+	user, _ := stdjwt.NewWithClaims(stdjwt.SigningMethodHS256, stdjwt.MapClaims{
+		"sub": "bob",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}).SignedString([]byte("secret"))
+	for i, tc := range []struct {
+		header, value string
+		code          int
+		who           string
+	}{
+		{APIKeyHeader, key, http.StatusOK, "service:batch"},
+		{"Authorization", "ApiKey " + key, http.StatusOK, "service:batch"},
+		{"Authorization", "Bearer " + key, http.StatusOK, "service:batch"},
+		{APIKeyHeader, revoked, http.StatusUnauthorized, ""},
+		{"Authorization", "Bearer " + token, http.StatusOK, "user:ann"},
+		{"Authorization", "Bearer " + user, http.StatusForbidden, ""},
+	} {
+		who.Store("")
+		req, _ := http.NewRequest("POST", srv.URL+"/create", strings.NewReader(`{"user":{"id":"a"}}`))
+		req.Header.Set(tc.header, tc.value)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.code || who.Load() != tc.who {
+			t.Errorf("%d: %s got %d as %q, want %d as %q", i, tc.header, resp.StatusCode, who.Load(), tc.code, tc.who)
+		}
+	}
+
+	// Over gRPC, keys go in x-api-key metadata.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	pb.RegisterUserServiceServer(s, MakeGRPCServer(ctx, endpoints, nil, nil, nil, nil, auth, log.NewNopLogger()))
+	go s.Serve(ln)
+	defer s.Stop()
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	who.Store("")
+	mctx := metadata.NewContext(ctx, metadata.Pairs(apiKeyMetadata, key))
+	if _, err := pb.NewUserServiceClient(conn).CreateUser(mctx, &pb.CreateRequest{User: &pb.User{Id: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if who.Load() != "service:batch" {
+		t.Errorf("gRPC call made as %q, want service:batch", who.Load())
+	}
+	mctx = metadata.NewContext(ctx, metadata.Pairs("authorization", "Bearer "+user))
+	if _, err := pb.NewUserServiceClient(conn).CreateUser(mctx, &pb.CreateRequest{User: &pb.User{Id: "b"}}); grpc.ErrorDesc(err) != ErrForbidden.Error() {
+		t.Errorf("gRPC create by a user = %v, want %v", err, ErrForbidden)
+	}
+}
+
+func TestServiceAccountsHTTPHandler(t *testing.T) {
+	sa, _ := NewServiceAccounts("")
+	sa.CreateAccount("root", []string{AdminRole})
+	admin, _, _ := sa.IssueKey("root", 0)
+	srv := httptest.NewServer(MakeServiceAccountsHTTPHandler(context.Background(), sa, sa, log.NewNopLogger()))
+	defer srv.Close()
+
+	do := func(method, path, key, body string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	post := func(path, body string) *http.Response {
+		return do("POST", path, admin, body)
+	}
+
+	// Only admins may manage accounts and keys.
+	resp := do("POST", "/serviceaccounts", "", `{"id":"evil","roles":["admin"]}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous create account = %d, want 401", resp.StatusCode)
+	}
+	sa.CreateAccount("batch", nil)
+	batch, _, _ := sa.IssueKey("batch", 0)
+	resp = do("POST", "/serviceaccounts/keys", batch, `{"account":"root"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("issuing a key as a non-admin = %d, want 403", resp.StatusCode)
+	}
+	if keys, _ := sa.Keys("root"); len(keys) != 1 {
+		t.Errorf("root has %d keys after a forbidden issue, want 1", len(keys))
+	}
+
+	resp = post("/serviceaccounts", `{"id":"etl","roles":["reader"]}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("create account = %d, want 200", resp.StatusCode)
+	}
+
+	resp = post("/serviceaccounts/keys", `{"account":"etl","ttl":"24h"}`)
+	var issued IssuedAPIKey
+	json.NewDecoder(resp.Body).Decode(&issued)
+	resp.Body.Close()
+	if issued.Expires.IsZero() {
+		t.Errorf("key issued for 24h = %+v, want an expiry", issued)
+	}
+	if p, err := sa.Resolve(issued.Key); err != nil || !p.HasRole("reader") {
+		t.Errorf("issued key = %v, %v, want the reader etl", p, err)
+	}
+
+	resp = post("/serviceaccounts/keys/revoke", `{"id":"nope"}`)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("revoking a missing key = %d, want 404", resp.StatusCode)
+	}
+
+	resp = do("GET", "/serviceaccounts/keys?account=etl", admin, "")
+	var listed []APIKey
+	json.NewDecoder(resp.Body).Decode(&listed)
+	resp.Body.Close()
+	if len(listed) != 1 || listed[0].Hash != nil {
+		t.Errorf("keys = %+v, want one without its hash", listed)
+	}
+}
@@ -0,0 +1,916 @@
+package learn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+)
+
+// Keys finds the key a token is verified with by the key id in its header,
+// which is empty if it has none.
+type Keys interface {
+	Key(kid string) (interface{}, error)
+}
+
+// SharedSecret is a single HS256 secret, shared by the servers and the
+// clients signing tokens. It is meant for development, since every client
+// holding it can sign any token.
+type SharedSecret []byte
+
+// Key returns the secret, whatever the key id.
+func (s SharedSecret) Key(string) (interface{}, error) {
+	return []byte(s), nil
+}
+
+// KeySet holds the public keys tokens are verified with, loaded from PEM
+// files or JWKS files. The keys are reloaded whenever a file changes, so
+// that keys can be rotated without a restart: publish the new key alongside
+// the old one, move the signers to it, then remove the old key.
+type KeySet struct {
+	paths  []string
+	logger log.Logger
+	quit   chan struct{}
+
+	mtx    sync.RWMutex
+	keys   map[string]interface{}
+	stamps []fileStamp
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewKeySet returns a KeySet with the keys of the files at paths, checking
+// every interval whether they changed until it is stopped. Files ending in
+// .json are JWKS files, whose keys are found by their kid. Others hold a
+// single PEM encoded public key or certificate, whose key id is the name of
+// the file without its extension. A file that fails to load on a reload is
+// logged, and the keys loaded last are kept.
+func NewKeySet(paths []string, interval time.Duration, logger log.Logger) (*KeySet, error) {
+	ks := &KeySet{
+		paths:  paths,
+		logger: logger,
+		quit:   make(chan struct{}),
+	}
+	stamps, err := ks.stat()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := loadKeys(paths)
+	if err != nil {
+		return nil, err
+	}
+	ks.keys, ks.stamps = keys, stamps
+
+	go ks.loop(interval)
+	return ks, nil
+}
+
+func (ks *KeySet) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ks.reload(); err != nil {
+				ks.logger.Log("err", err)
+			}
+		case <-ks.quit:
+			return
+		}
+	}
+}
+
+// reload loads the keys again if any of the files changed.
+func (ks *KeySet) reload() error {
+	stamps, err := ks.stat()
+	if err != nil {
+		return err
+	}
+
+	ks.mtx.RLock()
+	changed := false
+	for i, s := range stamps {
+		if !s.modTime.Equal(ks.stamps[i].modTime) || s.size != ks.stamps[i].size {
+			changed = true
+		}
+	}
+	ks.mtx.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	keys, err := loadKeys(ks.paths)
+	if err != nil {
+		return err
+	}
+
+	ks.mtx.Lock()
+	ks.keys, ks.stamps = keys, stamps
+	ks.mtx.Unlock()
+	ks.logger.Log("msg", "reloaded keys", "keys", len(keys))
+	return nil
+}
+
+func (ks *KeySet) stat() ([]fileStamp, error) {
+	stamps := make([]fileStamp, len(ks.paths))
+	for i, path := range ks.paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		stamps[i] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+	}
+	return stamps, nil
+}
+
+// Key returns the key with the key id kid. Tokens without a key id can only
+// be verified by a KeySet holding a single key.
+func (ks *KeySet) Key(kid string) (interface{}, error) {
+	ks.mtx.RLock()
+	defer ks.mtx.RUnlock()
+
+	if kid == "" && len(ks.keys) == 1 {
+		for _, key := range ks.keys {
+			return key, nil
+		}
+	}
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, jwt.ErrTokenInvalid
+	}
+	return key, nil
+}
+
+// Stop stops checking the files for changes. The keys loaded last are kept.
+func (ks *KeySet) Stop() {
+	close(ks.quit)
+}
+
+func loadKeys(paths []string) (map[string]interface{}, error) {
+	keys := make(map[string]interface{})
+	add := func(kid string, key interface{}, path string) error {
+		if _, ok := keys[kid]; ok {
+			return fmt.Errorf("%s: key id %q is used more than once", path, kid)
+		}
+		keys[kid] = key
+		return nil
+	}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if filepath.Ext(path) == ".json" {
+			set, err := parseJWKS(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			for kid, key := range set {
+				if err := add(kid, key, path); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		key, err := parsePEMKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		kid := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if err := add(kid, key, path); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
+
+// parsePEMKey returns the public key of the first PEM block of data holding
+// one.
+func parsePEMKey(data []byte) (interface{}, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no public key found")
+		}
+
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			return checkKey(key)
+		case "RSA PUBLIC KEY":
+			return x509.ParsePKCS1PublicKey(block.Bytes)
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			return checkKey(cert.PublicKey)
+		}
+	}
+}
+
+// checkKey returns key if tokens can be verified with it.
+func checkKey(key interface{}) (interface{}, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	case *ecdsa.PublicKey:
+		if k.Curve == elliptic.P256() {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+// jsonWebKey is a key of a JWKS file, as described by RFC 7517.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// parseJWKS returns the signing keys of a JWKS file by their kid. Keys of
+// types that can't verify tokens are skipped.
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key interface{}
+		switch {
+		case k.Kty == "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, err
+			}
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, err
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, err
+			}
+			if !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("key %q isn't on its curve", k.Kid)
+			}
+			key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		case k.Kty == "OKP" && k.Crv == "Ed25519":
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil {
+				return nil, err
+			}
+			if len(x) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("key %q has a bad length", k.Kid)
+			}
+			key = ed25519.PublicKey(x)
+		default:
+			continue
+		}
+
+		if _, ok := keys[k.Kid]; ok {
+			return nil, fmt.Errorf("key id %q is used more than once", k.Kid)
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// SigningMethodEdDSA signs and verifies tokens with Ed25519 keys, which the
+// jwt-go package has no signing method for. It is registered with jwt-go
+// under its alg, "EdDSA".
+var SigningMethodEdDSA stdjwt.SigningMethod = signingMethodEdDSA{}
+
+func init() {
+	stdjwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() stdjwt.SigningMethod { return SigningMethodEdDSA })
+}
+
+type signingMethodEdDSA struct{}
+
+func (signingMethodEdDSA) Alg() string { return "EdDSA" }
+
+func (signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return stdjwt.ErrInvalidKeyType
+	}
+	sig, err := stdjwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return stdjwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", stdjwt.ErrInvalidKeyType
+	}
+	return stdjwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
+
+// Verifier verifies the tokens of calls. A token must be signed with one of
+// its keys, using the algorithm for the type of the key: RS256 for RSA keys,
+// ES256 for P-256 keys, EdDSA for Ed25519 keys and HS256 for a
+// SharedSecret. It must carry an expiry, and be neither expired nor not yet
+// valid.
+type Verifier struct {
+	keys     Keys
+	issuer   string
+	audience string
+	skew     time.Duration
+	denylist *Denylist
+	rejected []string
+	now      func() time.Time
+}
+
+// NewVerifier returns a Verifier of the tokens signed with keys. If issuer
+// isn't empty, tokens must have been issued by it, and if audience isn't
+// empty, they must be meant for it. Times are checked allowing for clocks
+// that are up to skew apart.
+func NewVerifier(keys Keys, issuer, audience string, skew time.Duration) *Verifier {
+	return &Verifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		skew:     skew,
+		now:      time.Now,
+	}
+}
+
+// Verify returns the claims of token if it is valid. Errors are those of the
+// go-kit jwt package, so transports can tell them apart.
+func (v *Verifier) Verify(token string) (stdjwt.MapClaims, error) {
+	parser := &stdjwt.Parser{SkipClaimsValidation: true}
+	t, err := parser.Parse(token, v.key)
+	if err != nil {
+		if e, ok := err.(*stdjwt.ValidationError); ok {
+			switch {
+			case e.Inner == jwt.ErrUnexpectedSigningMethod:
+				return nil, jwt.ErrUnexpectedSigningMethod
+			case e.Errors&stdjwt.ValidationErrorMalformed != 0:
+				return nil, jwt.ErrTokenMalformed
+			}
+		}
+		return nil, jwt.ErrTokenInvalid
+	}
+
+	claims := t.Claims.(stdjwt.MapClaims)
+	now := v.now()
+	exp, ok := claimTime(claims, "exp")
+	if !ok {
+		return nil, jwt.ErrTokenInvalid
+	}
+	if now.After(exp.Add(v.skew)) {
+		return nil, jwt.ErrTokenExpired
+	}
+	if nbf, ok := claimTime(claims, "nbf"); ok && now.Add(v.skew).Before(nbf) {
+		return nil, jwt.ErrTokenNotActive
+	}
+	if iat, ok := claimTime(claims, "iat"); ok && now.Add(v.skew).Before(iat) {
+		return nil, jwt.ErrTokenNotActive
+	}
+
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return nil, jwt.ErrTokenInvalid
+	}
+	if v.audience != "" && !hasAudience(claims, v.audience) {
+		return nil, jwt.ErrTokenInvalid
+	}
+	for _, issuer := range v.rejected {
+		if claims.VerifyIssuer(issuer, true) {
+			return nil, jwt.ErrTokenInvalid
+		}
+	}
+	if v.denylist != nil {
+		jti, _ := claims["jti"].(string)
+		sid, _ := claims["sid"].(string)
+		if v.denylist.Denied(jti) || v.denylist.Denied(sid) {
+			return nil, ErrTokenRevoked
+		}
+	}
+
+	return claims, nil
+}
+
+// UseDenylist makes v reject the tokens whose jti or sid claim d denies, so
+// that tokens can be revoked before they expire.
+func (v *Verifier) UseDenylist(d *Denylist) {
+	v.denylist = d
+}
+
+// RejectIssuer makes v reject the tokens issued by issuer, such as those of
+// an OIDCProvider signing with the same key, which are meant for other apps.
+func (v *Verifier) RejectIssuer(issuer string) {
+	v.rejected = append(v.rejected, issuer)
+}
+
+// key returns the key of a token, checking that the token was signed with
+// the algorithm of the key, so that a public key can't be passed off as an
+// HS256 secret.
+func (v *Verifier) key(t *stdjwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, err := v.keys.Key(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	var method stdjwt.SigningMethod
+	switch key.(type) {
+	case *rsa.PublicKey:
+		method = stdjwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		method = stdjwt.SigningMethodES256
+	case ed25519.PublicKey:
+		method = SigningMethodEdDSA
+	case []byte:
+		method = stdjwt.SigningMethodHS256
+	}
+	if method == nil || t.Method.Alg() != method.Alg() {
+		return nil, jwt.ErrUnexpectedSigningMethod
+	}
+	return key, nil
+}
+
+// ErrTokenRevoked is returned for a token that was revoked before it
+// expired, such as one of a session that was ended.
+var ErrTokenRevoked = errors.New("token was revoked")
+
+// Denylist holds the IDs of revoked tokens, and of the sessions they were
+// issued to, until the tokens would have expired anyway.
+type Denylist struct {
+	mtx sync.Mutex
+	ids map[string]time.Time
+}
+
+// NewDenylist returns an empty Denylist.
+func NewDenylist() *Denylist {
+	return &Denylist{ids: map[string]time.Time{}}
+}
+
+// Deny denies id until the given time.
+func (d *Denylist) Deny(id string, until time.Time) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	now := time.Now()
+	for other, t := range d.ids {
+		if now.After(t) {
+			delete(d.ids, other)
+		}
+	}
+	if t, ok := d.ids[id]; !ok || until.After(t) {
+		d.ids[id] = until
+	}
+}
+
+// Denied reports whether id is denied. An empty id never is.
+func (d *Denylist) Denied(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	t, ok := d.ids[id]
+	return ok && !time.Now().After(t)
+}
+
+// Issuer issues access tokens, signed with a private key or a shared secret
+// that Verifiers check them with.
+type Issuer struct {
+	method   stdjwt.SigningMethod
+	kid      string
+	key      interface{}
+	issuer   string
+	audience string
+}
+
+// NewIssuer returns an Issuer of tokens signed with key, using the algorithm
+// the Verifier expects for it: RS256 for an *rsa.PrivateKey, ES256 for a
+// P-256 *ecdsa.PrivateKey, EdDSA for an ed25519.PrivateKey and HS256 for a
+// SharedSecret. Tokens carry kid in their header, and issuer and audience
+// as their iss and aud claims, where those aren't empty.
+func NewIssuer(key interface{}, kid, issuer, audience string) (*Issuer, error) {
+	var method stdjwt.SigningMethod
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		method = stdjwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("only P-256 EC keys are supported")
+		}
+		method = stdjwt.SigningMethodES256
+	case ed25519.PrivateKey:
+		method = SigningMethodEdDSA
+	case SharedSecret:
+		method, key = stdjwt.SigningMethodHS256, []byte(k)
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+
+	return &Issuer{
+		method:   method,
+		kid:      kid,
+		key:      key,
+		issuer:   issuer,
+		audience: audience,
+	}, nil
+}
+
+// LoadIssuer returns an Issuer of tokens signed with the PEM private key in
+// path, whose key id is the base name of the file without its extension,
+// as it is for the public keys of a KeySet.
+func LoadIssuer(path, issuer, audience string) (*Issuer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePEMPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	kid := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return NewIssuer(key, kid, issuer, audience)
+}
+
+func parsePEMPrivateKey(data []byte) (interface{}, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+// Issue returns a token for subject which expires after lifetime, along
+// with its expiry. Every token gets a unique jti claim, so that it can be
+// denied, and carries claims besides.
+func (i *Issuer) Issue(subject string, lifetime time.Duration, claims stdjwt.MapClaims) (string, time.Time, error) {
+	return i.issueAt(time.Now(), subject, lifetime, claims)
+}
+
+// issueAt is Issue with the current time now.
+func (i *Issuer) issueAt(now time.Time, subject string, lifetime time.Duration, claims stdjwt.MapClaims) (string, time.Time, error) {
+	jti, err := randomID()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	exp := now.Add(lifetime)
+
+	all := stdjwt.MapClaims{}
+	for k, v := range claims {
+		all[k] = v
+	}
+	all["sub"] = subject
+	all["jti"] = jti
+	all["iat"] = now.Unix()
+	all["exp"] = exp.Unix()
+	if i.issuer != "" {
+		all["iss"] = i.issuer
+	}
+	if i.audience != "" {
+		all["aud"] = i.audience
+	}
+
+	t := stdjwt.NewWithClaims(i.method, all)
+	if i.kid != "" {
+		t.Header["kid"] = i.kid
+	}
+	token, err := t.SignedString(i.key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, time.Unix(exp.Unix(), 0), nil
+}
+
+// Keys returns keys that also hold the public key of i, under its key id, so
+// that a Verifier with them accepts the tokens of i. An Issuer without a key
+// id, such as one with a SharedSecret, adds nothing.
+func (i *Issuer) Keys(keys Keys) Keys {
+	if i.kid == "" {
+		return keys
+	}
+	return issuerKeys{kid: i.kid, key: i.publicKey(), next: keys}
+}
+
+// publicKey returns the key that the tokens of i are verified with, which is
+// the shared secret itself for HS256.
+func (i *Issuer) publicKey() interface{} {
+	switch k := i.key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	}
+	return i.key
+}
+
+// jsonWebKey returns the public key of i as a JWK, or false if it has a
+// shared secret, which must not be published.
+func (i *Issuer) jsonWebKey() (jsonWebKey, bool) {
+	k := jsonWebKey{Kid: i.kid, Use: "sig", Alg: i.method.Alg()}
+	switch pub := i.publicKey().(type) {
+	case *rsa.PublicKey:
+		k.Kty = "RSA"
+		k.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		k.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		// Coordinates are padded to the size of the curve, as RFC 7518
+		// requires.
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		x, y := make([]byte, size), make([]byte, size)
+		k.Kty, k.Crv = "EC", "P-256"
+		k.X = base64.RawURLEncoding.EncodeToString(append(x[:size-len(pub.X.Bytes())], pub.X.Bytes()...))
+		k.Y = base64.RawURLEncoding.EncodeToString(append(y[:size-len(pub.Y.Bytes())], pub.Y.Bytes()...))
+	case ed25519.PublicKey:
+		k.Kty, k.Crv = "OKP", "Ed25519"
+		k.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return jsonWebKey{}, false
+	}
+	return k, true
+}
+
+type issuerKeys struct {
+	kid  string
+	key  interface{}
+	next Keys
+}
+
+func (k issuerKeys) Key(kid string) (interface{}, error) {
+	if kid == k.kid {
+		return k.key, nil
+	}
+	return k.next.Key(kid)
+}
+
+// randomID returns 16 random bytes in hex.
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// claimTime returns the time of a NumericDate claim, if it is present.
+func claimTime(claims stdjwt.MapClaims, name string) (time.Time, bool) {
+	var sec float64
+	switch v := claims[name].(type) {
+	case float64:
+		sec = v
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		sec = f
+	default:
+		return time.Time{}, false
+	}
+	return time.Unix(int64(sec), 0), true
+}
+
+// hasAudience reports whether the aud claim, a string or a list of them,
+// holds audience.
+func hasAudience(claims stdjwt.MapClaims, audience string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Middleware returns an endpoint middleware that verifies the token of every
+// call, which jwt.ToHTTPContext or jwt.ToGRPCContext put in the context, and
+// passes its claims on in the context under jwt.JWTClaimsContextKey, and its
+// Principal.
+func (v *Verifier) Middleware() endpoint.Middleware {
+	return AuthenticationMiddleware(v)
+}
+
+// AuthenticateContext verifies the token in ctx. The principal of the call
+// is the user named by the sub claim, with the roles of the roles claim.
+// Calls without a token that were made over mutual TLS, with a client
+// certificate the server verified, are made by the CertificatePrincipal
+// named by the CertificateIdentity of the certificate, without roles.
+func (v *Verifier) AuthenticateContext(ctx context.Context) (context.Context, error) {
+	token, ok := ctx.Value(jwt.JWTTokenContextKey).(string)
+	if !ok {
+		if cert, ok := ClientCertificate(ctx); ok {
+			return WithPrincipal(ctx, &Principal{ID: CertificateIdentity(cert), Kind: CertificatePrincipal}), nil
+		}
+		return nil, jwt.ErrTokenContextMissing
+	}
+	claims, err := v.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	p := &Principal{ID: sub, Kind: UserPrincipal, Roles: claimStrings(claims, "roles")}
+	ctx = context.WithValue(ctx, jwt.JWTClaimsContextKey, claims)
+	return WithPrincipal(ctx, p), nil
+}
+
+// claimStrings returns the strings of a claim that is a string or a list of
+// them.
+func claimStrings(claims stdjwt.MapClaims, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var ss []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				ss = append(ss, s)
+			}
+		}
+		return ss
+	}
+	return nil
+}
+
+// PrincipalKind tells users and the machines acting for themselves apart.
+type PrincipalKind string
+
+const (
+	// UserPrincipal is a person, authenticated by a token.
+	UserPrincipal PrincipalKind = "user"
+
+	// ServicePrincipal is a service account, authenticated by an API key.
+	ServicePrincipal PrincipalKind = "service"
+
+	// CertificatePrincipal is a client, authenticated by the certificate it
+	// presented over mutual TLS.
+	CertificatePrincipal PrincipalKind = "certificate"
+)
+
+// Principal is who a call is made by, and the roles they were granted,
+// whichever way they authenticated.
+type Principal struct {
+	ID    string        `json:"id"`
+	Kind  PrincipalKind `json:"kind"`
+	Roles []string      `json:"roles,omitempty"`
+}
+
+// HasRole reports whether p was granted role.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalContextKey struct{}
+
+// WithPrincipal returns a context carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, p)
+}
+
+// PrincipalFromContext returns the principal of a call, once an
+// Authenticator established it.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return p, ok
+}
+
+// Authenticator establishes who a call is made by from the credentials the
+// transports put in its context.
+type Authenticator interface {
+	// AuthenticateContext returns ctx carrying the Principal of the call,
+	// or an error if its credentials are missing or not valid.
+	AuthenticateContext(ctx context.Context) (context.Context, error)
+}
+
+// AuthenticationMiddleware returns an endpoint middleware that only passes on
+// the calls that a authenticates.
+func AuthenticationMiddleware(a Authenticator) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx, err := a.AuthenticateContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// authenticated wraps e with the middleware of a, if a isn't nil.
+func authenticated(a Authenticator, e endpoint.Endpoint) endpoint.Endpoint {
+	if a == nil {
+		return e
+	}
+	return AuthenticationMiddleware(a)(e)
+}
+
+// provisioning wraps e with the middleware of a, if a isn't nil, and only
+// passes on the calls of the principals that authorizeProvisioning allows.
+func provisioning(a Authenticator, e endpoint.Endpoint) endpoint.Endpoint {
+	if a == nil {
+		return e
+	}
+	return AuthenticationMiddleware(a)(func(ctx context.Context, request interface{}) (interface{}, error) {
+		if err := authorizeProvisioning(ctx); err != nil {
+			return nil, err
+		}
+		return e(ctx, request)
+	})
+}
+
+// isAuthError reports whether err is the failure to authenticate a call.
+func isAuthError(err error) bool {
+	switch err {
+	case jwt.ErrTokenContextMissing, jwt.ErrTokenInvalid, jwt.ErrTokenExpired,
+		jwt.ErrTokenMalformed, jwt.ErrTokenNotActive, jwt.ErrUnexpectedSigningMethod,
+		ErrTokenRevoked, ErrAPIKeyInvalid, ErrAPIKeyExpired, ErrAPIKeyRevoked:
+		return true
+	}
+	return false
+}
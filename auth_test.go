@@ -0,0 +1,308 @@
+package learn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/log"
+)
+
+// sign returns a token with claims signed with key, with kid in its header
+// unless it is empty.
+func sign(t *testing.T, method stdjwt.SigningMethod, kid string, key interface{}, claims stdjwt.MapClaims) string {
+	token := stdjwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+// writeJWKS writes keys to path as a JWKS, replacing the file atomically.
+func writeJWKS(t *testing.T, path string, keys ...map[string]string) {
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".tmp", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{"kty": "EC", "kid": kid, "crv": "P-256", "x": b64(key.X.Bytes()), "y": b64(key.Y.Bytes())}
+}
+
+// testKeys holds a key of every supported type, and a KeySet over their
+// public keys in dir: rsa1 in a PEM file, and ec1 and ed1 in keys.json,
+// along with an RSA key for encryption named enc.
+type testKeys struct {
+	dir  string
+	rsa  *rsa.PrivateKey
+	ec   *ecdsa.PrivateKey
+	ed   ed25519.PrivateKey
+	keys *KeySet
+}
+
+func newTestKeys(t *testing.T, reload time.Duration) *testKeys {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &testKeys{dir: dir}
+
+	if k.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&k.rsa.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "rsa1.pem"), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if k.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.ed = edPriv
+	writeJWKS(t, filepath.Join(dir, "keys.json"),
+		ecJWK("ec1", k.ec),
+		map[string]string{"kty": "OKP", "kid": "ed1", "crv": "Ed25519", "x": b64(edPub)},
+		map[string]string{"kty": "RSA", "kid": "enc", "use": "enc", "n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+	)
+
+	k.keys, err = NewKeySet([]string{filepath.Join(dir, "rsa1.pem"), filepath.Join(dir, "keys.json")}, reload, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func (k *testKeys) Close() {
+	k.keys.Stop()
+	os.RemoveAll(k.dir)
+}
+
+func TestVerifier(t *testing.T) {
+	k := newTestKeys(t, time.Hour)
+	defer k.Close()
+	v := NewVerifier(k.keys, "iss1", "learn", time.Second)
+
+	now := time.Now()
+	valid := stdjwt.MapClaims{"iss": "iss1", "aud": []interface{}{"other", "learn"}, "exp": now.Add(time.Minute).Unix(), "sub": "u"}
+	for _, test := range []struct {
+		method stdjwt.SigningMethod
+		kid    string
+		key    interface{}
+	}{
+		{stdjwt.SigningMethodRS256, "rsa1", k.rsa},
+		{stdjwt.SigningMethodES256, "ec1", k.ec},
+		{SigningMethodEdDSA, "ed1", k.ed},
+	} {
+		claims, err := v.Verify(sign(t, test.method, test.kid, test.key, valid))
+		if err != nil {
+			t.Errorf("%s: %v", test.kid, err)
+			continue
+		}
+		if claims["sub"] != "u" {
+			t.Errorf("%s: sub = %v, want u", test.kid, claims["sub"])
+		}
+	}
+
+	for _, test := range []struct {
+		name   string
+		claims stdjwt.MapClaims
+		want   error
+	}{
+		{"no exp", stdjwt.MapClaims{"iss": "iss1", "aud": "learn"}, jwt.ErrTokenInvalid},
+		{"expired", stdjwt.MapClaims{"iss": "iss1", "aud": "learn", "exp": now.Add(-5 * time.Second).Unix()}, jwt.ErrTokenExpired},
+		{"not yet valid", stdjwt.MapClaims{"iss": "iss1", "aud": "learn", "exp": now.Add(time.Hour).Unix(), "nbf": now.Add(time.Minute).Unix()}, jwt.ErrTokenNotActive},
+		{"other issuer", stdjwt.MapClaims{"iss": "other", "aud": "learn", "exp": now.Add(time.Hour).Unix()}, jwt.ErrTokenInvalid},
+		{"other audience", stdjwt.MapClaims{"iss": "iss1", "aud": "other", "exp": now.Add(time.Hour).Unix()}, jwt.ErrTokenInvalid},
+	} {
+		if _, err := v.Verify(sign(t, stdjwt.SigningMethodRS256, "rsa1", k.rsa, test.claims)); err != test.want {
+			t.Errorf("%s: %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	// The algorithm must match the key: HS256 with the public key of rsa1 as
+	// the secret is refused.
+	der, _ := x509.MarshalPKIXPublicKey(&k.rsa.PublicKey)
+	if _, err := v.Verify(sign(t, stdjwt.SigningMethodHS256, "rsa1", der, valid)); err != jwt.ErrUnexpectedSigningMethod {
+		t.Errorf("HS256 with an RSA key = %v, want jwt.ErrUnexpectedSigningMethod", err)
+	}
+
+	// So must the key id, which can't be left out with several keys, and
+	// keys for encryption aren't used.
+	for _, kid := range []string{"nope", "", "enc"} {
+		if _, err := v.Verify(sign(t, stdjwt.SigningMethodRS256, kid, k.rsa, valid)); err != jwt.ErrTokenInvalid {
+			t.Errorf("kid %q: %v, want jwt.ErrTokenInvalid", kid, err)
+		}
+	}
+
+	if _, err := v.Verify("garbage"); err != jwt.ErrTokenMalformed {
+		t.Errorf("garbage = %v, want jwt.ErrTokenMalformed", err)
+	}
+}
+
+func TestKeySetReload(t *testing.T) {
+	k := newTestKeys(t, 10*time.Millisecond)
+	defer k.Close()
+	v := NewVerifier(k.keys, "", "", 0)
+	claims := stdjwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()}
+
+	// Rotate to a new key. The modification time is moved forward so that
+	// the change is seen on file systems with coarse times.
+	ec2, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(k.dir, "keys.json")
+	writeJWKS(t, path, ecJWK("ec2", ec2))
+	later := time.Now().Add(2 * time.Second)
+	os.Chtimes(path, later, later)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := v.Verify(sign(t, stdjwt.SigningMethodES256, "ec2", ec2, claims))
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("new key not loaded after 5s: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := v.Verify(sign(t, stdjwt.SigningMethodES256, "ec1", k.ec, claims)); err != jwt.ErrTokenInvalid {
+		t.Errorf("rotated out key = %v, want jwt.ErrTokenInvalid", err)
+	}
+
+	// A broken file keeps the keys loaded last.
+	if err := ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := v.Verify(sign(t, stdjwt.SigningMethodES256, "ec2", ec2, claims)); err != nil {
+		t.Errorf("keys dropped after a broken reload: %v", err)
+	}
+}
+
+func TestIssuerKeys(t *testing.T) {
+	k := newTestKeys(t, time.Hour)
+	defer k.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := NewIssuer(key, "signer", "iss1", "learn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := issuer.Issue("u", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only keys extended with those of the issuer verify its tokens.
+	if _, err := NewVerifier(k.keys, "iss1", "learn", 0).Verify(token); err != jwt.ErrTokenInvalid {
+		t.Errorf("token of an unknown issuer = %v, want jwt.ErrTokenInvalid", err)
+	}
+	claims, err := NewVerifier(issuer.Keys(k.keys), "iss1", "learn", 0).Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "u" {
+		t.Errorf("sub = %v, want u", claims["sub"])
+	}
+
+	// An issuer with a shared secret has no key id, so a KeySet, which finds
+	// keys by id, can't verify its tokens.
+	secret, err := NewIssuer(SharedSecret("secret"), "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err = secret.Issue("u", time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewVerifier(secret.Keys(k.keys), "", "", 0).Verify(token); err == nil {
+		t.Error("key set verified a token signed with a shared secret")
+	}
+}
+
+func TestHTTPAuthentication(t *testing.T) {
+	svc := NewBasicService()
+	endpoints := Endpoints{
+		CreateUserEndpoint: MakeCreateUserEndpoint(svc),
+		GetUserEndpoint:    MakeGetUserEndpoint(svc),
+	}
+	auth := NewVerifier(SharedSecret("secret"), "", "", 0)
+	srv := httptest.NewServer(MakeHTTPHandler(context.Background(), endpoints, nil, auth, log.NewNopLogger()))
+	defer srv.Close()
+
+	post := func(path, token string) int {
+		req, err := http.NewRequest("POST", srv.URL+path, strings.NewReader(`{"user":{"id":"a"},"id":"a"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Creates need the token of an admin, gets don't need one.
+	if code := post("/create", ""); code != http.StatusUnauthorized {
+		t.Errorf("create without a token = %d, want 401", code)
+	}
+	token := sign(t, stdjwt.SigningMethodHS256, "", []byte("secret"), stdjwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix()})
+	if code := post("/create", token); code != http.StatusForbidden {
+		t.Errorf("create with the token of a user = %d, want 403", code)
+	}
+	token = sign(t, stdjwt.SigningMethodHS256, "", []byte("secret"), stdjwt.MapClaims{"exp": time.Now().Add(time.Minute).Unix(), "roles": []string{AdminRole}})
+	if code := post("/create", token); code != http.StatusOK {
+		t.Errorf("create with the token of an admin = %d, want 200", code)
+	}
+	if code := post("/get", ""); code != http.StatusOK {
+		t.Errorf("get without a token = %d, want 200", code)
+	}
+}
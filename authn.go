@@ -0,0 +1,526 @@
+package learn
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/metrics"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/net/context"
+)
+
+// AuthService authenticates users with their passwords, and TOTP codes for
+// those enrolled in MFA, and keeps the sessions they are issued tokens for.
+type AuthService interface {
+	// SetPassword sets the password of a user, ending their sessions. It
+	// may be called by the user or by an admin. Passwords breaking the
+	// password policy are refused with a *PasswordPolicyError.
+	SetPassword(ctx context.Context, userID, password string) error
+
+	// Authenticate starts a session for the user with username and
+	// password, and returns its tokens. For a user enrolled in MFA, it
+	// returns only a challenge, which VerifyMFA completes.
+	Authenticate(ctx context.Context, username, password string) (*Tokens, error)
+
+	// VerifyMFA completes an MFA challenge with a TOTP code or a recovery
+	// code, starting the session.
+	VerifyMFA(ctx context.Context, challenge, code string) (*Tokens, error)
+
+	// Refresh exchanges the refresh token of a session for new tokens.
+	// Each refresh token works once; using one again ends its session.
+	Refresh(ctx context.Context, refreshToken string) (*Tokens, error)
+
+	// ListSessions returns the active sessions of a user. It may be
+	// called by the user or by an admin, as may the revocations.
+	ListSessions(ctx context.Context, userID string) ([]Session, error)
+
+	// RevokeSession ends a session of a user.
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+
+	// RevokeSessions ends every session of a user.
+	RevokeSessions(ctx context.Context, userID string) error
+
+	// EnrollMFA generates a TOTP secret for a user, which takes effect once
+	// ConfirmMFA is given a code of it. It may be called by the user or by
+	// an admin, as may ConfirmMFA and DisableMFA.
+	EnrollMFA(ctx context.Context, userID string) (*MFAEnrollment, error)
+
+	// ConfirmMFA enables MFA for a user with the first code of their
+	// pending secret, and returns their one-time recovery codes.
+	ConfirmMFA(ctx context.Context, userID, code string) ([]string, error)
+
+	// DisableMFA disables MFA for a user. Unless called by an admin, it
+	// takes a TOTP or recovery code.
+	DisableMFA(ctx context.Context, userID, code string) error
+
+	// Unlock lifts the lockout of a username, a client IP, or both, and
+	// forgets their failed logins. Only admins may call it.
+	Unlock(ctx context.Context, username, ip string) error
+}
+
+var (
+	// ErrInvalidCredentials is returned when a username and password don't
+	// match. It doesn't tell whether the user exists.
+	ErrInvalidCredentials = errors.New("Invalid username or password")
+
+	// ErrMissingUsername is returned when setting the password of a user
+	// without a username to authenticate with.
+	ErrMissingUsername = errors.New("User has no username")
+
+	// ErrUsernameTaken is returned when setting the password of a user
+	// whose username another user already authenticates with.
+	ErrUsernameTaken = errors.New("Username is taken by another user")
+
+	// ErrRefreshTokenInvalid is returned for a refresh token that was never
+	// issued, or whose session ended.
+	ErrRefreshTokenInvalid = errors.New("Invalid refresh token")
+
+	// ErrRefreshTokenReused is returned for a refresh token that was used
+	// before. Its session is ended, since either it or its replacement was
+	// stolen.
+	ErrRefreshTokenReused = errors.New("Refresh token was already used, session revoked")
+
+	// ErrSessionNotFound is returned for a session that doesn't exist or
+	// already ended.
+	ErrSessionNotFound = errors.New("Session not found")
+
+	// ErrForbidden is returned when the caller may not act on a user.
+	ErrForbidden = errors.New("Forbidden")
+)
+
+// AdminRole is the role of the principals that may act on any user.
+const AdminRole = "admin"
+
+// RefreshTokenPrefix starts every refresh token.
+const RefreshTokenPrefix = "lrr_"
+
+// denySkew is how much longer than the tokens of a revoked session its ID is
+// denied, for Verifiers allowing for clock skew.
+const denySkew = 5 * time.Minute
+
+// Tokens are the tokens of a session. The access token is a JWT that
+// authenticates calls until Expires. The refresh token gets new tokens.
+//
+// Authenticating a user enrolled in MFA instead gets only a Challenge, which
+// must be completed with VerifyMFA before Expires.
+type Tokens struct {
+	AccessToken  string    `json:"access_token,omitempty"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	SessionID    string    `json:"session_id,omitempty"`
+	Challenge    string    `json:"challenge,omitempty"`
+	Expires      time.Time `json:"expires"`
+}
+
+// Session is a user's session, which lasts for as long as it is refreshed
+// before Expires.
+type Session struct {
+	ID       string    `json:"id"`
+	UserID   string    `json:"user_id"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used"`
+	Expires  time.Time `json:"expires"`
+}
+
+type credential struct {
+	userID   string
+	username string
+	hash     []byte
+	mfa      mfa
+}
+
+type session struct {
+	Session
+
+	// refresh is the hash of the current refresh token, and used those of
+	// the ones it replaced, which are kept to detect their reuse.
+	refresh []byte
+	used    [][]byte
+
+	// accessExpires is when the last access token issued expires.
+	accessExpires time.Time
+
+	// mfa is whether the session was started with an MFA code.
+	mfa bool
+}
+
+// AuthConfig configures an AuthService.
+type AuthConfig struct {
+	// Issuer signs the access tokens.
+	Issuer *Issuer
+
+	// Denylist denies the access tokens of ended sessions. If nil, they stay
+	// valid until they expire.
+	Denylist *Denylist
+
+	// AccessTTL is how long access tokens last, and RefreshTTL how long a
+	// session lasts from when it was refreshed last.
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+
+	// Roles returns the roles of a user, which the access tokens of their
+	// sessions carry. AdminRole is only granted to sessions started with an
+	// MFA code, so admins must enroll in MFA to act as admins. If nil, the
+	// tokens carry no roles.
+	Roles func(userID string) []string
+
+	// PasswordPolicy is what SetPassword requires of passwords.
+	PasswordPolicy PasswordPolicy
+
+	// MFAIssuer names the service in the authenticator apps of users
+	// enrolled in MFA. If empty, it is "learn".
+	MFAIssuer string
+
+	// Lockout throttles failed logins. Its zero value doesn't.
+	Lockout LockoutPolicy
+
+	// FailedLogins counts failed logins, and Lockouts the lockouts they
+	// cause, with a "scope" field of "account" or "ip". Either may be nil.
+	FailedLogins metrics.Counter
+	Lockouts     metrics.Counter
+
+	// Audit, if set, is called with every login attempt and lockout. It is
+	// called while the attempt is being made, so it must not block or call
+	// back into the service.
+	Audit func(AuthEvent)
+
+	// Now returns the current time, against which sessions, MFA challenges,
+	// TOTP codes and lockouts are checked. If nil, it is time.Now.
+	Now func() time.Time
+}
+
+type basicAuthService struct {
+	users  UserService
+	config AuthConfig
+
+	mtx         sync.Mutex
+	credentials map[string]*credential // by user id
+	usernames   map[string]string      // user id by username
+	sessions    map[string]*session
+	challenges  map[string]*challenge
+	throttle    *throttle
+}
+
+// dummyHash is compared with the password of an unknown user, so that
+// authenticating takes as long whether the user exists or not.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("learn"), bcrypt.DefaultCost)
+
+// NewAuthService returns an AuthService for the users of users, configured
+// by config. Passwords, MFA secrets and sessions are only kept in memory, by
+// this instance.
+func NewAuthService(users UserService, config AuthConfig) AuthService {
+	if config.MFAIssuer == "" {
+		config.MFAIssuer = "learn"
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	return &basicAuthService{
+		users:       users,
+		config:      config,
+		credentials: map[string]*credential{},
+		usernames:   map[string]string{},
+		sessions:    map[string]*session{},
+		challenges:  map[string]*challenge{},
+		throttle:    newThrottle(config.Lockout, config.FailedLogins, config.Lockouts),
+	}
+}
+
+// authorize checks that the principal of ctx may act on the user with
+// userID: the user themselves, or an admin. It guards the AuthService; the
+// records of users are guarded by authorizeProvisioning instead.
+func authorize(ctx context.Context, userID string) error {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return ErrForbidden
+	}
+	if p.HasRole(AdminRole) || (p.Kind == UserPrincipal && p.ID == userID) {
+		return nil
+	}
+	return ErrForbidden
+}
+
+// authorizeProvisioning checks that the principal of ctx may create users,
+// replacing any with the same ID: an admin, or a service account
+// provisioning them. Unlike authorize, it doesn't let users write their own
+// record, which holds the username and expiry given to them when they were
+// provisioned. Service accounts may overwrite the record of anyone, as
+// identity providers keeping their users in sync must.
+func authorizeProvisioning(ctx context.Context) error {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok || !(p.HasRole(AdminRole) || p.Kind == ServicePrincipal) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func (s *basicAuthService) SetPassword(ctx context.Context, userID, password string) error {
+	if err := authorize(ctx, userID); err != nil {
+		return err
+	}
+	user, err := s.users.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user.Username == "" {
+		return ErrMissingUsername
+	}
+	if err := s.config.PasswordPolicy.Check(password, user); err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if id, ok := s.usernames[user.Username]; ok && id != userID {
+		return ErrUsernameTaken
+	}
+	c, ok := s.credentials[userID]
+	if !ok {
+		c = &credential{userID: userID}
+		s.credentials[userID] = c
+	}
+	delete(s.usernames, c.username)
+	c.username, c.hash = user.Username, hash
+	s.usernames[user.Username] = userID
+	s.revokeAll(userID)
+	return nil
+}
+
+func (s *basicAuthService) Authenticate(ctx context.Context, username, password string) (*Tokens, error) {
+	s.mtx.Lock()
+	// A locked out username is refused before its password is checked, and
+	// alike whether it exists or not.
+	if err := s.checkLockout(ctx, username, s.config.Now()); err != nil {
+		s.mtx.Unlock()
+		return nil, err
+	}
+	c, ok := s.credentials[s.usernames[username]]
+	s.mtx.Unlock()
+
+	hash := dummyHash
+	if ok {
+		hash = c.hash
+	}
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := s.config.Now()
+	s.sweep(now)
+	if err != nil || !ok {
+		s.failed(ctx, username, now)
+		return nil, ErrInvalidCredentials
+	}
+	if c.mfa.secret != nil {
+		return s.challenge(c.userID, now)
+	}
+	s.succeeded(ctx, username, c.userID, now)
+	return s.start(c.userID, now, false)
+}
+
+// start starts a session for the user with userID, who gave an MFA code if
+// mfa is set. s.mtx must be held.
+func (s *basicAuthService) start(userID string, now time.Time, mfa bool) (*Tokens, error) {
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	sess := &session{Session: Session{ID: id, UserID: userID, Created: now}, mfa: mfa}
+	tokens, err := s.rotate(sess, now)
+	if err != nil {
+		return nil, err
+	}
+	s.sessions[id] = sess
+	return tokens, nil
+}
+
+func (s *basicAuthService) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	id, hash, ok := parseRefreshToken(refreshToken)
+	if !ok {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	sess, ok := s.sessions[id]
+	now := s.config.Now()
+	if !ok || now.After(sess.Expires) {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if subtle.ConstantTimeCompare(hash, sess.refresh) != 1 {
+		for _, used := range sess.used {
+			if subtle.ConstantTimeCompare(hash, used) == 1 {
+				s.revoke(sess)
+				return nil, ErrRefreshTokenReused
+			}
+		}
+		return nil, ErrRefreshTokenInvalid
+	}
+	return s.rotate(sess, now)
+}
+
+// rotate issues new tokens for sess, retiring its refresh token. s.mtx must
+// be held.
+func (s *basicAuthService) rotate(sess *session, now time.Time) (*Tokens, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	refreshToken := RefreshTokenPrefix + sess.ID + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	_, hash, _ := parseRefreshToken(refreshToken)
+
+	// The amr claim of RFC 8176 tells how the user authenticated. Roles are
+	// looked up again on every refresh, so that changes to them take effect.
+	claims := stdjwt.MapClaims{"sid": sess.ID, "amr": []string{"pwd"}}
+	if sess.mfa {
+		claims["amr"] = []string{"pwd", "otp"}
+	}
+	if roles := s.roles(sess); len(roles) > 0 {
+		claims["roles"] = roles
+	}
+	access, exp, err := s.config.Issuer.Issue(sess.UserID, s.config.AccessTTL, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if sess.refresh != nil {
+		sess.used = append(sess.used, sess.refresh)
+	}
+	sess.refresh = hash
+	sess.LastUsed = now
+	sess.Expires = now.Add(s.config.RefreshTTL)
+	sess.accessExpires = exp
+	return &Tokens{
+		AccessToken:  access,
+		RefreshToken: refreshToken,
+		SessionID:    sess.ID,
+		Expires:      exp,
+	}, nil
+}
+
+// roles returns the roles the tokens of sess carry: those of its user, but
+// AdminRole only if the session was started with an MFA code.
+func (s *basicAuthService) roles(sess *session) []string {
+	if s.config.Roles == nil {
+		return nil
+	}
+	var roles []string
+	for _, r := range s.config.Roles(sess.UserID) {
+		if r != AdminRole || sess.mfa {
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
+
+// parseRefreshToken returns the session ID of a refresh token, and the hash
+// it is kept as. The secret is random enough that a plain SHA-256 hash
+// protects it.
+func parseRefreshToken(token string) (string, []byte, bool) {
+	if !strings.HasPrefix(token, RefreshTokenPrefix) {
+		return "", nil, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(token, RefreshTokenPrefix), "_", 2)
+	if len(parts) != 2 {
+		return "", nil, false
+	}
+	hash := sha256.Sum256([]byte(parts[1]))
+	return parts[0], hash[:], true
+}
+
+func (s *basicAuthService) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	if err := authorize(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := s.config.Now()
+	sessions := []Session{}
+	for _, sess := range s.sessions {
+		if sess.UserID == userID && !now.After(sess.Expires) {
+			sessions = append(sessions, sess.Session)
+		}
+	}
+	sort.Sort(sessionsByCreated(sessions))
+	return sessions, nil
+}
+
+type sessionsByCreated []Session
+
+func (s sessionsByCreated) Len() int           { return len(s) }
+func (s sessionsByCreated) Less(i, j int) bool { return s[i].Created.Before(s[j].Created) }
+func (s sessionsByCreated) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (s *basicAuthService) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	if err := authorize(ctx, userID); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	sess, ok := s.sessions[sessionID]
+	if !ok || sess.UserID != userID {
+		return ErrSessionNotFound
+	}
+	s.revoke(sess)
+	return nil
+}
+
+func (s *basicAuthService) RevokeSessions(ctx context.Context, userID string) error {
+	if err := authorize(ctx, userID); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.revokeAll(userID)
+	return nil
+}
+
+// revoke ends sess, denying its access tokens until they expire. s.mtx must
+// be held.
+func (s *basicAuthService) revoke(sess *session) {
+	delete(s.sessions, sess.ID)
+	if s.config.Denylist != nil {
+		s.config.Denylist.Deny(sess.ID, sess.accessExpires.Add(denySkew))
+	}
+}
+
+// revokeAll ends every session of a user. s.mtx must be held.
+func (s *basicAuthService) revokeAll(userID string) {
+	for _, sess := range s.sessions {
+		if sess.UserID == userID {
+			s.revoke(sess)
+		}
+	}
+}
+
+// sweep drops the expired sessions and MFA challenges, and the failures
+// lockouts no longer need. The access tokens of
+// the sessions expired long before, so they needn't be denied. s.mtx must be
+// held.
+func (s *basicAuthService) sweep(now time.Time) {
+	for id, sess := range s.sessions {
+		if now.After(sess.Expires) {
+			delete(s.sessions, id)
+		}
+	}
+	for id, ch := range s.challenges {
+		if now.After(ch.expires) {
+			delete(s.challenges, id)
+		}
+	}
+	s.throttle.sweep(now)
+}
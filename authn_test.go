@@ -0,0 +1,276 @@
+package learn
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/auth/jwt"
+	"golang.org/x/net/context"
+)
+
+// testClock is a clock for AuthConfig.Now that only moves when told to.
+type testClock struct {
+	mtx sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock { return &testClock{now: time.Unix(1500000000, 0)} }
+
+func (c *testClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// testAuth is an AuthService for the users ann (u1), bob (u2) and u3, who
+// has no username, with a Verifier of its access tokens.
+type testAuth struct {
+	AuthService
+	users    UserService
+	verifier *Verifier
+}
+
+// newTestAuth returns a testAuth configured by config, in which Issuer and
+// Denylist are set.
+func newTestAuth(t *testing.T, config AuthConfig) *testAuth {
+	ctx := context.Background()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := NewIssuer(priv, "k1", "learn", "apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny := NewDenylist()
+	v := NewVerifier(issuer.Keys(SharedSecret(nil)), "learn", "apps", 0)
+	v.UseDenylist(deny)
+
+	users := NewBasicService()
+	users.CreateUser(ctx, &User{Id: "u1", Username: "ann"})
+	users.CreateUser(ctx, &User{Id: "u2", Username: "bob"})
+	users.CreateUser(ctx, &User{Id: "u3"})
+
+	config.Issuer, config.Denylist = issuer, deny
+	if config.AccessTTL == 0 {
+		config.AccessTTL = time.Minute
+	}
+	if config.RefreshTTL == 0 {
+		config.RefreshTTL = time.Hour
+	}
+	return &testAuth{AuthService: NewAuthService(users, config), users: users, verifier: v}
+}
+
+// as returns a context authenticated with the access token of tokens.
+func (a *testAuth) as(t *testing.T, tokens *Tokens) context.Context {
+	ctx, err := a.verifier.AuthenticateContext(context.WithValue(context.Background(), jwt.JWTTokenContextKey, tokens.AccessToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+var adminContext = WithPrincipal(context.Background(), &Principal{ID: "ops", Kind: ServicePrincipal, Roles: []string{AdminRole}})
+
+func TestAuthServicePasswords(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAuth(t, AuthConfig{})
+
+	if err := a.SetPassword(ctx, "u1", "correct horse"); err != ErrForbidden {
+		t.Errorf("unauthenticated SetPassword = %v, want ErrForbidden", err)
+	}
+	if err := a.SetPassword(adminContext, "u1", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetPassword(adminContext, "u3", "correct horse"); err != ErrMissingUsername {
+		t.Errorf("SetPassword without a username = %v, want ErrMissingUsername", err)
+	}
+
+	if _, err := a.Authenticate(ctx, "ann", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("wrong password = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := a.Authenticate(ctx, "zed", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("unknown user = %v, want ErrInvalidCredentials", err)
+	}
+	tokens, err := a.Authenticate(ctx, "ann", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tokens.RefreshToken, RefreshTokenPrefix) {
+		t.Errorf("refresh token = %q, want the prefix %s", tokens.RefreshToken, RefreshTokenPrefix)
+	}
+	claims, err := a.verifier.Verify(tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "u1" || claims["sid"] != tokens.SessionID {
+		t.Errorf("claims = %v, want the session of u1", claims)
+	}
+
+	// Users may change only their own password, which ends their sessions.
+	annCtx := a.as(t, tokens)
+	if err := a.SetPassword(annCtx, "u2", "stolen"); err != ErrForbidden {
+		t.Errorf("SetPassword of another user = %v, want ErrForbidden", err)
+	}
+	if err := a.SetPassword(annCtx, "u1", "battery staple"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.verifier.Verify(tokens.AccessToken); err != ErrTokenRevoked {
+		t.Errorf("token after a password change = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := a.Authenticate(ctx, "ann", "correct horse"); err != ErrInvalidCredentials {
+		t.Errorf("old password = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestAuthServiceRefresh(t *testing.T) {
+	ctx := context.Background()
+	clock := newTestClock()
+	a := newTestAuth(t, AuthConfig{Now: clock.Now})
+	a.SetPassword(adminContext, "u1", "correct horse")
+
+	tokens, err := a.Authenticate(ctx, "ann", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Refresh(ctx, "garbage"); err != ErrRefreshTokenInvalid {
+		t.Errorf("garbage refresh token = %v, want ErrRefreshTokenInvalid", err)
+	}
+
+	// Refresh tokens are rotated, and using one twice ends the session.
+	next, err := a.Refresh(ctx, tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.RefreshToken == tokens.RefreshToken || next.SessionID != tokens.SessionID {
+		t.Errorf("refresh gave %+v, want a new refresh token of the same session", next)
+	}
+	if _, err := a.Refresh(ctx, tokens.RefreshToken); err != ErrRefreshTokenReused {
+		t.Errorf("reused refresh token = %v, want ErrRefreshTokenReused", err)
+	}
+	if _, err := a.Refresh(ctx, next.RefreshToken); err != ErrRefreshTokenInvalid {
+		t.Errorf("refresh token of a revoked session = %v, want ErrRefreshTokenInvalid", err)
+	}
+	if _, err := a.verifier.Verify(next.AccessToken); err != ErrTokenRevoked {
+		t.Errorf("access token of a revoked session = %v, want ErrTokenRevoked", err)
+	}
+
+	// Sessions last for RefreshTTL from their last refresh.
+	tokens, _ = a.Authenticate(ctx, "ann", "correct horse")
+	clock.Add(50 * time.Minute)
+	if tokens, err = a.Refresh(ctx, tokens.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	clock.Add(50 * time.Minute)
+	if tokens, err = a.Refresh(ctx, tokens.RefreshToken); err != nil {
+		t.Errorf("refresh within RefreshTTL of the last = %v", err)
+	}
+	clock.Add(61 * time.Minute)
+	if _, err := a.Refresh(ctx, tokens.RefreshToken); err != ErrRefreshTokenInvalid {
+		t.Errorf("refresh of an expired session = %v, want ErrRefreshTokenInvalid", err)
+	}
+}
+
+func TestAuthServiceSessions(t *testing.T) {
+	ctx := context.Background()
+	clock := newTestClock()
+	a := newTestAuth(t, AuthConfig{Now: clock.Now})
+	a.SetPassword(adminContext, "u1", "correct horse")
+	a.SetPassword(adminContext, "u2", "battery staple")
+
+	first, _ := a.Authenticate(ctx, "ann", "correct horse")
+	clock.Add(time.Second)
+	second, _ := a.Authenticate(ctx, "ann", "correct horse")
+	bob, _ := a.Authenticate(ctx, "bob", "battery staple")
+	annCtx := a.as(t, second)
+
+	sessions, err := a.ListSessions(annCtx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || sessions[0].ID != first.SessionID || sessions[1].ID != second.SessionID {
+		t.Errorf("sessions = %+v, want the two of ann, oldest first", sessions)
+	}
+	for _, err := range []error{
+		func() error { _, err := a.ListSessions(annCtx, "u2"); return err }(),
+		a.RevokeSession(annCtx, "u2", bob.SessionID),
+		a.RevokeSessions(annCtx, "u2"),
+	} {
+		if err != ErrForbidden {
+			t.Errorf("acting on another user = %v, want ErrForbidden", err)
+		}
+	}
+
+	// A revoked session's tokens are denied, and the others' aren't.
+	if err := a.RevokeSession(annCtx, "u1", first.SessionID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.verifier.Verify(first.AccessToken); err != ErrTokenRevoked {
+		t.Errorf("token of the revoked session = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := a.verifier.Verify(second.AccessToken); err != nil {
+		t.Errorf("token of another session = %v", err)
+	}
+	if err := a.RevokeSession(annCtx, "u1", first.SessionID); err != ErrSessionNotFound {
+		t.Errorf("revoking a revoked session = %v, want ErrSessionNotFound", err)
+	}
+	if err := a.RevokeSession(adminContext, "u1", bob.SessionID); err != ErrSessionNotFound {
+		t.Errorf("revoking the session of another user = %v, want ErrSessionNotFound", err)
+	}
+
+	// Admins may end every session of a user.
+	if err := a.RevokeSessions(adminContext, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.verifier.Verify(second.AccessToken); err != ErrTokenRevoked {
+		t.Errorf("token after RevokeSessions = %v, want ErrTokenRevoked", err)
+	}
+	if sessions, _ := a.ListSessions(adminContext, "u1"); len(sessions) != 0 {
+		t.Errorf("sessions after RevokeSessions = %+v, want none", sessions)
+	}
+	if sessions, _ := a.ListSessions(adminContext, "u2"); len(sessions) != 1 {
+		t.Errorf("sessions of bob = %+v, want his one", sessions)
+	}
+
+	// Expired sessions aren't listed.
+	clock.Add(2 * time.Hour)
+	if sessions, _ := a.ListSessions(adminContext, "u2"); len(sessions) != 0 {
+		t.Errorf("expired sessions = %+v, want none", sessions)
+	}
+}
+
+func TestAuthorizeProvisioning(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		p                *Principal
+		authorize, write error
+	}{
+		{nil, ErrForbidden, ErrForbidden},
+		{&Principal{ID: "u1", Kind: UserPrincipal}, nil, ErrForbidden},
+		{&Principal{ID: "u2", Kind: UserPrincipal}, ErrForbidden, ErrForbidden},
+		{&Principal{ID: "u2", Kind: UserPrincipal, Roles: []string{AdminRole}}, nil, nil},
+		{&Principal{ID: "scim", Kind: ServicePrincipal}, ErrForbidden, nil},
+		{&Principal{ID: "svc.learn", Kind: CertificatePrincipal}, ErrForbidden, ErrForbidden},
+	} {
+		pctx := ctx
+		if tc.p != nil {
+			pctx = WithPrincipal(ctx, tc.p)
+		}
+		if err := authorize(pctx, "u1"); err != tc.authorize {
+			t.Errorf("authorize(%+v, u1) = %v, want %v", tc.p, err, tc.authorize)
+		}
+		if err := authorizeProvisioning(pctx); err != tc.write {
+			t.Errorf("authorizeProvisioning(%+v) = %v, want %v", tc.p, err, tc.write)
+		}
+	}
+}
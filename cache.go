@@ -0,0 +1,230 @@
+package learn
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// ServiceCachingMiddleware returns a read-through cache for GetUser and
+// BatchGetUsers. It holds up to size users, each for at most ttl, in least
+// recently used order. Lookups of users that don't exist are cached for
+// negativeTTL, which may be zero to disable negative caching. Creates made
+// through the returned service invalidate the users they write. If next is a
+// Publisher, every change it publishes invalidates its user too, so changes
+// made past the cache, such as expiries and replicated writes, are seen. If
+// next is a Finder, so is the returned service, though finds aren't cached.
+func ServiceCachingMiddleware(size int, ttl, negativeTTL time.Duration, hits, misses, evictions metrics.Counter) Middleware {
+	return func(next UserService) UserService {
+		mw := &serviceCachingMiddleware{
+			next:        next,
+			size:        size,
+			ttl:         ttl,
+			negativeTTL: negativeTTL,
+			hits:        hits,
+			misses:      misses,
+			evictions:   evictions,
+			entries:     make(map[string]*list.Element),
+			lru:         list.New(),
+		}
+		if p, ok := next.(Publisher); ok {
+			p.Subscribe(func(c Change) { mw.invalidate(c.User.Id) })
+		}
+		if f, ok := next.(Finder); ok {
+			return struct {
+				*serviceCachingMiddleware
+				Finder
+			}{mw, f}
+		}
+		return mw
+	}
+}
+
+type serviceCachingMiddleware struct {
+	next        UserService
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	hits        metrics.Counter
+	misses      metrics.Counter
+	evictions   metrics.Counter
+
+	mtx     sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+
+	// gen is bumped by every invalidation. A lookup only fills the cache if
+	// gen hasn't changed since it started, so a read racing a write can't
+	// put back the value the write replaced.
+	gen uint64
+}
+
+// cacheEntry is a cached lookup. A nil user is a cached ErrNotFound.
+type cacheEntry struct {
+	id      string
+	user    *User
+	expires time.Time
+}
+
+func (mw *serviceCachingMiddleware) CreateUser(ctx context.Context, u *User) (*User, error) {
+	if u != nil {
+		defer mw.invalidate(u.Id)
+	}
+	return mw.next.CreateUser(ctx, u)
+}
+
+func (mw *serviceCachingMiddleware) GetUser(ctx context.Context, id string) (*User, error) {
+	if entry, ok := mw.lookup(id, time.Now()); ok {
+		if entry.user == nil {
+			return nil, ErrNotFound
+		}
+		return entry.user, nil
+	}
+
+	gen := mw.generation()
+	user, err := mw.next.GetUser(ctx, id)
+	if err == nil || err == ErrNotFound {
+		mw.fill(gen, id, user)
+	}
+
+	return user, err
+}
+
+func (mw *serviceCachingMiddleware) BatchCreateUsers(ctx context.Context, users []*User, mode BatchMode) ([]BatchResult, error) {
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		if u != nil {
+			ids = append(ids, u.Id)
+		}
+	}
+	defer mw.invalidate(ids...)
+
+	return mw.next.BatchCreateUsers(ctx, users, mode)
+}
+
+func (mw *serviceCachingMiddleware) BatchGetUsers(ctx context.Context, ids []string, mode BatchMode) ([]BatchResult, error) {
+	now := time.Now()
+	results := make([]BatchResult, len(ids))
+
+	// Serve what we can from the cache and fetch the rest in one batch.
+	var missed []int
+	var missedIDs []string
+	for i, id := range ids {
+		entry, ok := mw.lookup(id, now)
+		switch {
+		case !ok:
+			missed = append(missed, i)
+			missedIDs = append(missedIDs, id)
+		case entry.user == nil:
+			results[i].Err = ErrNotFound
+		default:
+			results[i].User = entry.user
+		}
+	}
+
+	if len(missedIDs) > 0 {
+		gen := mw.generation()
+		fetched, err := mw.next.BatchGetUsers(ctx, missedIDs, BestEffort)
+		if err != nil {
+			return nil, err
+		}
+		for n, r := range fetched {
+			results[missed[n]] = r
+			if r.Err == nil || r.Err == ErrNotFound {
+				mw.fill(gen, missedIDs[n], r.User)
+			}
+		}
+	}
+
+	if mode == AllOrNothing && countFailed(results) > 0 {
+		return abortBatch(results), ErrBatchAborted
+	}
+
+	return results, nil
+}
+
+// lookup returns the live cache entry for id, counting the hit or miss.
+func (mw *serviceCachingMiddleware) lookup(id string, now time.Time) (cacheEntry, bool) {
+	mw.mtx.Lock()
+	defer mw.mtx.Unlock()
+
+	elem, ok := mw.entries[id]
+	if ok {
+		entry := elem.Value.(*cacheEntry)
+		if now.Before(entry.expires) {
+			mw.lru.MoveToFront(elem)
+			mw.hits.Add(1)
+			return *entry, true
+		}
+		mw.remove(elem)
+		mw.evictions.Add(1)
+	}
+
+	mw.misses.Add(1)
+	return cacheEntry{}, false
+}
+
+func (mw *serviceCachingMiddleware) generation() uint64 {
+	mw.mtx.Lock()
+	defer mw.mtx.Unlock()
+
+	return mw.gen
+}
+
+// fill caches the result of a lookup that started at generation gen. A nil
+// user caches the user as not found.
+func (mw *serviceCachingMiddleware) fill(gen uint64, id string, user *User) {
+	ttl := mw.ttl
+	if user == nil {
+		ttl = mw.negativeTTL
+	}
+	expires := time.Now().Add(ttl)
+	if user != nil && !user.ExpiresAt.IsZero() && user.ExpiresAt.Before(expires) {
+		expires = user.ExpiresAt
+	}
+	if mw.size <= 0 || ttl <= 0 {
+		return
+	}
+
+	mw.mtx.Lock()
+	defer mw.mtx.Unlock()
+
+	if gen != mw.gen {
+		return
+	}
+
+	if elem, ok := mw.entries[id]; ok {
+		elem.Value = &cacheEntry{id: id, user: user, expires: expires}
+		mw.lru.MoveToFront(elem)
+		return
+	}
+
+	mw.entries[id] = mw.lru.PushFront(&cacheEntry{id: id, user: user, expires: expires})
+	for mw.lru.Len() > mw.size {
+		mw.remove(mw.lru.Back())
+		mw.evictions.Add(1)
+	}
+}
+
+func (mw *serviceCachingMiddleware) invalidate(ids ...string) {
+	mw.mtx.Lock()
+	defer mw.mtx.Unlock()
+
+	mw.gen++
+	for _, id := range ids {
+		if elem, ok := mw.entries[id]; ok {
+			mw.remove(elem)
+		}
+	}
+}
+
+// remove drops an element from the cache. It must be called with the mutex
+// held.
+func (mw *serviceCachingMiddleware) remove(elem *list.Element) {
+	mw.lru.Remove(elem)
+	delete(mw.entries, elem.Value.(*cacheEntry).id)
+}
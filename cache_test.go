@@ -0,0 +1,87 @@
+package learn
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestCacheGetUser(t *testing.T) {
+	ctx := context.Background()
+	hits, misses, evictions := &testCounter{}, &testCounter{}, &testCounter{}
+	s := ServiceCachingMiddleware(2, time.Minute, time.Minute, hits, misses, evictions)(NewBasicService())
+
+	// Users that don't exist are cached too.
+	for i := 0; i < 2; i++ {
+		if _, err := s.GetUser(ctx, "a"); err != ErrNotFound {
+			t.Fatalf("GetUser = %v, want %v", err, ErrNotFound)
+		}
+	}
+	if hits.Value() != 1 || misses.Value() != 1 {
+		t.Errorf("%d hits and %d misses, want 1 and 1", hits.Value(), misses.Value())
+	}
+
+	// Creating a user through the cache invalidates it.
+	s.CreateUser(ctx, &User{Id: "a", FirstName: "Ann"})
+	if u, err := s.GetUser(ctx, "a"); err != nil || u.FirstName != "Ann" {
+		t.Fatalf("GetUser = %+v, %v", u, err)
+	}
+
+	// The least recently used entry makes room.
+	s.GetUser(ctx, "b")
+	s.GetUser(ctx, "c")
+	if evictions.Value() != 1 {
+		t.Errorf("%d evictions, want 1", evictions.Value())
+	}
+}
+
+func TestCacheBatchGetUsers(t *testing.T) {
+	ctx := context.Background()
+	s := ServiceCachingMiddleware(10, time.Minute, time.Minute, &testCounter{}, &testCounter{}, &testCounter{})(NewBasicService())
+
+	s.GetUser(ctx, "b")
+	s.BatchCreateUsers(ctx, []*User{{Id: "a"}, {Id: "b"}}, BestEffort)
+
+	results, err := s.BatchGetUsers(ctx, []string{"a", "b", "c"}, BestEffort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].User == nil || results[1].User == nil || results[2].Err != ErrNotFound {
+		t.Errorf("results = %+v", results)
+	}
+	if _, err := s.BatchGetUsers(ctx, []string{"a", "c"}, AllOrNothing); err != ErrBatchAborted {
+		t.Errorf("err = %v, want %v", err, ErrBatchAborted)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	base := NewBasicService()
+	s := ServiceCachingMiddleware(10, time.Hour, time.Hour, &testCounter{}, &testCounter{}, &testCounter{})(base)
+
+	// Writes that bypass the cache reach it through the store's changes.
+	s.GetUser(ctx, "a")
+	base.CreateUser(ctx, &User{Id: "a"})
+	if _, err := s.GetUser(ctx, "a"); err != nil {
+		t.Errorf("GetUser after a write past the cache = %v", err)
+	}
+
+	// A user isn't cached beyond its own expiry.
+	base.CreateUser(ctx, &User{Id: "z", ExpiresAt: time.Now().Add(50 * time.Millisecond)})
+	if _, err := s.GetUser(ctx, "z"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := s.GetUser(ctx, "z"); err != ErrNotFound {
+		t.Errorf("GetUser of an expired user = %v, want %v", err, ErrNotFound)
+	}
+
+	// Reaping publishes the expiry.
+	base.CreateUser(ctx, &User{Id: "y", ExpiresAt: time.Now().Add(time.Hour)})
+	s.GetUser(ctx, "y")
+	base.(Expirer).ExpireUsers(ctx, time.Now().Add(2*time.Hour), SoftDelete)
+	if _, err := s.GetUser(ctx, "y"); err != ErrNotFound {
+		t.Errorf("GetUser of a reaped user = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,86 @@
+package learn
+
+import "time"
+
+// ChangeType identifies the kind of change made to a user.
+type ChangeType int
+
+const (
+	// UserCreated is a user being created, or replaced by one with the same
+	// id.
+	UserCreated ChangeType = iota
+
+	// UserExpired is a user being deleted because its ExpiresAt passed.
+	UserExpired
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case UserCreated:
+		return "created"
+	case UserExpired:
+		return "expired"
+	}
+
+	return "unknown"
+}
+
+// Change describes a single change made to the users in a store.
+type Change struct {
+	// Seq orders the changes of a store. It starts at 1 and increases by one
+	// with every change.
+	Seq  uint64
+	Type ChangeType
+	User *User
+	Time time.Time
+
+	// Hard is set on deletions that removed the user outright, rather than
+	// keeping it as a soft deleted record.
+	Hard bool
+
+	// Repair is set on changes made by anti-entropy to bring a store back in
+	// line with its peer. They aren't part of the history of the store, so
+	// they aren't numbered, and their Seq is zero.
+	Repair bool
+}
+
+// Publisher is implemented by services that publish the changes made to
+// their users.
+type Publisher interface {
+	// Subscribe calls fn with every subsequent change, in order. fn is
+	// called while the change is being made, so it must not block or call
+	// back into the service. The returned function ends the subscription.
+	Subscribe(fn func(Change)) (cancel func())
+}
+
+func (s *basicService) Subscribe(fn func(Change)) (cancel func()) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	id := s.nextSub
+	s.nextSub++
+	s.subscribers[id] = fn
+
+	return func() {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+
+		delete(s.subscribers, id)
+	}
+}
+
+// publish numbers a change, unless it is a repair, and hands it to the
+// subscribers. It must be called with the mutex held.
+func (s *basicService) publish(c Change) {
+	if !c.Repair {
+		s.seq++
+		c.Seq = s.seq
+	}
+	if c.Time.IsZero() {
+		c.Time = time.Now()
+	}
+
+	for _, fn := range s.subscribers {
+		fn(c)
+	}
+}
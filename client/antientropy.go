@@ -0,0 +1,61 @@
+package client
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/briankassouf/learn"
+	"github.com/briankassouf/learn/pb"
+)
+
+// NewMerklePeer returns the Merkle tree of the remote instance, for
+// anti-entropy to compare with a local one. It is the responsibility of the
+// caller to dial, and later close, the connection. Of the options, only
+// those providing a token apply.
+func NewMerklePeer(conn *grpc.ClientConn, options ...Option) learn.MerklePeer {
+	return merklePeer{
+		client: pb.NewUserServiceClient(conn),
+		tokens: newClientConfig(options).tokens,
+	}
+}
+
+type merklePeer struct {
+	client pb.UserServiceClient
+	tokens TokenSource
+}
+
+func (p merklePeer) MerkleNodes(ctx context.Context, level int, indices []int) ([][]byte, error) {
+	req := &pb.MerkleNodesRequest{Level: uint32(level)}
+	for _, index := range indices {
+		req.Indices = append(req.Indices, uint32(index))
+	}
+
+	ctx, err := signContext(ctx, p.tokens)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := p.client.GetMerkleNodes(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Hashes, nil
+}
+
+func (p merklePeer) MerkleBucket(ctx context.Context, bucket int) (live, deleted []*learn.User, err error) {
+	ctx, err = signContext(ctx, p.tokens)
+	if err != nil {
+		return nil, nil, err
+	}
+	reply, err := p.client.GetMerkleBucket(ctx, &pb.MerkleBucketRequest{Bucket: uint32(bucket)})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, u := range reply.Live {
+		live = append(live, learn.DecodeGRPCUser(u))
+	}
+	for _, u := range reply.Deleted {
+		deleted = append(deleted, learn.DecodeGRPCUser(u))
+	}
+	return live, deleted, nil
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/briankassouf/learn"
+)
+
+// nopCounter is a metrics.Counter that drops what it counts.
+type nopCounter struct{}
+
+func (c nopCounter) Name() string                       { return "test" }
+func (c nopCounter) With(metrics.Field) metrics.Counter { return c }
+func (c nopCounter) Add(uint64)                         {}
+
+// nopHistogram is a metrics.TimeHistogram that drops its observations.
+type nopHistogram struct{}
+
+func (h nopHistogram) With(metrics.Field) metrics.TimeHistogram { return h }
+func (h nopHistogram) Observe(time.Duration)                    {}
+
+func TestMerklePeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leader := startNode(t, ctx, nil, 0)
+	defer leader.stop()
+	for _, id := range []string{"a", "b", "c"} {
+		leader.store.CreateUser(ctx, &learn.User{Id: id})
+	}
+	follower := startNode(t, ctx, leader, 0)
+	defer follower.stop()
+	waitForSeq(t, follower, 3)
+
+	// The follower loses a user that replication won't send again.
+	follower.store.(learn.Repairer).RepairUsers(func(id string) bool { return id == "c" }, nil, nil)
+
+	ae := learn.NewAntiEntropy(follower.tree, NewMerklePeer(leader.conn, SigningKey(testSecret)), time.Hour, nopCounter{}, nopCounter{}, nopHistogram{}, log.NewNopLogger())
+	stats, err := ae.Repair(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Buckets != 1 || stats.Users != 1 {
+		t.Errorf("stats = %+v, want 1 bucket and 1 user", stats)
+	}
+	if _, err := follower.store.GetUser(ctx, "c"); err != nil {
+		t.Error(err)
+	}
+
+	// The tree, soft deleted users and all, is only served to callers with
+	// a token.
+	peer := NewMerklePeer(leader.conn)
+	if _, err := peer.MerkleNodes(ctx, 0, []int{0}); grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("MerkleNodes without a token = %v", err)
+	}
+	if _, _, err := peer.MerkleBucket(ctx, 0); grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("MerkleBucket without a token = %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/briankassouf/learn"
+	"github.com/briankassouf/learn/pb"
+	"github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// NewAuthHTTP returns an AuthService backed by an HTTP server living at the
+// remote instance, with its methods set up as described by NewHTTP. Logging
+// in, refreshing and the MFA operations taking a code are never retried,
+// since refresh tokens and codes only work once.
+func NewAuthHTTP(instance string, logger log.Logger, options ...Option) (learn.AuthService, error) {
+	config := newClientConfig(options)
+	u, err := config.baseURL(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	transportOptions := []httptransport.ClientOption{
+		httptransport.ClientBefore(jwt.FromHTTPContext()),
+		httptransport.SetClient(config.client()),
+	}
+	method := func(name, path string, idempotent func(context.Context) bool) endpoint.Endpoint {
+		return config.endpoint(name, httptransport.NewClient(
+			"POST",
+			copyURL(u, path),
+			learn.EncodeHTTPGenericRequest,
+			learn.DecodeHTTPAuthResponse,
+			transportOptions...,
+		).Endpoint(), idempotent)
+	}
+
+	return learn.AuthEndpoints{
+		AuthenticateEndpoint:   method("Authenticate", "/auth/login", never),
+		RefreshEndpoint:        method("Refresh", "/auth/refresh", never),
+		SetPasswordEndpoint:    method("SetPassword", "/auth/password", Always),
+		ListSessionsEndpoint:   method("ListSessions", "/auth/sessions", Always),
+		RevokeSessionEndpoint:  method("RevokeSession", "/auth/sessions/revoke", Always),
+		RevokeSessionsEndpoint: method("RevokeSessions", "/auth/sessions/revoke-all", Always),
+		VerifyMFAEndpoint:      method("VerifyMFA", "/auth/mfa/verify", never),
+		EnrollMFAEndpoint:      method("EnrollMFA", "/auth/mfa/enroll", Always),
+		ConfirmMFAEndpoint:     method("ConfirmMFA", "/auth/mfa/confirm", never),
+		DisableMFAEndpoint:     method("DisableMFA", "/auth/mfa/disable", never),
+		UnlockEndpoint:         method("Unlock", "/auth/unlock", Always),
+	}, nil
+}
+
+// NewAuth returns an AuthService backed by a gRPC client connection, with
+// its methods set up as described by NewAuthHTTP. It is the responsibility
+// of the caller to dial, and later close, the connection.
+func NewAuth(conn *grpc.ClientConn, options ...Option) learn.AuthService {
+	config := newClientConfig(options)
+	transportOptions := []grpctransport.ClientOption{
+		grpctransport.ClientBefore(jwt.FromGRPCContext()),
+	}
+	method := func(name string, enc grpctransport.EncodeRequestFunc, idempotent func(context.Context) bool) endpoint.Endpoint {
+		return config.endpoint(name, grpctransport.NewClient(
+			conn,
+			"AuthService",
+			name,
+			enc,
+			learn.DecodeGRPCAuthResponse,
+			pb.AuthResponse{},
+			transportOptions...,
+		).Endpoint(), idempotent)
+	}
+
+	return learn.AuthEndpoints{
+		AuthenticateEndpoint:   method("Authenticate", learn.EncodeGRPCAuthenticateRequest, never),
+		RefreshEndpoint:        method("Refresh", learn.EncodeGRPCRefreshRequest, never),
+		SetPasswordEndpoint:    method("SetPassword", learn.EncodeGRPCSetPasswordRequest, Always),
+		ListSessionsEndpoint:   method("ListSessions", learn.EncodeGRPCSessionsRequest, Always),
+		RevokeSessionEndpoint:  method("RevokeSession", learn.EncodeGRPCSessionsRequest, Always),
+		RevokeSessionsEndpoint: method("RevokeSessions", learn.EncodeGRPCSessionsRequest, Always),
+		VerifyMFAEndpoint:      method("VerifyMFA", learn.EncodeGRPCVerifyMFARequest, never),
+		EnrollMFAEndpoint:      method("EnrollMFA", learn.EncodeGRPCMFARequest, Always),
+		ConfirmMFAEndpoint:     method("ConfirmMFA", learn.EncodeGRPCMFARequest, never),
+		DisableMFAEndpoint:     method("DisableMFA", learn.EncodeGRPCMFARequest, never),
+		UnlockEndpoint:         method("Unlock", learn.EncodeGRPCUnlockRequest, Always),
+	}
+}
+
+// ErrMFARequired is returned by the TokenSource of SessionTokens for a user
+// enrolled in MFA, whose sessions can't be started without a code.
+var ErrMFARequired = errors.New("User is enrolled in MFA")
+
+// SessionTokens returns a TokenSource of the access tokens of a session of
+// the user with username and password. The session is started on first use,
+// and refreshed a minute before its access token expires. If the session
+// ends, such as when it is revoked, a new one is started. It is meant for
+// accounts not enrolled in MFA.
+func SessionTokens(auth learn.AuthService, username, password string) TokenSource {
+	s := &sessionTokens{auth: auth, username: username, password: password}
+	return RefreshingTokens(s.token, time.Minute)
+}
+
+type sessionTokens struct {
+	auth               learn.AuthService
+	username, password string
+
+	// refresh needs no lock, as RefreshingTokens makes one call of token at
+	// a time.
+	refresh string
+}
+
+func (s *sessionTokens) token(ctx context.Context) (string, error) {
+	if s.refresh != "" {
+		tokens, err := s.auth.Refresh(ctx, s.refresh)
+		if err == nil {
+			s.refresh = tokens.RefreshToken
+			return tokens.AccessToken, nil
+		}
+		if !learn.IsServiceError(err) {
+			return "", err
+		}
+		// The session ended, so start another.
+		s.refresh = ""
+	}
+
+	tokens, err := s.auth.Authenticate(ctx, s.username, s.password)
+	if err != nil {
+		return "", err
+	}
+	if tokens.Challenge != "" {
+		return "", ErrMFARequired
+	}
+	s.refresh = tokens.RefreshToken
+	return tokens.AccessToken, nil
+}
@@ -0,0 +1,338 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/briankassouf/learn"
+	"github.com/briankassouf/learn/pb"
+	"github.com/go-kit/kit/log"
+)
+
+// newAuthService returns an AuthService in which ann (u1) and bob (u2) have
+// the passwords "Correct-Horse-1" and "Battery-Staple-2", with a Verifier of
+// its access tokens.
+func newAuthService(t *testing.T, config learn.AuthConfig) (learn.AuthService, *learn.Verifier) {
+	ctx := context.Background()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := learn.NewIssuer(priv, "k1", "learn", "apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny := learn.NewDenylist()
+	v := learn.NewVerifier(issuer.Keys(learn.SharedSecret(nil)), "learn", "apps", 0)
+	v.UseDenylist(deny)
+
+	users := learn.NewBasicService()
+	users.CreateUser(ctx, &learn.User{Id: "u1", Username: "ann"})
+	users.CreateUser(ctx, &learn.User{Id: "u2", Username: "bob"})
+	config.Issuer, config.Denylist = issuer, deny
+	config.AccessTTL, config.RefreshTTL = time.Minute, time.Hour
+	svc := learn.NewAuthService(users, config)
+
+	admin := learn.WithPrincipal(ctx, &learn.Principal{ID: "ops", Kind: learn.ServicePrincipal, Roles: []string{learn.AdminRole}})
+	for id, password := range map[string]string{"u1": "Correct-Horse-1", "u2": "Battery-Staple-2"} {
+		if err := svc.SetPassword(admin, id, password); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return svc, v
+}
+
+func TestAuthHTTP(t *testing.T) {
+	ctx := context.Background()
+	svc, v := newAuthService(t, learn.AuthConfig{})
+	srv := httptest.NewServer(learn.MakeAuthHTTPHandler(ctx, learn.MakeAuthEndpoints(svc), v, log.NewNopLogger()))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/auth/sessions", "application/json", strings.NewReader(`{"user_id":"u1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthenticated listing = %d, want 401", resp.StatusCode)
+	}
+
+	anonymous, err := NewAuthHTTP(srv.URL, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anonymous.Authenticate(ctx, "ann", "wrong"); err != learn.ErrInvalidCredentials {
+		t.Errorf("wrong password = %v, want learn.ErrInvalidCredentials", err)
+	}
+	tokens, err := anonymous.Authenticate(ctx, "bob", "Battery-Staple-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, _ := NewAuthHTTP(srv.URL, log.NewNopLogger(), Tokens(StaticTokens(tokens.AccessToken)))
+	sessions, err := bob.ListSessions(ctx, "u2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != tokens.SessionID {
+		t.Errorf("sessions = %+v, want the one of bob", sessions)
+	}
+	if _, err := bob.ListSessions(ctx, "u1"); err != learn.ErrForbidden {
+		t.Errorf("listing the sessions of ann = %v, want learn.ErrForbidden", err)
+	}
+
+	// Reusing a refresh token ends the session, and with it the access
+	// token.
+	if _, err := anonymous.Refresh(ctx, tokens.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anonymous.Refresh(ctx, tokens.RefreshToken); err != learn.ErrRefreshTokenReused {
+		t.Errorf("reused refresh token = %v, want learn.ErrRefreshTokenReused", err)
+	}
+	if _, err := bob.ListSessions(ctx, "u2"); err == nil {
+		t.Error("the access token of a revoked session was accepted")
+	}
+}
+
+func TestAuthGRPC(t *testing.T) {
+	ctx := context.Background()
+	svc, v := newAuthService(t, learn.AuthConfig{})
+	conn, stop := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterAuthServiceServer(s, learn.MakeGRPCAuthServer(ctx, learn.MakeAuthEndpoints(svc), v, log.NewNopLogger()))
+	})
+	defer stop()
+
+	if _, err := NewAuth(conn).Authenticate(ctx, "bob", "wrong"); err != learn.ErrInvalidCredentials {
+		t.Errorf("wrong password = %v, want learn.ErrInvalidCredentials", err)
+	}
+
+	// SessionTokens starts a session, and a new one once it is revoked.
+	ts := SessionTokens(NewAuth(conn), "bob", "Battery-Staple-2")
+	token, err := ts.Token(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob := NewAuth(conn, Tokens(ts))
+	sessions, err := bob.ListSessions(ctx, "u2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Created.IsZero() {
+		t.Errorf("sessions = %+v, want the one of bob", sessions)
+	}
+	if err := bob.RevokeSessions(ctx, "u2"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewAuth(conn, Tokens(StaticTokens(token))).ListSessions(ctx, "u2")
+	if err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Errorf("revoked token = %v, want it refused as revoked", err)
+	}
+}
+
+func TestAuthMFA(t *testing.T) {
+	ctx := context.Background()
+	svc, v := newAuthService(t, learn.AuthConfig{})
+	endpoints := learn.MakeAuthEndpoints(svc)
+	srv := httptest.NewServer(learn.MakeAuthHTTPHandler(ctx, endpoints, v, log.NewNopLogger()))
+	defer srv.Close()
+	conn, stop := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterAuthServiceServer(s, learn.MakeGRPCAuthServer(ctx, endpoints, v, log.NewNopLogger()))
+	})
+	defer stop()
+
+	anonymous, _ := NewAuthHTTP(srv.URL, log.NewNopLogger())
+	tokens, err := anonymous.Authenticate(ctx, "ann", "Correct-Horse-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Enrolling over HTTP, and confirming over gRPC.
+	ann, _ := NewAuthHTTP(srv.URL, log.NewNopLogger(), Tokens(StaticTokens(tokens.AccessToken)))
+	e, err := ann.EnrollMFA(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := learn.TOTP(e.Secret, time.Now())
+	recovery, err := NewAuth(conn, Tokens(StaticTokens(tokens.AccessToken))).ConfirmMFA(ctx, "u1", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovery) == 0 {
+		t.Fatal("no recovery codes")
+	}
+
+	// Logging in takes a code now, which SessionTokens can't give.
+	tokens, err = NewAuth(conn).Authenticate(ctx, "ann", "Correct-Horse-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Challenge == "" {
+		t.Fatalf("tokens = %+v, want a challenge", tokens)
+	}
+	if _, err := anonymous.VerifyMFA(ctx, tokens.Challenge, "999999"); err != learn.ErrMFAInvalidCode {
+		t.Errorf("wrong code = %v, want learn.ErrMFAInvalidCode", err)
+	}
+	if tokens, err = NewAuth(conn).VerifyMFA(ctx, tokens.Challenge, recovery[0]); err != nil {
+		t.Fatal(err)
+	}
+	if tokens.AccessToken == "" {
+		t.Errorf("tokens = %+v, want a session", tokens)
+	}
+	if _, err := SessionTokens(anonymous, "ann", "Correct-Horse-1").Token(ctx); err != ErrMFARequired {
+		t.Errorf("session tokens of a user enrolled in MFA = %v, want ErrMFARequired", err)
+	}
+	if err := ann.DisableMFA(ctx, "u1", recovery[1]); err != nil {
+		t.Errorf("disabling MFA = %v", err)
+	}
+}
+
+func TestAuthLockout(t *testing.T) {
+	ctx := context.Background()
+	svc, v := newAuthService(t, learn.AuthConfig{
+		Lockout: learn.LockoutPolicy{IPAttempts: 3, Lockout: time.Minute},
+	})
+	srv := httptest.NewServer(learn.MakeAuthHTTPHandler(ctx, learn.MakeAuthEndpoints(svc), v, log.NewNopLogger()))
+	defer srv.Close()
+
+	// Logins are throttled by the address they come from, and refused with
+	// 429 Too Many Requests.
+	anonymous, _ := NewAuthHTTP(srv.URL, log.NewNopLogger())
+	for _, username := range []string{"ann", "bob", "ghost"} {
+		anonymous.Authenticate(ctx, username, "wrong")
+	}
+	resp, err := http.Post(srv.URL+"/auth/login", "application/json", strings.NewReader(`{"username":"ann","password":"Correct-Horse-1"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("login from a locked out address = %d, want 429", resp.StatusCode)
+	}
+	if _, err := anonymous.Authenticate(ctx, "ann", "Correct-Horse-1"); err != learn.ErrLockedOut {
+		t.Errorf("login from a locked out address = %v, want learn.ErrLockedOut", err)
+	}
+
+	tokens, _ := svc.Authenticate(ctx, "ann", "Correct-Horse-1")
+	ann, _ := NewAuthHTTP(srv.URL, log.NewNopLogger(), Tokens(StaticTokens(tokens.AccessToken)))
+	if err := ann.Unlock(ctx, "", "127.0.0.1"); err != learn.ErrForbidden {
+		t.Errorf("unlock by a user = %v, want learn.ErrForbidden", err)
+	}
+}
+
+func TestAuthLockoutGRPC(t *testing.T) {
+	ctx := context.Background()
+	var (
+		mtx    sync.Mutex
+		events []learn.AuthEvent
+	)
+	svc, v := newAuthService(t, learn.AuthConfig{
+		Lockout: learn.LockoutPolicy{IPAttempts: 3, Lockout: time.Minute},
+		Audit: func(e learn.AuthEvent) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			events = append(events, e)
+		},
+	})
+	conn, stop := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterAuthServiceServer(s, learn.MakeGRPCAuthServer(ctx, learn.MakeAuthEndpoints(svc), v, log.NewNopLogger()))
+	})
+	defer stop()
+
+	// gRPC logins are throttled by the address of their connection too.
+	client := NewAuth(conn)
+	for _, username := range []string{"ann", "bob", "ghost"} {
+		if _, err := client.Authenticate(ctx, username, "wrong"); err != learn.ErrInvalidCredentials {
+			t.Errorf("%s: wrong password = %v, want learn.ErrInvalidCredentials", username, err)
+		}
+	}
+	if _, err := client.Authenticate(ctx, "ann", "Correct-Horse-1"); err != learn.ErrLockedOut {
+		t.Errorf("login from a locked out address = %v, want learn.ErrLockedOut", err)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	locked := 0
+	for _, e := range events {
+		if e.IP != "127.0.0.1" {
+			t.Errorf("event %+v, want the address of the connection", e)
+		}
+		if e.Type == learn.IPLocked {
+			locked++
+		}
+	}
+	if locked != 1 {
+		t.Errorf("%d IP lockout events, want 1", locked)
+	}
+}
+
+func TestAuthPasswordPolicy(t *testing.T) {
+	ctx := context.Background()
+	svc, v := newAuthService(t, learn.AuthConfig{
+		PasswordPolicy: learn.PasswordPolicy{MinLength: 10, MinClasses: 3, NoUserInfo: true},
+	})
+	endpoints := learn.MakeAuthEndpoints(svc)
+	srv := httptest.NewServer(learn.MakeAuthHTTPHandler(ctx, endpoints, v, log.NewNopLogger()))
+	defer srv.Close()
+	conn, stop := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterAuthServiceServer(s, learn.MakeGRPCAuthServer(ctx, endpoints, v, log.NewNopLogger()))
+	})
+	defer stop()
+	tokens, err := svc.Authenticate(ctx, "ann", "Correct-Horse-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Violations are 422 Unprocessable Entity over HTTP, listing the rules.
+	req, _ := http.NewRequest("POST", srv.URL+"/auth/password", strings.NewReader(`{"user_id":"u1","password":"ann"}`))
+	req.Header.Set("Authorization", "Bearer "+tokens.AccessToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 422 || !strings.Contains(string(body), `"rule":"contains_username"`) {
+		t.Errorf("refused password got %d %s, want 422 with the rules", resp.StatusCode, body)
+	}
+
+	// Both clients return them as a *learn.PasswordPolicyError.
+	for name, svc := range map[string]learn.AuthService{
+		"HTTP": mustAuthHTTP(t, srv.URL, Tokens(StaticTokens(tokens.AccessToken))),
+		"gRPC": NewAuth(conn, Tokens(StaticTokens(tokens.AccessToken))),
+	} {
+		err := svc.SetPassword(ctx, "u1", "short")
+		perr, ok := err.(*learn.PasswordPolicyError)
+		if !ok {
+			t.Errorf("%s: refused password = %v, want a *learn.PasswordPolicyError", name, err)
+			continue
+		}
+		var rules []string
+		for _, v := range perr.Violations {
+			rules = append(rules, v.Rule)
+		}
+		if got := strings.Join(rules, ","); got != "too_short,too_few_classes" {
+			t.Errorf("%s: rules = %s, want too_short,too_few_classes", name, got)
+		}
+	}
+	if err := NewAuth(conn, Tokens(StaticTokens(tokens.AccessToken))).SetPassword(ctx, "u1", "Correct7Horse"); err != nil {
+		t.Errorf("SetPassword = %v", err)
+	}
+}
+
+func mustAuthHTTP(t *testing.T, instance string, options ...Option) learn.AuthService {
+	svc, err := NewAuthHTTP(instance, log.NewNopLogger(), options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
@@ -0,0 +1,457 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/briankassouf/learn"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+)
+
+// ErrNoInstances is returned by a balanced client when service discovery has
+// found no instance to call.
+var ErrNoInstances = errors.New("No instances available")
+
+// Instancer is a source of the instances of learnd, typically a service
+// discovery system. Instances are of the form "host:port".
+type Instancer interface {
+	Instances() ([]string, error)
+}
+
+// StaticInstancer is a fixed list of instances.
+type StaticInstancer []string
+
+func (s StaticInstancer) Instances() ([]string, error) {
+	return s, nil
+}
+
+// pollingInstancer keeps the instances returned by lookup, calling it again
+// every interval. A failed lookup keeps the instances of the last one that
+// succeeded.
+type pollingInstancer struct {
+	lookup func() ([]string, error)
+	logger log.Logger
+	quit   chan struct{}
+
+	mtx       sync.RWMutex
+	instances []string
+	err       error
+}
+
+func newPollingInstancer(lookup func() ([]string, error), interval time.Duration, logger log.Logger) *pollingInstancer {
+	p := &pollingInstancer{
+		lookup: lookup,
+		logger: logger,
+		quit:   make(chan struct{}),
+	}
+	p.instances, p.err = lookup()
+	if p.err != nil {
+		logger.Log("err", p.err)
+	}
+
+	go p.loop(interval)
+	return p
+}
+
+func (p *pollingInstancer) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			instances, err := p.lookup()
+			if err != nil {
+				p.logger.Log("err", err)
+			}
+
+			p.mtx.Lock()
+			if err == nil || p.instances == nil {
+				p.instances, p.err = instances, err
+			}
+			p.mtx.Unlock()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *pollingInstancer) Instances() ([]string, error) {
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+
+	return p.instances, p.err
+}
+
+// Stop stops polling. The instances found last are kept.
+func (p *pollingInstancer) Stop() {
+	close(p.quit)
+}
+
+// DNSSRVInstancer finds instances with DNS SRV lookups.
+type DNSSRVInstancer struct {
+	*pollingInstancer
+}
+
+// NewDNSSRVInstancer returns an Instancer that resolves the SRV records of
+// name, such as "_grpc._tcp.learnd.service.consul", immediately and then
+// every ttl, until it is stopped.
+func NewDNSSRVInstancer(name string, ttl time.Duration, logger log.Logger) *DNSSRVInstancer {
+	lookup := func() ([]string, error) {
+		_, addrs, err := net.LookupSRV("", "", name)
+		if err != nil {
+			return nil, err
+		}
+
+		instances := make([]string, len(addrs))
+		for i, addr := range addrs {
+			host := strings.TrimSuffix(addr.Target, ".")
+			instances[i] = net.JoinHostPort(host, strconv.Itoa(int(addr.Port)))
+		}
+		return instances, nil
+	}
+
+	return &DNSSRVInstancer{newPollingInstancer(lookup, ttl, logger)}
+}
+
+// FileInstancer reads instances from a file, one per line. Blank lines and
+// lines starting with # are ignored.
+type FileInstancer struct {
+	*pollingInstancer
+}
+
+// NewFileInstancer returns an Instancer that reads the file at path
+// immediately, and again whenever it changes, checking every interval until
+// it is stopped. Files are replaced atomically by renaming them into place,
+// so that a half-written file is never read.
+func NewFileInstancer(path string, interval time.Duration, logger log.Logger) *FileInstancer {
+	var (
+		modTime   time.Time
+		size      int64
+		instances []string
+	)
+	lookup := func() ([]string, error) {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if instances != nil && fi.ModTime().Equal(modTime) && fi.Size() == size {
+			return instances, nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		found := []string{}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			found = append(found, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+
+		modTime, size, instances = fi.ModTime(), fi.Size(), found
+		return instances, nil
+	}
+
+	return &FileInstancer{newPollingInstancer(lookup, interval, logger)}
+}
+
+// Balancing is the strategy a balanced client uses to pick the instance for
+// each call.
+type Balancing int
+
+const (
+	// RoundRobin calls the instances in turn.
+	RoundRobin Balancing = iota
+
+	// Random calls an instance chosen at random.
+	Random
+
+	// LeastOutstanding calls the instance with the fewest calls in flight
+	// from this client, across all methods.
+	LeastOutstanding
+)
+
+func (b Balancing) String() string {
+	switch b {
+	case Random:
+		return "random"
+	case LeastOutstanding:
+		return "least-outstanding"
+	}
+
+	return "round-robin"
+}
+
+// NewBalanced returns a UserService that calls the gRPC servers found by
+// instancer, picking one for each call with balancing. A call that fails is
+// retried against other instances, up to retryMax attempts in all and within
+// retryTimeout, unless it failed with an error of the service itself. As
+// with Retry, gets are always retried, CreateUser only if it carries an
+// idempotency key, and BatchCreateUsers never. If hedge isn't nil, gets are
+// also hedged with it.
+// Connections are dialed to new instances as they are found, and closed when
+// their instance goes away. The client of each instance is made by New with
+// options, though it never retries itself, so rate limits and breakers apply
+// to each instance on its own.
+func NewBalanced(instancer Instancer, balancing Balancing, hedge *HedgePolicy, retryMax int, retryTimeout time.Duration, logger log.Logger, options ...Option) learn.UserService {
+	// The balancer retries against other instances instead.
+	options = append(options[:len(options):len(options)], Retries(RetryPolicy{MaxAttempts: 1}))
+	security := newClientConfig(options).dialOption()
+	factory := func(instance string) (learn.Endpoints, io.Closer, error) {
+		conn, err := grpc.Dial(instance, security)
+		if err != nil {
+			return learn.Endpoints{}, nil, err
+		}
+		return New(conn, options...).(learn.Endpoints), conn, nil
+	}
+
+	return newBalancer(instancer, balancing, hedge, factory, logger).endpoints(retryMax, retryTimeout)
+}
+
+// NewBalancedHTTP is like NewBalanced, for HTTP servers, with the clients
+// made by NewHTTP.
+func NewBalancedHTTP(instancer Instancer, balancing Balancing, hedge *HedgePolicy, retryMax int, retryTimeout time.Duration, logger log.Logger, options ...Option) learn.UserService {
+	options = append(options[:len(options):len(options)], Retries(RetryPolicy{MaxAttempts: 1}))
+	factory := func(instance string) (learn.Endpoints, io.Closer, error) {
+		svc, err := NewHTTP(instance, logger, options...)
+		if err != nil {
+			return learn.Endpoints{}, nil, err
+		}
+		return svc.(learn.Endpoints), nil, nil
+	}
+
+	return newBalancer(instancer, balancing, hedge, factory, logger).endpoints(retryMax, retryTimeout)
+}
+
+// instance is a single instance known to a balancer.
+type instance struct {
+	// outstanding is the number of calls in flight, updated atomically. It
+	// comes first to be 64-bit aligned.
+	outstanding int64
+
+	addr      string
+	endpoints learn.Endpoints
+	closer    io.Closer
+}
+
+type balancer struct {
+	instancer Instancer
+	balancing Balancing
+	hedge     *HedgePolicy
+	latencies *latencies
+	factory   func(instance string) (learn.Endpoints, io.Closer, error)
+	logger    log.Logger
+
+	mtx       sync.Mutex
+	instances map[string]*instance
+	sorted    []*instance
+	next      int
+	rand      *rand.Rand
+}
+
+func newBalancer(instancer Instancer, balancing Balancing, hedge *HedgePolicy, factory func(string) (learn.Endpoints, io.Closer, error), logger log.Logger) *balancer {
+	b := &balancer{
+		instancer: instancer,
+		balancing: balancing,
+		hedge:     hedge,
+		factory:   factory,
+		logger:    logger,
+		instances: make(map[string]*instance),
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if hedge != nil && hedge.Percentile > 0 {
+		b.latencies = newLatencies(hedge.Percentile)
+	}
+	return b
+}
+
+// endpoints returns the endpoints of the balanced client, each retrying as
+// described by NewBalanced.
+func (b *balancer) endpoints(retryMax int, retryTimeout time.Duration) learn.Endpoints {
+	return learn.Endpoints{
+		CreateUserEndpoint:       b.retry(func(e learn.Endpoints) endpoint.Endpoint { return e.CreateUserEndpoint }, HasIdempotencyKey, false, retryMax, retryTimeout),
+		GetUserEndpoint:          b.retry(func(e learn.Endpoints) endpoint.Endpoint { return e.GetUserEndpoint }, Always, true, retryMax, retryTimeout),
+		BatchCreateUsersEndpoint: b.retry(func(e learn.Endpoints) endpoint.Endpoint { return e.BatchCreateUsersEndpoint }, never, false, retryMax, retryTimeout),
+		BatchGetUsersEndpoint:    b.retry(func(e learn.Endpoints) endpoint.Endpoint { return e.BatchGetUsersEndpoint }, Always, true, retryMax, retryTimeout),
+	}
+}
+
+// retry returns an endpoint calling the method endpoint of the instances
+// picked by the balancer, trying instances that weren't tried yet after each
+// failure if idempotent allows it. Calls are hedged if hedged is set and the
+// balancer has a HedgePolicy.
+func (b *balancer) retry(method func(learn.Endpoints) endpoint.Endpoint, idempotent func(context.Context) bool, hedged bool, retryMax int, retryTimeout time.Duration) endpoint.Endpoint {
+	call := b.call
+	if hedged && b.hedge != nil {
+		call = b.callHedged
+	}
+
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, retryTimeout)
+		defer cancel()
+
+		tried := make(map[string]bool)
+		var err error
+		for i := 0; i < retryMax || i == 0; i++ {
+			var response interface{}
+			var called []string
+			response, called, err = call(ctx, method, request, tried)
+			if len(called) == 0 {
+				return nil, err
+			}
+
+			if err == nil || learn.IsServiceError(err) || !idempotent(ctx) {
+				return response, err
+			}
+			for _, addr := range called {
+				tried[addr] = true
+			}
+
+			if ctx.Err() != nil {
+				break
+			}
+		}
+
+		return nil, err
+	}
+}
+
+// call calls the method endpoint of an instance picked by the balancer,
+// returning the address of the instance it called.
+func (b *balancer) call(ctx context.Context, method func(learn.Endpoints) endpoint.Endpoint, request interface{}, tried map[string]bool) (interface{}, []string, error) {
+	inst, err := b.pick(tried)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	atomic.AddInt64(&inst.outstanding, 1)
+	defer atomic.AddInt64(&inst.outstanding, -1)
+
+	response, err := method(inst.endpoints)(ctx, request)
+	return response, []string{inst.addr}, err
+}
+
+func never(context.Context) bool { return false }
+
+// pick returns the instance for the next call, preferring instances that
+// aren't in tried.
+func (b *balancer) pick(tried map[string]bool) (*instance, error) {
+	addrs, err := b.instancer.Instances()
+	if err != nil && len(addrs) == 0 {
+		return nil, err
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.update(addrs)
+	if len(b.sorted) == 0 {
+		return nil, ErrNoInstances
+	}
+
+	candidates := make([]*instance, 0, len(b.sorted))
+	for _, inst := range b.sorted {
+		if !tried[inst.addr] {
+			candidates = append(candidates, inst)
+		}
+	}
+	if len(candidates) == 0 {
+		// Every instance failed once already, so any of them may do.
+		candidates = b.sorted
+	}
+
+	// Only first attempts take a turn, so that retries and hedges don't skew
+	// which instance the next call goes to.
+	if len(tried) == 0 {
+		b.next++
+	}
+
+	switch b.balancing {
+	case Random:
+		return candidates[b.rand.Intn(len(candidates))], nil
+	case LeastOutstanding:
+		// Ties go to the instances in turn, so that an idle client still
+		// spreads its calls.
+		var least *instance
+		for i := range candidates {
+			inst := candidates[(b.next+i)%len(candidates)]
+			if least == nil || atomic.LoadInt64(&inst.outstanding) < atomic.LoadInt64(&least.outstanding) {
+				least = inst
+			}
+		}
+		return least, nil
+	default:
+		return candidates[b.next%len(candidates)], nil
+	}
+}
+
+// update makes the known instances those in addrs, making endpoints for new
+// instances and closing the instances that are gone. It must be called with
+// the mutex held.
+func (b *balancer) update(addrs []string) {
+	current := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		current[addr] = true
+	}
+
+	changed := false
+	for addr, inst := range b.instances {
+		if current[addr] {
+			continue
+		}
+		if inst.closer != nil {
+			inst.closer.Close()
+		}
+		delete(b.instances, addr)
+		changed = true
+	}
+	for addr := range current {
+		if _, ok := b.instances[addr]; ok {
+			continue
+		}
+		endpoints, closer, err := b.factory(addr)
+		if err != nil {
+			b.logger.Log("instance", addr, "err", err)
+			continue
+		}
+		b.instances[addr] = &instance{addr: addr, endpoints: endpoints, closer: closer}
+		changed = true
+	}
+
+	if changed {
+		b.sorted = b.sorted[:0]
+		for _, inst := range b.instances {
+			b.sorted = append(b.sorted, inst)
+		}
+		sort.Sort(byAddr(b.sorted))
+	}
+}
+
+type byAddr []*instance
+
+func (s byAddr) Len() int           { return len(s) }
+func (s byAddr) Less(i, j int) bool { return s[i].addr < s[j].addr }
+func (s byAddr) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/briankassouf/learn"
+	"github.com/briankassouf/learn/pb"
+	"github.com/go-kit/kit/log"
+)
+
+// deadAddr is an address nothing listens on.
+const deadAddr = "127.0.0.1:1"
+
+// listenGRPC serves svc over gRPC on a local port, and returns its address
+// and a func that stops it.
+func listenGRPC(t *testing.T, svc learn.UserService) (string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoints := learn.Endpoints{
+		CreateUserEndpoint:       learn.MakeCreateUserEndpoint(svc),
+		GetUserEndpoint:          learn.MakeGetUserEndpoint(svc),
+		BatchCreateUsersEndpoint: learn.MakeBatchCreateUsersEndpoint(svc),
+		BatchGetUsersEndpoint:    learn.MakeBatchGetUsersEndpoint(svc),
+	}
+	s := grpc.NewServer()
+	pb.RegisterUserServiceServer(s, learn.MakeGRPCServer(context.Background(), endpoints, nil, nil, nil, nil, nil, log.NewNopLogger()))
+	go s.Serve(ln)
+	return ln.Addr().String(), s.Stop
+}
+
+func TestBalanced(t *testing.T) {
+	ctx := context.Background()
+
+	store := learn.NewBasicService()
+	addr1, stop1 := listenGRPC(t, store)
+	defer stop1()
+	addr2, stop2 := listenGRPC(t, store)
+	defer stop2()
+
+	// Calls skip the dead instance, whichever way they are balanced.
+	for _, balancing := range []Balancing{RoundRobin, Random, LeastOutstanding} {
+		svc := NewBalanced(StaticInstancer{deadAddr, addr1, addr2}, balancing, nil, 3, 5*time.Second, log.NewNopLogger())
+		id := "u-" + balancing.String()
+		for i := 0; i < 10; i++ {
+			u, err := svc.CreateUser(learn.WithIdempotencyKey(ctx, id), &learn.User{Id: id})
+			if err != nil {
+				t.Fatalf("%s: %v", balancing, err)
+			}
+			if u.Id != id {
+				t.Errorf("%s: created %s, want %s", balancing, u.Id, id)
+			}
+		}
+		if _, err := svc.GetUser(ctx, id); err != nil {
+			t.Errorf("%s: %v", balancing, err)
+		}
+	}
+
+	svc := NewBalanced(StaticInstancer{}, RoundRobin, nil, 3, time.Second, log.NewNopLogger())
+	if _, err := svc.GetUser(ctx, "u"); err != ErrNoInstances {
+		t.Errorf("GetUser without instances = %v, want ErrNoInstances", err)
+	}
+}
+
+func TestBalancedErrors(t *testing.T) {
+	ctx := context.Background()
+
+	var calls int64
+	endpoints := learn.Endpoints{
+		GetUserEndpoint: func(context.Context, interface{}) (interface{}, error) {
+			atomic.AddInt64(&calls, 1)
+			return nil, learn.ErrNotFound
+		},
+		BatchCreateUsersEndpoint: func(context.Context, interface{}) (interface{}, error) {
+			atomic.AddInt64(&calls, 1)
+			return nil, io.ErrUnexpectedEOF
+		},
+	}
+	factory := func(string) (learn.Endpoints, io.Closer, error) { return endpoints, nil, nil }
+	svc := newBalancer(StaticInstancer{"a", "b"}, RoundRobin, nil, factory, log.NewNopLogger()).endpoints(3, time.Second)
+
+	// Errors of the service aren't retried on other instances.
+	if _, err := svc.GetUser(ctx, "u"); err != learn.ErrNotFound || calls != 1 {
+		t.Errorf("GetUser = %v after %d calls, want ErrNotFound after 1", err, calls)
+	}
+
+	// Neither are batch creates, which may have been applied.
+	calls = 0
+	if _, err := svc.BatchCreateUsers(ctx, []*learn.User{{Id: "u"}}, learn.BestEffort); err == nil || calls != 1 {
+		t.Errorf("BatchCreateUsers = %v after %d calls, want an error after 1", err, calls)
+	}
+}
+
+func TestBalancedLeastOutstanding(t *testing.T) {
+	factory := func(string) (learn.Endpoints, io.Closer, error) { return learn.Endpoints{}, nil, nil }
+	b := newBalancer(StaticInstancer{"a", "b", "c"}, LeastOutstanding, nil, factory, log.NewNopLogger())
+	if _, err := b.pick(nil); err != nil {
+		t.Fatal(err)
+	}
+	b.instances["a"].outstanding = 2
+	b.instances["c"].outstanding = 1
+
+	for i := 0; i < 3; i++ {
+		inst, err := b.pick(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inst.addr != "b" {
+			t.Errorf("picked %s, want b", inst.addr)
+		}
+	}
+	if inst, _ := b.pick(map[string]bool{"b": true}); inst.addr != "c" {
+		t.Errorf("picked %s once b was tried, want c", inst.addr)
+	}
+}
+
+func TestFileInstancer(t *testing.T) {
+	ctx := context.Background()
+
+	store := learn.NewBasicService()
+	store.CreateUser(ctx, &learn.User{Id: "u"})
+	addr1, stop1 := listenGRPC(t, store)
+	defer stop1()
+	addr2, stop2 := listenGRPC(t, store)
+	defer stop2()
+
+	dir, err := ioutil.TempDir("", "instances")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "instances")
+	if err := ioutil.WriteFile(path, []byte("# none yet\n"+deadAddr+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	instancer := NewFileInstancer(path, 10*time.Millisecond, log.NewNopLogger())
+	defer instancer.Stop()
+	svc := NewBalanced(instancer, RoundRobin, nil, 2, time.Second, log.NewNopLogger())
+	if _, err := svc.GetUser(ctx, "u"); err == nil {
+		t.Error("GetUser succeeded with only a dead instance")
+	}
+
+	if err := ioutil.WriteFile(path+".tmp", []byte(addr1+"\n\n"+addr2+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		instances, _ := instancer.Instances()
+		if len(instances) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("instances = %v after 5s, want %s and %s", instances, addr1, addr2)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := svc.GetUser(ctx, "u"); err != nil {
+		t.Error(err)
+	}
+}
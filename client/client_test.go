@@ -0,0 +1,49 @@
+package client
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/briankassouf/learn"
+)
+
+// serveGRPC serves the services that register registers on a local port,
+// and returns a connection to them and a func that stops both.
+func serveGRPC(t *testing.T, register func(*grpc.Server)) (*grpc.ClientConn, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	register(s)
+	go s.Serve(ln)
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		s.Stop()
+		t.Fatal(err)
+	}
+	return conn, func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+// sliceReader reads users from a slice.
+type sliceReader struct {
+	users []*learn.User
+}
+
+func (r *sliceReader) Read() (*learn.User, error) {
+	if len(r.users) == 0 {
+		return nil, io.EOF
+	}
+	u := r.users[0]
+	r.users = r.users[1:]
+	return u, nil
+}
+
+func (r *sliceReader) Close() error { return nil }
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+
+	"github.com/briankassouf/learn"
+	"github.com/go-kit/kit/auth/jwt"
+)
+
+// JoinCluster asks the cluster node whose membership handler lives at
+// instance to add the node id, reachable for Raft at addr. The node asked
+// must be the leader, and the caller an admin. We expect instance to be of
+// the form "host:port". Of the options, only HTTPClient, TLS and those
+// providing a token apply.
+func JoinCluster(ctx context.Context, instance, id, addr string, options ...Option) error {
+	return postClusterMember(ctx, newClientConfig(options), instance, "/cluster/join", learn.ClusterMemberRequest{ID: id, Addr: addr})
+}
+
+// LeaveCluster asks the cluster node whose membership handler lives at
+// instance to remove the node id. The node asked must be the leader, and the
+// caller an admin. Of the options, only HTTPClient, TLS and those providing a
+// token apply.
+func LeaveCluster(ctx context.Context, instance, id string, options ...Option) error {
+	return postClusterMember(ctx, newClientConfig(options), instance, "/cluster/leave", learn.ClusterMemberRequest{ID: id})
+}
+
+func postClusterMember(ctx context.Context, config *clientConfig, instance, path string, req learn.ClusterMemberRequest) error {
+	u, err := config.baseURL(instance)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+		return err
+	}
+
+	r, err := http.NewRequest("POST", copyURL(u, path).String(), &buf)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	if config.tokens != nil {
+		token, err := config.tokens.Token(ctx)
+		if err != nil {
+			return err
+		}
+		jwt.FromHTTPContext()(context.WithValue(ctx, jwt.JWTTokenContextKey, token), r)
+	}
+
+	resp, err := ctxhttp.Do(ctx, config.client(), r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s: %s %s", path, resp.Status, e.Error)
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/briankassouf/learn"
+	"github.com/briankassouf/learn/pb"
+	"github.com/go-kit/kit/auth/jwt"
+)
+
+// Export streams the users matching filter from the remote instance with the
+// server-streaming ExportUsers RPC, calling fn for each of them in id order.
+// Users are received only as fast as fn handles them. If fn returns an
+// error, the export is cancelled and the error returned. It is the
+// responsibility of the caller to dial, and later close, the connection. Of
+// the options, only those providing a token apply.
+func Export(ctx context.Context, conn *grpc.ClientConn, filter learn.ExportFilter, fn func(*learn.User) error, options ...Option) error {
+	_, err := export(ctx, conn, filter, fn, options)
+	return err
+}
+
+// Snapshot exports every user of the remote instance, along with the latest
+// seq of its replication log as of just before the export. It is a
+// learn.SnapshotSource once bound to a connection. Of the options, only
+// those providing a token apply.
+func Snapshot(ctx context.Context, conn *grpc.ClientConn, options ...Option) ([]*learn.User, uint64, error) {
+	var users []*learn.User
+	header, err := export(ctx, conn, learn.ExportFilter{}, func(u *learn.User) error {
+		users = append(users, u)
+		return nil
+	}, options)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	values := header[learn.ReplicationSeqMetadata]
+	if len(values) == 0 {
+		return nil, 0, fmt.Errorf("snapshot: no %s header", learn.ReplicationSeqMetadata)
+	}
+	seq, err := strconv.ParseUint(values[0], 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("snapshot: %v", err)
+	}
+	return users, seq, nil
+}
+
+// export is Export, which also returns the header of the stream.
+func export(ctx context.Context, conn *grpc.ClientConn, filter learn.ExportFilter, fn func(*learn.User) error, options []Option) (metadata.MD, error) {
+	ctx, err := signContext(ctx, newClientConfig(options).tokens)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := pb.NewUserServiceClient(conn).ExportUsers(ctx, learn.EncodeGRPCExportRequest(filter))
+	if err != nil {
+		return nil, err
+	}
+	header, err := stream.Header()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		user, err := stream.Recv()
+		if err == io.EOF {
+			return header, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := fn(learn.DecodeGRPCUser(user)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ExportHTTP is Export over the chunked HTTP export of the remote instance.
+// We expect instance to be of the form "host:port". Of the options, only
+// HTTPClient, TLS and those providing a token apply.
+func ExportHTTP(ctx context.Context, instance string, filter learn.ExportFilter, fn func(*learn.User) error, options ...Option) error {
+	config := newClientConfig(options)
+	u, err := config.baseURL(instance)
+	if err != nil {
+		return err
+	}
+	u = copyURL(u, "/export")
+	learn.EncodeHTTPExportFilter(u, filter)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if config.tokens != nil {
+		token, err := config.tokens.Token(ctx)
+		if err != nil {
+			return err
+		}
+		jwt.FromHTTPContext()(context.WithValue(ctx, jwt.JWTTokenContextKey, token), req)
+	}
+
+	resp, err := ctxhttp.Do(ctx, config.client(), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("export: unexpected status %s", resp.Status)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var user learn.User
+		err := dec.Decode(&user)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/briankassouf/learn"
+	"github.com/briankassouf/learn/pb"
+)
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	s := learn.NewBasicService()
+	for i := 0; i < 250; i++ {
+		domain := "a.com"
+		if i%2 == 0 {
+			domain = "b.com"
+		}
+		s.CreateUser(ctx, &learn.User{Id: fmt.Sprintf("u%03d", i), Email: "x@" + domain})
+	}
+	exporter := s.(learn.Exporter)
+
+	conn, stop := serveGRPC(t, func(srv *grpc.Server) {
+		pb.RegisterUserServiceServer(srv, learn.MakeGRPCServer(ctx, learn.Endpoints{}, nil, exporter, nil, nil, nil, log.NewNopLogger()))
+	})
+	defer stop()
+	var got []string
+	err := Export(ctx, conn, learn.ExportFilter{EmailDomain: "B.com", After: "u100"}, func(u *learn.User) error {
+		got = append(got, u.Id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 74 || got[0] != "u102" || got[73] != "u248" {
+		t.Errorf("exported %d users from %v to %v", len(got), got[0], got[len(got)-1])
+	}
+
+	srv := httptest.NewServer(learn.MakeHTTPHandler(ctx, learn.Endpoints{}, exporter, nil, log.NewNopLogger()))
+	defer srv.Close()
+	got = nil
+	err = ExportHTTP(ctx, srv.URL, learn.ExportFilter{IdPrefix: "u1"}, func(u *learn.User) error {
+		got = append(got, u.Id)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 100 || got[0] != "u100" || got[99] != "u199" {
+		t.Errorf("exported %d users over HTTP", len(got))
+	}
+}
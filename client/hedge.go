@@ -0,0 +1,183 @@
+package client
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/briankassouf/learn"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+)
+
+// HedgePolicy configures hedged reads. A read that hasn't returned after a
+// delay is sent to a second instance as well, and whichever answers first is
+// used, cancelling the other.
+type HedgePolicy struct {
+	// Delay is how long a read waits before it is hedged.
+	Delay time.Duration
+
+	// Percentile, if greater than zero, hedges a read once it is slower than
+	// this fraction of recent reads, such as 0.95, but never sooner than
+	// Delay. Reads use Delay alone until enough have been seen.
+	Percentile float64
+
+	// Hedges counts the hedged reads sent, and Wins those that answered
+	// before the read they hedged.
+	Hedges metrics.Counter
+	Wins   metrics.Counter
+}
+
+const (
+	// latencyWindow is the number of recent reads a percentile is taken
+	// over.
+	latencyWindow = 1000
+
+	// latencyRefresh is the number of reads after which the percentile is
+	// computed again.
+	latencyRefresh = 100
+)
+
+// latencies keeps the durations of recent reads to find the delay for a
+// HedgePolicy with a Percentile.
+type latencies struct {
+	percentile float64
+
+	mtx       sync.Mutex
+	samples   []time.Duration
+	next      int
+	sinceCalc int
+
+	// threshold is the last percentile computed, in nanoseconds, read
+	// atomically. It is zero until enough reads were seen.
+	threshold int64
+}
+
+func newLatencies(percentile float64) *latencies {
+	return &latencies{
+		percentile: percentile,
+		samples:    make([]time.Duration, 0, latencyWindow),
+	}
+}
+
+func (l *latencies) observe(d time.Duration) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if len(l.samples) < latencyWindow {
+		l.samples = append(l.samples, d)
+	} else {
+		l.samples[l.next] = d
+		l.next = (l.next + 1) % latencyWindow
+	}
+
+	if l.sinceCalc++; l.sinceCalc < latencyRefresh {
+		return
+	}
+	l.sinceCalc = 0
+
+	sorted := make([]time.Duration, len(l.samples))
+	copy(sorted, l.samples)
+	sort.Sort(durations(sorted))
+	i := int(l.percentile * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	atomic.StoreInt64(&l.threshold, int64(sorted[i]))
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// delay returns how long a read should wait before it is hedged.
+func (b *balancer) delay() time.Duration {
+	delay := b.hedge.Delay
+	if b.latencies != nil {
+		if t := time.Duration(atomic.LoadInt64(&b.latencies.threshold)); t > delay {
+			delay = t
+		}
+	}
+	return delay
+}
+
+// hedgedResult is the outcome of one of the calls of a hedged read.
+type hedgedResult struct {
+	response interface{}
+	err      error
+	inst     *instance
+	hedge    bool
+}
+
+// callHedged calls the method endpoint of an instance picked by the
+// balancer, and after the hedging delay that of another instance too,
+// returning the first answer that isn't a failure to reach an instance. It
+// returns the addresses of the instances it called.
+func (b *balancer) callHedged(ctx context.Context, method func(learn.Endpoints) endpoint.Endpoint, request interface{}, tried map[string]bool) (interface{}, []string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgedResult, 2)
+	call := func(inst *instance, hedge bool) {
+		begin := time.Now()
+		atomic.AddInt64(&inst.outstanding, 1)
+		response, err := method(inst.endpoints)(ctx, request)
+		atomic.AddInt64(&inst.outstanding, -1)
+
+		// Hedges are only sent to slow reads, and reads cancelled by a hedge
+		// were slow, so neither would tell the percentile much.
+		if !hedge && err == nil && b.latencies != nil {
+			b.latencies.observe(time.Since(begin))
+		}
+		results <- hedgedResult{response: response, err: err, inst: inst, hedge: hedge}
+	}
+
+	first, err := b.pick(tried)
+	if err != nil {
+		return nil, nil, err
+	}
+	called := []string{first.addr}
+	go call(first, false)
+
+	timer := time.NewTimer(b.delay())
+	defer timer.Stop()
+
+	pending := 1
+	select {
+	case r := <-results:
+		return r.response, called, r.err
+	case <-timer.C:
+		exclude := map[string]bool{first.addr: true}
+		for addr := range tried {
+			exclude[addr] = true
+		}
+		if second, err := b.pick(exclude); err == nil && second != first {
+			called = append(called, second.addr)
+			pending++
+			if b.hedge.Hedges != nil {
+				b.hedge.Hedges.Add(1)
+			}
+			go call(second, true)
+		}
+	case <-ctx.Done():
+		return nil, called, ctx.Err()
+	}
+
+	var r hedgedResult
+	for ; pending > 0; pending-- {
+		r = <-results
+		if r.err == nil || learn.IsServiceError(r.err) {
+			break
+		}
+	}
+	if r.hedge && r.err == nil && b.hedge.Wins != nil {
+		b.hedge.Wins.Add(1)
+	}
+
+	return r.response, called, r.err
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/briankassouf/learn"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+)
+
+// testCounter is a metrics.Counter that tests can read back.
+type testCounter struct {
+	value uint64
+}
+
+func (c *testCounter) Name() string                       { return "test" }
+func (c *testCounter) With(metrics.Field) metrics.Counter { return c }
+func (c *testCounter) Add(delta uint64)                   { atomic.AddUint64(&c.value, delta) }
+func (c *testCounter) Value() uint64                      { return atomic.LoadUint64(&c.value) }
+
+// delayedEndpoints returns endpoints answering after delay, with users whose
+// id is the delay, and the number of gets that were cancelled before then.
+func delayedEndpoints(delay time.Duration) (learn.Endpoints, *int64) {
+	cancelled := new(int64)
+	get := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		select {
+		case <-time.After(delay):
+			return learn.GetUserResponse{User: &learn.User{Id: delay.String()}}, nil
+		case <-ctx.Done():
+			atomic.AddInt64(cancelled, 1)
+			return nil, ctx.Err()
+		}
+	}
+	create := func(context.Context, interface{}) (interface{}, error) {
+		time.Sleep(delay)
+		return learn.CreateUserResponse{User: &learn.User{Id: delay.String()}}, nil
+	}
+	return learn.Endpoints{GetUserEndpoint: get, BatchGetUsersEndpoint: get, CreateUserEndpoint: create}, cancelled
+}
+
+func TestHedge(t *testing.T) {
+	ctx := context.Background()
+
+	slow, cancelled := delayedEndpoints(300 * time.Millisecond)
+	fast, _ := delayedEndpoints(5 * time.Millisecond)
+	instances := map[string]learn.Endpoints{"slow": slow, "fast": fast}
+	factory := func(addr string) (learn.Endpoints, io.Closer, error) { return instances[addr], nil, nil }
+
+	hedges, wins := &testCounter{}, &testCounter{}
+	policy := &HedgePolicy{Delay: 30 * time.Millisecond, Hedges: hedges, Wins: wins}
+	svc := newBalancer(StaticInstancer{"slow", "fast"}, RoundRobin, policy, factory, log.NewNopLogger()).endpoints(2, time.Second)
+
+	// Half the reads go to the slow instance first, and are answered by the
+	// fast one once hedged.
+	for i := 0; i < 10; i++ {
+		begin := time.Now()
+		u, err := svc.GetUser(ctx, "u")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Id != "5ms" {
+			t.Errorf("answered by the %s instance, want the fast one", u.Id)
+		}
+		if d := time.Since(begin); d > 150*time.Millisecond {
+			t.Errorf("read took %v", d)
+		}
+	}
+	if hedges.Value() != 5 || wins.Value() != 5 {
+		t.Errorf("%d hedges and %d wins, want 5 of each", hedges.Value(), wins.Value())
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt64(cancelled) != 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt64(cancelled); n != 5 {
+		t.Errorf("%d slow reads cancelled, want 5", n)
+	}
+
+	// Writes aren't hedged.
+	begin := time.Now()
+	svc.CreateUser(ctx, &learn.User{Id: "u"})
+	svc.CreateUser(ctx, &learn.User{Id: "u"})
+	if d := time.Since(begin); d < 300*time.Millisecond {
+		t.Errorf("writes took %v, so one was hedged", d)
+	}
+	if hedges.Value() != 5 {
+		t.Errorf("%d hedges after writes, want 5", hedges.Value())
+	}
+}
+
+func TestHedgePercentile(t *testing.T) {
+	l := newLatencies(0.9)
+	for i := 1; i <= 200; i++ {
+		l.observe(time.Duration(i) * time.Millisecond)
+	}
+	if d := time.Duration(l.threshold); d != 181*time.Millisecond {
+		t.Errorf("90th percentile of 1-200ms = %v, want 181ms", d)
+	}
+
+	// The delay follows the percentile once enough reads were seen, but
+	// never drops below the policy's.
+	fast, _ := delayedEndpoints(5 * time.Millisecond)
+	factory := func(string) (learn.Endpoints, io.Closer, error) { return fast, nil, nil }
+	b := newBalancer(StaticInstancer{"fast"}, RoundRobin, &HedgePolicy{Delay: time.Millisecond, Percentile: 0.9}, factory, log.NewNopLogger())
+	if d := b.delay(); d != time.Millisecond {
+		t.Errorf("delay before any read = %v, want 1ms", d)
+	}
+	svc := b.endpoints(1, time.Second)
+	for i := 0; i < latencyRefresh+50; i++ {
+		svc.GetUser(context.Background(), "u")
+	}
+	if d := b.delay(); d < 5*time.Millisecond {
+		t.Errorf("delay after 5ms reads = %v, want at least 5ms", d)
+	}
+}
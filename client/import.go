@@ -0,0 +1,134 @@
+package client
+
+import (
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/briankassouf/learn"
+	"github.com/briankassouf/learn/pb"
+	"github.com/go-kit/kit/auth/jwt"
+)
+
+// ImportSource opens a reader over the users of an import, positioned at the
+// record with the given offset. Import calls it again to resume after a
+// disconnect, so it must be able to start from any offset.
+type ImportSource func(offset int64) (learn.UserReader, error)
+
+// sourceError marks errors that came from the ImportSource rather than the
+// connection, which retrying won't fix.
+type sourceError struct {
+	error
+}
+
+// Import streams every user from src to the remote instance with the
+// client-streaming ImportUsers RPC. If the stream breaks, Import asks the
+// server for the last acknowledged offset and resumes from there, up to
+// maxRetries times. It is the responsibility of the caller to dial, and later
+// close, the connection. Of the options, only those providing a token apply.
+func Import(ctx context.Context, conn *grpc.ClientConn, importID string, src ImportSource, maxRetries int, options ...Option) (learn.ImportStatus, error) {
+	c := pb.NewUserServiceClient(conn)
+	ctx, err := signContext(ctx, newClientConfig(options).tokens)
+	if err != nil {
+		return learn.ImportStatus{}, err
+	}
+
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		status, err := importOnce(ctx, c, importID, src)
+		if err == nil {
+			return learn.DecodeGRPCImportStatus(status), nil
+		}
+		if _, ok := err.(sourceError); ok || attempt >= maxRetries || !retryable(err) {
+			return learn.ImportStatus{}, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return learn.ImportStatus{}, ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff < 5*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+// importOnce streams src from the server's acknowledged offset to the end.
+func importOnce(ctx context.Context, c pb.UserServiceClient, importID string, src ImportSource) (*pb.ImportStatus, error) {
+	status, err := c.GetImportStatus(ctx, &pb.ImportStatusRequest{ImportId: importID})
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := src(status.Acked)
+	if err != nil {
+		return nil, sourceError{err}
+	}
+	defer r.Close()
+
+	// Read the first record before opening the stream. If there is none,
+	// the import is already complete and there's nothing to send.
+	user, err := r.Read()
+	if err == io.EOF {
+		return status, nil
+	}
+	if err != nil {
+		return nil, sourceError{err}
+	}
+
+	stream, err := c.ImportUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for offset := status.Acked; ; offset++ {
+		if err := stream.Send(learn.EncodeGRPCImportRequest(importID, offset, user)); err != nil {
+			if err == io.EOF {
+				// The server ended the stream. CloseAndRecv returns why.
+				break
+			}
+			return nil, err
+		}
+
+		user, err = r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			stream.CloseSend()
+			return nil, sourceError{err}
+		}
+	}
+
+	return stream.CloseAndRecv()
+}
+
+func retryable(err error) bool {
+	switch grpc.Code(err) {
+	case codes.InvalidArgument, codes.Unauthenticated, codes.PermissionDenied:
+		return false
+	}
+	return true
+}
+
+// signContext attaches a token from ts to the outgoing gRPC metadata, the
+// same way FromGRPCContext does for the unary endpoints. Without ts, ctx is
+// returned as is.
+func signContext(ctx context.Context, ts TokenSource) (context.Context, error) {
+	if ts == nil {
+		return ctx, nil
+	}
+	token, err := ts.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	md := metadata.MD{}
+	jwt.FromGRPCContext()(context.WithValue(ctx, jwt.JWTTokenContextKey, token), &md)
+	return metadata.NewContext(ctx, md), nil
+}
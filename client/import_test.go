@@ -0,0 +1,86 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/briankassouf/learn"
+	"github.com/briankassouf/learn/pb"
+)
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	s := learn.NewBasicService()
+	imp := learn.NewImporter(s, 3, log.NewNopLogger())
+	conn, stop := serveGRPC(t, func(srv *grpc.Server) {
+		pb.RegisterUserServiceServer(srv, learn.MakeGRPCServer(ctx, learn.Endpoints{}, imp, nil, nil, nil, nil, log.NewNopLogger()))
+	})
+	defer stop()
+
+	var users []*learn.User
+	for i := 0; i < 10; i++ {
+		users = append(users, &learn.User{Id: fmt.Sprint("u", i)})
+	}
+
+	// An earlier run got as far as the fourth record.
+	if _, err := imp.Apply(ctx, "nightly", 0, users[:4]); err != nil {
+		t.Fatal(err)
+	}
+
+	var offsets []int64
+	src := func(offset int64) (learn.UserReader, error) {
+		offsets = append(offsets, offset)
+		return &sliceReader{users[offset:]}, nil
+	}
+	status, err := Import(ctx, conn, "nightly", src, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Acked != 10 || status.Applied != 10 {
+		t.Errorf("status = %+v", status)
+	}
+
+	// Importing again has nothing left to send.
+	if status, err = Import(ctx, conn, "nightly", src, 1); err != nil || status.Acked != 10 {
+		t.Errorf("Import = %+v, %v", status, err)
+	}
+	if want := []int64{4, 10}; fmt.Sprint(offsets) != fmt.Sprint(want) {
+		t.Errorf("source offsets = %v, want %v", offsets, want)
+	}
+	if _, err := s.GetUser(ctx, "u9"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportNeedsAProvisioner(t *testing.T) {
+	ctx := context.Background()
+	s := learn.NewBasicService()
+	imp := learn.NewImporter(s, 3, log.NewNopLogger())
+	auth := learn.NewVerifier(learn.SharedSecret("secret"), "", "", 0)
+	conn, stop := serveGRPC(t, func(srv *grpc.Server) {
+		pb.RegisterUserServiceServer(srv, learn.MakeGRPCServer(ctx, learn.Endpoints{}, imp, nil, nil, nil, auth, log.NewNopLogger()))
+	})
+	defer stop()
+
+	src := func(offset int64) (learn.UserReader, error) {
+		return &sliceReader{[]*learn.User{{Id: "u0"}}[offset:]}, nil
+	}
+	if _, err := Import(ctx, conn, "nightly", src, 1, SigningKey("secret")); grpc.Code(err) != codes.PermissionDenied {
+		t.Errorf("import by a user = %v, want PermissionDenied", err)
+	}
+	if _, err := s.GetUser(ctx, "u0"); err != learn.ErrNotFound {
+		t.Errorf("user imported by a user = %v, want ErrNotFound", err)
+	}
+
+	admin := Tokens(SignedTokens(stdjwt.SigningMethodHS256, "", []byte("secret"), stdjwt.MapClaims{"roles": []string{learn.AdminRole}}, time.Minute))
+	if status, err := Import(ctx, conn, "nightly", src, 1, admin); err != nil || status.Applied != 1 {
+		t.Errorf("import by an admin = %+v, %v", status, err)
+	}
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
+
+	"github.com/briankassouf/learn"
+)
+
+// Members returns the live members of the gossip cluster, as seen by the node
+// whose membership handler lives at instance. We expect instance to be of the
+// form "host:port".
+func Members(ctx context.Context, instance string) ([]learn.Member, error) {
+	if !strings.HasPrefix(instance, "http") {
+		instance = "http://" + instance
+	}
+	u, err := url.Parse(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := ctxhttp.Get(ctx, http.DefaultClient, copyURL(u, "/members").String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("members: unexpected status %s", resp.Status)
+	}
+
+	var members []learn.Member
+	if err := json.NewDecoder(resp.Body).Decode(&members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// GRPCInstances returns the gRPC addresses of the members that serve gRPC,
+// ready to be dialed with New.
+func GRPCInstances(members []learn.Member) []string {
+	var instances []string
+	for _, m := range members {
+		if m.GRPCAddr != "" {
+			instances = append(instances, m.GRPCAddr)
+		}
+	}
+	return instances
+}
+
+// HTTPInstances returns the HTTP addresses of the members that serve HTTP,
+// ready to be used with NewHTTP.
+func HTTPInstances(members []learn.Member) []string {
+	var instances []string
+	for _, m := range members {
+		if m.HTTPAddr != "" {
+			instances = append(instances, m.HTTPAddr)
+		}
+	}
+	return instances
+}
@@ -0,0 +1,218 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	jujuratelimit "github.com/juju/ratelimit"
+	"github.com/sony/gobreaker"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/briankassouf/learn"
+	"github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/circuitbreaker"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/ratelimit"
+)
+
+// Option configures a client made by New, NewHTTP and the constructors built
+// on them.
+type Option func(*clientConfig)
+
+// Tokens attaches a token of ts to every call. Without it, or SigningKey, no
+// token is attached, and servers that require one refuse the calls that
+// need it.
+func Tokens(ts TokenSource) Option {
+	return func(c *clientConfig) { c.tokens = ts }
+}
+
+// SigningKey attaches tokens signed with key using HS256, for servers
+// sharing that secret, which expire after a minute. It is a shorthand for
+// Tokens with SignedTokens.
+func SigningKey(key string) Option {
+	return Tokens(SignedTokens(stdjwt.SigningMethodHS256, "", []byte(key), nil, time.Minute))
+}
+
+// APIKey attaches the API key of a service account to every call. It is sent
+// as a bearer token, which servers tell apart from JWTs by its prefix.
+func APIKey(key string) Option {
+	return Tokens(StaticTokens(key))
+}
+
+// RateLimit limits the calls of the client, across all its methods, to qps
+// per second with bursts of up to burst calls. Calls over the limit fail
+// with ratelimit.ErrLimited rather than wait. By default calls aren't
+// limited.
+func RateLimit(qps float64, burst int64) Option {
+	return func(c *clientConfig) { c.qps, c.burst = qps, burst }
+}
+
+// Breaker sets the circuit breaker settings of every method. Each method
+// gets its own breaker, named after it. By default breakers use the
+// defaults of gobreaker, with a 30s Timeout.
+func Breaker(settings gobreaker.Settings) Option {
+	return func(c *clientConfig) { c.breaker = settings }
+}
+
+// MethodBreaker sets the circuit breaker settings of a single method, such as
+// "GetUser", overriding Breaker.
+func MethodBreaker(method string, settings gobreaker.Settings) Option {
+	return func(c *clientConfig) { c.breakers[method] = settings }
+}
+
+// Timeout bounds every attempt of a call. By default attempts are only bound
+// by the context of the call.
+func Timeout(d time.Duration) Option {
+	return func(c *clientConfig) { c.timeout = d }
+}
+
+// Retries sets the retry policy. By default it is DefaultRetryPolicy.
+func Retries(p RetryPolicy) Option {
+	return func(c *clientConfig) { c.retry = p }
+}
+
+// HTTPClient sets the HTTP client making the calls of NewHTTP. By default it
+// is http.DefaultClient.
+func HTTPClient(client *http.Client) Option {
+	return func(c *clientConfig) { c.httpClient = client }
+}
+
+// TLS makes the calls of NewHTTP, and the connections dialed by
+// NewBalanced, over TLS with files, which verify the servers and hold the
+// client certificate presented for mutual TLS. Instances without a scheme
+// are reached over https. The connection given to New must be dialed with
+// files.ClientCredentials instead.
+func TLS(files *learn.TLSFiles) Option {
+	return func(c *clientConfig) { c.tls = files }
+}
+
+// Middleware wraps every method endpoint of the client with mws, outside the
+// middleware of the client, so the first sees every call as made. They are
+// added to the middleware of earlier Middleware options.
+func Middleware(mws ...endpoint.Middleware) Option {
+	return func(c *clientConfig) { c.middleware = append(c.middleware, mws...) }
+}
+
+type clientConfig struct {
+	tokens     TokenSource
+	qps        float64
+	burst      int64
+	breaker    gobreaker.Settings
+	breakers   map[string]gobreaker.Settings
+	timeout    time.Duration
+	retry      RetryPolicy
+	httpClient *http.Client
+	tls        *learn.TLSFiles
+	middleware []endpoint.Middleware
+
+	// limiter is shared by every method, so it is made once the options are
+	// applied.
+	limiter endpoint.Middleware
+}
+
+func newClientConfig(options []Option) *clientConfig {
+	c := &clientConfig{
+		breaker:  gobreaker.Settings{Timeout: 30 * time.Second},
+		breakers: make(map[string]gobreaker.Settings),
+		retry:    DefaultRetryPolicy(),
+	}
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.qps > 0 {
+		burst := c.burst
+		if burst < 1 {
+			burst = 1
+		}
+		c.limiter = ratelimit.NewTokenBucketLimiter(jujuratelimit.NewBucketWithRate(c.qps, burst))
+	}
+	return c
+}
+
+// baseURL returns the URL of instance, which may go without a scheme.
+func (c *clientConfig) baseURL(instance string) (*url.URL, error) {
+	if !strings.HasPrefix(instance, "http") {
+		if c.tls != nil {
+			instance = "https://" + instance
+		} else {
+			instance = "http://" + instance
+		}
+	}
+	return url.Parse(instance)
+}
+
+// client returns the HTTP client making the calls of the client.
+func (c *clientConfig) client() *http.Client {
+	switch {
+	case c.httpClient != nil:
+		return c.httpClient
+	case c.tls != nil:
+		return &http.Client{Transport: &http.Transport{DialTLS: c.tls.DialTLS}}
+	}
+	return http.DefaultClient
+}
+
+// dialOption returns the security of the connections the client dials.
+func (c *clientConfig) dialOption() grpc.DialOption {
+	if c.tls != nil {
+		return grpc.WithTransportCredentials(c.tls.ClientCredentials(""))
+	}
+	return grpc.WithInsecure()
+}
+
+// endpoint wraps the transport endpoint e of method with the middleware of
+// the client. Calls are only retried if idempotent allows it.
+func (c *clientConfig) endpoint(method string, e endpoint.Endpoint, idempotent func(context.Context) bool) endpoint.Endpoint {
+	if c.limiter != nil {
+		e = c.limiter(e)
+	}
+
+	settings, ok := c.breakers[method]
+	if !ok {
+		settings = c.breaker
+	}
+	settings.Name = method
+	e = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(settings))(e)
+
+	if c.tokens != nil {
+		e = attachToken(c.tokens)(e)
+	}
+	if c.timeout > 0 {
+		e = timeout(c.timeout)(e)
+	}
+	e = Retry(c.retry, idempotent)(e)
+
+	for i := len(c.middleware) - 1; i >= 0; i-- {
+		e = c.middleware[i](e)
+	}
+	return e
+}
+
+// attachToken puts a token from ts in the context, where the jwt package's
+// request funcs pick it up for the transport.
+func attachToken(ts TokenSource) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			token, err := ts.Token(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return next(context.WithValue(ctx, jwt.JWTTokenContextKey, token), request)
+		}
+	}
+}
+
+func timeout(d time.Duration) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(ctx, request)
+		}
+	}
+}
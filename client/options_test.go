@@ -0,0 +1,154 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"golang.org/x/net/context"
+
+	"github.com/briankassouf/learn"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/ratelimit"
+)
+
+// recordingServer answers creates and gets with a user, after delay, and
+// records the Authorization header of each path.
+type recordingServer struct {
+	delay int64 // nanoseconds, read atomically
+
+	mtx  sync.Mutex
+	auth map[string]string
+}
+
+func (s *recordingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	s.auth[r.URL.Path] = r.Header.Get("Authorization")
+	s.mtx.Unlock()
+
+	time.Sleep(time.Duration(atomic.LoadInt64(&s.delay)))
+	json.NewEncoder(w).Encode(map[string]interface{}{"user": map[string]string{"id": "a"}})
+}
+
+func (s *recordingServer) authorization(path string) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.auth[path]
+}
+
+// countingTransport is an http.RoundTripper counting the requests it makes.
+type countingTransport struct {
+	requests int64
+}
+
+func (t *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&t.requests, 1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestOptions(t *testing.T) {
+	ctx := context.Background()
+	rs := &recordingServer{auth: make(map[string]string)}
+	srv := httptest.NewServer(rs)
+	defer srv.Close()
+
+	// No token is sent unless one is configured.
+	svc, err := NewHTTP(srv.URL, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.CreateUser(ctx, &learn.User{Id: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if auth := rs.authorization("/create"); auth != "" {
+		t.Errorf("Authorization = %q without a token", auth)
+	}
+
+	// Tokens go with every method, over the HTTP client given.
+	transport := &countingTransport{}
+	svc, err = NewHTTP(srv.URL, log.NewNopLogger(), SigningKey("secret"), HTTPClient(&http.Client{Transport: transport}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.CreateUser(ctx, &learn.User{Id: "a"})
+	svc.GetUser(ctx, "a")
+	for _, path := range []string{"/create", "/get"} {
+		if auth := rs.authorization(path); !strings.HasPrefix(strings.ToLower(auth), "bearer ey") {
+			t.Errorf("Authorization of %s = %q, want a signed token", path, auth)
+		}
+	}
+	if n := atomic.LoadInt64(&transport.requests); n != 2 {
+		t.Errorf("%d requests over the HTTP client, want 2", n)
+	}
+
+	svc, _ = NewHTTP(srv.URL, log.NewNopLogger(), APIKey("lk_key"))
+	svc.GetUser(ctx, "a")
+	if auth := rs.authorization("/get"); !strings.EqualFold(auth, "Bearer lk_key") {
+		t.Errorf("Authorization = %q, want the API key", auth)
+	}
+
+	// Timeout bounds each attempt.
+	atomic.StoreInt64(&rs.delay, int64(200*time.Millisecond))
+	svc, _ = NewHTTP(srv.URL, log.NewNopLogger(), Timeout(20*time.Millisecond), Retries(RetryPolicy{MaxAttempts: 1}))
+	if _, err := svc.GetUser(ctx, "a"); err == nil {
+		t.Error("slow call didn't time out")
+	}
+	atomic.StoreInt64(&rs.delay, 0)
+
+	// The rate limit is shared by every method.
+	svc, _ = NewHTTP(srv.URL, log.NewNopLogger(), RateLimit(1, 1), Retries(RetryPolicy{MaxAttempts: 1}))
+	if _, err := svc.GetUser(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.CreateUser(ctx, &learn.User{Id: "a"}); err != ratelimit.ErrLimited {
+		t.Errorf("call over the limit = %v, want ratelimit.ErrLimited", err)
+	}
+}
+
+func TestOptionsBreakersAndMiddleware(t *testing.T) {
+	ctx := context.Background()
+
+	var order []string
+	record := func(name string) endpoint.Middleware {
+		return func(next endpoint.Endpoint) endpoint.Endpoint {
+			return func(ctx context.Context, request interface{}) (interface{}, error) {
+				order = append(order, name)
+				return next(ctx, request)
+			}
+		}
+	}
+	config := newClientConfig([]Option{
+		MethodBreaker("GetUser", gobreaker.Settings{
+			ReadyToTrip: func(c gobreaker.Counts) bool { return c.ConsecutiveFailures >= 1 },
+			Timeout:     time.Minute,
+		}),
+		Retries(RetryPolicy{MaxAttempts: 1}),
+		Middleware(record("a"), record("b")),
+		Middleware(record("c")),
+	})
+	down := func(context.Context, interface{}) (interface{}, error) { return nil, errors.New("down") }
+	get := config.endpoint("GetUser", down, Always)
+	create := config.endpoint("CreateUser", down, Always)
+
+	// Methods trip their own breaker only.
+	get(ctx, nil)
+	if _, err := get(ctx, nil); err != gobreaker.ErrOpenState {
+		t.Errorf("GetUser after a failure = %v, want gobreaker.ErrOpenState", err)
+	}
+	if _, err := create(ctx, nil); err == gobreaker.ErrOpenState {
+		t.Error("CreateUser shares the breaker of GetUser")
+	}
+
+	// Middleware runs in the order it was given, for every call.
+	if want := "abcabcabc"; strings.Join(order, "") != want {
+		t.Errorf("middleware ran as %v, want %s", order, want)
+	}
+}
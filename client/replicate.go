@@ -0,0 +1,50 @@
+package client
+
+import (
+	"io"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/briankassouf/learn"
+	"github.com/briankassouf/learn/pb"
+)
+
+// Replicate follows the replication log of the remote instance from the
+// change after the one numbered after, calling fn with each change and the
+// latest seq of the remote instance. It is a learn.ChangeSource once bound to
+// a connection. If the replication log of the remote instance doesn't reach
+// back to after, it returns learn.ErrReplicationGap. It is the responsibility
+// of the caller to dial, and later close, the connection. Of the options,
+// only those providing a token apply.
+func Replicate(ctx context.Context, conn *grpc.ClientConn, after uint64, fn func(c learn.Change, head uint64) error, options ...Option) error {
+	ctx, err := signContext(ctx, newClientConfig(options).tokens)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := pb.NewUserServiceClient(conn).Replicate(ctx, &pb.ReplicateRequest{AfterSeq: after})
+	if err != nil {
+		return err
+	}
+
+	for {
+		entry, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if grpc.Code(err) == codes.OutOfRange {
+			return learn.ErrReplicationGap
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(learn.DecodeGRPCReplicationEntry(entry)); err != nil {
+			return err
+		}
+	}
+}
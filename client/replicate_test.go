@@ -0,0 +1,191 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/briankassouf/learn"
+	"github.com/briankassouf/learn/pb"
+)
+
+// testSecret signs the tokens that test nodes accept.
+const testSecret = "secret"
+
+// testNode is an in-process instance serving gRPC on a local port, following
+// a leader unless it is one.
+type testNode struct {
+	store    learn.UserService
+	service  learn.UserService
+	tree     *learn.MerkleTree
+	follower *learn.Follower
+	leader   *grpc.ClientConn
+	conn     *grpc.ClientConn
+	stop     func()
+}
+
+// startNode starts a node that keeps up to retain changes for its followers.
+// Its gRPC server requires tokens signed with testSecret. The node follows
+// leader, if it isn't nil, until ctx is done.
+func startNode(t *testing.T, ctx context.Context, leader *testNode, retain int) *testNode {
+	store := learn.NewBasicService()
+	n := &testNode{
+		store:   store,
+		service: store,
+		tree:    learn.NewMerkleTree(store.(learn.Repairer)),
+	}
+	replog := learn.NewReplicationLog(store.(learn.Publisher), retain)
+
+	if leader != nil {
+		credentials := SigningKey(testSecret)
+		source := func(ctx context.Context, after uint64, fn func(learn.Change, uint64) error) error {
+			return Replicate(ctx, leader.conn, after, fn, credentials)
+		}
+		snapshot := func(ctx context.Context) ([]*learn.User, uint64, error) {
+			return Snapshot(ctx, leader.conn, credentials)
+		}
+		n.leader = leader.conn
+		n.follower = learn.NewFollower(store.(learn.Replica), source, snapshot, &testGauge{}, &testGauge{}, log.NewNopLogger())
+		n.service = learn.ServiceFollowerMiddleware(nil)(store)
+		go n.follower.Run(ctx)
+	}
+
+	endpoints := learn.Endpoints{
+		CreateUserEndpoint:       learn.MakeCreateUserEndpoint(n.service),
+		GetUserEndpoint:          learn.MakeGetUserEndpoint(n.service),
+		BatchCreateUsersEndpoint: learn.MakeBatchCreateUsersEndpoint(n.service),
+		BatchGetUsersEndpoint:    learn.MakeBatchGetUsersEndpoint(n.service),
+	}
+	auth := learn.NewVerifier(learn.SharedSecret(testSecret), "", "", 0)
+	n.conn, n.stop = serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterUserServiceServer(s, learn.MakeGRPCServer(ctx, endpoints, nil, store.(learn.Exporter), replog, n.tree, auth, log.NewNopLogger()))
+	})
+	return n
+}
+
+// waitForSeq waits for the store of n to reach seq.
+func waitForSeq(t *testing.T, n *testNode, seq uint64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for n.store.(learn.Replica).LastSeq() != seq {
+		if time.Now().After(deadline) {
+			t.Fatalf("seq is %d after 5s, want %d", n.store.(learn.Replica).LastSeq(), seq)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leader := startNode(t, ctx, nil, 0)
+	defer leader.stop()
+	for _, id := range []string{"a", "b", "c"} {
+		leader.store.CreateUser(ctx, &learn.User{Id: id})
+	}
+
+	// A follower can be followed in turn.
+	follower := startNode(t, ctx, leader, 0)
+	defer follower.stop()
+	chained := startNode(t, ctx, follower, 0)
+	defer chained.stop()
+
+	leader.store.CreateUser(ctx, &learn.User{Id: "z", ExpiresAt: time.Now().Add(-time.Second)})
+	leader.store.(learn.Expirer).ExpireUsers(ctx, time.Now(), learn.SoftDelete)
+	waitForSeq(t, chained, 5)
+
+	if _, err := chained.service.GetUser(ctx, "c"); err != nil {
+		t.Error(err)
+	}
+	if _, err := follower.service.GetUser(ctx, "z"); err != learn.ErrNotFound {
+		t.Errorf("GetUser of an expired user = %v, want %v", err, learn.ErrNotFound)
+	}
+	if _, err := follower.service.CreateUser(ctx, &learn.User{Id: "q"}); err != learn.ErrNotLeader {
+		t.Errorf("CreateUser on a follower = %v, want %v", err, learn.ErrNotLeader)
+	}
+	if behind, _ := follower.follower.Lag(); behind != 0 {
+		t.Errorf("caught up follower is %d behind", behind)
+	}
+}
+
+func TestReplicationBootstrap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The leader has long dropped the first of its changes.
+	leader := startNode(t, ctx, nil, 10)
+	defer leader.stop()
+	for i := 0; i < 50; i++ {
+		leader.store.CreateUser(ctx, &learn.User{Id: fmt.Sprint("u", i%30)})
+	}
+
+	follower := startNode(t, ctx, leader, 10)
+	defer follower.stop()
+	waitForSeq(t, follower, 50)
+	leader.store.CreateUser(ctx, &learn.User{Id: "later"})
+	waitForSeq(t, follower, 51)
+
+	for _, id := range []string{"u0", "u29", "later"} {
+		if _, err := follower.store.GetUser(ctx, id); err != nil {
+			t.Errorf("GetUser(%q) = %v", id, err)
+		}
+	}
+}
+
+func TestReplicationRequiresAuth(t *testing.T) {
+	ctx := context.Background()
+	leader := startNode(t, ctx, nil, 0)
+	defer leader.stop()
+	leader.store.CreateUser(ctx, &learn.User{Id: "a"})
+
+	err := Replicate(ctx, leader.conn, 0, func(learn.Change, uint64) error { return nil })
+	if grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("Replicate without a token = %v", err)
+	}
+	err = Export(ctx, leader.conn, learn.ExportFilter{}, func(*learn.User) error { return nil })
+	if grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("Export without a token = %v", err)
+	}
+	if _, _, err := Snapshot(ctx, leader.conn, SigningKey("wrong")); grpc.Code(err) != codes.Unauthenticated {
+		t.Errorf("Snapshot with a bad token = %v", err)
+	}
+
+	users, seq, err := Snapshot(ctx, leader.conn, SigningKey(testSecret))
+	if err != nil || len(users) != 1 || seq != 1 {
+		t.Errorf("Snapshot = %d users at %d, %v", len(users), seq, err)
+	}
+}
+
+// testGauge is a metrics.Gauge holding its last value.
+type testGauge struct {
+	mtx   sync.Mutex
+	value float64
+}
+
+func (g *testGauge) Name() string                     { return "test" }
+func (g *testGauge) With(metrics.Field) metrics.Gauge { return g }
+
+func (g *testGauge) Set(value float64) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.value = value
+}
+
+func (g *testGauge) Add(delta float64) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.value += delta
+}
+
+func (g *testGauge) Get() float64 {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.value
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"golang.org/x/net/context"
+
+	"github.com/briankassouf/learn"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/ratelimit"
+)
+
+// RetryPolicy configures how a client retries failed calls.
+type RetryPolicy struct {
+	// MaxAttempts bounds the attempts made for a single call, the first one
+	// included. A policy with at most one attempt never retries.
+	MaxAttempts int
+
+	// BaseBackoff and MaxBackoff bound the wait before each retry. The n-th
+	// retry waits a random time, for jitter, of up to BaseBackoff*2^(n-1),
+	// capped at MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+
+	// Budget, if not nil, caps the retries made across all calls sharing it.
+	Budget *RetryBudget
+}
+
+// DefaultRetryPolicy returns the policy used by New and NewHTTP: up to 3
+// attempts, waiting up to 50ms and then up to 100ms, with retries capped at
+// a fifth of calls plus 10 per second.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseBackoff: 50 * time.Millisecond,
+		MaxBackoff:  time.Second,
+		Budget:      NewRetryBudget(0.2, 10),
+	}
+}
+
+// backoff returns the wait before retry n, counting from 1.
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.BaseBackoff
+	for i := 1; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+
+	return time.Duration(jitter.Int63n(int64(d) + 1))
+}
+
+// jitter is shared by every policy. The rand.Rand returned by rand.New isn't
+// safe for concurrent use, unlike the top-level functions of math/rand, but
+// those would make every process wait for the same times.
+var jitter = &lockedRand{r: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+type lockedRand struct {
+	mtx sync.Mutex
+	r   *rand.Rand
+}
+
+func (r *lockedRand) Int63n(n int64) int64 {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return r.r.Int63n(n)
+}
+
+// RetryBudget caps retries so that they can't multiply the load on an
+// instance that is already failing. Every call earns a fraction of a retry,
+// and a few more are earned every second so that clients making few calls
+// can still retry. A retry is only made if one was earned.
+type RetryBudget struct {
+	ratio     float64
+	perSecond float64
+
+	mtx     sync.Mutex
+	balance float64
+	last    time.Time
+}
+
+// NewRetryBudget returns a budget allowing retries for ratio of the calls,
+// plus perSecond retries every second. Unspent retries are saved up to ten
+// seconds' worth of the latter plus those earned by a hundred calls.
+func NewRetryBudget(ratio float64, perSecond int) *RetryBudget {
+	return &RetryBudget{
+		ratio:     ratio,
+		perSecond: float64(perSecond),
+		balance:   float64(perSecond),
+		last:      time.Now(),
+	}
+}
+
+// deposit records a call.
+func (b *RetryBudget) deposit() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.refill(time.Now())
+	b.balance += b.ratio
+}
+
+// withdraw reports whether a retry may be made, spending it if so.
+func (b *RetryBudget) withdraw() bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.refill(time.Now())
+	if b.balance < 1 {
+		return false
+	}
+	b.balance--
+	return true
+}
+
+// refill adds the retries earned over time. It must be called with the mutex
+// held.
+func (b *RetryBudget) refill(now time.Time) {
+	b.balance += now.Sub(b.last).Seconds() * b.perSecond
+	b.last = now
+
+	if max := 10*b.perSecond + 100*b.ratio; b.balance > max {
+		b.balance = max
+	}
+}
+
+// Retry returns an endpoint middleware retrying failed calls with p, when
+// idempotent reports that the call may be made more than once. Calls that
+// failed with an error of the service, or that were refused by the circuit
+// breaker or rate limiter, aren't retried. Neither are calls whose context
+// is done, or would be before the next retry is due, though an attempt that
+// ran out of its own Timeout is.
+func Retry(p RetryPolicy, idempotent func(ctx context.Context) bool) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if p.Budget != nil {
+				p.Budget.deposit()
+			}
+
+			response, err := next(ctx, request)
+			for n := 1; n < p.MaxAttempts && !permanent(err) && ctx.Err() == nil && idempotent(ctx); n++ {
+				wait := p.backoff(n)
+				if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+					break
+				}
+				if p.Budget != nil && !p.Budget.withdraw() {
+					break
+				}
+
+				select {
+				case <-time.After(wait):
+				case <-ctx.Done():
+					return response, err
+				}
+				response, err = next(ctx, request)
+			}
+
+			return response, err
+		}
+	}
+}
+
+// permanent reports whether a call that failed with err would fail the same
+// way if retried right away. A nil err is permanent, since there is nothing
+// to retry.
+func permanent(err error) bool {
+	switch {
+	case err == nil, learn.IsServiceError(err):
+		return true
+	case err == gobreaker.ErrOpenState, err == gobreaker.ErrTooManyRequests, err == ratelimit.ErrLimited:
+		return true
+	}
+
+	return false
+}
+
+// Always is an idempotent func for calls that may always be retried, such as
+// reads.
+func Always(context.Context) bool { return true }
+
+// HasIdempotencyKey is an idempotent func for calls that may only be retried
+// if they carry an idempotency key, such as CreateUser.
+func HasIdempotencyKey(ctx context.Context) bool {
+	_, ok := learn.IdempotencyKey(ctx)
+	return ok
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/briankassouf/learn"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+)
+
+var errTransient = errors.New("transient")
+
+// flaky returns an endpoint failing with err for its first fails calls,
+// and the number of calls made to it.
+func flaky(fails int, err error) (endpoint.Endpoint, *int) {
+	calls := new(int)
+	return func(context.Context, interface{}) (interface{}, error) {
+		*calls++
+		if *calls <= fails {
+			return nil, err
+		}
+		return "ok", nil
+	}, calls
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	p := RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+	for _, test := range []struct {
+		name       string
+		ctx        context.Context
+		fails      int
+		err        error
+		idempotent func(context.Context) bool
+		wantErr    error
+		wantCalls  int
+	}{
+		{"recovers", ctx, 2, errTransient, Always, nil, 3},
+		{"gives up", ctx, 3, errTransient, Always, errTransient, 3},
+		{"service error", ctx, 2, learn.ErrNotFound, Always, learn.ErrNotFound, 1},
+		{"no idempotency key", ctx, 2, errTransient, HasIdempotencyKey, errTransient, 1},
+		{"idempotency key", learn.WithIdempotencyKey(ctx, "k"), 2, errTransient, HasIdempotencyKey, nil, 3},
+	} {
+		e, calls := flaky(test.fails, test.err)
+		if _, err := Retry(p, test.idempotent)(e)(test.ctx, nil); err != test.wantErr || *calls != test.wantCalls {
+			t.Errorf("%s: %v after %d calls, want %v after %d", test.name, err, *calls, test.wantErr, test.wantCalls)
+		}
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Second, MaxBackoff: time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// Retries that wouldn't be made before the deadline aren't waited for.
+	begin := time.Now()
+	for i := 0; i < 20; i++ {
+		e, _ := flaky(5, errTransient)
+		Retry(p, Always)(e)(ctx, nil)
+	}
+	if d := time.Since(begin); d > 500*time.Millisecond {
+		t.Errorf("retries took %v, past the deadline", d)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	p.Budget = NewRetryBudget(0.1, 0)
+
+	// Each call earns a tenth of a retry, so 100 failing calls retry about
+	// ten times rather than 200.
+	total := 0
+	for i := 0; i < 100; i++ {
+		e, calls := flaky(100, errTransient)
+		Retry(p, Always)(e)(context.Background(), nil)
+		total += *calls
+	}
+	if total > 100+11 {
+		t.Errorf("%d calls made for 100 with a budget of 10%%", total)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{BaseBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for n := 1; n < 10; n++ {
+		for i := 0; i < 100; i++ {
+			if d := p.backoff(n); d < 0 || d > 50*time.Millisecond {
+				t.Fatalf("backoff(%d) = %v, want up to 50ms", n, d)
+			}
+		}
+	}
+	if d := (RetryPolicy{}).backoff(1); d != 0 {
+		t.Errorf("backoff without a base = %v, want 0", d)
+	}
+}
+
+// countingCreates is a store counting the creates that reach it.
+type countingCreates struct {
+	learn.UserService
+	creates int64
+}
+
+func (s *countingCreates) CreateUser(ctx context.Context, u *learn.User) (*learn.User, error) {
+	atomic.AddInt64(&s.creates, 1)
+	time.Sleep(10 * time.Millisecond)
+	return s.UserService.CreateUser(ctx, u)
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingCreates{UserService: learn.NewBasicService()}
+	addr, stop := listenGRPC(t, learn.ServiceIdempotencyMiddleware(time.Minute)(backend))
+	defer stop()
+	svc := NewBalanced(StaticInstancer{addr}, RoundRobin, nil, 1, time.Second, log.NewNopLogger())
+
+	// The key travels with the call, so the server creates the user once.
+	keyed := learn.WithIdempotencyKey(ctx, "k")
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := svc.CreateUser(keyed, &learn.User{Id: "a"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	svc.CreateUser(ctx, &learn.User{Id: "a"})
+	if n := atomic.LoadInt64(&backend.creates); n != 2 {
+		t.Errorf("%d creates, want 2", n)
+	}
+}
@@ -0,0 +1,444 @@
+package client
+
+import (
+	"errors"
+	"hash/crc32"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/briankassouf/learn"
+)
+
+var (
+	// ErrNoShards is returned when a sharded client has no shard to route a
+	// user to.
+	ErrNoShards = errors.New("No shards to route the user to")
+
+	// ErrCrossShardBatch is returned by an AllOrNothing BatchCreateUsers whose
+	// users are owned by more than one shard, since shards can't apply a batch
+	// together.
+	ErrCrossShardBatch = errors.New("All-or-nothing batch spans more than one shard")
+)
+
+// DefaultReplicas is the number of virtual nodes placed on a ring for every
+// shard when none is given.
+const DefaultReplicas = 160
+
+// Ring assigns keys to shards with consistent hashing. Every shard is placed
+// on the ring at many points, its virtual nodes, and a key is owned by the
+// shard of the first point that follows the hash of the key. Adding or
+// removing a shard only moves the keys of the points it gains or loses, about
+// 1/n of them.
+//
+// A ring can also bound the load of its shards. Each shard then takes at most
+// loadFactor times the average number of requests in flight, and requests for
+// a key whose owner is full go to the next shard on the ring that isn't.
+type Ring struct {
+	replicas   int
+	loadFactor float64
+
+	mtx    sync.RWMutex
+	hashes []uint32
+	owners map[uint32]string
+	shards map[string]bool
+
+	// load and total count the requests in flight, per shard and overall,
+	// when the load is bounded.
+	load  map[string]int
+	total int
+}
+
+// NewRing returns an empty ring that places every shard at replicas points,
+// or at DefaultReplicas points if replicas isn't positive.
+func NewRing(replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+
+	return &Ring{
+		replicas: replicas,
+		owners:   make(map[uint32]string),
+		shards:   make(map[string]bool),
+		load:     make(map[string]int),
+	}
+}
+
+// NewBoundedLoadRing returns an empty ring like NewRing that also bounds the
+// load of every shard to loadFactor times the average, which must be greater
+// than 1; 1.25 is a good start. Since a key may then be served by a shard
+// other than its owner, this only suits shards that can all serve every user,
+// such as the followers of a single leader.
+func NewBoundedLoadRing(replicas int, loadFactor float64) *Ring {
+	r := NewRing(replicas)
+	r.loadFactor = loadFactor
+	return r
+}
+
+// Add places shard on the ring. Adding a shard twice has no effect.
+func (r *Ring) Add(shard string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.shards[shard] {
+		return
+	}
+	r.shards[shard] = true
+
+	for i := 0; i < r.replicas; i++ {
+		h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + shard))
+		// On the rare collision the shard whose name sorts first keeps the
+		// point, so that every client agrees on the owner regardless of the
+		// order in which shards were added.
+		if owner, ok := r.owners[h]; ok && owner < shard {
+			continue
+		}
+		if _, ok := r.owners[h]; !ok {
+			r.hashes = append(r.hashes, h)
+		}
+		r.owners[h] = shard
+	}
+	sort.Sort(uint32s(r.hashes))
+}
+
+// Remove takes shard off the ring. Its keys move to the shards that follow
+// its points.
+func (r *Ring) Remove(shard string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if !r.shards[shard] {
+		return
+	}
+	delete(r.shards, shard)
+
+	// Points lost to a collision are rebuilt from scratch, which is simpler
+	// than tracking them.
+	r.hashes = r.hashes[:0]
+	r.owners = make(map[uint32]string)
+	shards := make([]string, 0, len(r.shards))
+	for s := range r.shards {
+		shards = append(shards, s)
+	}
+	sort.Strings(shards)
+	for _, s := range shards {
+		for i := 0; i < r.replicas; i++ {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + s))
+			if _, ok := r.owners[h]; ok {
+				continue
+			}
+			r.owners[h] = s
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Sort(uint32s(r.hashes))
+}
+
+// Shards returns the shards on the ring, sorted.
+func (r *Ring) Shards() []string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	shards := make([]string, 0, len(r.shards))
+	for s := range r.shards {
+		shards = append(shards, s)
+	}
+	sort.Strings(shards)
+
+	return shards
+}
+
+// Owner returns the shard that owns key, ignoring load. It returns false if
+// the ring is empty.
+func (r *Ring) Owner(key string) (string, bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	if len(r.hashes) == 0 {
+		return "", false
+	}
+
+	return r.owners[r.hashes[r.search(key)]], true
+}
+
+// Acquire returns the shard that should serve a request for key, counting the
+// request against its load until release is called. Without bounded load the
+// shard is always the owner of key. It returns false if the ring is empty.
+func (r *Ring) Acquire(key string) (shard string, release func(), ok bool) {
+	if r.loadFactor <= 0 {
+		shard, ok = r.Owner(key)
+		return shard, func() {}, ok
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if len(r.hashes) == 0 {
+		return "", nil, false
+	}
+
+	// Every shard may take its share of the requests in flight, this one
+	// included, scaled by the load factor.
+	limit := int(math.Ceil(r.loadFactor * float64(r.total+1) / float64(len(r.shards))))
+	i := r.search(key)
+	for n := 0; n < len(r.hashes); n++ {
+		shard = r.owners[r.hashes[(i+n)%len(r.hashes)]]
+		if r.load[shard] < limit {
+			break
+		}
+	}
+	r.load[shard]++
+	r.total++
+
+	var once sync.Once
+	return shard, func() {
+		once.Do(func() {
+			r.mtx.Lock()
+			defer r.mtx.Unlock()
+
+			r.total--
+			if r.load[shard]--; r.load[shard] <= 0 {
+				delete(r.load, shard)
+			}
+		})
+	}, true
+}
+
+// search returns the index of the first point at or after the hash of key. It
+// must be called with the mutex held, on a ring that isn't empty.
+func (r *Ring) search(key string) int {
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return i
+}
+
+type uint32s []uint32
+
+func (h uint32s) Len() int           { return len(h) }
+func (h uint32s) Less(i, j int) bool { return h[i] < h[j] }
+func (h uint32s) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+// Sharded is a learn.UserService that spreads users across many instances,
+// routing every user ID to a shard with a Ring. Batches are split by shard
+// and the shards are called concurrently.
+type Sharded struct {
+	ring *Ring
+
+	mtx    sync.RWMutex
+	shards map[string]learn.UserService
+}
+
+// NewSharded returns a sharded client with no shards, routing with ring.
+// Shards are added with AddShard.
+func NewSharded(ring *Ring) *Sharded {
+	return &Sharded{
+		ring:   ring,
+		shards: make(map[string]learn.UserService),
+	}
+}
+
+// AddShard adds, or replaces, the shard called name, served by svc. The name
+// places the shard on the ring, so every client must use the same names for
+// the same shards, typically their addresses.
+func (s *Sharded) AddShard(name string, svc learn.UserService) {
+	s.mtx.Lock()
+	s.shards[name] = svc
+	s.mtx.Unlock()
+
+	s.ring.Add(name)
+}
+
+// RemoveShard removes the shard called name. The users it owned are routed to
+// other shards, which won't have them unless they were moved there.
+func (s *Sharded) RemoveShard(name string) {
+	s.ring.Remove(name)
+
+	s.mtx.Lock()
+	delete(s.shards, name)
+	s.mtx.Unlock()
+}
+
+// acquire returns the name of the shard serving id and its service, and the
+// function that must be called once the request is done.
+func (s *Sharded) acquire(id string) (string, learn.UserService, func(), error) {
+	name, release, ok := s.ring.Acquire(id)
+	if !ok {
+		return "", nil, nil, ErrNoShards
+	}
+
+	s.mtx.RLock()
+	svc, ok := s.shards[name]
+	s.mtx.RUnlock()
+	if !ok {
+		// The shard was removed after it was picked.
+		release()
+		return "", nil, nil, ErrNoShards
+	}
+
+	return name, svc, release, nil
+}
+
+func (s *Sharded) CreateUser(ctx context.Context, u *learn.User) (*learn.User, error) {
+	if u == nil || u.Id == "" {
+		return nil, learn.ErrMissingID
+	}
+
+	_, svc, release, err := s.acquire(u.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return svc.CreateUser(ctx, u)
+}
+
+func (s *Sharded) GetUser(ctx context.Context, id string) (*learn.User, error) {
+	_, svc, release, err := s.acquire(id)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return svc.GetUser(ctx, id)
+}
+
+func (s *Sharded) BatchCreateUsers(ctx context.Context, users []*learn.User, mode learn.BatchMode) ([]learn.BatchResult, error) {
+	ids := make([]string, len(users))
+	for i, u := range users {
+		if u != nil {
+			ids[i] = u.Id
+		}
+	}
+
+	return s.batch(ctx, ids, mode, false, func(ctx context.Context, svc learn.UserService, items []int, mode learn.BatchMode) ([]learn.BatchResult, error) {
+		part := make([]*learn.User, len(items))
+		for j, i := range items {
+			part[j] = users[i]
+		}
+		return svc.BatchCreateUsers(ctx, part, mode)
+	})
+}
+
+func (s *Sharded) BatchGetUsers(ctx context.Context, ids []string, mode learn.BatchMode) ([]learn.BatchResult, error) {
+	return s.batch(ctx, ids, mode, true, func(ctx context.Context, svc learn.UserService, items []int, mode learn.BatchMode) ([]learn.BatchResult, error) {
+		part := make([]string, len(items))
+		for j, i := range items {
+			part[j] = ids[i]
+		}
+		return svc.BatchGetUsers(ctx, part, mode)
+	})
+}
+
+// batch splits a batch by shard and calls each shard with its items, given
+// by their index in the batch, merging the results back in order.
+//
+// Shards can't apply a batch together, so an all-or-nothing batch that spans
+// them is only possible if it is read-only. Reads are then made best-effort
+// on every shard and aborted here if any failed. Other batches fail with
+// ErrCrossShardBatch before any shard is called, once the checks every shard
+// would make have passed.
+func (s *Sharded) batch(ctx context.Context, ids []string, mode learn.BatchMode, readOnly bool, call func(context.Context, learn.UserService, []int, learn.BatchMode) ([]learn.BatchResult, error)) ([]learn.BatchResult, error) {
+	results := make([]learn.BatchResult, len(ids))
+	items := make(map[string][]int)
+	services := make(map[string]learn.UserService)
+	var releases []func()
+	defer func() {
+		for _, release := range releases {
+			release()
+		}
+	}()
+
+	failed := false
+	seen := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		switch {
+		case id == "":
+			results[i].Err = learn.ErrMissingID
+		case seen[id]:
+			results[i].Err = learn.ErrDuplicateID
+		default:
+			seen[id] = true
+			name, svc, release, err := s.acquire(id)
+			if err != nil {
+				results[i].Err = err
+				break
+			}
+			releases = append(releases, release)
+			services[name] = svc
+			items[name] = append(items[name], i)
+			continue
+		}
+		failed = true
+	}
+
+	if failed && mode == learn.AllOrNothing {
+		return abortBatch(results), learn.ErrBatchAborted
+	}
+
+	shardMode := mode
+	if len(items) > 1 && mode == learn.AllOrNothing {
+		if !readOnly {
+			return nil, ErrCrossShardBatch
+		}
+		shardMode = learn.BestEffort
+	}
+
+	type shardResult struct {
+		items   []int
+		results []learn.BatchResult
+		err     error
+	}
+	c := make(chan shardResult, len(items))
+	for name, idx := range items {
+		go func(svc learn.UserService, idx []int) {
+			rs, err := call(ctx, svc, idx, shardMode)
+			c <- shardResult{items: idx, results: rs, err: err}
+		}(services[name], idx)
+	}
+
+	var err error
+	for range items {
+		r := <-c
+		switch {
+		case r.err != nil && len(r.results) != len(r.items):
+			for _, i := range r.items {
+				results[i].Err = r.err
+			}
+			failed = true
+			if err == nil {
+				err = r.err
+			}
+		default:
+			for j, i := range r.items {
+				results[i] = r.results[j]
+				if results[i].Err != nil {
+					failed = true
+				}
+			}
+		}
+	}
+
+	if failed && mode == learn.AllOrNothing {
+		return abortBatch(results), learn.ErrBatchAborted
+	}
+
+	return results, err
+}
+
+// abortBatch marks every successful result in an AllOrNothing batch as
+// aborted, leaving the errors of the items that caused the abort in place.
+func abortBatch(results []learn.BatchResult) []learn.BatchResult {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i] = learn.BatchResult{Err: learn.ErrBatchAborted}
+		}
+	}
+
+	return results
+}
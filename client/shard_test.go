@@ -0,0 +1,148 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/briankassouf/learn"
+)
+
+func TestRing(t *testing.T) {
+	const keys = 100000
+
+	r := NewRing(0)
+	if _, ok := r.Owner("user"); ok {
+		t.Error("empty ring owns a key")
+	}
+	for i := 0; i < 4; i++ {
+		r.Add(fmt.Sprint("s", i))
+	}
+
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprint("user", i)
+		owners[key], _ = r.Owner(key)
+		counts[owners[key]]++
+	}
+	for shard, n := range counts {
+		if n < keys/8 || n > keys/2 {
+			t.Errorf("%s owns %d of %d keys", shard, n, keys)
+		}
+	}
+
+	// Only keys moving to the new shard move, about a fifth of them.
+	r.Add("s4")
+	moved := 0
+	for key, owner := range owners {
+		if o, _ := r.Owner(key); o != owner {
+			if o != "s4" {
+				t.Fatalf("%s moved from %s to %s", key, owner, o)
+			}
+			moved++
+		}
+	}
+	if moved < keys/10 || moved > keys*3/10 {
+		t.Errorf("%d of %d keys moved", moved, keys)
+	}
+
+	r.Remove("s4")
+	for key, owner := range owners {
+		if o, _ := r.Owner(key); o != owner {
+			t.Fatalf("%s owned by %s after removing s4, want %s", key, o, owner)
+		}
+	}
+}
+
+func TestBoundedLoadRing(t *testing.T) {
+	r := NewBoundedLoadRing(0, 1.25)
+	for i := 0; i < 4; i++ {
+		r.Add(fmt.Sprint("s", i))
+	}
+
+	// A hot key spills over to other shards once its owner is full.
+	load := make(map[string]int)
+	var releases []func()
+	for i := 0; i < 1000; i++ {
+		shard, release, ok := r.Acquire("hot")
+		if !ok {
+			t.Fatal("no shard acquired")
+		}
+		load[shard]++
+		releases = append(releases, release)
+	}
+	for shard, n := range load {
+		if n > 1000*5/4/4+1 {
+			t.Errorf("%s took %d of 1000 requests", shard, n)
+		}
+	}
+
+	for _, release := range releases {
+		release()
+	}
+	if r.total != 0 || len(r.load) != 0 {
+		t.Errorf("load after releasing everything = %d, %v", r.total, r.load)
+	}
+}
+
+func TestSharded(t *testing.T) {
+	ctx := context.Background()
+
+	s := NewSharded(NewRing(0))
+	if _, err := s.GetUser(ctx, "u0"); err != ErrNoShards {
+		t.Errorf("GetUser without shards = %v, want ErrNoShards", err)
+	}
+	shards := make(map[string]learn.UserService)
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprint("s", i)
+		shards[name] = learn.NewBasicService()
+		s.AddShard(name, shards[name])
+	}
+
+	var users []*learn.User
+	for i := 0; i < 30; i++ {
+		users = append(users, &learn.User{Id: fmt.Sprint("u", i)})
+	}
+	results, err := s.BatchCreateUsers(ctx, users, learn.BestEffort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if r.Err != nil || r.User.Id != users[i].Id {
+			t.Errorf("result %d = %+v, want %s", i, r, users[i].Id)
+		}
+	}
+
+	// Every user lands on its owner, and is read back from there.
+	for _, u := range users {
+		owner, _ := s.ring.Owner(u.Id)
+		if _, err := shards[owner].GetUser(ctx, u.Id); err != nil {
+			t.Errorf("%s not on its owner %s: %v", u.Id, owner, err)
+		}
+		if _, err := s.GetUser(ctx, u.Id); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := s.BatchCreateUsers(ctx, users, learn.AllOrNothing); err != ErrCrossShardBatch {
+		t.Errorf("cross-shard AllOrNothing batch = %v, want ErrCrossShardBatch", err)
+	}
+
+	results, err = s.BatchGetUsers(ctx, []string{"u1", "nope", "u2"}, learn.AllOrNothing)
+	if err != learn.ErrBatchAborted {
+		t.Errorf("AllOrNothing get with a missing user = %v, want ErrBatchAborted", err)
+	}
+	if results[0].Err != learn.ErrBatchAborted || results[1].Err != learn.ErrNotFound {
+		t.Errorf("results = %+v", results)
+	}
+
+	results, err = s.BatchGetUsers(ctx, []string{"u1", "u2", "u1", ""}, learn.BestEffort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[1].User.Id != "u2" || results[2].Err != learn.ErrDuplicateID || results[3].Err != learn.ErrMissingID {
+		t.Errorf("results = %+v", results)
+	}
+}
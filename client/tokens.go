@@ -0,0 +1,170 @@
+package client
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/net/context"
+
+	"github.com/briankassouf/learn"
+	"github.com/go-kit/kit/auth/jwt"
+)
+
+// TokenSource provides the bearer token attached to the calls of a client.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc is an adapter to use a func as a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+// Token calls f.
+func (f TokenSourceFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticTokens returns a TokenSource that always provides token, such as one
+// handed out to a service account.
+func StaticTokens(token string) TokenSource {
+	return TokenSourceFunc(func(context.Context) (string, error) { return token, nil })
+}
+
+// ForwardedTokens returns a TokenSource that provides the token of the call
+// being served, which the server transport put in the context, so that a
+// server calling another on behalf of its caller does so with the caller's
+// credentials. A caller's API key, including one sent in the X-Api-Key
+// header, is forwarded too, as a bearer token.
+func ForwardedTokens() TokenSource {
+	return TokenSourceFunc(func(ctx context.Context) (string, error) {
+		if token, ok := ctx.Value(jwt.JWTTokenContextKey).(string); ok {
+			return token, nil
+		}
+		if key, ok := learn.APIKeyFromContext(ctx); ok {
+			return key, nil
+		}
+		return "", jwt.ErrTokenContextMissing
+	})
+}
+
+// FileTokens returns a TokenSource that provides the token held by the file
+// at path, such as one kept fresh by a sidecar. The file is read again
+// whenever it changes.
+func FileTokens(path string) TokenSource {
+	return &fileTokens{path: path}
+}
+
+type fileTokens struct {
+	path string
+
+	mtx     sync.Mutex
+	token   string
+	modTime time.Time
+	size    int64
+}
+
+func (f *fileTokens) Token(context.Context) (string, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return "", err
+	}
+	if f.token != "" && fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return f.token, nil
+	}
+
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return "", err
+	}
+	f.token, f.modTime, f.size = strings.TrimSpace(string(data)), fi.ModTime(), fi.Size()
+	return f.token, nil
+}
+
+// SignedTokens returns a TokenSource that signs its own tokens with key,
+// using method, such as stdjwt.SigningMethodRS256 with an *rsa.PrivateKey.
+// Tokens carry claims, such as the sub, iss and aud the servers expect, and
+// the key id kid unless it is empty. Each expires after lifetime, and is
+// reused until shortly before then.
+func SignedTokens(method stdjwt.SigningMethod, kid string, key interface{}, claims stdjwt.MapClaims, lifetime time.Duration) TokenSource {
+	sign := func(context.Context) (string, error) {
+		now := time.Now()
+		c := stdjwt.MapClaims{}
+		for k, v := range claims {
+			c[k] = v
+		}
+		c["iat"] = now.Unix()
+		c["exp"] = now.Add(lifetime).Unix()
+
+		token := stdjwt.NewWithClaims(method, c)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		return token.SignedString(key)
+	}
+
+	return RefreshingTokens(sign, lifetime/10)
+}
+
+// RefreshingTokens returns a TokenSource that gets its tokens from
+// authenticate, such as a call exchanging credentials for a token. A token
+// is reused until early before it expires, and concurrent calls share a
+// single refresh. If a refresh fails, the token got last is used for as long
+// as it is still valid. Tokens without an expiry are reused for good.
+func RefreshingTokens(authenticate func(ctx context.Context) (string, error), early time.Duration) TokenSource {
+	return &refreshingTokens{authenticate: authenticate, early: early}
+}
+
+type refreshingTokens struct {
+	authenticate func(ctx context.Context) (string, error)
+	early        time.Duration
+
+	mtx     sync.Mutex
+	token   string
+	expires time.Time // zero if the token doesn't expire
+}
+
+func (r *refreshingTokens) Token(ctx context.Context) (string, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	now := time.Now()
+	if r.token != "" && (r.expires.IsZero() || now.Add(r.early).Before(r.expires)) {
+		return r.token, nil
+	}
+
+	token, err := r.authenticate(ctx)
+	if err != nil {
+		if r.token != "" && now.Before(r.expires) {
+			return r.token, nil
+		}
+		return "", err
+	}
+	r.token, r.expires = token, tokenExpiry(token)
+	return r.token, nil
+}
+
+// tokenExpiry returns the expiry of a JWT, read without verifying it, or the
+// zero time if it has none.
+func tokenExpiry(token string) time.Time {
+	claims := stdjwt.MapClaims{}
+	parser := &stdjwt.Parser{UseJSONNumber: true}
+	if _, _, err := parser.ParseUnverified(token, claims); err != nil {
+		return time.Time{}
+	}
+	exp, ok := claims["exp"].(json.Number)
+	if !ok {
+		return time.Time{}
+	}
+	sec, err := exp.Float64()
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(sec), 0)
+}
@@ -0,0 +1,223 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/briankassouf/learn"
+	"github.com/briankassouf/learn/pb"
+	"github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/log"
+)
+
+// countingAuthenticate returns an authenticate func for RefreshingTokens
+// handing out tokens that expire after lifetime, failing while down is set,
+// and the number of calls made to it.
+func countingAuthenticate(lifetime time.Duration, down *int32) (func(context.Context) (string, error), *int64) {
+	calls := new(int64)
+	return func(context.Context) (string, error) {
+		atomic.AddInt64(calls, 1)
+		if atomic.LoadInt32(down) != 0 {
+			return "", errors.New("down")
+		}
+		claims := stdjwt.MapClaims{"exp": time.Now().Add(lifetime).Unix()}
+		return stdjwt.NewWithClaims(stdjwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	}, calls
+}
+
+func TestRefreshingTokens(t *testing.T) {
+	ctx := context.Background()
+	var down int32
+
+	// Concurrent calls share a token until it is about to expire.
+	authenticate, calls := countingAuthenticate(time.Minute, &down)
+	ts := RefreshingTokens(authenticate, time.Second)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := ts.Token(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt64(calls); n != 1 {
+		t.Errorf("%d authentications, want 1", n)
+	}
+
+	// Tokens are refreshed early, and while refreshes fail the last one is
+	// used for as long as it is valid.
+	authenticate, calls = countingAuthenticate(time.Minute, &down)
+	ts = RefreshingTokens(authenticate, 2*time.Minute)
+	first, err := ts.Token(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&down, 1)
+	token, err := ts.Token(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != first || atomic.LoadInt64(calls) != 2 {
+		t.Errorf("failed refresh after %d calls gave %q, want the last token", atomic.LoadInt64(calls), token)
+	}
+	atomic.StoreInt32(&down, 0)
+	ts.Token(ctx)
+	if n := atomic.LoadInt64(calls); n != 3 {
+		t.Errorf("%d authentications, want 3", n)
+	}
+
+	// Without a token to fall back on, failures are returned.
+	ts = RefreshingTokens(authenticate, 0)
+	atomic.StoreInt32(&down, 1)
+	if _, err := ts.Token(ctx); err == nil {
+		t.Error("failed authentication gave a token")
+	}
+}
+
+func TestSignedTokens(t *testing.T) {
+	ctx := context.Background()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := SignedTokens(learn.SigningMethodEdDSA, "k1", priv, stdjwt.MapClaims{"sub": "svc", "aud": "learn"}, time.Minute)
+	token, err := ts.Token(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := ts.Token(ctx); again != token {
+		t.Error("token signed again before it was due")
+	}
+
+	issuer, err := learn.NewIssuer(priv, "k1", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := learn.NewVerifier(issuer.Keys(learn.SharedSecret(nil)), "", "learn", 0)
+	claims, err := v.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "svc" {
+		t.Errorf("sub = %v, want svc", claims["sub"])
+	}
+}
+
+func TestFileTokens(t *testing.T) {
+	f, err := ioutil.TempFile("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	ts := FileTokens(f.Name())
+	for _, content := range []string{"first\n", "second"} {
+		if err := ioutil.WriteFile(f.Name(), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		token, err := ts.Token(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strings.TrimSpace(content); token != want {
+			t.Errorf("token = %q, want %q", token, want)
+		}
+	}
+
+	os.Remove(f.Name())
+	if _, err := ts.Token(context.Background()); err == nil {
+		t.Error("token read from a missing file")
+	}
+}
+
+func TestForwardedTokens(t *testing.T) {
+	ctx := context.Background()
+	if _, err := ForwardedTokens().Token(ctx); err != jwt.ErrTokenContextMissing {
+		t.Errorf("token without one to forward = %v, want jwt.ErrTokenContextMissing", err)
+	}
+
+	token, err := ForwardedTokens().Token(context.WithValue(ctx, jwt.JWTTokenContextKey, "caller"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "caller" {
+		t.Errorf("token = %q, want the caller's", token)
+	}
+
+	// So are API keys sent in the X-Api-Key header.
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set(learn.APIKeyHeader, "lrn_a_secret")
+	token, err = ForwardedTokens().Token(learn.APIKeyToHTTPContext()(ctx, r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "lrn_a_secret" {
+		t.Errorf("token = %q, want the caller's API key", token)
+	}
+}
+
+func TestTokensAttached(t *testing.T) {
+	ctx := context.Background()
+
+	// Every method carries the token over HTTP...
+	var mtx sync.Mutex
+	seen := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		seen[r.URL.Path] = r.Header.Get("Authorization")
+		mtx.Unlock()
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	svc, err := NewHTTP(srv.URL, log.NewNopLogger(), Tokens(StaticTokens("token")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.GetUser(ctx, "a")
+	svc.BatchGetUsers(ctx, []string{"a"}, learn.BestEffort)
+	mtx.Lock()
+	for _, path := range []string{"/get", "/batch/get"} {
+		if !strings.EqualFold(seen[path], "Bearer token") {
+			t.Errorf("Authorization of %s = %q, want the token", path, seen[path])
+		}
+	}
+	mtx.Unlock()
+
+	// ...and over gRPC.
+	var got atomic.Value
+	store := learn.NewBasicService()
+	endpoints := learn.Endpoints{
+		GetUserEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			token, _ := ctx.Value(jwt.JWTTokenContextKey).(string)
+			got.Store(token)
+			return learn.MakeGetUserEndpoint(store)(ctx, request)
+		},
+	}
+	conn, stop := serveGRPC(t, func(s *grpc.Server) {
+		pb.RegisterUserServiceServer(s, learn.MakeGRPCServer(ctx, endpoints, nil, nil, nil, nil, nil, log.NewNopLogger()))
+	})
+	defer stop()
+	New(conn, Tokens(StaticTokens("token"))).GetUser(ctx, "a")
+	if token, _ := got.Load().(string); token != "token" {
+		t.Errorf("token over gRPC = %q, want token", token)
+	}
+}
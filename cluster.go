@@ -0,0 +1,503 @@
+package learn
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb"
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+)
+
+// ErrUnknownCommand is returned by a node applying a Raft log entry that it
+// doesn't understand, typically one written by a newer version.
+var ErrUnknownCommand = errors.New("Unknown cluster command")
+
+const (
+	// raftApplyTimeout bounds how long a write waits to be accepted by the
+	// leader.
+	raftApplyTimeout = 10 * time.Second
+
+	// raftSnapshotsRetained is the number of snapshots kept on disk.
+	raftSnapshotsRetained = 2
+)
+
+// ClusterConfig configures a node of a Raft cluster.
+type ClusterConfig struct {
+	// ID identifies the node within the cluster. It must not change across
+	// restarts.
+	ID string
+
+	// Addr is the address the node listens on for Raft traffic, and that
+	// other nodes reach it at.
+	Addr string
+
+	// Dir holds the Raft log and snapshots. If empty, both are kept in
+	// memory, and the node starts from scratch every time.
+	Dir string
+
+	// Bootstrap starts a new cluster with this node as its only member. It is
+	// ignored if the node already has state.
+	Bootstrap bool
+
+	// AutoJoin lists the IDs of the nodes that Reconcile adds to the cluster
+	// when gossip finds them, and RemoveMember removes once gossip loses
+	// them. Other nodes are only ever added and removed by hand.
+	AutoJoin []string
+}
+
+// Cluster is a node of a Raft cluster of user stores. Every mutation is
+// committed to a majority of the nodes before being applied, in the same
+// order, to the store of each node.
+type Cluster struct {
+	id       string
+	raft     *raft.Raft
+	store    *basicService
+	autoJoin map[string]bool
+}
+
+// NewCluster starts a node of a Raft cluster. Unless it bootstraps a new
+// cluster, or rejoins one it was already a member of, it takes no part in
+// the cluster until an existing member adds it with Join.
+func NewCluster(config ClusterConfig, logger log.Logger) (*Cluster, error) {
+	store := NewBasicService().(*basicService)
+	logOutput := log.NewStdlibAdapter(logger)
+
+	addr, err := net.ResolveTCPAddr("tcp", config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := raft.NewTCPTransport(config.Addr, addr, 3, 10*time.Second, logOutput)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		logs   raft.LogStore
+		stable raft.StableStore
+		snaps  raft.SnapshotStore
+	)
+	if config.Dir == "" {
+		inmem := raft.NewInmemStore()
+		logs, stable, snaps = inmem, inmem, raft.NewInmemSnapshotStore()
+	} else {
+		if err := os.MkdirAll(config.Dir, 0700); err != nil {
+			return nil, err
+		}
+		bolt, err := raftboltdb.NewBoltStore(filepath.Join(config.Dir, "raft.db"))
+		if err != nil {
+			return nil, err
+		}
+		logs, stable = bolt, bolt
+		if snaps, err = raft.NewFileSnapshotStore(config.Dir, raftSnapshotsRetained, logOutput); err != nil {
+			return nil, err
+		}
+	}
+
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(config.ID)
+	conf.LogOutput = logOutput
+
+	r, err := raft.NewRaft(conf, &raftFSM{store: store}, logs, stable, snaps, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Bootstrap {
+		err := r.BootstrapCluster(raft.Configuration{
+			Servers: []raft.Server{{ID: conf.LocalID, Address: transport.LocalAddr()}},
+		}).Error()
+		if err != nil && err != raft.ErrCantBootstrap {
+			r.Shutdown()
+			return nil, err
+		}
+	}
+
+	autoJoin := make(map[string]bool, len(config.AutoJoin))
+	for _, id := range config.AutoJoin {
+		autoJoin[id] = true
+	}
+
+	return &Cluster{id: config.ID, raft: r, store: store, autoJoin: autoJoin}, nil
+}
+
+// Store returns the local store of the node. Reads made on it directly may be
+// stale, and it must not be written to except through Service.
+func (c *Cluster) Store() UserService {
+	return c.store
+}
+
+// Service returns the cluster as a UserService. Writes are committed
+// through Raft, and reads are linearizable. Both can only be served by the
+// leader, and fail with ErrNotLeader on any other node.
+func (c *Cluster) Service() UserService {
+	return clusterService{c}
+}
+
+// Join adds a node to the cluster as a voter. It must be called on the
+// leader.
+func (c *Cluster) Join(id, addr string) error {
+	return raftError(c.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0).Error())
+}
+
+// Leave removes a node from the cluster. It must be called on the leader.
+func (c *Cluster) Leave(id string) error {
+	return raftError(c.raft.RemoveServer(raft.ServerID(id), 0, 0).Error())
+}
+
+// Reconcile adds every member listed in AutoJoin that isn't one of the
+// servers of the cluster yet, so that nodes found by gossip join without
+// being added by hand. Gossip can't vouch for the Raft ID a member claims,
+// so members that aren't listed are ignored. It does nothing unless the node
+// is the leader.
+func (c *Cluster) Reconcile(members []Member) error {
+	if c.raft.State() != raft.Leader {
+		return nil
+	}
+
+	future := c.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return raftError(err)
+	}
+	servers := make(map[raft.ServerID]bool)
+	for _, s := range future.Configuration().Servers {
+		servers[s.ID] = true
+	}
+
+	for _, m := range members {
+		if m.RaftID == "" || !c.autoJoin[m.RaftID] || servers[raft.ServerID(m.RaftID)] {
+			continue
+		}
+		if err := c.Join(m.RaftID, m.RaftAddr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RemoveMember removes a member that gossip saw leave, or declared dead, from
+// the cluster, if it is listed in AutoJoin, so that it no longer counts
+// towards the quorum. If it comes back, Reconcile adds it again. It does
+// nothing unless the node is the leader.
+func (c *Cluster) RemoveMember(m Member) error {
+	if c.raft.State() != raft.Leader || !c.autoJoin[m.RaftID] || m.RaftID == c.id {
+		return nil
+	}
+
+	future := c.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return raftError(err)
+	}
+	for _, s := range future.Configuration().Servers {
+		if s.ID == raft.ServerID(m.RaftID) {
+			return c.Leave(m.RaftID)
+		}
+	}
+
+	return nil
+}
+
+// ClusterStatus describes a node and its view of the cluster.
+type ClusterStatus struct {
+	ID           string          `json:"id"`
+	State        string          `json:"state"`
+	Leader       string          `json:"leader"`
+	AppliedIndex uint64          `json:"applied_index"`
+	LastIndex    uint64          `json:"last_index"`
+	Servers      []ClusterServer `json:"servers"`
+}
+
+// ClusterServer is a member of the cluster.
+type ClusterServer struct {
+	ID       string `json:"id"`
+	Addr     string `json:"addr"`
+	Suffrage string `json:"suffrage"`
+}
+
+// Status returns the status of the node.
+func (c *Cluster) Status() (ClusterStatus, error) {
+	status := ClusterStatus{
+		ID:           c.id,
+		State:        c.raft.State().String(),
+		Leader:       string(c.raft.Leader()),
+		AppliedIndex: c.raft.AppliedIndex(),
+		LastIndex:    c.raft.LastIndex(),
+	}
+
+	future := c.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return status, err
+	}
+	for _, s := range future.Configuration().Servers {
+		status.Servers = append(status.Servers, ClusterServer{
+			ID:       string(s.ID),
+			Addr:     string(s.Address),
+			Suffrage: s.Suffrage.String(),
+		})
+	}
+
+	return status, nil
+}
+
+// Shutdown stops the node. It doesn't remove the node from the cluster.
+func (c *Cluster) Shutdown() error {
+	return c.raft.Shutdown().Error()
+}
+
+// apply commits a command and returns the result of applying it.
+func (c *Cluster) apply(cmd raftCommand) (raftResult, error) {
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return raftResult{}, err
+	}
+
+	future := c.raft.Apply(data, raftApplyTimeout)
+	if err := future.Error(); err != nil {
+		return raftResult{}, raftError(err)
+	}
+
+	return future.Response().(raftResult), nil
+}
+
+// barrier returns once the local store reflects every write committed before
+// it was called, and only if this node is still the leader. Reads made after
+// it returns are linearizable.
+func (c *Cluster) barrier(ctx context.Context) error {
+	// Every committed entry is at or below the last index, so once that has
+	// been applied, and leadership confirmed, the store is up to date.
+	index := c.raft.LastIndex()
+	if err := c.raft.VerifyLeader().Error(); err != nil {
+		return raftError(err)
+	}
+
+	for c.raft.AppliedIndex() < index {
+		if c.raft.State() != raft.Leader {
+			return ErrNotLeader
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	return nil
+}
+
+// raftError translates the errors of a node that isn't the leader.
+func raftError(err error) error {
+	switch err {
+	case raft.ErrNotLeader, raft.ErrLeadershipLost:
+		return ErrNotLeader
+	}
+
+	return err
+}
+
+type clusterService struct {
+	c *Cluster
+}
+
+func (s clusterService) CreateUser(_ context.Context, u *User) (*User, error) {
+	// Every node applies what is committed, so invalid users must be kept
+	// out of the log rather than fail once applied.
+	if u == nil || u.Id == "" {
+		return nil, ErrMissingID
+	}
+
+	res, err := s.c.apply(raftCommand{Op: opCreateUser, Users: []*User{u}})
+	if err != nil {
+		return nil, err
+	}
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return res.Users[0], nil
+}
+
+func (s clusterService) GetUser(ctx context.Context, id string) (*User, error) {
+	if err := s.c.barrier(ctx); err != nil {
+		return nil, err
+	}
+	return s.c.store.GetUser(ctx, id)
+}
+
+func (s clusterService) BatchCreateUsers(_ context.Context, users []*User, mode BatchMode) ([]BatchResult, error) {
+	res, err := s.c.apply(raftCommand{Op: opBatchCreateUsers, Users: users, BatchMode: mode})
+	if err != nil {
+		return nil, err
+	}
+	return res.Results, res.Err
+}
+
+func (s clusterService) BatchGetUsers(ctx context.Context, ids []string, mode BatchMode) ([]BatchResult, error) {
+	if err := s.c.barrier(ctx); err != nil {
+		return nil, err
+	}
+	return s.c.store.BatchGetUsers(ctx, ids, mode)
+}
+
+// ExpireUsers commits the expiry of the users that have expired as of now.
+// Only the leader expires users. On any other node it does nothing, so that
+// every node can run a Reaper.
+func (s clusterService) ExpireUsers(_ context.Context, now time.Time, mode DeleteMode) ([]*User, error) {
+	if s.c.raft.State() != raft.Leader {
+		return nil, nil
+	}
+
+	res, err := s.c.apply(raftCommand{Op: opExpireUsers, Now: now, DeleteMode: mode})
+	if err != nil {
+		return nil, err
+	}
+	return res.Users, nil
+}
+
+type raftOp int
+
+const (
+	opCreateUser raftOp = iota
+	opBatchCreateUsers
+	opExpireUsers
+)
+
+// raftCommand is a mutation as it is written to the Raft log. It carries
+// everything needed to apply it, so that every node applies it alike.
+type raftCommand struct {
+	Op         raftOp     `json:"op"`
+	Users      []*User    `json:"users,omitempty"`
+	BatchMode  BatchMode  `json:"batch_mode,omitempty"`
+	Now        time.Time  `json:"now"`
+	DeleteMode DeleteMode `json:"delete_mode,omitempty"`
+}
+
+// raftResult is the outcome of applying a raftCommand, handed back to the
+// node that made it.
+type raftResult struct {
+	Users   []*User
+	Results []BatchResult
+	Err     error
+}
+
+// raftFSM applies committed commands to a store.
+type raftFSM struct {
+	store *basicService
+}
+
+func (f *raftFSM) Apply(l *raft.Log) interface{} {
+	var cmd raftCommand
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return raftResult{Err: err}
+	}
+
+	ctx := context.Background()
+	switch cmd.Op {
+	case opCreateUser:
+		if len(cmd.Users) != 1 {
+			return raftResult{Err: ErrMissingID}
+		}
+		user, err := f.store.CreateUser(ctx, cmd.Users[0])
+		return raftResult{Users: []*User{user}, Err: err}
+	case opBatchCreateUsers:
+		results, err := f.store.BatchCreateUsers(ctx, cmd.Users, cmd.BatchMode)
+		return raftResult{Results: results, Err: err}
+	case opExpireUsers:
+		users, err := f.store.ExpireUsers(ctx, cmd.Now, cmd.DeleteMode)
+		return raftResult{Users: users, Err: err}
+	}
+
+	return raftResult{Err: ErrUnknownCommand}
+}
+
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	return f.store.snapshot(), nil
+}
+
+func (f *raftFSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	var snap storeSnapshot
+	if err := json.NewDecoder(rc).Decode(&snap); err != nil {
+		return err
+	}
+
+	f.store.restore(snap)
+	return nil
+}
+
+// storeSnapshot is the content of a store at a point in time, in id order.
+type storeSnapshot struct {
+	Users   []*User `json:"users"`
+	Deleted []*User `json:"deleted"`
+}
+
+func (s storeSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := json.NewEncoder(sink).Encode(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+
+	return sink.Close()
+}
+
+func (s storeSnapshot) Release() {}
+
+// snapshot copies the content of the store. Stored users are replaced rather
+// than modified, so copying the pointers is enough.
+func (s *basicService) snapshot() storeSnapshot {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	var snap storeSnapshot
+	for _, user := range s.Users {
+		snap.Users = append(snap.Users, user)
+	}
+	for _, user := range s.deleted {
+		snap.Deleted = append(snap.Deleted, user)
+	}
+	sort.Sort(byID(snap.Users))
+	sort.Sort(byID(snap.Deleted))
+
+	return snap
+}
+
+// restore replaces the content of the store with a snapshot. The difference
+// is published as changes, so that subscribers stay in step with the store.
+func (s *basicService) restore(snap storeSnapshot) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	users := make(map[string]*User, len(snap.Users))
+	for _, user := range snap.Users {
+		users[user.Id] = user
+	}
+	deleted := make(map[string]*User, len(snap.Deleted))
+	for _, user := range snap.Deleted {
+		deleted[user.Id] = user
+	}
+
+	now := time.Now()
+	for id, user := range s.Users {
+		if _, ok := users[id]; !ok {
+			_, soft := deleted[id]
+			s.publish(Change{Type: UserExpired, User: user, Time: now, Hard: !soft})
+		}
+	}
+	for _, user := range snap.Users {
+		// Users decoded from a snapshot are never the same pointers, so
+		// they are compared by value.
+		if old, ok := s.Users[user.Id]; !ok || userDigest(old, false) != userDigest(user, false) {
+			s.publish(Change{Type: UserCreated, User: user, Time: now})
+		}
+	}
+
+	s.Users, s.deleted = users, deleted
+}
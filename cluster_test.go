@@ -0,0 +1,239 @@
+package learn
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/hashicorp/raft"
+	"golang.org/x/net/context"
+)
+
+// freeAddr returns a local address that nothing listens on.
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// waitForLeader waits for one of the nodes to become the leader, and
+// returns it.
+func waitForLeader(t *testing.T, nodes ...*Cluster) *Cluster {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, c := range nodes {
+			if c.raft.State() == raft.Leader {
+				return c
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("no leader elected after 10s")
+	return nil
+}
+
+// waitFor waits for cond to hold.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s didn't happen after 10s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	if testing.Short() {
+		t.Skip("elections take seconds")
+	}
+	ctx := context.Background()
+
+	var (
+		nodes []*Cluster
+		addrs []string
+	)
+	for i := 0; i < 3; i++ {
+		addrs = append(addrs, freeAddr(t))
+		c, err := NewCluster(ClusterConfig{
+			ID:        fmt.Sprint("node", i),
+			Addr:      addrs[i],
+			Bootstrap: i == 0,
+		}, log.NewNopLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Shutdown()
+		nodes = append(nodes, c)
+	}
+
+	// The bootstrapped node elects itself and adds the others, the last
+	// over its membership handler, which only admins may change.
+	leader := waitForLeader(t, nodes[0])
+	if err := leader.Join(nodes[1].id, addrs[1]); err != nil {
+		t.Fatal(err)
+	}
+	sa, _ := NewServiceAccounts("")
+	sa.CreateAccount("root", []string{AdminRole})
+	sa.CreateAccount("batch", nil)
+	admin, _, _ := sa.IssueKey("root", 0)
+	batch, _, _ := sa.IssueKey("batch", 0)
+	srv := httptest.NewServer(MakeClusterHTTPHandler(ctx, leader, sa, log.NewNopLogger()))
+	defer srv.Close()
+	body := `{"id":"node2","addr":"` + addrs[2] + `"}`
+	for _, tc := range []struct {
+		key  string
+		code int
+	}{
+		{"", http.StatusUnauthorized},
+		{batch, http.StatusForbidden},
+		{admin, http.StatusNoContent},
+	} {
+		req, _ := http.NewRequest("POST", srv.URL+"/cluster/join", strings.NewReader(body))
+		if tc.key != "" {
+			req.Header.Set(APIKeyHeader, tc.key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.code {
+			t.Errorf("join with key %q = %d, want %d", tc.key, resp.StatusCode, tc.code)
+		}
+		if status, _ := leader.Status(); tc.code != http.StatusNoContent && len(status.Servers) != 2 {
+			t.Errorf("servers after a refused join = %+v", status.Servers)
+		}
+	}
+
+	if _, err := leader.Service().CreateUser(ctx, &User{Id: "a", FirstName: "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leader.Service().CreateUser(ctx, &User{}); err != ErrMissingID {
+		t.Errorf("CreateUser without an id = %v, want %v", err, ErrMissingID)
+	}
+	if _, err := leader.Service().CreateUser(ctx, nil); err != ErrMissingID {
+		t.Errorf("CreateUser of nil = %v, want %v", err, ErrMissingID)
+	}
+
+	// Reads on the leader see every committed write. Other nodes refuse
+	// them, though their stores catch up.
+	if u, err := leader.Service().GetUser(ctx, "a"); err != nil || u.FirstName != "Ann" {
+		t.Errorf("GetUser on the leader = %+v, %v", u, err)
+	}
+	for _, c := range nodes[1:] {
+		if _, err := c.Service().GetUser(ctx, "a"); err != ErrNotLeader {
+			t.Errorf("GetUser on a follower = %v, want %v", err, ErrNotLeader)
+		}
+		waitFor(t, "replication to "+c.id, func() bool {
+			_, err := c.Store().GetUser(ctx, "a")
+			return err == nil
+		})
+	}
+
+	// Once the leader is gone the others elect one of their own, which has
+	// every write.
+	leader.Shutdown()
+	newLeader := waitForLeader(t, nodes[1:]...)
+	if u, err := newLeader.Service().GetUser(ctx, "a"); err != nil || u.FirstName != "Ann" {
+		t.Errorf("GetUser on the new leader = %+v, %v", u, err)
+	}
+
+	// The old leader can be removed, leaving a cluster of two.
+	if err := newLeader.Leave(leader.id); err != nil {
+		t.Fatal(err)
+	}
+	status, err := newLeader.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Servers) != 2 {
+		t.Errorf("servers after a leave = %+v", status.Servers)
+	}
+	if _, err := newLeader.Service().CreateUser(ctx, &User{Id: "b"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRaftFSMApply(t *testing.T) {
+	f := &raftFSM{store: NewBasicService().(*basicService)}
+
+	// Commands that got into the log before they were checked must fail
+	// rather than bring down every node.
+	for _, data := range []string{
+		`{"op":0,"users":[null]}`,
+		`{"op":0}`,
+		`{"op":0,"users":[{}]}`,
+	} {
+		res := f.Apply(&raft.Log{Data: []byte(data)}).(raftResult)
+		if res.Err != ErrMissingID {
+			t.Errorf("Apply(%s) = %v, want %v", data, res.Err, ErrMissingID)
+		}
+	}
+
+	res := f.Apply(&raft.Log{Data: []byte(`{"op":99}`)}).(raftResult)
+	if res.Err != ErrUnknownCommand {
+		t.Errorf("Apply of an unknown op = %v, want %v", res.Err, ErrUnknownCommand)
+	}
+}
+
+// bufferSink is a raft.SnapshotSink that keeps the snapshot in memory.
+type bufferSink struct {
+	bytes.Buffer
+}
+
+func (s *bufferSink) ID() string    { return "test" }
+func (s *bufferSink) Cancel() error { return nil }
+func (s *bufferSink) Close() error  { return nil }
+
+func TestRaftFSMRestore(t *testing.T) {
+	ctx := context.Background()
+	src := &raftFSM{store: NewBasicService().(*basicService)}
+	src.store.CreateUser(ctx, &User{Id: "a"})
+	src.store.CreateUser(ctx, &User{Id: "b", FirstName: "new"})
+	src.store.CreateUser(ctx, &User{Id: "c", ExpiresAt: time.Now().Add(-time.Second)})
+	src.store.ExpireUsers(ctx, time.Now(), SoftDelete)
+
+	snap, err := src.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sink bufferSink
+	if err := snap.Persist(&sink); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := &raftFSM{store: NewBasicService().(*basicService)}
+	dst.store.CreateUser(ctx, &User{Id: "a"})
+	dst.store.CreateUser(ctx, &User{Id: "b", FirstName: "old"})
+	dst.store.CreateUser(ctx, &User{Id: "d"})
+	var changes []Change
+	dst.store.Subscribe(func(c Change) { changes = append(changes, c) })
+
+	if err := dst.Restore(&sink); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the users that differ are published: b changed and d is gone.
+	if len(changes) != 2 {
+		t.Errorf("restore published %+v", changes)
+	}
+	if u, err := dst.store.GetUser(ctx, "b"); err != nil || u.FirstName != "new" {
+		t.Errorf("GetUser = %+v, %v", u, err)
+	}
+	if _, err := dst.store.GetUser(ctx, "d"); err != ErrNotFound {
+		t.Errorf("GetUser of a user missing from the snapshot = %v", err)
+	}
+	if len(dst.store.deleted) != 1 {
+		t.Errorf("restored %d soft deleted users, want 1", len(dst.store.deleted))
+	}
+}
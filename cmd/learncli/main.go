@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"golang.org/x/net/context"
@@ -16,9 +22,24 @@ import (
 
 func main() {
 	var (
-		grpcAddr = flag.String("grpc.addr", "", "gRPC (HTTP) address of addsvc")
+		grpcAddr = flag.String("grpc.addr", "", "gRPC (HTTP) address of addsvc, or comma-separated addresses of shards")
 		httpAddr = flag.String("http.addr", "", "http address")
-		method   = flag.String("method", "create", "create, get")
+		method   = flag.String("method", "create", "create, get, import, export, login, refresh, passwd, sessions, revoke, revoke-all, mfa-enroll, mfa-confirm, mfa-verify, mfa-disable, unlock")
+		ttl      = flag.Duration("ttl", 0, "with create, make a guest user that expires after this long")
+		importID = flag.String("import.id", "", "id of the import, used to resume it (defaults to the file name)")
+		prefix   = flag.String("export.prefix", "", "only export users whose id has this prefix")
+		domain   = flag.String("export.domain", "", "only export users with an email address at this domain")
+		after    = flag.String("export.after", "", "only export users whose id sorts after this one")
+		secret   = flag.String("jwt.secret", "", "HS256 secret of the server to sign tokens with when there is no -token, -token.file or -apikey, empty to send none")
+		apiKey   = flag.String("apikey", "", "API key of a service account to send with every call instead of a token")
+		token    = flag.String("token", "", "token to send with every call")
+		tokFile  = flag.String("token.file", "", "file holding the token to send with every call, read again when it changes")
+		timeout  = flag.Duration("timeout", 0, "bound every attempt of a call to this long, 0 for no bound")
+		useTLS   = flag.Bool("tls", false, "connect over TLS, implied by the other -tls flags")
+		tlsCA    = flag.String("tls.ca", "", "PEM file of the CAs the server is verified with, empty for those of the system")
+		tlsCert  = flag.String("tls.cert", "", "PEM client certificate file, for servers requiring mutual TLS")
+		tlsKey   = flag.String("tls.key", "", "PEM key file of -tls.cert")
+		mfaCode  = flag.String("mfa.code", "", "with login, TOTP or recovery code completing the MFA challenge; with mfa-disable, the code proving the user has MFA")
 	)
 	flag.Parse()
 
@@ -32,18 +53,86 @@ func main() {
 		os.Exit(1)
 	}
 
+	// The arguments of the auth methods.
+	authArgs := map[string][]string{
+		"login":       {"username", "password"},
+		"refresh":     {"refresh token"},
+		"passwd":      {"user id", "password"},
+		"sessions":    {"user id"},
+		"revoke":      {"user id", "session id"},
+		"revoke-all":  {"user id"},
+		"mfa-enroll":  {"user id"},
+		"mfa-confirm": {"user id", "code"},
+		"mfa-verify":  {"challenge", "code"},
+		"mfa-disable": {"user id"},
+		"unlock":      {"username or client ip"},
+	}
+	if names, ok := authArgs[*method]; ok && len(flag.Args()) != len(names) {
+		fmt.Fprintf(os.Stderr, "usage: learncli --method=%s <%s>\n", *method, strings.Join(names, "> <"))
+		os.Exit(1)
+	}
+
+	if len(flag.Args()) != 1 && *method == "import" {
+		fmt.Fprintf(os.Stderr, "usage: learncli --grpc.addr=<addr> --method=import [--import.id=<id>] <file of JSON users, one per line>\n")
+		os.Exit(1)
+	}
+
+	var options []client.Option
+	switch {
+	case *apiKey != "":
+		options = append(options, client.APIKey(*apiKey))
+	case *tokFile != "":
+		options = append(options, client.Tokens(client.FileTokens(*tokFile)))
+	case *token != "":
+		options = append(options, client.Tokens(client.StaticTokens(*token)))
+	case *secret != "":
+		options = append(options, client.SigningKey(*secret))
+	}
+	if *timeout > 0 {
+		options = append(options, client.Timeout(*timeout))
+	}
+	security := grpc.WithInsecure()
+	if *useTLS || *tlsCA != "" || *tlsCert != "" {
+		files, err := learn.NewTLSFiles(*tlsCert, *tlsKey, *tlsCA, time.Minute, log.NewNopLogger())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		options = append(options, client.TLS(files))
+		security = grpc.WithTransportCredentials(files.ClientCredentials(""))
+	}
+
 	var service learn.UserService
+	var authService learn.AuthService
+	var conn *grpc.ClientConn
 	var err error
 	if *httpAddr != "" {
-		service, err = client.NewHTTP(*httpAddr, log.NewNopLogger())
+		service, err = client.NewHTTP(*httpAddr, log.NewNopLogger(), options...)
+		if err == nil {
+			authService, err = client.NewAuthHTTP(*httpAddr, log.NewNopLogger(), options...)
+		}
+	} else if strings.Contains(*grpcAddr, ",") {
+		// Users are spread across the shards by id.
+		sharded := client.NewSharded(client.NewRing(client.DefaultReplicas))
+		for _, addr := range strings.Split(*grpcAddr, ",") {
+			c, err := grpc.Dial(addr, security, grpc.WithTimeout(time.Second))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v", err)
+				os.Exit(1)
+			}
+			defer c.Close()
+			sharded.AddShard(addr, client.New(c, options...))
+		}
+		service = sharded
 	} else if *grpcAddr != "" {
-		conn, err := grpc.Dial(*grpcAddr, grpc.WithInsecure(), grpc.WithTimeout(time.Second))
+		conn, err = grpc.Dial(*grpcAddr, security, grpc.WithTimeout(time.Second))
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v", err)
 			os.Exit(1)
 		}
 		defer conn.Close()
-		service = client.New(conn)
+		service = client.New(conn, options...)
+		authService = client.NewAuth(conn, options...)
 
 	} else {
 		fmt.Fprintf(os.Stderr, "error: no remote address specified\n")
@@ -54,14 +143,59 @@ func main() {
 		os.Exit(1)
 	}
 
+	if _, ok := authArgs[*method]; ok && authService == nil {
+		fmt.Fprintf(os.Stderr, "error: %s requires --http.addr or a single --grpc.addr\n", *method)
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	args := flag.Args()
 	switch *method {
+	case "login":
+		tokens, err := authService.Authenticate(ctx, args[0], args[1])
+		if err == nil && tokens.Challenge != "" && *mfaCode != "" {
+			tokens, err = authService.VerifyMFA(ctx, tokens.Challenge, *mfaCode)
+		}
+		printJSON(tokens, err)
+	case "mfa-verify":
+		tokens, err := authService.VerifyMFA(ctx, args[0], args[1])
+		printJSON(tokens, err)
+	case "refresh":
+		tokens, err := authService.Refresh(ctx, args[0])
+		printJSON(tokens, err)
+	case "passwd":
+		printJSON(nil, authService.SetPassword(ctx, args[0], args[1]))
+	case "sessions":
+		sessions, err := authService.ListSessions(ctx, args[0])
+		printJSON(sessions, err)
+	case "revoke":
+		printJSON(nil, authService.RevokeSession(ctx, args[0], args[1]))
+	case "revoke-all":
+		printJSON(nil, authService.RevokeSessions(ctx, args[0]))
+	case "mfa-enroll":
+		enrollment, err := authService.EnrollMFA(ctx, args[0])
+		printJSON(enrollment, err)
+	case "mfa-confirm":
+		codes, err := authService.ConfirmMFA(ctx, args[0], args[1])
+		printJSON(codes, err)
+	case "mfa-disable":
+		printJSON(nil, authService.DisableMFA(ctx, args[0], *mfaCode))
+	case "unlock":
+		if net.ParseIP(args[0]) != nil {
+			printJSON(nil, authService.Unlock(ctx, "", args[0]))
+		} else {
+			printJSON(nil, authService.Unlock(ctx, args[0], ""))
+		}
 	case "create":
 		user := &learn.User{
-			flag.Args()[0],
-			flag.Args()[1],
-			flag.Args()[2],
-			flag.Args()[3],
-			flag.Args()[4],
+			Id:        flag.Args()[0],
+			FirstName: flag.Args()[1],
+			LastName:  flag.Args()[2],
+			Email:     flag.Args()[3],
+			Username:  flag.Args()[4],
+		}
+		if *ttl > 0 {
+			user.ExpiresAt = time.Now().Add(*ttl)
 		}
 
 		u, err := service.CreateUser(context.Background(), user)
@@ -81,5 +215,104 @@ func main() {
 		}
 
 		fmt.Println(u)
+	case "import":
+		if conn == nil {
+			fmt.Fprintf(os.Stderr, "error: import requires a single --grpc.addr\n")
+			os.Exit(1)
+		}
+		path := flag.Args()[0]
+		id := *importID
+		if id == "" {
+			id = filepath.Base(path)
+		}
+
+		status, err := client.Import(context.Background(), conn, id, fileSource(path), 5, options...)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Printf("import %s: %d applied, %d failed\n", status.ImportId, status.Applied, status.Failed)
+		for _, f := range status.Failures {
+			fmt.Printf("  line %d (%s): %v\n", f.Offset+1, f.Id, f.Err)
+		}
+	case "export":
+		if conn == nil && *httpAddr == "" {
+			fmt.Fprintf(os.Stderr, "error: export requires --http.addr or a single --grpc.addr\n")
+			os.Exit(1)
+		}
+		// Users are written one JSON object per line, which is the format
+		// import reads.
+		filter := learn.ExportFilter{IdPrefix: *prefix, EmailDomain: *domain, After: *after}
+		enc := json.NewEncoder(os.Stdout)
+		write := func(u *learn.User) error { return enc.Encode(u) }
+
+		if conn != nil {
+			err = client.Export(context.Background(), conn, filter, write, options...)
+		} else {
+			err = client.ExportHTTP(context.Background(), *httpAddr, filter, write, options...)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// printJSON prints v as indented JSON, or err if the call failed.
+func printJSON(v interface{}, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	if v == nil {
+		return
+	}
+	b, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(b))
+}
+
+// fileSource reads users for an import from a file holding one JSON-encoded
+// user per line. The offset of a user is its line number, counting from 0.
+func fileSource(path string) client.ImportSource {
+	return func(offset int64) (learn.UserReader, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		r := &lineReader{f: f, scanner: bufio.NewScanner(f)}
+		for i := int64(0); i < offset; i++ {
+			if !r.scanner.Scan() {
+				break
+			}
+		}
+
+		return r, nil
+	}
+}
+
+type lineReader struct {
+	f       *os.File
+	scanner *bufio.Scanner
+}
+
+func (r *lineReader) Read() (*learn.User, error) {
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	var user learn.User
+	if err := json.Unmarshal(r.scanner.Bytes(), &user); err != nil {
+		return nil, err
 	}
+
+	return &user, nil
+}
+
+func (r *lineReader) Close() error {
+	return r.f.Close()
 }
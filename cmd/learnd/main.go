@@ -1,6 +1,11 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
 	"flag"
 	"fmt"
 	"net"
@@ -8,17 +13,17 @@ import (
 	"net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	stdjwt "github.com/dgrijalva/jwt-go"
 	jujuratelimit "github.com/juju/ratelimit"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 
 	"github.com/briankassouf/learn"
+	"github.com/briankassouf/learn/client"
 	"github.com/briankassouf/learn/pb"
-	"github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics"
@@ -29,8 +34,60 @@ import (
 
 func main() {
 	var (
-		httpAddr = flag.String("http.addr", ":8081", "HTTP listen address")
-		grpcAddr = flag.String("grpc.addr", ":8082", "gRPC (HTTP) listen address")
+		httpAddr    = flag.String("http.addr", ":8081", "HTTP listen address")
+		grpcAddr    = flag.String("grpc.addr", ":8082", "gRPC (HTTP) listen address")
+		importChunk = flag.Int("import.chunk", 500, "number of users applied at a time by ImportUsers")
+		reapEvery   = flag.Duration("reaper.interval", time.Minute, "how often expired users are deleted")
+		reapHard    = flag.Bool("reaper.hard", false, "delete expired users outright instead of soft deleting them")
+		cacheSize   = flag.Int("cache.size", 10000, "number of users held by the read cache, 0 to disable it")
+		cacheTTL    = flag.Duration("cache.ttl", time.Minute, "how long a user is held by the read cache")
+		cacheNegTTL = flag.Duration("cache.negative-ttl", 5*time.Second, "how long the read cache remembers that a user doesn't exist")
+		idemTTL     = flag.Duration("idempotency.ttl", 24*time.Hour, "how long the user created for an idempotency key is remembered, 0 to ignore idempotency keys")
+		leaderAddr  = flag.String("replication.leader", "", "gRPC address of the leader to follow, empty to run as the leader")
+		forward     = flag.Bool("replication.forward", true, "forward writes made on a follower to its leader instead of rejecting them")
+		replRetain  = flag.Int("replication.retain", 100000, "latest changes kept for followers to replicate, 0 to keep every change; followers further behind bootstrap from an export")
+		replAPIKey  = flag.String("replication.api-key", "", "API key of a service account on the leader that a follower replicates with")
+		raftID      = flag.String("raft.id", "", "ID of this node in a Raft cluster, empty to run without one")
+		raftAddr    = flag.String("raft.addr", "127.0.0.1:7000", "Raft listen address, which other nodes must be able to reach")
+		raftDir     = flag.String("raft.dir", "", "directory for the Raft log and snapshots, empty to keep them in memory")
+		bootstrap   = flag.Bool("raft.bootstrap", false, "start a new Raft cluster with this node as its only member")
+		joinAddr    = flag.String("raft.join", "", "debug address of the Raft leader to ask to add this node")
+		joinAPIKey  = flag.String("raft.join-api-key", "", "API key of an admin service account on the -raft.join leader that this node asks to be added with")
+		autoJoin    = flag.String("raft.auto-join", "", "comma-separated IDs of the Raft nodes added to the cluster when gossip finds them, and removed when gossip loses them")
+		debugAddr   = flag.String("debug.addr", ":8080", "debug, metrics and admin listen address, served over TLS with -tls.cert")
+		jwtKeys     = flag.String("jwt.keys", "", "comma-separated PEM or JWKS (.json) files of the keys that tokens are signed with")
+		jwtReload   = flag.Duration("jwt.reload", 10*time.Second, "how often the files of -jwt.keys are checked for changes")
+		jwtSecret   = flag.String("jwt.secret", "", "HS256 secret that tokens are signed with when there is no -jwt.keys; one of the two is required")
+		jwtIssuer   = flag.String("jwt.issuer", "", "issuer that tokens must carry, empty to accept any")
+		jwtAudience = flag.String("jwt.audience", "", "audience that tokens must be meant for, empty to accept any")
+		jwtSkew     = flag.Duration("jwt.skew", 30*time.Second, "clock skew allowed when checking the times of tokens")
+		jwtSignKey  = flag.String("jwt.signing-key", "", "PEM private key file that access tokens are signed with, its base name without extension as key id; required with -jwt.keys, otherwise they are signed with -jwt.secret")
+		accessTTL   = flag.Duration("auth.access-ttl", 15*time.Minute, "how long the access tokens of sessions last")
+		refreshTTL  = flag.Duration("auth.refresh-ttl", 30*24*time.Hour, "how long a session lasts without being refreshed; every node keeps its own sessions and passwords")
+		authAdmins  = flag.String("auth.admins", "", "comma-separated IDs of the users whose sessions carry the admin role, which is only granted to sessions started with an MFA code")
+		mfaIssuer   = flag.String("auth.mfa-issuer", "learn", "name of the service shown in the authenticator apps of users enrolled in MFA")
+		lockAccount = flag.Int("auth.lockout.account", 5, "failed logins in a row that lock a username out, 0 for no limit")
+		lockIP      = flag.Int("auth.lockout.ip", 50, "failed logins in a row that lock a client IP out, 0 for no limit")
+		lockout     = flag.Duration("auth.lockout", time.Minute, "how long the first lockout lasts; each further failure doubles it")
+		lockoutMax  = flag.Duration("auth.lockout.max", time.Hour, "the longest a lockout lasts")
+		passMinLen  = flag.Int("password.min-length", 8, "fewest characters a password may have")
+		passMaxLen  = flag.Int("password.max-length", 0, "most characters a password may have, 0 for as many as bcrypt hashes (72 bytes)")
+		passClasses = flag.Int("password.min-classes", 0, "fewest of lower case letters, upper case letters, digits and symbols a password must mix")
+		passNoInfo  = flag.Bool("password.no-user-info", true, "refuse passwords containing the username or email address of their user")
+		breached    = flag.String("password.breached", "", "file of SHA-1 hashes of breached passwords, or directory of their k-anonymity range files, to refuse")
+		apiKeysFile = flag.String("apikeys.file", "", "file that service accounts and hashes of their API keys are kept in, empty to keep them in memory; every node keeps its own")
+		tlsCert     = flag.String("tls.cert", "", "PEM certificate file of the HTTP, gRPC and debug listeners, empty to serve without TLS")
+		tlsKey      = flag.String("tls.key", "", "PEM key file of -tls.cert")
+		tlsCA       = flag.String("tls.ca", "", "PEM file of the CAs that client certificates, and the certificate of the leader, are verified with")
+		tlsAuth     = flag.String("tls.client-auth", "optional", "with -tls.ca, whether clients must present a certificate: none, optional or require")
+		tlsReload   = flag.Duration("tls.reload", 30*time.Second, "how often the TLS files are checked for changes")
+		gossipAddr  = flag.String("gossip.addr", "", "gossip listen address, over UDP and TCP, empty to disable gossip")
+		gossipName  = flag.String("gossip.name", "", "name of this node among the gossip members, defaults to -raft.id or -gossip.addr")
+		gossipJoin  = flag.String("gossip.join", "", "comma-separated gossip addresses of existing members to join")
+		gossipKey   = flag.String("gossip.key", "", "base64 16, 24 or 32 byte key that gossip is encrypted with, the same on every member; required with -gossip.addr")
+		oidcIssuer  = flag.String("oidc.issuer", "", "URL of the OpenID Connect provider served over HTTP, which its tokens carry, empty to disable it")
+		oidcClients = flag.String("oidc.clients", "", "JSON file of the clients of the OpenID Connect provider, with their id, secret and redirect_uris")
+		repairEvery = flag.Duration("antientropy.interval", 10*time.Minute, "how often a follower repairs itself from its leader with anti-entropy")
 	)
 	flag.Parse()
 
@@ -61,6 +118,106 @@ func main() {
 			Help:      "Total count of get operations",
 		}, []string{})
 	}
+	var expired metrics.Counter
+	var reapDuration metrics.TimeHistogram
+	{
+		// Reaper metrics.
+		expired = prometheus.NewCounter(stdprometheus.CounterOpts{
+			Namespace: "learn",
+			Name:      "user_expired",
+			Help:      "Total count of expired users deleted",
+		}, []string{})
+		reapDuration = metrics.NewTimeHistogram(time.Nanosecond, prometheus.NewSummary(stdprometheus.SummaryOpts{
+			Namespace: "learn",
+			Name:      "reap_duration_ns",
+			Help:      "Duration of expired user sweeps in nanoseconds.",
+		}, []string{}))
+	}
+	var cacheHits, cacheMisses, cacheEvictions metrics.Counter
+	{
+		// Cache metrics.
+		cacheHits = prometheus.NewCounter(stdprometheus.CounterOpts{
+			Namespace: "learn",
+			Name:      "cache_hits",
+			Help:      "Total count of user lookups served by the read cache",
+		}, []string{})
+		cacheMisses = prometheus.NewCounter(stdprometheus.CounterOpts{
+			Namespace: "learn",
+			Name:      "cache_misses",
+			Help:      "Total count of user lookups missing the read cache",
+		}, []string{})
+		cacheEvictions = prometheus.NewCounter(stdprometheus.CounterOpts{
+			Namespace: "learn",
+			Name:      "cache_evictions",
+			Help:      "Total count of users evicted from the read cache",
+		}, []string{})
+	}
+	var replicationLag, replicationLagTime metrics.Gauge
+	{
+		// Replication metrics.
+		replicationLag = prometheus.NewGauge(stdprometheus.GaugeOpts{
+			Namespace: "learn",
+			Name:      "replication_lag_changes",
+			Help:      "Number of changes this follower is behind its leader",
+		}, []string{})
+		replicationLagTime = prometheus.NewGauge(stdprometheus.GaugeOpts{
+			Namespace: "learn",
+			Name:      "replication_lag_seconds",
+			Help:      "Age of the last change applied by this follower, while behind its leader",
+		}, []string{})
+	}
+	var repairedBuckets, repairedUsers metrics.Counter
+	var repairDuration metrics.TimeHistogram
+	{
+		// Anti-entropy metrics.
+		repairedBuckets = prometheus.NewCounter(stdprometheus.CounterOpts{
+			Namespace: "learn",
+			Name:      "antientropy_buckets_repaired",
+			Help:      "Total count of buckets found to differ from the leader",
+		}, []string{})
+		repairedUsers = prometheus.NewCounter(stdprometheus.CounterOpts{
+			Namespace: "learn",
+			Name:      "antientropy_users_repaired",
+			Help:      "Total count of users changed to match the leader",
+		}, []string{})
+		repairDuration = metrics.NewTimeHistogram(time.Nanosecond, prometheus.NewSummary(stdprometheus.SummaryOpts{
+			Namespace: "learn",
+			Name:      "antientropy_duration_ns",
+			Help:      "Duration of anti-entropy repairs in nanoseconds.",
+		}, []string{}))
+	}
+	var members metrics.Gauge
+	{
+		// Gossip metrics.
+		members = prometheus.NewGauge(stdprometheus.GaugeOpts{
+			Namespace: "learn",
+			Name:      "gossip_members",
+			Help:      "Number of live members known to gossip",
+		}, []string{})
+	}
+	var coalesced metrics.Counter
+	{
+		// Coalescing metrics.
+		coalesced = prometheus.NewCounter(stdprometheus.CounterOpts{
+			Namespace: "learn",
+			Name:      "user_get_coalesced",
+			Help:      "Total count of get operations served by a concurrent identical get",
+		}, []string{})
+	}
+	var failedLogins, lockouts metrics.Counter
+	{
+		// Login metrics.
+		failedLogins = prometheus.NewCounter(stdprometheus.CounterOpts{
+			Namespace: "learn",
+			Name:      "login_failures",
+			Help:      "Total count of failed logins",
+		}, []string{})
+		lockouts = prometheus.NewCounter(stdprometheus.CounterOpts{
+			Namespace: "learn",
+			Name:      "login_lockouts",
+			Help:      "Total count of usernames and client IPs locked out by failed logins",
+		}, []string{"scope"})
+	}
 	var duration metrics.TimeHistogram
 	{
 		// Transport level metrics.
@@ -71,27 +228,305 @@ func main() {
 		}, []string{"method", "success"}))
 	}
 
+	// TLS domain.
+	var tlsFiles *learn.TLSFiles
+	var clientAuth tls.ClientAuthType
+	security := grpc.WithInsecure()
+	if *tlsCert != "" {
+		var err error
+		clientAuth, err = learn.ParseClientAuth(*tlsAuth)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		tlsFiles, err = learn.NewTLSFiles(*tlsCert, *tlsKey, *tlsCA, *tlsReload, log.NewContext(logger).With("component", "tls"))
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		defer tlsFiles.Stop()
+
+		// Followers reach their leader with the same certificate.
+		security = grpc.WithTransportCredentials(tlsFiles.ClientCredentials(""))
+	}
+
 	// Business domain.
 	var service learn.UserService
+	var exporter learn.Exporter
+	var reaper *learn.Reaper
+	var replog *learn.ReplicationLog
+	var follower *learn.Follower
+	var leader learn.UserService
+	var cluster *learn.Cluster
+	var tree *learn.MerkleTree
+	var antiEntropy *learn.AntiEntropy
 	{
-		service = learn.NewBasicService()
+		// In cluster mode, writes and reads go through Raft, while the
+		// exports, the change feed and replication use the local store.
+		store := learn.NewBasicService()
+		service = store
+		if *raftID != "" {
+			if *leaderAddr != "" {
+				logger.Log("err", "-raft.id and -replication.leader are mutually exclusive")
+				os.Exit(1)
+			}
+
+			var autoJoinIDs []string
+			if *autoJoin != "" {
+				autoJoinIDs = strings.Split(*autoJoin, ",")
+			}
+
+			var err error
+			clusterLogger := log.NewContext(logger).With("component", "raft")
+			cluster, err = learn.NewCluster(learn.ClusterConfig{
+				ID:        *raftID,
+				Addr:      *raftAddr,
+				Dir:       *raftDir,
+				Bootstrap: *bootstrap,
+				AutoJoin:  autoJoinIDs,
+			}, clusterLogger)
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			defer cluster.Shutdown()
+
+			store = cluster.Store()
+			service = cluster.Service()
+		}
+		exporter = store.(learn.Exporter)
+		replog = learn.NewReplicationLog(store.(learn.Publisher), *replRetain)
+		tree = learn.NewMerkleTree(store.(learn.Repairer))
+
+		// Followers apply the changes of their leader, so they must not make
+		// any of their own.
+		if *leaderAddr != "" {
+			conn, err := grpc.Dial(*leaderAddr, security)
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			defer conn.Close()
+
+			// Replication, snapshots and anti-entropy need credentials on the
+			// leader.
+			var credentials []client.Option
+			if *replAPIKey != "" {
+				credentials = append(credentials, client.APIKey(*replAPIKey))
+			}
+			source := func(ctx context.Context, after uint64, fn func(learn.Change, uint64) error) error {
+				return client.Replicate(ctx, conn, after, fn, credentials...)
+			}
+			snapshot := func(ctx context.Context) ([]*learn.User, uint64, error) {
+				return client.Snapshot(ctx, conn, credentials...)
+			}
+			followerLogger := log.NewContext(logger).With("component", "follower", "leader", *leaderAddr)
+			follower = learn.NewFollower(store.(learn.Replica), source, snapshot, replicationLag, replicationLagTime, followerLogger)
+			if *forward {
+				// Writes carry the token of their caller to the leader.
+				leader = client.New(conn, client.Tokens(client.ForwardedTokens()))
+			}
+
+			antiEntropyLogger := log.NewContext(logger).With("component", "antientropy", "leader", *leaderAddr)
+			antiEntropy = learn.NewAntiEntropy(tree, client.NewMerklePeer(conn, credentials...), *repairEvery, repairedBuckets, repairedUsers, repairDuration, antiEntropyLogger)
+		}
+
+		mode := learn.SoftDelete
+		if *reapHard {
+			mode = learn.HardDelete
+		}
+		reaperLogger := log.NewContext(logger).With("component", "reaper")
+		reaper = learn.NewReaper(service.(learn.Expirer), *reapEvery, mode, expired, reapDuration, reaperLogger)
+
+		// Audit log of every change to the store, including expiries.
+		auditLogger := log.NewContext(logger).With("component", "audit")
+		store.(learn.Publisher).Subscribe(func(c learn.Change) {
+			auditLogger.Log("seq", c.Seq, "change", c.Type, "id", c.User.Id, "hard", c.Hard, "at", c.Time)
+		})
+
+		// The cache sits directly over the store, whose changes invalidate
+		// it, and coalescing in front of it collapses concurrent misses.
+		// Cached reads wouldn't be linearizable, so clusters go without.
+		if *cacheSize > 0 && cluster == nil {
+			service = learn.ServiceCachingMiddleware(*cacheSize, *cacheTTL, *cacheNegTTL, cacheHits, cacheMisses, cacheEvictions)(service)
+		}
+		service = learn.ServiceCoalescingMiddleware(coalesced)(service)
+		if follower != nil {
+			service = learn.ServiceFollowerMiddleware(leader)(service)
+		}
+		if *idemTTL > 0 {
+			service = learn.ServiceIdempotencyMiddleware(*idemTTL)(service)
+		}
 		service = learn.ServiceLoggingMiddleware(logger)(service)
 		service = learn.ServiceMetricsMiddleware(gets, creates)(service)
 	}
 
+	// Auth domain.
+	var (
+		accounts      *learn.ServiceAccounts
+		auth          learn.Authenticator
+		authService   learn.AuthService
+		authEndpoints learn.AuthEndpoints
+		signer        *learn.Issuer
+	)
+	{
+		// Tokens signed with a guessable secret would let anyone in, and
+		// tokens signed with the secret aren't verified by a key set that
+		// only holds the keys of -jwt.keys.
+		switch {
+		case *jwtKeys == "" && *jwtSecret == "":
+			logger.Log("err", "one of -jwt.keys and -jwt.secret is required")
+			os.Exit(1)
+		case *jwtKeys != "" && *jwtSignKey == "":
+			logger.Log("err", "-jwt.keys requires -jwt.signing-key")
+			os.Exit(1)
+		case *oidcIssuer != "" && *oidcIssuer == *jwtIssuer:
+			logger.Log("err", "-oidc.issuer must differ from -jwt.issuer")
+			os.Exit(1)
+		}
+
+		var keys learn.Keys = learn.SharedSecret(*jwtSecret)
+		if *jwtKeys != "" {
+			keySet, err := learn.NewKeySet(strings.Split(*jwtKeys, ","), *jwtReload, log.NewContext(logger).With("component", "keys"))
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			defer keySet.Stop()
+			keys = keySet
+		}
+
+		var (
+			issuer *learn.Issuer
+			err    error
+		)
+		if *jwtSignKey != "" {
+			issuer, err = learn.LoadIssuer(*jwtSignKey, *jwtIssuer, *jwtAudience)
+		} else {
+			issuer, err = learn.NewIssuer(learn.SharedSecret(*jwtSecret), "", *jwtIssuer, *jwtAudience)
+		}
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		denylist := learn.NewDenylist()
+		verifier := learn.NewVerifier(issuer.Keys(keys), *jwtIssuer, *jwtAudience, *jwtSkew)
+		verifier.UseDenylist(denylist)
+		// The OIDC provider signs with the same key, for other apps.
+		if *oidcIssuer != "" {
+			verifier.RejectIssuer(*oidcIssuer)
+		}
+		policy := learn.PasswordPolicy{
+			MinLength:  *passMinLen,
+			MaxLength:  *passMaxLen,
+			MinClasses: *passClasses,
+			NoUserInfo: *passNoInfo,
+		}
+		if *breached != "" {
+			policy.Breached, err = learn.LoadBreachedPasswords(*breached)
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+		}
+
+		// Admins act as admins only with sessions started with MFA.
+		admins := map[string]bool{}
+		if *authAdmins != "" {
+			for _, id := range strings.Split(*authAdmins, ",") {
+				admins[id] = true
+			}
+		}
+
+		// Audit log of every login attempt and lockout.
+		authAuditLogger := log.NewContext(logger).With("component", "audit")
+		authService = learn.NewAuthService(service, learn.AuthConfig{
+			Issuer:         issuer,
+			Denylist:       denylist,
+			AccessTTL:      *accessTTL,
+			RefreshTTL:     *refreshTTL,
+			MFAIssuer:      *mfaIssuer,
+			PasswordPolicy: policy,
+			Lockout: learn.LockoutPolicy{
+				AccountAttempts: *lockAccount,
+				IPAttempts:      *lockIP,
+				Lockout:         *lockout,
+				MaxLockout:      *lockoutMax,
+			},
+			FailedLogins: failedLogins,
+			Lockouts:     lockouts,
+			Audit: func(e learn.AuthEvent) {
+				authAuditLogger.Log("event", e.Type, "username", e.Username, "id", e.UserID, "ip", e.IP, "at", e.Time, "until", e.Until)
+			},
+			Roles: func(userID string) []string {
+				if admins[userID] {
+					return []string{learn.AdminRole}
+				}
+				return nil
+			},
+		})
+		authEndpoints = learn.MakeAuthEndpoints(authService)
+		signer = issuer
+
+		accounts, err = learn.NewServiceAccounts(*apiKeysFile)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		auth = learn.APIKeysOr(accounts, verifier)
+	}
+
+	// OIDC domain.
+	var oidc *learn.OIDCProvider
+	if *oidcIssuer != "" {
+		var clients []learn.OIDCClient
+		if *oidcClients != "" {
+			var err error
+			clients, err = learn.LoadOIDCClients(*oidcClients)
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+		}
+		// A shared secret can't be published for clients to verify tokens
+		// with, so without -jwt.signing-key the provider signs with a key of
+		// its own, which clients have to fetch again after every restart.
+		if *jwtSignKey == "" {
+			key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			signer, err = learn.NewIssuer(key, fmt.Sprintf("oidc-%d", time.Now().Unix()), "", "")
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			logger.Log("msg", "OIDC tokens are signed with an ephemeral key, set -jwt.signing-key to keep it across restarts")
+		}
+		var err error
+		oidc, err = learn.NewOIDCProvider(service, authService, signer, learn.OIDCConfig{
+			Issuer:   *oidcIssuer,
+			Clients:  clients,
+			TokenTTL: *accessTTL,
+		})
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+	}
+
 	// Endpoint domain.
 	var createUserEndpoint endpoint.Endpoint
 	{
 		createUserDuration := duration.With(metrics.Field{Key: "method", Value: "CreateUser"})
 		createUserLogger := log.NewContext(logger).With("method", "CreateUser")
 		limiter := ratelimit.NewTokenBucketLimiter(jujuratelimit.NewBucketWithRate(1, 1))
-		auth := jwt.NewParser(func(token *stdjwt.Token) (interface{}, error) { return []byte("testSigningString1"), nil }, stdjwt.SigningMethodHS256)
 
 		createUserEndpoint = learn.MakeCreateUserEndpoint(service)
 		createUserEndpoint = limiter(createUserEndpoint)
 		createUserEndpoint = learn.EndpointLoggingMiddleware(createUserLogger)(createUserEndpoint)
 		createUserEndpoint = learn.EndpointMetricsMiddleware(createUserDuration)(createUserEndpoint)
-		createUserEndpoint = auth(createUserEndpoint)
 	}
 
 	var getUserEndpoint endpoint.Endpoint
@@ -106,15 +541,119 @@ func main() {
 		getUserEndpoint = learn.EndpointMetricsMiddleware(getUserDuration)(getUserEndpoint)
 	}
 
+	var batchCreateUsersEndpoint endpoint.Endpoint
+	{
+		batchCreateUsersDuration := duration.With(metrics.Field{Key: "method", Value: "BatchCreateUsers"})
+		batchCreateUsersLogger := log.NewContext(logger).With("method", "BatchCreateUsers")
+		limiter := ratelimit.NewTokenBucketLimiter(jujuratelimit.NewBucketWithRate(1, 1))
+
+		batchCreateUsersEndpoint = learn.MakeBatchCreateUsersEndpoint(service)
+		batchCreateUsersEndpoint = limiter(batchCreateUsersEndpoint)
+		batchCreateUsersEndpoint = learn.EndpointLoggingMiddleware(batchCreateUsersLogger)(batchCreateUsersEndpoint)
+		batchCreateUsersEndpoint = learn.EndpointMetricsMiddleware(batchCreateUsersDuration)(batchCreateUsersEndpoint)
+	}
+
+	var batchGetUsersEndpoint endpoint.Endpoint
+	{
+		batchGetUsersDuration := duration.With(metrics.Field{Key: "method", Value: "BatchGetUsers"})
+		batchGetUsersLogger := log.NewContext(logger).With("method", "BatchGetUsers")
+		limiter := ratelimit.NewTokenBucketLimiter(jujuratelimit.NewBucketWithRate(1, 1))
+
+		batchGetUsersEndpoint = learn.MakeBatchGetUsersEndpoint(service)
+		batchGetUsersEndpoint = limiter(batchGetUsersEndpoint)
+		batchGetUsersEndpoint = learn.EndpointLoggingMiddleware(batchGetUsersLogger)(batchGetUsersEndpoint)
+		batchGetUsersEndpoint = learn.EndpointMetricsMiddleware(batchGetUsersDuration)(batchGetUsersEndpoint)
+	}
+
 	endpoints := learn.Endpoints{
-		CreateUserEndpoint: createUserEndpoint,
-		GetUserEndpoint:    getUserEndpoint,
+		CreateUserEndpoint:       createUserEndpoint,
+		GetUserEndpoint:          getUserEndpoint,
+		BatchCreateUsersEndpoint: batchCreateUsersEndpoint,
+		BatchGetUsersEndpoint:    batchGetUsersEndpoint,
+	}
+
+	// Imports apply their chunks through their own batch create endpoint,
+	// which waits for the rate limit rather than rejecting, so a long import
+	// is slowed down instead of failed.
+	var importer *learn.Importer
+	{
+		importDuration := duration.With(metrics.Field{Key: "method", Value: "ImportUsers"})
+		importLogger := log.NewContext(logger).With("method", "ImportUsers")
+		throttler := ratelimit.NewTokenBucketThrottler(jujuratelimit.NewBucketWithRate(1, 1), time.Sleep)
+
+		var importEndpoint endpoint.Endpoint
+		importEndpoint = learn.MakeBatchCreateUsersEndpoint(service)
+		importEndpoint = throttler(importEndpoint)
+		importEndpoint = learn.EndpointLoggingMiddleware(importLogger)(importEndpoint)
+		importEndpoint = learn.EndpointMetricsMiddleware(importDuration)(importEndpoint)
+
+		importer = learn.NewImporter(learn.Endpoints{BatchCreateUsersEndpoint: importEndpoint}, *importChunk, importLogger)
+	}
+
+	// Gossip domain.
+	var membership *learn.Membership
+	if *gossipAddr != "" {
+		host, _, err := net.SplitHostPort(*gossipAddr)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+
+		// Unencrypted gossip would let any host that reaches it join, and
+		// claim to be whichever node it likes.
+		if *gossipKey == "" {
+			logger.Log("err", "-gossip.addr requires -gossip.key")
+			os.Exit(1)
+		}
+		key, err := base64.StdEncoding.DecodeString(*gossipKey)
+		if err != nil {
+			logger.Log("err", fmt.Sprintf("-gossip.key: %v", err))
+			os.Exit(1)
+		}
+
+		name := *gossipName
+		if name == "" {
+			name = *raftID
+		}
+		if name == "" {
+			name = *gossipAddr
+		}
+
+		meta := learn.NodeMeta{
+			GRPCAddr: advertiseAddr(*grpcAddr, host),
+			HTTPAddr: advertiseAddr(*httpAddr, host),
+		}
+		if cluster != nil {
+			meta.RaftID, meta.RaftAddr = *raftID, *raftAddr
+		}
+
+		gossipLogger := log.NewContext(logger).With("component", "gossip")
+		membership, err = learn.NewMembership(learn.MembershipConfig{
+			Name:      name,
+			Addr:      *gossipAddr,
+			SecretKey: key,
+			Meta:      meta,
+		}, members, gossipLogger)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		defer membership.Leave(5 * time.Second)
 	}
 
 	// Mechanical domain.
 	errc := make(chan error)
 	ctx := context.Background()
 
+	// Expired user reaper, or replication and anti-entropy for a follower,
+	// whose expiries come from its leader.
+	if follower != nil {
+		go follower.Run(ctx)
+		go antiEntropy.Run(ctx)
+	} else {
+		go reaper.Run(ctx)
+	}
+
 	// Interrupt handler.
 	go func() {
 		c := make(chan os.Signal, 1)
@@ -133,11 +672,97 @@ func main() {
 		m.Handle("/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
 		m.Handle("/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
 		m.Handle("/metrics", stdprometheus.Handler())
+		serviceAccounts := learn.MakeServiceAccountsHTTPHandler(ctx, accounts, auth, logger)
+		m.Handle("/serviceaccounts", serviceAccounts)
+		m.Handle("/serviceaccounts/", serviceAccounts)
+		if cluster != nil {
+			m.Handle("/cluster/", learn.MakeClusterHTTPHandler(ctx, cluster, auth, logger))
+		}
+		if antiEntropy != nil {
+			m.Handle("/antientropy/", learn.MakeAntiEntropyHTTPHandler(ctx, antiEntropy, auth, logger))
+		}
+		if membership != nil {
+			m.Handle("/members", learn.MakeMembershipHTTPHandler(membership))
+		}
 
-		logger.Log("addr", ":8080")
-		errc <- http.ListenAndServe(":8080", m)
+		logger.Log("addr", *debugAddr)
+		if tlsFiles != nil {
+			srv := &http.Server{Addr: *debugAddr, Handler: m, TLSConfig: tlsFiles.ServerConfig(clientAuth)}
+			errc <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		errc <- http.ListenAndServe(*debugAddr, m)
 	}()
 
+	// Ask the leader to add this node to its cluster, until it does.
+	if cluster != nil && *joinAddr != "" {
+		var options []client.Option
+		if tlsFiles != nil {
+			options = append(options, client.TLS(tlsFiles))
+		}
+		if *joinAPIKey != "" {
+			options = append(options, client.APIKey(*joinAPIKey))
+		}
+		go func() {
+			logger := log.NewContext(logger).With("component", "raft")
+			for {
+				err := client.JoinCluster(ctx, *joinAddr, *raftID, *raftAddr, options...)
+				if err == nil {
+					logger.Log("msg", "joined cluster", "via", *joinAddr)
+					return
+				}
+				logger.Log("msg", "join failed", "via", *joinAddr, "err", err)
+				time.Sleep(time.Second)
+			}
+		}()
+	}
+
+	// Join the gossip of the existing members, until one of them answers.
+	if membership != nil && *gossipJoin != "" {
+		go func() {
+			logger := log.NewContext(logger).With("component", "gossip")
+			for {
+				n, err := membership.Join(strings.Split(*gossipJoin, ","))
+				if err == nil {
+					logger.Log("msg", "joined gossip", "reached", n)
+					return
+				}
+				logger.Log("msg", "join failed", "via", *gossipJoin, "err", err)
+				time.Sleep(time.Second)
+			}
+		}()
+	}
+
+	// Add the Raft nodes found by gossip to the cluster, and remove those
+	// that it loses.
+	if membership != nil && cluster != nil {
+		raftLogger := log.NewContext(logger).With("component", "raft")
+		membership.Subscribe(func(e learn.MemberEvent) {
+			if e.Type != learn.MemberLeft {
+				return
+			}
+			go func() {
+				if err := cluster.RemoveMember(e.Member); err != nil {
+					raftLogger.Log("msg", "remove failed", "member", e.Member.Name, "err", err)
+				}
+			}()
+		})
+		go func() {
+			ticker := time.NewTicker(2 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := cluster.Reconcile(membership.Members()); err != nil {
+						raftLogger.Log("msg", "reconcile failed", "err", err)
+					}
+				}
+			}
+		}()
+	}
+
 	// gRPC transport.
 	go func() {
 		ln, err := net.Listen("tcp", *grpcAddr)
@@ -146,9 +771,14 @@ func main() {
 			return
 		}
 
-		srv := learn.MakeGRPCServer(ctx, endpoints, logger)
-		s := grpc.NewServer()
+		srv := learn.MakeGRPCServer(ctx, endpoints, importer, exporter, replog, tree, auth, logger)
+		var options []grpc.ServerOption
+		if tlsFiles != nil {
+			options = append(options, grpc.Creds(tlsFiles.ServerCredentials(clientAuth)))
+		}
+		s := grpc.NewServer(options...)
 		pb.RegisterUserServiceServer(s, srv)
+		pb.RegisterAuthServiceServer(s, learn.MakeGRPCAuthServer(ctx, authEndpoints, auth, logger))
 
 		fmt.Println("addr", *grpcAddr)
 		errc <- s.Serve(ln)
@@ -157,10 +787,33 @@ func main() {
 	// HTTP transport.
 	go func() {
 		logger := log.NewContext(logger).With("transport", "HTTP")
-		h := learn.MakeHTTPHandler(ctx, endpoints, logger)
+		m := http.NewServeMux()
+		m.Handle("/", learn.MakeHTTPHandler(ctx, endpoints, exporter, auth, logger))
+		m.Handle("/auth/", learn.MakeAuthHTTPHandler(ctx, authEndpoints, auth, logger))
+		m.Handle("/scim/", learn.MakeSCIMHTTPHandler(ctx, service, exporter, auth, log.NewContext(logger).With("component", "scim")))
+		if oidc != nil {
+			h := learn.MakeOIDCHTTPHandler(ctx, oidc, log.NewContext(logger).With("component", "oidc"))
+			m.Handle(oidc.Path()+"/.well-known/openid-configuration", h)
+			m.Handle(oidc.Path()+"/oidc/", h)
+		}
 		logger.Log("addr", *httpAddr)
-		errc <- http.ListenAndServe(*httpAddr, h)
+		if tlsFiles != nil {
+			srv := &http.Server{Addr: *httpAddr, Handler: m, TLSConfig: tlsFiles.ServerConfig(clientAuth)}
+			errc <- srv.ListenAndServeTLS("", "")
+			return
+		}
+		errc <- http.ListenAndServe(*httpAddr, m)
 	}()
 
 	fmt.Println("exit", <-errc)
 }
+
+// advertiseAddr returns a listen address that other nodes can reach, using
+// host when the address doesn't name one.
+func advertiseAddr(addr, host string) string {
+	h, port, err := net.SplitHostPort(addr)
+	if err != nil || h != "" {
+		return addr
+	}
+	return net.JoinHostPort(host, port)
+}
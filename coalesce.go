@@ -0,0 +1,119 @@
+package learn
+
+import (
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/metrics"
+)
+
+// ServiceCoalescingMiddleware returns a middleware that collapses concurrent
+// GetUser calls for the same id into a single call to the next service. If
+// the next service is a Finder, so is the returned one, and concurrent lookups
+// of the same email address or username are collapsed the same way. Calls
+// that joined one already in flight, rather than making their own, are
+// counted in coalesced.
+func ServiceCoalescingMiddleware(coalesced metrics.Counter) Middleware {
+	return func(next UserService) UserService {
+		mw := &serviceCoalescingMiddleware{
+			next:      next,
+			coalesced: coalesced,
+			calls:     make(map[string]*inflightCall),
+		}
+		if f, ok := next.(Finder); ok {
+			return &finderCoalescingMiddleware{mw, f}
+		}
+		return mw
+	}
+}
+
+type serviceCoalescingMiddleware struct {
+	next      UserService
+	coalesced metrics.Counter
+
+	mtx   sync.Mutex
+	calls map[string]*inflightCall
+}
+
+// inflightCall is a lookup shared by every caller asking for the same key
+// while it runs. done is closed once user and err are set.
+type inflightCall struct {
+	done chan struct{}
+	user *User
+	err  error
+}
+
+func (mw *serviceCoalescingMiddleware) CreateUser(ctx context.Context, u *User) (*User, error) {
+	return mw.next.CreateUser(ctx, u)
+}
+
+func (mw *serviceCoalescingMiddleware) GetUser(ctx context.Context, id string) (*User, error) {
+	return mw.do(ctx, "id:"+id, func() (*User, error) {
+		return mw.next.GetUser(ctx, id)
+	})
+}
+
+func (mw *serviceCoalescingMiddleware) BatchCreateUsers(ctx context.Context, users []*User, mode BatchMode) ([]BatchResult, error) {
+	return mw.next.BatchCreateUsers(ctx, users, mode)
+}
+
+func (mw *serviceCoalescingMiddleware) BatchGetUsers(ctx context.Context, ids []string, mode BatchMode) ([]BatchResult, error) {
+	return mw.next.BatchGetUsers(ctx, ids, mode)
+}
+
+// finderCoalescingMiddleware is the coalescing middleware over a Finder.
+type finderCoalescingMiddleware struct {
+	*serviceCoalescingMiddleware
+	finder Finder
+}
+
+func (mw *finderCoalescingMiddleware) FindUserByEmail(ctx context.Context, email string) (*User, error) {
+	// Addresses compare case insensitively, so their keys do too.
+	return mw.do(ctx, "email:"+strings.ToLower(email), func() (*User, error) {
+		return mw.finder.FindUserByEmail(ctx, email)
+	})
+}
+
+func (mw *finderCoalescingMiddleware) FindUserByUsername(ctx context.Context, username string) (*User, error) {
+	return mw.do(ctx, "username:"+username, func() (*User, error) {
+		return mw.finder.FindUserByUsername(ctx, username)
+	})
+}
+
+// do runs fn for key, unless a call for key is already in flight, in which
+// case it waits for and returns that call's result instead. Keys are
+// prefixed by the kind of lookup so that different lookups can share the
+// middleware.
+//
+// The call is made with the context of whoever started it. A caller that
+// joined it stops waiting when its own context is done.
+func (mw *serviceCoalescingMiddleware) do(ctx context.Context, key string, fn func() (*User, error)) (*User, error) {
+	mw.mtx.Lock()
+	if c, ok := mw.calls[key]; ok {
+		mw.mtx.Unlock()
+		mw.coalesced.Add(1)
+
+		select {
+		case <-c.done:
+			return c.user, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	c := &inflightCall{done: make(chan struct{})}
+	mw.calls[key] = c
+	mw.mtx.Unlock()
+
+	defer func() {
+		mw.mtx.Lock()
+		delete(mw.calls, key)
+		mw.mtx.Unlock()
+		close(c.done)
+	}()
+
+	c.user, c.err = fn()
+	return c.user, c.err
+}
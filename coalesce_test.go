@@ -0,0 +1,131 @@
+package learn
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// slowService is a store that takes a while to answer, and counts the
+// lookups that reach it.
+type slowService struct {
+	*basicService
+	delay time.Duration
+	calls int64
+}
+
+func newSlowService(delay time.Duration) *slowService {
+	return &slowService{basicService: NewBasicService().(*basicService), delay: delay}
+}
+
+func (s *slowService) GetUser(ctx context.Context, id string) (*User, error) {
+	atomic.AddInt64(&s.calls, 1)
+	time.Sleep(s.delay)
+	return s.basicService.GetUser(ctx, id)
+}
+
+func (s *slowService) FindUserByEmail(ctx context.Context, email string) (*User, error) {
+	atomic.AddInt64(&s.calls, 1)
+	time.Sleep(s.delay)
+	return s.basicService.FindUserByEmail(ctx, email)
+}
+
+func (s *slowService) FindUserByUsername(ctx context.Context, username string) (*User, error) {
+	atomic.AddInt64(&s.calls, 1)
+	time.Sleep(s.delay)
+	return s.basicService.FindUserByUsername(ctx, username)
+}
+
+func TestCoalesce(t *testing.T) {
+	ctx := context.Background()
+	backend := newSlowService(20 * time.Millisecond)
+	backend.CreateUser(ctx, &User{Id: "a", Email: "ann@example.com", Username: "ann"})
+	coalesced := &testCounter{}
+	s := ServiceCoalescingMiddleware(coalesced)(backend)
+	f, ok := s.(Finder)
+	if !ok {
+		t.Fatal("coalescing a Finder isn't a Finder")
+	}
+
+	lookups := []func() (*User, error){
+		func() (*User, error) { return s.GetUser(ctx, "a") },
+		func() (*User, error) { return f.FindUserByEmail(ctx, "ANN@example.com") },
+		func() (*User, error) { return f.FindUserByEmail(ctx, "ann@example.com") },
+		func() (*User, error) { return f.FindUserByUsername(ctx, "ann") },
+	}
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		for _, lookup := range lookups {
+			wg.Add(1)
+			go func(lookup func() (*User, error)) {
+				defer wg.Done()
+				if u, err := lookup(); err != nil || u.Id != "a" {
+					t.Errorf("lookup = %+v, %v", u, err)
+				}
+			}(lookup)
+		}
+	}
+	wg.Wait()
+
+	calls := atomic.LoadInt64(&backend.calls)
+	if total := calls + int64(coalesced.Value()); total != n*int64(len(lookups)) {
+		t.Errorf("%d backend calls and %d coalesced, want %d in all", calls, coalesced.Value(), n*len(lookups))
+	}
+	if calls >= n {
+		t.Errorf("%d backend calls for %d lookups of 3 keys", calls, n*len(lookups))
+	}
+}
+
+func TestCoalesceErrors(t *testing.T) {
+	backend := newSlowService(100 * time.Millisecond)
+	s := ServiceCoalescingMiddleware(&testCounter{})(backend)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.GetUser(context.Background(), "missing"); err != ErrNotFound {
+				t.Errorf("GetUser = %v, want %v", err, ErrNotFound)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A caller that gives up stops waiting for the call it joined.
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.GetUser(context.Background(), "slow")
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if _, err := s.GetUser(ctx, "slow"); err != context.Canceled {
+		t.Errorf("GetUser = %v, want %v", err, context.Canceled)
+	}
+}
+
+// benchmarkCoalesce looks up one popular user from many goroutines at once,
+// and logs how many of the lookups reach the backend.
+func benchmarkCoalesce(b *testing.B, coalesce bool) {
+	ctx := context.Background()
+	backend := newSlowService(100 * time.Microsecond)
+	backend.CreateUser(ctx, &User{Id: "popular"})
+	var s UserService = backend
+	if coalesce {
+		s = ServiceCoalescingMiddleware(&testCounter{})(backend)
+	}
+
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.GetUser(ctx, "popular")
+		}
+	})
+	b.Logf("%d lookups, %d reached the backend", b.N, atomic.LoadInt64(&backend.calls))
+}
+
+func BenchmarkCoalesceGetUserOff(b *testing.B) { benchmarkCoalesce(b, false) }
+func BenchmarkCoalesceGetUserOn(b *testing.B)  { benchmarkCoalesce(b, true) }
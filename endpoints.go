@@ -1,6 +1,7 @@
 package learn
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -12,8 +13,10 @@ import (
 )
 
 type Endpoints struct {
-	CreateUserEndpoint endpoint.Endpoint
-	GetUserEndpoint    endpoint.Endpoint
+	CreateUserEndpoint       endpoint.Endpoint
+	GetUserEndpoint          endpoint.Endpoint
+	BatchCreateUsersEndpoint endpoint.Endpoint
+	BatchGetUsersEndpoint    endpoint.Endpoint
 }
 
 // CreateUser implements Service. Primarily useful in a client.
@@ -38,6 +41,30 @@ func (e Endpoints) GetUser(ctx context.Context, id string) (*User, error) {
 	return response.(GetUserResponse).User, nil
 }
 
+// BatchCreateUsers implements Service. Primarily useful in a client.
+func (e Endpoints) BatchCreateUsers(ctx context.Context, users []*User, mode BatchMode) ([]BatchResult, error) {
+	request := BatchCreateUsersRequest{Users: users, Mode: mode}
+	response, err := e.BatchCreateUsersEndpoint(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := response.(BatchCreateUsersResponse)
+	return resp.Results, resp.Err
+}
+
+// BatchGetUsers implements Service. Primarily useful in a client.
+func (e Endpoints) BatchGetUsers(ctx context.Context, ids []string, mode BatchMode) ([]BatchResult, error) {
+	request := BatchGetUsersRequest{Ids: ids, Mode: mode}
+	response, err := e.BatchGetUsersEndpoint(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := response.(BatchGetUsersResponse)
+	return resp.Results, resp.Err
+}
+
 func MakeCreateUserEndpoint(s UserService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		userRequest := request.(CreateUserRequest)
@@ -62,6 +89,30 @@ func MakeGetUserEndpoint(s UserService) endpoint.Endpoint {
 	}
 }
 
+func MakeBatchCreateUsersEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		batchRequest := request.(BatchCreateUsersRequest)
+		results, err := s.BatchCreateUsers(ctx, batchRequest.Users, batchRequest.Mode)
+
+		return BatchCreateUsersResponse{
+			Results: results,
+			Err:     err,
+		}, nil
+	}
+}
+
+func MakeBatchGetUsersEndpoint(s UserService) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		batchRequest := request.(BatchGetUsersRequest)
+		results, err := s.BatchGetUsers(ctx, batchRequest.Ids, batchRequest.Mode)
+
+		return BatchGetUsersResponse{
+			Results: results,
+			Err:     err,
+		}, nil
+	}
+}
+
 func EndpointLoggingMiddleware(logger log.Logger) endpoint.Middleware {
 	return func(next endpoint.Endpoint) endpoint.Endpoint {
 		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
@@ -105,3 +156,287 @@ type GetUserResponse struct {
 	User *User
 	Err  error
 }
+
+type BatchCreateUsersRequest struct {
+	Users []*User
+	Mode  BatchMode
+}
+
+type BatchCreateUsersResponse struct {
+	Results []BatchResult
+	Err     error
+}
+
+type BatchGetUsersRequest struct {
+	Ids  []string
+	Mode BatchMode
+}
+
+type BatchGetUsersResponse struct {
+	Results []BatchResult
+	Err     error
+}
+
+// AuthEndpoints are the endpoints of an AuthService. Every one of them
+// responds with an AuthResponse.
+type AuthEndpoints struct {
+	AuthenticateEndpoint   endpoint.Endpoint
+	RefreshEndpoint        endpoint.Endpoint
+	SetPasswordEndpoint    endpoint.Endpoint
+	ListSessionsEndpoint   endpoint.Endpoint
+	RevokeSessionEndpoint  endpoint.Endpoint
+	RevokeSessionsEndpoint endpoint.Endpoint
+	VerifyMFAEndpoint      endpoint.Endpoint
+	EnrollMFAEndpoint      endpoint.Endpoint
+	ConfirmMFAEndpoint     endpoint.Endpoint
+	DisableMFAEndpoint     endpoint.Endpoint
+	UnlockEndpoint         endpoint.Endpoint
+}
+
+// MakeAuthEndpoints returns the endpoints of s.
+func MakeAuthEndpoints(s AuthService) AuthEndpoints {
+	return AuthEndpoints{
+		AuthenticateEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(AuthenticateRequest)
+			tokens, err := s.Authenticate(ctx, req.Username, req.Password)
+			return AuthResponse{Tokens: tokens, Err: err}, nil
+		},
+		RefreshEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(RefreshRequest)
+			tokens, err := s.Refresh(ctx, req.RefreshToken)
+			return AuthResponse{Tokens: tokens, Err: err}, nil
+		},
+		SetPasswordEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(SetPasswordRequest)
+			return AuthResponse{Err: s.SetPassword(ctx, req.UserID, req.Password)}, nil
+		},
+		ListSessionsEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(SessionsRequest)
+			sessions, err := s.ListSessions(ctx, req.UserID)
+			return AuthResponse{Sessions: sessions, Err: err}, nil
+		},
+		RevokeSessionEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(SessionsRequest)
+			return AuthResponse{Err: s.RevokeSession(ctx, req.UserID, req.SessionID)}, nil
+		},
+		RevokeSessionsEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(SessionsRequest)
+			return AuthResponse{Err: s.RevokeSessions(ctx, req.UserID)}, nil
+		},
+		VerifyMFAEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(VerifyMFARequest)
+			tokens, err := s.VerifyMFA(ctx, req.Challenge, req.Code)
+			return AuthResponse{Tokens: tokens, Err: err}, nil
+		},
+		EnrollMFAEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(MFARequest)
+			enrollment, err := s.EnrollMFA(ctx, req.UserID)
+			return AuthResponse{Enrollment: enrollment, Err: err}, nil
+		},
+		ConfirmMFAEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(MFARequest)
+			codes, err := s.ConfirmMFA(ctx, req.UserID, req.Code)
+			return AuthResponse{RecoveryCodes: codes, Err: err}, nil
+		},
+		DisableMFAEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(MFARequest)
+			return AuthResponse{Err: s.DisableMFA(ctx, req.UserID, req.Code)}, nil
+		},
+		UnlockEndpoint: func(ctx context.Context, request interface{}) (interface{}, error) {
+			req := request.(UnlockRequest)
+			return AuthResponse{Err: s.Unlock(ctx, req.Username, req.IP)}, nil
+		},
+	}
+}
+
+// Authenticate implements AuthService. Primarily useful in a client.
+func (e AuthEndpoints) Authenticate(ctx context.Context, username, password string) (*Tokens, error) {
+	resp, err := e.call(ctx, e.AuthenticateEndpoint, AuthenticateRequest{Username: username, Password: password})
+	return resp.Tokens, err
+}
+
+// Refresh implements AuthService. Primarily useful in a client.
+func (e AuthEndpoints) Refresh(ctx context.Context, refreshToken string) (*Tokens, error) {
+	resp, err := e.call(ctx, e.RefreshEndpoint, RefreshRequest{RefreshToken: refreshToken})
+	return resp.Tokens, err
+}
+
+// SetPassword implements AuthService. Primarily useful in a client.
+func (e AuthEndpoints) SetPassword(ctx context.Context, userID, password string) error {
+	_, err := e.call(ctx, e.SetPasswordEndpoint, SetPasswordRequest{UserID: userID, Password: password})
+	return err
+}
+
+// ListSessions implements AuthService. Primarily useful in a client.
+func (e AuthEndpoints) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	resp, err := e.call(ctx, e.ListSessionsEndpoint, SessionsRequest{UserID: userID})
+	return resp.Sessions, err
+}
+
+// RevokeSession implements AuthService. Primarily useful in a client.
+func (e AuthEndpoints) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	_, err := e.call(ctx, e.RevokeSessionEndpoint, SessionsRequest{UserID: userID, SessionID: sessionID})
+	return err
+}
+
+// RevokeSessions implements AuthService. Primarily useful in a client.
+func (e AuthEndpoints) RevokeSessions(ctx context.Context, userID string) error {
+	_, err := e.call(ctx, e.RevokeSessionsEndpoint, SessionsRequest{UserID: userID})
+	return err
+}
+
+// VerifyMFA implements AuthService. Primarily useful in a client.
+func (e AuthEndpoints) VerifyMFA(ctx context.Context, challenge, code string) (*Tokens, error) {
+	resp, err := e.call(ctx, e.VerifyMFAEndpoint, VerifyMFARequest{Challenge: challenge, Code: code})
+	return resp.Tokens, err
+}
+
+// EnrollMFA implements AuthService. Primarily useful in a client.
+func (e AuthEndpoints) EnrollMFA(ctx context.Context, userID string) (*MFAEnrollment, error) {
+	resp, err := e.call(ctx, e.EnrollMFAEndpoint, MFARequest{UserID: userID})
+	return resp.Enrollment, err
+}
+
+// ConfirmMFA implements AuthService. Primarily useful in a client.
+func (e AuthEndpoints) ConfirmMFA(ctx context.Context, userID, code string) ([]string, error) {
+	resp, err := e.call(ctx, e.ConfirmMFAEndpoint, MFARequest{UserID: userID, Code: code})
+	return resp.RecoveryCodes, err
+}
+
+// DisableMFA implements AuthService. Primarily useful in a client.
+func (e AuthEndpoints) DisableMFA(ctx context.Context, userID, code string) error {
+	_, err := e.call(ctx, e.DisableMFAEndpoint, MFARequest{UserID: userID, Code: code})
+	return err
+}
+
+// Unlock implements AuthService. Primarily useful in a client.
+func (e AuthEndpoints) Unlock(ctx context.Context, username, ip string) error {
+	_, err := e.call(ctx, e.UnlockEndpoint, UnlockRequest{Username: username, IP: ip})
+	return err
+}
+
+func (e AuthEndpoints) call(ctx context.Context, ep endpoint.Endpoint, request interface{}) (AuthResponse, error) {
+	response, err := ep(ctx, request)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+	resp := response.(AuthResponse)
+	return resp, resp.Err
+}
+
+type AuthenticateRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type SetPasswordRequest struct {
+	UserID   string `json:"user_id"`
+	Password string `json:"password"`
+}
+
+// SessionsRequest names the user, and for RevokeSession the session, that a
+// session operation acts on.
+type SessionsRequest struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+type VerifyMFARequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+// MFARequest names the user that an MFA operation acts on, and the code it
+// takes, if any.
+type MFARequest struct {
+	UserID string `json:"user_id"`
+	Code   string `json:"code,omitempty"`
+}
+
+// UnlockRequest names the username, the client IP, or both, whose lockout
+// to lift.
+type UnlockRequest struct {
+	Username string `json:"username,omitempty"`
+	IP       string `json:"ip,omitempty"`
+}
+
+// AuthResponse is the response of every AuthService operation, holding what
+// the operation returns, if anything.
+type AuthResponse struct {
+	Tokens        *Tokens
+	Sessions      []Session
+	Enrollment    *MFAEnrollment
+	RecoveryCodes []string
+	Err           error
+}
+
+// knownErrors are the service errors that can be reconstructed from their
+// message after crossing a transport, so callers can compare against them.
+var knownErrors = []error{
+	ErrNotFound,
+	ErrMissingID,
+	ErrDuplicateID,
+	ErrBatchAborted,
+	ErrMissingImportID,
+	ErrImportOffset,
+	ErrNotLeader,
+	ErrIdempotencyKeyReused,
+	ErrInvalidCredentials,
+	ErrMissingUsername,
+	ErrUsernameTaken,
+	ErrRefreshTokenInvalid,
+	ErrRefreshTokenReused,
+	ErrSessionNotFound,
+	ErrForbidden,
+	ErrPasswordNotSet,
+	ErrMFAAlreadyEnabled,
+	ErrMFANotEnrolled,
+	ErrMFANotEnabled,
+	ErrMFAInvalidCode,
+	ErrMFAChallengeInvalid,
+	ErrLockedOut,
+}
+
+// IsServiceError reports whether err is an error of the service itself, such
+// as ErrNotFound, rather than a failure to reach it or of the transport.
+func IsServiceError(err error) bool {
+	if _, ok := err.(*PasswordPolicyError); ok {
+		return true
+	}
+	for _, e := range knownErrors {
+		if err == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// errorFromString turns an error message received over a transport back into
+// an error, returning the matching service error where there is one. An empty
+// message means no error.
+func errorFromString(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	for _, err := range knownErrors {
+		if err.Error() == msg {
+			return err
+		}
+	}
+
+	return errors.New(msg)
+}
+
+// errorToString is the inverse of errorFromString.
+func errorToString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
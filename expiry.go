@@ -0,0 +1,113 @@
+package learn
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+)
+
+// DeleteMode controls what happens to a deleted user.
+type DeleteMode int
+
+const (
+	// SoftDelete hides the user from reads but keeps its record.
+	SoftDelete DeleteMode = iota
+
+	// HardDelete removes the user entirely.
+	HardDelete
+)
+
+func (m DeleteMode) String() string {
+	if m == HardDelete {
+		return "hard"
+	}
+
+	return "soft"
+}
+
+// Expirer is implemented by services that can delete users whose ExpiresAt
+// has passed.
+type Expirer interface {
+	// ExpireUsers deletes every user that has expired as of now, and returns
+	// the deleted users.
+	ExpireUsers(ctx context.Context, now time.Time, mode DeleteMode) ([]*User, error)
+}
+
+func (s *basicService) ExpireUsers(_ context.Context, now time.Time, mode DeleteMode) ([]*User, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var expired []*User
+	for id, user := range s.Users {
+		if !user.Expired(now) {
+			continue
+		}
+
+		delete(s.Users, id)
+		if mode == SoftDelete {
+			s.deleted[id] = user
+		}
+		s.publish(Change{Type: UserExpired, User: user, Time: now, Hard: mode == HardDelete})
+		expired = append(expired, user)
+	}
+
+	return expired, nil
+}
+
+// Reaper periodically deletes expired users.
+type Reaper struct {
+	expirer  Expirer
+	interval time.Duration
+	mode     DeleteMode
+	expired  metrics.Counter
+	duration metrics.TimeHistogram
+	logger   log.Logger
+}
+
+// NewReaper returns a Reaper that deletes the expired users of e every
+// interval. It counts deleted users in expired and times each sweep with
+// duration.
+func NewReaper(e Expirer, interval time.Duration, mode DeleteMode, expired metrics.Counter, duration metrics.TimeHistogram, logger log.Logger) *Reaper {
+	return &Reaper{
+		expirer:  e,
+		interval: interval,
+		mode:     mode,
+		expired:  expired,
+		duration: duration,
+		logger:   logger,
+	}
+}
+
+// Run reaps every interval until the context is done.
+func (r *Reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.Reap(ctx, now)
+		}
+	}
+}
+
+// Reap deletes the users that have expired as of now, and returns how many
+// there were.
+func (r *Reaper) Reap(ctx context.Context, now time.Time) (n int, err error) {
+	defer func(begin time.Time) {
+		r.duration.Observe(time.Since(begin))
+		if n > 0 || err != nil {
+			r.logger.Log("expired", n, "mode", r.mode, "error", err, "took", time.Since(begin))
+		}
+	}(time.Now())
+
+	users, err := r.expirer.ExpireUsers(ctx, now, r.mode)
+	r.expired.Add(uint64(len(users)))
+
+	return len(users), err
+}
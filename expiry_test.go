@@ -0,0 +1,96 @@
+package learn
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"golang.org/x/net/context"
+
+	"github.com/briankassouf/learn/pb"
+)
+
+// testCounter is a metrics.Counter that tests can read back.
+type testCounter struct {
+	mtx   sync.Mutex
+	value uint64
+}
+
+func (c *testCounter) Name() string                       { return "test" }
+func (c *testCounter) With(metrics.Field) metrics.Counter { return c }
+
+func (c *testCounter) Add(delta uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.value += delta
+}
+
+func (c *testCounter) Value() uint64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.value
+}
+
+// testHistogram is a metrics.TimeHistogram that drops observations.
+type testHistogram struct{}
+
+func (h testHistogram) With(metrics.Field) metrics.TimeHistogram { return h }
+func (h testHistogram) Observe(time.Duration)                    {}
+
+func TestReap(t *testing.T) {
+	for _, mode := range []DeleteMode{SoftDelete, HardDelete} {
+		ctx := context.Background()
+		now := time.Now()
+		s := NewBasicService()
+		var changes []Change
+		s.(Publisher).Subscribe(func(c Change) { changes = append(changes, c) })
+
+		s.CreateUser(ctx, &User{Id: "later", ExpiresAt: now.Add(time.Hour)})
+		s.CreateUser(ctx, &User{Id: "gone", ExpiresAt: now.Add(-time.Second)})
+		s.CreateUser(ctx, &User{Id: "never"})
+
+		// Expired users can't be read even before they're reaped.
+		if _, err := s.GetUser(ctx, "gone"); err != ErrNotFound {
+			t.Errorf("%v: GetUser of an expired user = %v, want %v", mode, err, ErrNotFound)
+		}
+
+		expired := &testCounter{}
+		r := NewReaper(s.(Expirer), time.Minute, mode, expired, testHistogram{}, log.NewNopLogger())
+		if n, err := r.Reap(ctx, now); n != 1 || err != nil {
+			t.Fatalf("%v: Reap = %d, %v", mode, n, err)
+		}
+		if expired.Value() != 1 {
+			t.Errorf("%v: counted %d expired users", mode, expired.Value())
+		}
+		if len(changes) != 4 || changes[3].Type != UserExpired || changes[3].User.Id != "gone" || changes[3].Hard != (mode == HardDelete) {
+			t.Errorf("%v: changes = %+v", mode, changes)
+		}
+		if _, err := s.GetUser(ctx, "later"); err != nil {
+			t.Errorf("%v: %v", mode, err)
+		}
+
+		// Reaping again finds nothing new.
+		if n, _ := r.Reap(ctx, now); n != 0 {
+			t.Errorf("%v: second Reap = %d", mode, n)
+		}
+	}
+}
+
+func TestGRPCExpiresAt(t *testing.T) {
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	req, err := DecodeGRPCCreateUserRequest(ctx, &pb.CreateRequest{User: &pb.User{Id: "z", ExpiresAt: expires.Unix()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.(CreateUserRequest).User.ExpiresAt; !got.Equal(expires) {
+		t.Errorf("ExpiresAt = %v, want %v", got, expires)
+	}
+
+	req, _ = DecodeGRPCCreateUserRequest(ctx, &pb.CreateRequest{User: &pb.User{Id: "z"}})
+	if got := req.(CreateUserRequest).User.ExpiresAt; !got.IsZero() {
+		t.Errorf("ExpiresAt without expiry = %v, want the zero time", got)
+	}
+}
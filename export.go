@@ -0,0 +1,103 @@
+package learn
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// UserReader yields users in order. Read returns io.EOF once there are no
+// more users.
+type UserReader interface {
+	Read() (*User, error)
+	Close() error
+}
+
+// Exporter is implemented by services that can export a consistent snapshot
+// of their users.
+type Exporter interface {
+	// ExportUsers returns a reader over the users matching filter, in id
+	// order, as they were when ExportUsers was called. Writes made while
+	// the reader is in use are not seen by it.
+	ExportUsers(ctx context.Context, filter ExportFilter) (UserReader, error)
+}
+
+// ExportFilter selects the users included in an export. Empty fields match
+// every user.
+type ExportFilter struct {
+	// IdPrefix matches users whose id starts with it.
+	IdPrefix string
+
+	// EmailDomain matches users whose email address is at the domain.
+	EmailDomain string
+
+	// After matches users whose id sorts after it, so that an export that was
+	// cut short can be continued from the last id received.
+	After string
+}
+
+// Match reports whether the user passes the filter.
+func (f ExportFilter) Match(u *User) bool {
+	if f.IdPrefix != "" && !strings.HasPrefix(u.Id, f.IdPrefix) {
+		return false
+	}
+	if f.EmailDomain != "" && !strings.HasSuffix(strings.ToLower(u.Email), "@"+strings.ToLower(f.EmailDomain)) {
+		return false
+	}
+	if f.After != "" && u.Id <= f.After {
+		return false
+	}
+
+	return true
+}
+
+func (s *basicService) ExportUsers(ctx context.Context, filter ExportFilter) (UserReader, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	// Stored users are replaced rather than modified, so copying the
+	// pointers is enough for a consistent snapshot.
+	now := time.Now()
+	var users []*User
+	for _, user := range s.Users {
+		if !user.Expired(now) && filter.Match(user) {
+			users = append(users, user)
+		}
+	}
+	sort.Sort(byID(users))
+
+	return &snapshotReader{ctx: ctx, users: users}, nil
+}
+
+type snapshotReader struct {
+	ctx   context.Context
+	users []*User
+}
+
+func (r *snapshotReader) Read() (*User, error) {
+	if err := r.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(r.users) == 0 {
+		return nil, io.EOF
+	}
+
+	user := r.users[0]
+	r.users[0] = nil
+	r.users = r.users[1:]
+	return user, nil
+}
+
+func (r *snapshotReader) Close() error {
+	r.users = nil
+	return nil
+}
+
+type byID []*User
+
+func (u byID) Len() int           { return len(u) }
+func (u byID) Less(i, j int) bool { return u[i].Id < u[j].Id }
+func (u byID) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
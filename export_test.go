@@ -0,0 +1,62 @@
+package learn
+
+import (
+	"io"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestExportFilterMatch(t *testing.T) {
+	u := &User{Id: "u42", Email: "ann@Example.com"}
+	for _, c := range []struct {
+		filter ExportFilter
+		want   bool
+	}{
+		{ExportFilter{}, true},
+		{ExportFilter{IdPrefix: "u4"}, true},
+		{ExportFilter{IdPrefix: "u5"}, false},
+		{ExportFilter{EmailDomain: "example.COM"}, true},
+		{ExportFilter{EmailDomain: "ample.com"}, false},
+		{ExportFilter{After: "u41"}, true},
+		{ExportFilter{After: "u42"}, false},
+	} {
+		if got := c.filter.Match(u); got != c.want {
+			t.Errorf("%+v.Match = %v, want %v", c.filter, got, c.want)
+		}
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	ctx := context.Background()
+	s := NewBasicService()
+	for _, id := range []string{"c", "a", "d", "b"} {
+		if _, err := s.CreateUser(ctx, &User{Id: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := s.(Exporter).ExportUsers(ctx, ExportFilter{After: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Users created after the export began aren't part of it.
+	s.CreateUser(ctx, &User{Id: "e"})
+
+	var got []string
+	for {
+		u, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, u.Id)
+	}
+	if want := []string{"b", "c", "d"}; len(got) != len(want) || got[0] != want[0] || got[2] != want[2] {
+		t.Errorf("exported %v, want %v", got, want)
+	}
+}
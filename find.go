@@ -0,0 +1,48 @@
+package learn
+
+import (
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Finder is implemented by services that can look users up by something
+// other than their id. Both lookups return ErrNotFound if no user matches.
+type Finder interface {
+	// FindUserByEmail returns the user with the email address, compared
+	// case insensitively.
+	FindUserByEmail(ctx context.Context, email string) (*User, error)
+
+	// FindUserByUsername returns the user with the username.
+	FindUserByUsername(ctx context.Context, username string) (*User, error)
+}
+
+func (s *basicService) FindUserByEmail(_ context.Context, email string) (*User, error) {
+	return s.find(func(u *User) bool { return email != "" && strings.EqualFold(u.Email, email) })
+}
+
+func (s *basicService) FindUserByUsername(_ context.Context, username string) (*User, error) {
+	return s.find(func(u *User) bool { return username != "" && u.Username == username })
+}
+
+// find returns the live user that matches. The store keeps no secondary
+// indexes, so it scans every user; ids decide between several matches so
+// that the answer doesn't depend on map order.
+func (s *basicService) find(match func(*User) bool) (*User, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	now := time.Now()
+	var found *User
+	for _, user := range s.Users {
+		if !user.Expired(now) && match(user) && (found == nil || user.Id < found.Id) {
+			found = user
+		}
+	}
+	if found == nil {
+		return nil, ErrNotFound
+	}
+
+	return found, nil
+}
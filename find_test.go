@@ -0,0 +1,29 @@
+package learn
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestFindUser(t *testing.T) {
+	ctx := context.Background()
+	s := NewBasicService()
+	s.CreateUser(ctx, &User{Id: "b", Email: "Ann@Example.com", Username: "ann"})
+	s.CreateUser(ctx, &User{Id: "a", Email: "ann@example.com"})
+	s.CreateUser(ctx, &User{Id: "old", Username: "bob", ExpiresAt: time.Now().Add(-time.Second)})
+	f := s.(Finder)
+
+	if u, err := f.FindUserByEmail(ctx, "ANN@example.com"); err != nil || u.Id != "a" {
+		t.Errorf("FindUserByEmail = %+v, %v, want the lowest id", u, err)
+	}
+	if u, err := f.FindUserByUsername(ctx, "ann"); err != nil || u.Id != "b" {
+		t.Errorf("FindUserByUsername = %+v, %v", u, err)
+	}
+	for _, username := range []string{"Ann", "bob", ""} {
+		if _, err := f.FindUserByUsername(ctx, username); err != ErrNotFound {
+			t.Errorf("FindUserByUsername(%q) = %v, want %v", username, err, ErrNotFound)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package learn
+
+import (
+	"container/list"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is used again
+// to create a different user.
+var ErrIdempotencyKeyReused = errors.New("Idempotency key was already used for another user")
+
+const (
+	// IdempotencyKeyHeader is the HTTP header carrying an idempotency key.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotencyKeyMetadata is the gRPC metadata key carrying an
+	// idempotency key. gRPC metadata keys are lower case.
+	idempotencyKeyMetadata = "idempotency-key"
+)
+
+type idempotencyKeyContextKey struct{}
+
+// WithIdempotencyKey returns a context carrying key. A CreateUser made with
+// it is applied at most once by the instances that serve it with
+// ServiceIdempotencyMiddleware, however often it is sent, so it may be safely
+// retried. Keys should be unique, such as random UUIDs.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+// IdempotencyKey returns the idempotency key carried by ctx, if any.
+func IdempotencyKey(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKeyContextKey{}).(string)
+	return key, ok && key != ""
+}
+
+// IdempotencyKeyToHTTPContext moves the idempotency key of a request from its
+// header to the context. Primarily useful in a server.
+func IdempotencyKeyToHTTPContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+			return WithIdempotencyKey(ctx, key)
+		}
+		return ctx
+	}
+}
+
+// IdempotencyKeyFromHTTPContext moves the idempotency key of the context to
+// the header of a request. Primarily useful in a client.
+func IdempotencyKeyFromHTTPContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if key, ok := IdempotencyKey(ctx); ok {
+			r.Header.Set(IdempotencyKeyHeader, key)
+		}
+		return ctx
+	}
+}
+
+// IdempotencyKeyToGRPCContext moves the idempotency key of a request from its
+// metadata to the context. Primarily useful in a server.
+func IdempotencyKeyToGRPCContext() grpctransport.RequestFunc {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		if keys := (*md)[idempotencyKeyMetadata]; len(keys) > 0 && keys[0] != "" {
+			return WithIdempotencyKey(ctx, keys[0])
+		}
+		return ctx
+	}
+}
+
+// IdempotencyKeyFromGRPCContext moves the idempotency key of the context to
+// the metadata of a request. Primarily useful in a client.
+func IdempotencyKeyFromGRPCContext() grpctransport.RequestFunc {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		if key, ok := IdempotencyKey(ctx); ok {
+			(*md)[idempotencyKeyMetadata] = []string{key}
+		}
+		return ctx
+	}
+}
+
+// ServiceIdempotencyMiddleware returns a middleware that applies a CreateUser
+// carrying an idempotency key at most once. The user created is remembered
+// for ttl, and returned again to every CreateUser with the same key instead
+// of creating it anew. Concurrent calls with the same key wait for the first
+// one. Failed calls aren't remembered, so they can be retried. Keys are only
+// known to the instance that saw them.
+func ServiceIdempotencyMiddleware(ttl time.Duration) Middleware {
+	return func(next UserService) UserService {
+		return &serviceIdempotencyMiddleware{
+			next:  next,
+			ttl:   ttl,
+			keys:  make(map[string]*idempotentCall),
+			order: list.New(),
+		}
+	}
+}
+
+type serviceIdempotencyMiddleware struct {
+	next UserService
+	ttl  time.Duration
+
+	mtx   sync.Mutex
+	keys  map[string]*idempotentCall
+	order *list.List
+}
+
+// idempotentCall is a CreateUser made with an idempotency key. done is closed
+// once user and err are set.
+type idempotentCall struct {
+	key     string
+	id      string
+	done    chan struct{}
+	user    *User
+	err     error
+	expires time.Time
+}
+
+func (mw *serviceIdempotencyMiddleware) CreateUser(ctx context.Context, u *User) (*User, error) {
+	key, ok := IdempotencyKey(ctx)
+	if !ok || u == nil {
+		return mw.next.CreateUser(ctx, u)
+	}
+
+	for {
+		call, first := mw.start(key, u.Id, time.Now())
+		if call.id != u.Id {
+			return nil, ErrIdempotencyKeyReused
+		}
+		if first {
+			call.user, call.err = mw.next.CreateUser(ctx, u)
+			mw.finish(call)
+			return call.user, call.err
+		}
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if call.err == nil {
+			return call.user, nil
+		}
+		// The first call failed and was forgotten, so this one may try.
+	}
+}
+
+func (mw *serviceIdempotencyMiddleware) GetUser(ctx context.Context, id string) (*User, error) {
+	return mw.next.GetUser(ctx, id)
+}
+
+func (mw *serviceIdempotencyMiddleware) BatchCreateUsers(ctx context.Context, users []*User, mode BatchMode) ([]BatchResult, error) {
+	return mw.next.BatchCreateUsers(ctx, users, mode)
+}
+
+func (mw *serviceIdempotencyMiddleware) BatchGetUsers(ctx context.Context, ids []string, mode BatchMode) ([]BatchResult, error) {
+	return mw.next.BatchGetUsers(ctx, ids, mode)
+}
+
+// start returns the call remembered for key, or starts a new one, reporting
+// whether it did.
+func (mw *serviceIdempotencyMiddleware) start(key, id string, now time.Time) (*idempotentCall, bool) {
+	mw.mtx.Lock()
+	defer mw.mtx.Unlock()
+
+	// Calls expire in the order they finished, oldest first.
+	for e := mw.order.Front(); e != nil; e = mw.order.Front() {
+		call := e.Value.(*idempotentCall)
+		if now.Before(call.expires) {
+			break
+		}
+		mw.order.Remove(e)
+		delete(mw.keys, call.key)
+	}
+
+	if call, ok := mw.keys[key]; ok {
+		return call, false
+	}
+
+	call := &idempotentCall{key: key, id: id, done: make(chan struct{})}
+	mw.keys[key] = call
+	return call, true
+}
+
+// finish remembers a successful call until it expires, and forgets a failed
+// one.
+func (mw *serviceIdempotencyMiddleware) finish(call *idempotentCall) {
+	mw.mtx.Lock()
+	defer mw.mtx.Unlock()
+
+	if call.err != nil {
+		delete(mw.keys, call.key)
+	} else {
+		call.expires = time.Now().Add(mw.ttl)
+		mw.order.PushBack(call)
+	}
+	close(call.done)
+}
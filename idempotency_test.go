@@ -0,0 +1,104 @@
+package learn
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// countingCreates is a store that counts the creates reaching it, taking a
+// while to make each, and fails the first fails of them.
+type countingCreates struct {
+	UserService
+	fails   int64
+	creates int64
+}
+
+func (s *countingCreates) CreateUser(ctx context.Context, u *User) (*User, error) {
+	if atomic.AddInt64(&s.creates, 1) <= atomic.LoadInt64(&s.fails) {
+		return nil, errors.New("unavailable")
+	}
+	time.Sleep(10 * time.Millisecond)
+	return s.UserService.CreateUser(ctx, u)
+}
+
+func TestIdempotency(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingCreates{UserService: NewBasicService()}
+	s := ServiceIdempotencyMiddleware(time.Minute)(backend)
+
+	// Concurrent calls with the same key create the user once.
+	keyed := WithIdempotencyKey(ctx, "k1")
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, err := s.CreateUser(keyed, &User{Id: "a"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if u.Id != "a" {
+				t.Errorf("created %s, want a", u.Id)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt64(&backend.creates); n != 1 {
+		t.Errorf("%d creates for one key, want 1", n)
+	}
+
+	// So do later ones, while calls without a key go through.
+	s.CreateUser(keyed, &User{Id: "a"})
+	s.CreateUser(ctx, &User{Id: "a"})
+	if n := atomic.LoadInt64(&backend.creates); n != 2 {
+		t.Errorf("%d creates, want 2", n)
+	}
+
+	if _, err := s.CreateUser(keyed, &User{Id: "b"}); err != ErrIdempotencyKeyReused {
+		t.Errorf("key reused for another user = %v, want ErrIdempotencyKeyReused", err)
+	}
+}
+
+func TestIdempotencyFailure(t *testing.T) {
+	ctx := WithIdempotencyKey(context.Background(), "k")
+	backend := &countingCreates{UserService: NewBasicService(), fails: 1}
+	s := ServiceIdempotencyMiddleware(time.Minute)(backend)
+
+	// Failed calls are forgotten, so that they can be retried.
+	if _, err := s.CreateUser(ctx, &User{Id: "a"}); err == nil {
+		t.Fatal("first create succeeded")
+	}
+	if _, err := s.CreateUser(ctx, &User{Id: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&backend.creates); n != 2 {
+		t.Errorf("%d creates, want 2", n)
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	ctx := WithIdempotencyKey(context.Background(), "k")
+	backend := &countingCreates{UserService: NewBasicService()}
+	mw := ServiceIdempotencyMiddleware(time.Minute)(backend).(*serviceIdempotencyMiddleware)
+
+	if _, err := mw.CreateUser(ctx, &User{Id: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, first := mw.start("k", "a", time.Now().Add(59*time.Second)); first {
+		t.Error("key forgotten before its ttl")
+	}
+	call, first := mw.start("k", "b", time.Now().Add(61*time.Second))
+	if !first {
+		t.Fatal("key remembered after its ttl")
+	}
+	if len(mw.keys) != 1 || mw.order.Len() != 0 {
+		t.Errorf("%d keys and %d expiries left, want the new call only", len(mw.keys), mw.order.Len())
+	}
+	mw.finish(call)
+}
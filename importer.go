@@ -0,0 +1,185 @@
+package learn
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+)
+
+var (
+	// ErrMissingImportID is returned when an import record or status request
+	// doesn't name an import.
+	ErrMissingImportID = errors.New("Import id is required")
+
+	// ErrImportOffset is returned when records arrive for an offset past the
+	// last acknowledged one, which would leave a gap in the import.
+	ErrImportOffset = errors.New("Import offset is past the acknowledged offset")
+)
+
+const (
+	// maxImportFailures bounds how many failed records an import keeps
+	// details for. Failures past the limit are only counted.
+	maxImportFailures = 1000
+
+	// importTTL is how long an import is remembered after its last chunk,
+	// which is how long a client has to resume it.
+	importTTL = 24 * time.Hour
+)
+
+// ImportStatus reports how far an import has got.
+type ImportStatus struct {
+	ImportId string
+
+	// Acked is the offset of the next record the import expects. Every
+	// record before it has been either applied or reported as failed, so an
+	// interrupted import resumes by sending records from Acked onwards.
+	Acked int64
+
+	Applied  int64
+	Failed   int64
+	Failures []ImportFailure
+}
+
+// ImportFailure is a single record that could not be applied.
+type ImportFailure struct {
+	Offset int64
+	Id     string
+	Err    error
+}
+
+// Importer applies the records of long-running imports to a UserService in
+// chunks, remembering the acknowledged offset of each import so that it can
+// resume after a disconnect.
+type Importer struct {
+	mtx       sync.Mutex
+	service   UserService
+	chunkSize int
+	logger    log.Logger
+	imports   map[string]*importState
+}
+
+type importState struct {
+	status   ImportStatus
+	lastSeen time.Time
+}
+
+// NewImporter returns an Importer that creates users through the given
+// service, chunkSize users at a time.
+func NewImporter(s UserService, chunkSize int, logger log.Logger) *Importer {
+	if chunkSize <= 0 {
+		chunkSize = 1
+	}
+
+	return &Importer{
+		service:   s,
+		chunkSize: chunkSize,
+		logger:    logger,
+		imports:   make(map[string]*importState),
+	}
+}
+
+// ChunkSize is the number of records a transport should buffer before
+// calling Apply.
+func (i *Importer) ChunkSize() int {
+	return i.chunkSize
+}
+
+// Apply creates a contiguous run of import records, the first of which has
+// the given offset. Records before the acknowledged offset have already been
+// handled and are skipped, so resending them after a disconnect is safe.
+func (i *Importer) Apply(ctx context.Context, importID string, offset int64, users []*User) (ImportStatus, error) {
+	if importID == "" {
+		return ImportStatus{}, ErrMissingImportID
+	}
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	i.expire()
+	state, ok := i.imports[importID]
+	if !ok {
+		state = &importState{status: ImportStatus{ImportId: importID}}
+		i.imports[importID] = state
+	}
+	state.lastSeen = time.Now()
+
+	status := &state.status
+	if offset > status.Acked {
+		return status.copy(), ErrImportOffset
+	}
+	if skip := status.Acked - offset; skip < int64(len(users)) {
+		users = users[skip:]
+	} else {
+		users = nil
+	}
+	if len(users) == 0 {
+		return status.copy(), nil
+	}
+
+	results, err := i.service.BatchCreateUsers(ctx, users, BestEffort)
+	if err != nil {
+		return status.copy(), err
+	}
+
+	for n, r := range results {
+		if r.Err == nil {
+			status.Applied++
+			continue
+		}
+		status.Failed++
+		if len(status.Failures) < maxImportFailures {
+			f := ImportFailure{Offset: status.Acked + int64(n), Err: r.Err}
+			if users[n] != nil {
+				f.Id = users[n].Id
+			}
+			status.Failures = append(status.Failures, f)
+		}
+	}
+	status.Acked += int64(len(users))
+
+	i.logger.Log(
+		"import", importID,
+		"acked", status.Acked, "applied", status.Applied, "failed", status.Failed,
+	)
+
+	return status.copy(), nil
+}
+
+// Status returns the progress of an import. An import that hasn't started,
+// or has expired, reports nothing acknowledged.
+func (i *Importer) Status(importID string) (ImportStatus, error) {
+	if importID == "" {
+		return ImportStatus{}, ErrMissingImportID
+	}
+
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	i.expire()
+	state, ok := i.imports[importID]
+	if !ok {
+		return ImportStatus{ImportId: importID}, nil
+	}
+
+	return state.status.copy(), nil
+}
+
+// expire forgets imports that haven't been touched for importTTL. It must be
+// called with the mutex held.
+func (i *Importer) expire() {
+	for id, state := range i.imports {
+		if time.Since(state.lastSeen) > importTTL {
+			delete(i.imports, id)
+		}
+	}
+}
+
+func (s *ImportStatus) copy() ImportStatus {
+	c := *s
+	c.Failures = append([]ImportFailure(nil), s.Failures...)
+	return c
+}
@@ -0,0 +1,50 @@
+package learn
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+)
+
+func TestImporterResume(t *testing.T) {
+	ctx := context.Background()
+	s := NewBasicService()
+	imp := NewImporter(s, 2, log.NewNopLogger())
+
+	status, err := imp.Apply(ctx, "nightly", 0, []*User{{Id: "a"}, {Id: "b"}})
+	if err != nil || status.Acked != 2 || status.Applied != 2 {
+		t.Fatalf("Apply = %+v, %v", status, err)
+	}
+
+	// Records resent after a disconnect are skipped, and failed records are
+	// acknowledged and reported.
+	status, err = imp.Apply(ctx, "nightly", 1, []*User{{Id: "b"}, {Id: ""}, {Id: "c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Acked != 4 || status.Applied != 3 || status.Failed != 1 {
+		t.Errorf("Apply = %+v", status)
+	}
+	if len(status.Failures) != 1 || status.Failures[0].Offset != 2 || status.Failures[0].Err != ErrMissingID {
+		t.Errorf("failures = %+v", status.Failures)
+	}
+
+	// Skipping ahead would leave a gap.
+	if _, err := imp.Apply(ctx, "nightly", 5, []*User{{Id: "z"}}); err != ErrImportOffset {
+		t.Errorf("err = %v, want %v", err, ErrImportOffset)
+	}
+	if _, err := imp.Apply(ctx, "", 0, nil); err != ErrMissingImportID {
+		t.Errorf("err = %v, want %v", err, ErrMissingImportID)
+	}
+
+	if got, _ := imp.Status("nightly"); got.Acked != 4 {
+		t.Errorf("Status = %+v", got)
+	}
+	if got, _ := imp.Status("unknown"); got.Acked != 0 {
+		t.Errorf("Status of unknown import = %+v", got)
+	}
+	if _, err := s.GetUser(ctx, "c"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,281 @@
+package learn
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+// ErrLockedOut is returned when logging in to an account, or from an
+// address, with too many recent failures. It is returned whether the account
+// exists or not, and whatever the password.
+var ErrLockedOut = errors.New("Too many failed attempts, try again later")
+
+// LockoutPolicy configures the throttling of failed logins, which are
+// counted both per username and per client IP. Once either has as many
+// failures as it is allowed, it is locked out for Lockout, and for twice as
+// long with every failure after, up to MaxLockout. Failures are forgotten
+// after a successful login to the account, or MaxLockout without any.
+type LockoutPolicy struct {
+	// AccountAttempts and IPAttempts are the failures allowed per username
+	// and per client IP. Zero turns the respective lockout off.
+	AccountAttempts int
+	IPAttempts      int
+
+	Lockout    time.Duration
+	MaxLockout time.Duration
+}
+
+// AuthEventType identifies the kind of an AuthEvent.
+type AuthEventType int
+
+const (
+	// LoginSucceeded is a user starting a session.
+	LoginSucceeded AuthEventType = iota
+
+	// LoginFailed is a wrong password, or a wrong MFA code.
+	LoginFailed
+
+	// LoginLockedOut is a login refused because of earlier failures.
+	LoginLockedOut
+
+	// AccountLocked and IPLocked are a username or a client IP being
+	// locked out.
+	AccountLocked
+	IPLocked
+
+	// Unlocked is an admin lifting a lockout.
+	Unlocked
+)
+
+func (t AuthEventType) String() string {
+	switch t {
+	case LoginSucceeded:
+		return "login_succeeded"
+	case LoginFailed:
+		return "login_failed"
+	case LoginLockedOut:
+		return "login_locked_out"
+	case AccountLocked:
+		return "account_locked"
+	case IPLocked:
+		return "ip_locked"
+	case Unlocked:
+		return "unlocked"
+	}
+
+	return "unknown"
+}
+
+// AuthEvent describes a login attempt, or a change to a lockout, for the
+// audit log. UserID is only set when the username belongs to a user.
+type AuthEvent struct {
+	Type     AuthEventType
+	Username string
+	UserID   string
+	IP       string
+	Time     time.Time
+
+	// Until is when the lockout of an AccountLocked or IPLocked event ends.
+	Until time.Time
+}
+
+// throttle counts the failures of usernames and client IPs.
+type throttle struct {
+	policy   LockoutPolicy
+	failures map[string]*failures
+
+	failed, locked metrics.Counter
+}
+
+type failures struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+func newThrottle(policy LockoutPolicy, failed, locked metrics.Counter) *throttle {
+	if policy.MaxLockout < policy.Lockout {
+		policy.MaxLockout = policy.Lockout
+	}
+	return &throttle{policy: policy, failures: map[string]*failures{}, failed: failed, locked: locked}
+}
+
+func accountKey(username string) string { return "account:" + username }
+func ipKey(ip string) string            { return "ip:" + ip }
+
+// lockedOut reports whether the key is locked out at now.
+func (t *throttle) lockedOut(key string, now time.Time) bool {
+	f, ok := t.failures[key]
+	return ok && now.Before(f.until)
+}
+
+// fail counts a failure of the key, which is locked out once it has limit
+// failures. It returns when the lockout ends, or the zero time if there is
+// none.
+func (t *throttle) fail(key string, limit int, now time.Time) time.Time {
+	if limit <= 0 || t.policy.Lockout <= 0 {
+		return time.Time{}
+	}
+	f, ok := t.failures[key]
+	if !ok {
+		f = &failures{}
+		t.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	if f.count < limit {
+		return time.Time{}
+	}
+
+	d := t.policy.MaxLockout
+	if shift := uint(f.count - limit); shift < 32 && t.policy.Lockout<<shift < d {
+		d = t.policy.Lockout << shift
+	}
+	f.until = now.Add(d)
+	return f.until
+}
+
+// count counts a lockout of scope, "account" or "ip".
+func (t *throttle) count(scope string) {
+	if t.locked != nil {
+		t.locked.With(metrics.Field{Key: "scope", Value: scope}).Add(1)
+	}
+}
+
+func (t *throttle) reset(key string) {
+	delete(t.failures, key)
+}
+
+// sweep forgets the failures that are neither locked out nor recent.
+func (t *throttle) sweep(now time.Time) {
+	for key, f := range t.failures {
+		if !now.Before(f.until) && now.Sub(f.last) > t.policy.MaxLockout {
+			delete(t.failures, key)
+		}
+	}
+}
+
+func (s *basicAuthService) Unlock(ctx context.Context, username, ip string) error {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok || !p.HasRole(AdminRole) {
+		return ErrForbidden
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if username != "" {
+		s.throttle.reset(accountKey(username))
+	}
+	if ip != "" {
+		s.throttle.reset(ipKey(ip))
+	}
+	s.audit(AuthEvent{Type: Unlocked, Username: username, UserID: s.usernames[username], IP: ip})
+	return nil
+}
+
+// checkLockout returns ErrLockedOut if the username or the client IP of ctx
+// is locked out. s.mtx must be held.
+func (s *basicAuthService) checkLockout(ctx context.Context, username string, now time.Time) error {
+	ip, _ := ClientIPFromContext(ctx)
+	if s.throttle.lockedOut(accountKey(username), now) || (ip != "" && s.throttle.lockedOut(ipKey(ip), now)) {
+		s.audit(AuthEvent{Type: LoginLockedOut, Username: username, UserID: s.usernames[username], IP: ip, Time: now})
+		return ErrLockedOut
+	}
+	return nil
+}
+
+// failed counts a failed login to username from the client IP of ctx,
+// locking either out once they have too many. s.mtx must be held.
+func (s *basicAuthService) failed(ctx context.Context, username string, now time.Time) {
+	ip, _ := ClientIPFromContext(ctx)
+	userID := s.usernames[username]
+	if s.throttle.failed != nil {
+		s.throttle.failed.Add(1)
+	}
+	s.audit(AuthEvent{Type: LoginFailed, Username: username, UserID: userID, IP: ip, Time: now})
+
+	policy := s.throttle.policy
+	if until := s.throttle.fail(accountKey(username), policy.AccountAttempts, now); !until.IsZero() {
+		s.throttle.count("account")
+		s.audit(AuthEvent{Type: AccountLocked, Username: username, UserID: userID, IP: ip, Time: now, Until: until})
+	}
+	if ip == "" {
+		return
+	}
+	if until := s.throttle.fail(ipKey(ip), policy.IPAttempts, now); !until.IsZero() {
+		s.throttle.count("ip")
+		s.audit(AuthEvent{Type: IPLocked, IP: ip, Time: now, Until: until})
+	}
+}
+
+// succeeded forgets the failures of username after a login from the client
+// IP of ctx. Those of the IP are kept, as a credential stuffing attack
+// succeeds now and then. s.mtx must be held.
+func (s *basicAuthService) succeeded(ctx context.Context, username, userID string, now time.Time) {
+	ip, _ := ClientIPFromContext(ctx)
+	s.throttle.reset(accountKey(username))
+	s.audit(AuthEvent{Type: LoginSucceeded, Username: username, UserID: userID, IP: ip, Time: now})
+}
+
+// audit passes e to the audit function of the service, if any. s.mtx must
+// be held.
+func (s *basicAuthService) audit(e AuthEvent) {
+	if s.config.Audit == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = s.config.Now()
+	}
+	s.config.Audit(e)
+}
+
+type clientIPContextKey struct{}
+
+// WithClientIP returns a context carrying the IP address of the client of a
+// request, which logins are throttled by.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPContextKey{}, ip)
+}
+
+// ClientIPFromContext returns the client IP address carried by ctx, if any.
+func ClientIPFromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(clientIPContextKey{}).(string)
+	return ip, ok && ip != ""
+}
+
+// ClientIPToHTTPContext moves the IP address a request came from to the
+// context. Forwarding headers aren't trusted, so behind a proxy every
+// request has the address of the proxy. Primarily useful in a server.
+func ClientIPToHTTPContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return ctx
+		}
+		return WithClientIP(ctx, host)
+	}
+}
+
+// peerIPMetadata is the metadata key under which PeerToGRPCMetadata puts the
+// IP address of the connection of a call.
+const peerIPMetadata = "learn-peer-ip"
+
+// ClientIPToGRPCContext moves the IP address of the connection of a call,
+// which PeerToGRPCMetadata copied to its metadata, to the context. Primarily
+// useful in a server.
+func ClientIPToGRPCContext() grpctransport.RequestFunc {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		if ip := (*md)[peerIPMetadata]; len(ip) > 0 {
+			return WithClientIP(ctx, ip[0])
+		}
+		return ctx
+	}
+}
@@ -0,0 +1,148 @@
+package learn
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestLockoutAccount(t *testing.T) {
+	ctx := context.Background()
+	clock := newTestClock()
+	failed, locked := &testCounter{}, &testCounter{}
+	a := newTestAuth(t, AuthConfig{
+		Now:          clock.Now,
+		Lockout:      LockoutPolicy{AccountAttempts: 3, Lockout: time.Minute, MaxLockout: 3 * time.Minute},
+		FailedLogins: failed,
+		Lockouts:     locked,
+	})
+	a.SetPassword(adminContext, "u1", "correct horse")
+
+	// Accounts that exist and ones that don't are locked out alike, even
+	// with the right password.
+	for _, username := range []string{"ann", "ghost"} {
+		for i := 0; i < 3; i++ {
+			if _, err := a.Authenticate(ctx, username, "wrong"); err != ErrInvalidCredentials {
+				t.Errorf("%s: failure %d = %v, want ErrInvalidCredentials", username, i+1, err)
+			}
+		}
+		if _, err := a.Authenticate(ctx, username, "correct horse"); err != ErrLockedOut {
+			t.Errorf("%s: login after 3 failures = %v, want ErrLockedOut", username, err)
+		}
+	}
+	if failed.Value() != 6 || locked.Value() != 2 {
+		t.Errorf("%d failures and %d lockouts counted, want 6 and 2", failed.Value(), locked.Value())
+	}
+
+	// Every failure after the limit doubles the lockout.
+	clock.Add(61 * time.Second)
+	if _, err := a.Authenticate(ctx, "ann", "wrong"); err != ErrInvalidCredentials {
+		t.Fatalf("failure after the lockout = %v, want ErrInvalidCredentials", err)
+	}
+	clock.Add(90 * time.Second)
+	if _, err := a.Authenticate(ctx, "ann", "correct horse"); err != ErrLockedOut {
+		t.Errorf("login within the doubled lockout = %v, want ErrLockedOut", err)
+	}
+	clock.Add(31 * time.Second)
+	if _, err := a.Authenticate(ctx, "ann", "correct horse"); err != nil {
+		t.Fatalf("login after the doubled lockout = %v", err)
+	}
+
+	// A successful login forgets the failures.
+	a.Authenticate(ctx, "ann", "wrong")
+	if _, err := a.Authenticate(ctx, "ann", "correct horse"); err != nil {
+		t.Errorf("login after a single failure = %v", err)
+	}
+}
+
+func TestLockoutIP(t *testing.T) {
+	ctx := context.Background()
+	clock := newTestClock()
+	var events []AuthEvent
+	a := newTestAuth(t, AuthConfig{
+		Now:     clock.Now,
+		Lockout: LockoutPolicy{IPAttempts: 3, Lockout: time.Minute},
+		Audit:   func(e AuthEvent) { events = append(events, e) },
+	})
+	a.SetPassword(adminContext, "u1", "correct horse")
+	attacker := WithClientIP(ctx, "10.0.0.1")
+
+	// Failures from an IP count across usernames, and lock out only it.
+	for _, username := range []string{"ann", "bob", "ghost"} {
+		a.Authenticate(attacker, username, "wrong")
+	}
+	if _, err := a.Authenticate(attacker, "ann", "correct horse"); err != ErrLockedOut {
+		t.Errorf("login from a locked out IP = %v, want ErrLockedOut", err)
+	}
+	if _, err := a.Authenticate(WithClientIP(ctx, "10.0.0.2"), "ann", "correct horse"); err != nil {
+		t.Errorf("login from another IP = %v", err)
+	}
+
+	// Only admins lift lockouts.
+	ann := WithPrincipal(ctx, &Principal{ID: "u1", Kind: UserPrincipal})
+	if err := a.Unlock(ann, "", "10.0.0.1"); err != ErrForbidden {
+		t.Errorf("unlock by a user = %v, want ErrForbidden", err)
+	}
+	if err := a.Unlock(adminContext, "", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Authenticate(attacker, "ann", "correct horse"); err != nil {
+		t.Errorf("login after the unlock = %v", err)
+	}
+
+	// Every attempt and lockout is audited, without the user IDs of
+	// usernames that don't exist.
+	counts := map[AuthEventType]int{}
+	for _, e := range events {
+		counts[e.Type]++
+		switch {
+		case e.Type == IPLocked && (e.IP != "10.0.0.1" || !e.Until.Equal(e.Time.Add(time.Minute))):
+			t.Errorf("IP lockout event %+v, want 10.0.0.1 locked for a minute", e)
+		case e.Username == "ghost" && e.UserID != "":
+			t.Errorf("event %+v has a user ID for a missing user", e)
+		case e.Username == "ann" && e.UserID != "u1":
+			t.Errorf("event %+v, want the user ID of ann", e)
+		}
+	}
+	for typ, want := range map[AuthEventType]int{
+		LoginFailed:    3,
+		IPLocked:       1,
+		LoginLockedOut: 1,
+		LoginSucceeded: 2,
+		Unlocked:       1,
+		AccountLocked:  0,
+	} {
+		if counts[typ] != want {
+			t.Errorf("%d %s events, want %d", counts[typ], typ, want)
+		}
+	}
+}
+
+func TestLockoutMFA(t *testing.T) {
+	ctx := context.Background()
+	clock := newTestClock()
+	a := newTestAuth(t, AuthConfig{
+		Now:     clock.Now,
+		Lockout: LockoutPolicy{AccountAttempts: 3, Lockout: time.Minute},
+	})
+	a.SetPassword(adminContext, "u1", "correct horse")
+	_, recovery := enrollMFA(t, a, adminContext, "u1", clock.Now())
+
+	// Wrong MFA codes count as failures of the account, which end the
+	// challenges it has open.
+	first, _ := a.Authenticate(ctx, "ann", "correct horse")
+	second, _ := a.Authenticate(ctx, "ann", "correct horse")
+	for i := 0; i < 3; i++ {
+		if _, err := a.VerifyMFA(ctx, first.Challenge, "000000"); err != ErrMFAInvalidCode {
+			t.Errorf("wrong code %d = %v, want ErrMFAInvalidCode", i+1, err)
+		}
+	}
+	if _, err := a.VerifyMFA(ctx, second.Challenge, recovery[0]); err != ErrLockedOut {
+		t.Errorf("code for a locked out account = %v, want ErrLockedOut", err)
+	}
+	clock.Add(61 * time.Second)
+	if _, err := a.VerifyMFA(ctx, second.Challenge, recovery[0]); err != ErrMFAChallengeInvalid {
+		t.Errorf("challenge open during the lockout = %v, want ErrMFAChallengeInvalid", err)
+	}
+}
@@ -0,0 +1,254 @@
+package learn
+
+import (
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+)
+
+// NodeMeta is what a node tells the other members about itself, so that they
+// can reach its services.
+type NodeMeta struct {
+	GRPCAddr string `json:"grpc_addr,omitempty"`
+	HTTPAddr string `json:"http_addr,omitempty"`
+
+	// RaftID and RaftAddr are set on nodes of a Raft cluster.
+	RaftID   string `json:"raft_id,omitempty"`
+	RaftAddr string `json:"raft_addr,omitempty"`
+}
+
+// Member is a node that gossip believes to be alive.
+type Member struct {
+	Name string `json:"name"`
+
+	// Addr is the address the node gossips on.
+	Addr string `json:"addr"`
+
+	NodeMeta
+}
+
+// MemberEventType identifies the kind of change in membership.
+type MemberEventType int
+
+const (
+	// MemberJoined is a node joining, or being seen again after having been
+	// declared dead.
+	MemberJoined MemberEventType = iota
+
+	// MemberLeft is a node leaving, or being declared dead by the failure
+	// detector.
+	MemberLeft
+
+	// MemberUpdated is a node changing its NodeMeta.
+	MemberUpdated
+)
+
+func (t MemberEventType) String() string {
+	switch t {
+	case MemberJoined:
+		return "joined"
+	case MemberLeft:
+		return "left"
+	case MemberUpdated:
+		return "updated"
+	}
+
+	return "unknown"
+}
+
+// MemberEvent is a single change in membership.
+type MemberEvent struct {
+	Type   MemberEventType
+	Member Member
+}
+
+// MembershipConfig configures the gossip of a node.
+type MembershipConfig struct {
+	// Name identifies the node among the members. It must be unique.
+	Name string
+
+	// Addr is the address the node gossips on, over both UDP and TCP. Other
+	// nodes must be able to reach it there.
+	Addr string
+
+	// SecretKey encrypts and authenticates the gossip with AES. It must be
+	// 16, 24 or 32 bytes long, and the same on every member. Without it
+	// gossip is in the clear, and any host that reaches Addr can join.
+	SecretKey []byte
+
+	Meta NodeMeta
+}
+
+// Membership tracks the nodes of a cluster with SWIM-style gossip. Nodes
+// find every other member by joining any one of them, and nodes that stop
+// responding to probes are declared dead and removed.
+type Membership struct {
+	list    *memberlist.Memberlist
+	meta    []byte
+	members metrics.Gauge
+	logger  log.Logger
+
+	mtx         sync.Mutex
+	count       int
+	subscribers map[int]func(MemberEvent)
+	nextSub     int
+}
+
+// NewMembership starts gossiping. The node is its own only member until it
+// joins another with Join, or another joins it. The number of members is
+// kept in members.
+func NewMembership(config MembershipConfig, members metrics.Gauge, logger log.Logger) (*Membership, error) {
+	meta, err := json.Marshal(config.Meta)
+	if err != nil {
+		return nil, err
+	}
+
+	host, port, err := net.SplitHostPort(config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Membership{
+		meta:        meta,
+		members:     members,
+		logger:      logger,
+		subscribers: make(map[int]func(MemberEvent)),
+	}
+
+	conf := memberlist.DefaultLANConfig()
+	conf.Name = config.Name
+	conf.BindAddr = host
+	conf.BindPort = p
+	conf.AdvertisePort = p
+	conf.SecretKey = config.SecretKey
+	conf.Delegate = membershipDelegate{m}
+	conf.Events = membershipDelegate{m}
+	conf.LogOutput = log.NewStdlibAdapter(logger)
+
+	if m.list, err = memberlist.Create(conf); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Join contacts the nodes at addrs, and through them learns of every other
+// member. It returns how many of them it reached, and fails only if it
+// reached none.
+func (m *Membership) Join(addrs []string) (int, error) {
+	return m.list.Join(addrs)
+}
+
+// Members returns the members that are alive, by name.
+func (m *Membership) Members() []Member {
+	var members []Member
+	for _, node := range m.list.Members() {
+		members = append(members, toMember(node))
+	}
+	sort.Sort(byName(members))
+
+	return members
+}
+
+// Subscribe calls fn with every subsequent change in membership. fn is
+// called from the gossip goroutines, so it must not block. The returned
+// function ends the subscription.
+func (m *Membership) Subscribe(fn func(MemberEvent)) (cancel func()) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	id := m.nextSub
+	m.nextSub++
+	m.subscribers[id] = fn
+
+	return func() {
+		m.mtx.Lock()
+		defer m.mtx.Unlock()
+
+		delete(m.subscribers, id)
+	}
+}
+
+// Leave tells the other members that the node is leaving, waiting up to
+// timeout for the message to spread, and then stops gossiping.
+func (m *Membership) Leave(timeout time.Duration) error {
+	if err := m.list.Leave(timeout); err != nil {
+		return err
+	}
+
+	return m.list.Shutdown()
+}
+
+func (m *Membership) notify(t MemberEventType, node *memberlist.Node) {
+	member := toMember(node)
+	m.logger.Log("event", t, "member", member.Name, "addr", member.Addr)
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	switch t {
+	case MemberJoined:
+		m.count++
+	case MemberLeft:
+		m.count--
+	}
+	m.members.Set(float64(m.count))
+
+	for _, fn := range m.subscribers {
+		fn(MemberEvent{Type: t, Member: member})
+	}
+}
+
+func toMember(node *memberlist.Node) Member {
+	member := Member{
+		Name: node.Name,
+		Addr: net.JoinHostPort(node.Addr.String(), strconv.Itoa(int(node.Port))),
+	}
+	// Nodes that send no or unreadable meta are still members, they just
+	// can't be routed to.
+	json.Unmarshal(node.Meta, &member.NodeMeta)
+
+	return member
+}
+
+// membershipDelegate hooks a Membership into memberlist. The node's meta is
+// the only state it gossips.
+type membershipDelegate struct {
+	m *Membership
+}
+
+func (d membershipDelegate) NodeMeta(limit int) []byte {
+	if len(d.m.meta) > limit {
+		d.m.logger.Log("msg", "node meta too large to gossip", "size", len(d.m.meta), "limit", limit)
+		return nil
+	}
+
+	return d.m.meta
+}
+
+func (d membershipDelegate) NotifyMsg([]byte)                           {}
+func (d membershipDelegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+func (d membershipDelegate) LocalState(join bool) []byte                { return nil }
+func (d membershipDelegate) MergeRemoteState(buf []byte, join bool)     {}
+
+func (d membershipDelegate) NotifyJoin(node *memberlist.Node)   { d.m.notify(MemberJoined, node) }
+func (d membershipDelegate) NotifyLeave(node *memberlist.Node)  { d.m.notify(MemberLeft, node) }
+func (d membershipDelegate) NotifyUpdate(node *memberlist.Node) { d.m.notify(MemberUpdated, node) }
+
+type byName []Member
+
+func (m byName) Len() int           { return len(m) }
+func (m byName) Less(i, j int) bool { return m[i].Name < m[j].Name }
+func (m byName) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
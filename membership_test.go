@@ -0,0 +1,198 @@
+package learn
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/hashicorp/raft"
+)
+
+// testGauge is a metrics.Gauge that tests can read back.
+type testGauge struct {
+	mtx   sync.Mutex
+	value float64
+}
+
+func (g *testGauge) Name() string                     { return "test" }
+func (g *testGauge) With(metrics.Field) metrics.Gauge { return g }
+
+func (g *testGauge) Set(value float64) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.value = value
+}
+
+func (g *testGauge) Add(delta float64) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.value += delta
+}
+
+func (g *testGauge) Get() float64 {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return g.value
+}
+
+var testGossipKey = []byte("0123456789abcdef")
+
+func TestMembership(t *testing.T) {
+	if testing.Short() {
+		t.Skip("gossip takes seconds")
+	}
+
+	var (
+		nodes  []*Membership
+		gauges []*testGauge
+	)
+	defer func() {
+		for _, m := range nodes {
+			m.Leave(0)
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		g := &testGauge{}
+		m, err := NewMembership(MembershipConfig{
+			Name:      fmt.Sprint("n", i),
+			Addr:      freeAddr(t),
+			SecretKey: testGossipKey,
+			Meta:      NodeMeta{GRPCAddr: fmt.Sprint("grpc", i)},
+		}, g, log.NewNopLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, m)
+		gauges = append(gauges, g)
+	}
+	for _, m := range nodes[1:] {
+		if _, err := m.Join([]string{nodes[0].Members()[0].Addr}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "gossip to spread", func() bool {
+		for _, g := range gauges {
+			if g.Get() != 3 {
+				return false
+			}
+		}
+		return true
+	})
+	if members := nodes[2].Members(); len(members) != 3 || members[1].GRPCAddr != "grpc1" {
+		t.Errorf("members = %+v", members)
+	}
+
+	// Nodes without the key can't join.
+	outsider, err := NewMembership(MembershipConfig{
+		Name:      "outsider",
+		Addr:      freeAddr(t),
+		SecretKey: []byte("fedcba9876543210"),
+	}, &testGauge{}, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outsider.Leave(0)
+	if _, err := outsider.Join([]string{nodes[0].Members()[0].Addr}); err == nil {
+		t.Error("joined with the wrong key")
+	}
+
+	left := make(chan MemberEvent, 1)
+	nodes[0].Subscribe(func(e MemberEvent) {
+		if e.Type != MemberLeft {
+			return
+		}
+		select {
+		case left <- e:
+		default:
+		}
+	})
+	if err := nodes[2].Leave(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	nodes = nodes[:2]
+	select {
+	case e := <-left:
+		if e.Member.Name != "n2" {
+			t.Errorf("%s left, want n2", e.Member.Name)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("no leave seen after 10s")
+	}
+	if n := len(nodes[0].Members()); n != 2 || gauges[0].Get() != 2 {
+		t.Errorf("%d members left, gauge at %v", n, gauges[0].Get())
+	}
+}
+
+func TestClusterReconcile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("elections take seconds")
+	}
+
+	var (
+		nodes []*Cluster
+		addrs []string
+	)
+	for i := 0; i < 3; i++ {
+		addrs = append(addrs, freeAddr(t))
+		c, err := NewCluster(ClusterConfig{
+			ID:        fmt.Sprint("node", i),
+			Addr:      addrs[i],
+			Bootstrap: i == 0,
+			AutoJoin:  []string{"node0", "node1"},
+		}, log.NewNopLogger())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Shutdown()
+		nodes = append(nodes, c)
+	}
+	leader := waitForLeader(t, nodes[0])
+
+	// Only the members allowed to are added.
+	var members []Member
+	for i, c := range nodes {
+		members = append(members, Member{Name: c.id, NodeMeta: NodeMeta{RaftID: c.id, RaftAddr: addrs[i]}})
+	}
+	if err := leader.Reconcile(members); err != nil {
+		t.Fatal(err)
+	}
+	servers := func() map[string]bool {
+		status, err := leader.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make(map[string]bool)
+		for _, s := range status.Servers {
+			ids[s.ID] = true
+		}
+		return ids
+	}
+	if ids := servers(); len(ids) != 2 || !ids["node1"] {
+		t.Errorf("servers after reconciling = %v, want node0 and node1", ids)
+	}
+
+	// Members that gossip loses are removed, as long as they were added
+	// automatically.
+	if err := leader.Join("node2", addrs[2]); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range members[1:] {
+		if err := leader.RemoveMember(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids := servers(); len(ids) != 2 || ids["node1"] || !ids["node2"] {
+		t.Errorf("servers after members left = %v, want node0 and node2", ids)
+	}
+
+	// Followers leave membership to the leader.
+	if err := nodes[2].RemoveMember(members[0]); err != nil {
+		t.Error(err)
+	}
+	if nodes[2].raft.State() == raft.Leader {
+		t.Error("follower became the leader")
+	}
+}
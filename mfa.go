@@ -0,0 +1,306 @@
+package learn
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrPasswordNotSet is returned when enrolling a user in MFA before
+	// their password is set.
+	ErrPasswordNotSet = errors.New("User has no password")
+
+	// ErrMFAAlreadyEnabled is returned when enrolling a user already
+	// enrolled in MFA.
+	ErrMFAAlreadyEnabled = errors.New("MFA is already enabled")
+
+	// ErrMFANotEnrolled is returned when confirming MFA for a user with no
+	// pending enrollment.
+	ErrMFANotEnrolled = errors.New("No MFA enrollment to confirm")
+
+	// ErrMFANotEnabled is returned when disabling MFA for a user not
+	// enrolled in it.
+	ErrMFANotEnabled = errors.New("MFA is not enabled")
+
+	// ErrMFAInvalidCode is returned for a TOTP or recovery code that is
+	// wrong, or was already used.
+	ErrMFAInvalidCode = errors.New("Invalid MFA code")
+
+	// ErrMFAChallengeInvalid is returned for an MFA challenge that was never
+	// issued, expired, or was failed too many times.
+	ErrMFAChallengeInvalid = errors.New("Invalid or expired MFA challenge")
+)
+
+const (
+	// totpPeriod and totpDigits are those of RFC 6238, which authenticator
+	// apps assume. Codes of the period either side of the current one are
+	// accepted too, for clock drift.
+	totpPeriod = 30
+	totpDigits = 6
+
+	// mfaChallengeTTL is how long a user has to complete a challenge, and
+	// mfaChallengeAttempts how many wrong codes they may give for it.
+	mfaChallengeTTL      = 5 * time.Minute
+	mfaChallengeAttempts = 5
+
+	// recoveryCodes is how many recovery codes a user gets when enrolling.
+	recoveryCodes = 10
+)
+
+// MFAEnrollment is a TOTP secret pending confirmation. URI is the otpauth
+// URI of the secret, which authenticator apps read from a QR code.
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// challenge is a password check passed by a user enrolled in MFA, awaiting a
+// code.
+type challenge struct {
+	userID   string
+	expires  time.Time
+	attempts int
+}
+
+// mfa is the MFA state of a credential.
+type mfa struct {
+	// secret is the confirmed TOTP secret, and pending one awaiting
+	// confirmation.
+	secret  []byte
+	pending []byte
+
+	// lastStep is the period of the last TOTP code accepted. Codes of it and
+	// earlier periods are refused, so that an observed code can't be
+	// replayed.
+	lastStep int64
+
+	// recovery are the hashes of the unused recovery codes.
+	recovery [][]byte
+}
+
+func (s *basicAuthService) EnrollMFA(ctx context.Context, userID string) (*MFAEnrollment, error) {
+	if err := authorize(ctx, userID); err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	c, ok := s.credentials[userID]
+	if !ok {
+		return nil, ErrPasswordNotSet
+	}
+	if c.mfa.secret != nil {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	c.mfa.pending = secret
+
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+	issuer := s.config.MFAIssuer
+	u := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + issuer + ":" + c.username,
+		RawQuery: url.Values{
+			"secret":    {encoded},
+			"issuer":    {issuer},
+			"algorithm": {"SHA1"},
+			"digits":    {strconv.Itoa(totpDigits)},
+			"period":    {strconv.Itoa(totpPeriod)},
+		}.Encode(),
+	}
+	return &MFAEnrollment{Secret: encoded, URI: u.String()}, nil
+}
+
+func (s *basicAuthService) ConfirmMFA(ctx context.Context, userID, code string) ([]string, error) {
+	if err := authorize(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	c, ok := s.credentials[userID]
+	if !ok || c.mfa.pending == nil {
+		return nil, ErrMFANotEnrolled
+	}
+	step, ok := checkTOTP(c.mfa.pending, code, s.config.Now(), 0)
+	if !ok {
+		return nil, ErrMFAInvalidCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	c.mfa = mfa{secret: c.mfa.pending, lastStep: step, recovery: hashes}
+	return codes, nil
+}
+
+func (s *basicAuthService) DisableMFA(ctx context.Context, userID, code string) error {
+	if err := authorize(ctx, userID); err != nil {
+		return err
+	}
+	p, _ := PrincipalFromContext(ctx)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	c, ok := s.credentials[userID]
+	if !ok || c.mfa.secret == nil {
+		return ErrMFANotEnabled
+	}
+	// Admins reset MFA for users who lost their device, and their codes. A
+	// user must prove they still have either, so that a stolen access token
+	// isn't enough to turn MFA off.
+	if !p.HasRole(AdminRole) && !s.checkCode(c, code) {
+		return ErrMFAInvalidCode
+	}
+	c.mfa = mfa{}
+	return nil
+}
+
+func (s *basicAuthService) VerifyMFA(ctx context.Context, challengeID, code string) (*Tokens, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := s.config.Now()
+	ch, ok := s.challenges[challengeID]
+	if !ok || now.After(ch.expires) {
+		return nil, ErrMFAChallengeInvalid
+	}
+	c, ok := s.credentials[ch.userID]
+	if !ok || c.mfa.secret == nil {
+		delete(s.challenges, challengeID)
+		return nil, ErrMFAChallengeInvalid
+	}
+	if err := s.checkLockout(ctx, c.username, now); err != nil {
+		delete(s.challenges, challengeID)
+		return nil, err
+	}
+	if !s.checkCode(c, code) {
+		if ch.attempts++; ch.attempts >= mfaChallengeAttempts {
+			delete(s.challenges, challengeID)
+		}
+		s.failed(ctx, c.username, now)
+		return nil, ErrMFAInvalidCode
+	}
+
+	delete(s.challenges, challengeID)
+	s.succeeded(ctx, c.username, ch.userID, now)
+	return s.start(ch.userID, now, true)
+}
+
+// challenge returns a challenge for the user with userID to complete with a
+// code. s.mtx must be held.
+func (s *basicAuthService) challenge(userID string, now time.Time) (*Tokens, error) {
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	ch := &challenge{userID: userID, expires: now.Add(mfaChallengeTTL)}
+	s.challenges[id] = ch
+	return &Tokens{Challenge: id, Expires: ch.expires}, nil
+}
+
+// checkCode reports whether code is a current TOTP code or an unused
+// recovery code of c, and uses it up. s.mtx must be held.
+func (s *basicAuthService) checkCode(c *credential, code string) bool {
+	if step, ok := checkTOTP(c.mfa.secret, code, s.config.Now(), c.mfa.lastStep); ok {
+		c.mfa.lastStep = step
+		return true
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, h := range c.mfa.recovery {
+		if subtle.ConstantTimeCompare(hash, h) == 1 {
+			c.mfa.recovery = append(c.mfa.recovery[:i], c.mfa.recovery[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// checkTOTP reports whether code is the TOTP code of secret for the period
+// of now, or one either side of it, that is later than the period after.
+// It returns the period matched.
+func checkTOTP(secret []byte, code string, now time.Time, after int64) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - 1; step <= current+1; step++ {
+		if step <= after {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totp(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totp returns the code of secret for the period step, as in RFC 4226 with
+// the period as the counter.
+func totp(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// TOTP returns the current TOTP code of an MFA secret, as encoded in an
+// MFAEnrollment, as an authenticator app would. Primarily useful in tests
+// and tools.
+func TOTP(secret string, now time.Time) (string, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	return totp(key, now.Unix()/totpPeriod), nil
+}
+
+// newRecoveryCodes returns fresh recovery codes, and the hashes they are
+// kept as. Like refresh tokens, they are random enough for a plain SHA-256
+// hash.
+func newRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, recoveryCodes)
+	hashes := make([][]byte, recoveryCodes)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes a recovery code as typed, ignoring case and
+// dashes.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(strings.Replace(code, "-", "", -1))
+	hash := sha256.Sum256([]byte(code))
+	return hash[:]
+}
@@ -0,0 +1,229 @@
+package learn
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestTOTP(t *testing.T) {
+	// The SHA-1 vectors of RFC 6238, truncated to six digits.
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	} {
+		if code, err := TOTP(secret, time.Unix(tc.unix, 0)); err != nil || code != tc.code {
+			t.Errorf("TOTP at %d = %q, %v, want %s", tc.unix, code, err, tc.code)
+		}
+	}
+}
+
+// enrollMFA enrolls the user with userID in MFA, returning their secret and
+// recovery codes.
+func enrollMFA(t *testing.T, a AuthService, ctx context.Context, userID string, now time.Time) (string, []string) {
+	e, err := a.EnrollMFA(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := TOTP(e.Secret, now)
+	recovery, err := a.ConfirmMFA(ctx, userID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e.Secret, recovery
+}
+
+func TestMFAEnrollment(t *testing.T) {
+	clock := newTestClock()
+	a := newTestAuth(t, AuthConfig{MFAIssuer: "Learn Co", Now: clock.Now})
+	ann := WithPrincipal(context.Background(), &Principal{ID: "u1", Kind: UserPrincipal})
+
+	if _, err := a.EnrollMFA(ann, "u1"); err != ErrPasswordNotSet {
+		t.Errorf("enrolling without a password = %v, want ErrPasswordNotSet", err)
+	}
+	a.SetPassword(adminContext, "u1", "correct horse")
+	if _, err := a.EnrollMFA(ann, "u2"); err != ErrForbidden {
+		t.Errorf("enrolling another user = %v, want ErrForbidden", err)
+	}
+	if _, err := a.ConfirmMFA(ann, "u1", "000000"); err != ErrMFANotEnrolled {
+		t.Errorf("confirming before enrolling = %v, want ErrMFANotEnrolled", err)
+	}
+
+	e, err := a.EnrollMFA(ann, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(e.URI, "otpauth://totp/Learn%20Co:ann?") || !strings.Contains(e.URI, "secret="+e.Secret) {
+		t.Errorf("URI = %q, want the secret of ann at Learn Co", e.URI)
+	}
+	if _, err := a.ConfirmMFA(ann, "u1", "123"); err != ErrMFAInvalidCode {
+		t.Errorf("confirming with a bad code = %v, want ErrMFAInvalidCode", err)
+	}
+
+	// A code of the period either side of the current one is accepted.
+	code, _ := TOTP(e.Secret, clock.Now().Add(-30*time.Second))
+	recovery, err := a.ConfirmMFA(ann, "u1", code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovery) != recoveryCodes {
+		t.Errorf("%d recovery codes, want %d", len(recovery), recoveryCodes)
+	}
+	if _, err := a.EnrollMFA(ann, "u1"); err != ErrMFAAlreadyEnabled {
+		t.Errorf("enrolling again = %v, want ErrMFAAlreadyEnabled", err)
+	}
+}
+
+func TestMFAChallenge(t *testing.T) {
+	ctx := context.Background()
+	clock := newTestClock()
+	a := newTestAuth(t, AuthConfig{Now: clock.Now})
+	a.SetPassword(adminContext, "u1", "correct horse")
+	secret, recovery := enrollMFA(t, a, adminContext, "u1", clock.Now())
+	code := func() string { c, _ := TOTP(secret, clock.Now()); return c }
+
+	// The password only gets a challenge, which a code completes. The code
+	// that confirmed the enrollment can't be used again.
+	tokens, err := a.Authenticate(ctx, "ann", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Challenge == "" || tokens.AccessToken != "" {
+		t.Fatalf("tokens = %+v, want only a challenge", tokens)
+	}
+	if _, err := a.VerifyMFA(ctx, tokens.Challenge, code()); err != ErrMFAInvalidCode {
+		t.Errorf("replayed code = %v, want ErrMFAInvalidCode", err)
+	}
+	clock.Add(30 * time.Second)
+	session, err := a.VerifyMFA(ctx, tokens.Challenge, code())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.verifier.Verify(session.AccessToken); err != nil {
+		t.Errorf("access token = %v", err)
+	}
+	if _, err := a.VerifyMFA(ctx, tokens.Challenge, code()); err != ErrMFAChallengeInvalid {
+		t.Errorf("completed challenge = %v, want ErrMFAChallengeInvalid", err)
+	}
+
+	// Recovery codes work once, however they are typed.
+	tokens, _ = a.Authenticate(ctx, "ann", "correct horse")
+	if _, err := a.VerifyMFA(ctx, tokens.Challenge, strings.ToUpper(strings.Replace(recovery[0], "-", "", 1))); err != nil {
+		t.Errorf("recovery code = %v", err)
+	}
+
+	// Challenges end after too many wrong codes, or when they expire.
+	tokens, _ = a.Authenticate(ctx, "ann", "correct horse")
+	for i := 0; i < mfaChallengeAttempts; i++ {
+		if _, err := a.VerifyMFA(ctx, tokens.Challenge, recovery[0]); err != ErrMFAInvalidCode {
+			t.Errorf("used recovery code = %v, want ErrMFAInvalidCode", err)
+		}
+	}
+	if _, err := a.VerifyMFA(ctx, tokens.Challenge, recovery[1]); err != ErrMFAChallengeInvalid {
+		t.Errorf("challenge after %d wrong codes = %v, want ErrMFAChallengeInvalid", mfaChallengeAttempts, err)
+	}
+	tokens, _ = a.Authenticate(ctx, "ann", "correct horse")
+	clock.Add(mfaChallengeTTL + time.Second)
+	if _, err := a.VerifyMFA(ctx, tokens.Challenge, recovery[1]); err != ErrMFAChallengeInvalid {
+		t.Errorf("expired challenge = %v, want ErrMFAChallengeInvalid", err)
+	}
+}
+
+func TestMFADisable(t *testing.T) {
+	ctx := context.Background()
+	clock := newTestClock()
+	a := newTestAuth(t, AuthConfig{Now: clock.Now})
+	a.SetPassword(adminContext, "u1", "correct horse")
+	ann := WithPrincipal(ctx, &Principal{ID: "u1", Kind: UserPrincipal})
+	_, recovery := enrollMFA(t, a, ann, "u1", clock.Now())
+
+	// Changing the password keeps MFA.
+	a.SetPassword(ann, "u1", "battery staple")
+	if tokens, _ := a.Authenticate(ctx, "ann", "battery staple"); tokens == nil || tokens.Challenge == "" {
+		t.Errorf("tokens after a password change = %+v, want a challenge", tokens)
+	}
+
+	// Users disable MFA with a code, and admins without.
+	if err := a.DisableMFA(ann, "u1", ""); err != ErrMFAInvalidCode {
+		t.Errorf("disabling without a code = %v, want ErrMFAInvalidCode", err)
+	}
+	if err := a.DisableMFA(ann, "u1", recovery[0]); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _ := a.Authenticate(ctx, "ann", "battery staple"); tokens == nil || tokens.AccessToken == "" {
+		t.Errorf("tokens after disabling MFA = %+v, want a session", tokens)
+	}
+	if err := a.DisableMFA(adminContext, "u1", ""); err != ErrMFANotEnabled {
+		t.Errorf("disabling again = %v, want ErrMFANotEnabled", err)
+	}
+	enrollMFA(t, a, ann, "u1", clock.Now())
+	if err := a.DisableMFA(adminContext, "u1", ""); err != nil {
+		t.Errorf("admin disabling MFA = %v", err)
+	}
+}
+
+func TestMFAAdminRole(t *testing.T) {
+	ctx := context.Background()
+	clock := newTestClock()
+	a := newTestAuth(t, AuthConfig{
+		Now: clock.Now,
+		Roles: func(userID string) []string {
+			if userID == "u1" {
+				return []string{AdminRole, "reader"}
+			}
+			return nil
+		},
+	})
+	a.SetPassword(adminContext, "u1", "correct horse")
+
+	// Without MFA, an admin's sessions carry their other roles only.
+	tokens, err := a.Authenticate(ctx, "ann", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	annCtx := a.as(t, tokens)
+	if p, _ := PrincipalFromContext(annCtx); p.HasRole(AdminRole) || !p.HasRole("reader") {
+		t.Errorf("roles without MFA = %v, want reader only", p.Roles)
+	}
+	if _, err := a.ListSessions(annCtx, "u2"); err != ErrForbidden {
+		t.Errorf("admin action without MFA = %v, want ErrForbidden", err)
+	}
+
+	// With MFA, they are admins, and their tokens say how they logged in.
+	secret, _ := enrollMFA(t, a, annCtx, "u1", clock.Now())
+	clock.Add(30 * time.Second)
+	tokens, _ = a.Authenticate(ctx, "ann", "correct horse")
+	code, _ := TOTP(secret, clock.Now())
+	if tokens, err = a.VerifyMFA(ctx, tokens.Challenge, code); err != nil {
+		t.Fatal(err)
+	}
+	annCtx = a.as(t, tokens)
+	if p, _ := PrincipalFromContext(annCtx); !p.HasRole(AdminRole) {
+		t.Errorf("roles with MFA = %v, want admin", p.Roles)
+	}
+	if _, err := a.ListSessions(annCtx, "u2"); err != nil {
+		t.Errorf("admin action with MFA = %v", err)
+	}
+	claims, _ := a.verifier.Verify(tokens.AccessToken)
+	if amr := claimStrings(claims, "amr"); strings.Join(amr, " ") != "pwd otp" {
+		t.Errorf("amr = %v, want pwd otp", amr)
+	}
+
+	// Refreshed tokens keep the roles of the session.
+	tokens, err = a.Refresh(ctx, tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := PrincipalFromContext(a.as(t, tokens)); !p.HasRole(AdminRole) {
+		t.Errorf("roles after a refresh = %v, want admin", p.Roles)
+	}
+}
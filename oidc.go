@@ -0,0 +1,542 @@
+package learn
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+)
+
+// ErrOIDCSigningKey is returned by NewOIDCProvider for an Issuer whose key
+// can't be published, such as a SharedSecret, or that has no key id.
+var ErrOIDCSigningKey = errors.New("OIDC tokens must be signed with a private key that has a key id")
+
+const (
+	// oidcCodeTTL is how long an authorization code may wait to be
+	// exchanged for tokens.
+	oidcCodeTTL = time.Minute
+
+	// oidcDiscoveryPath and the others are where the endpoints of a provider
+	// are served, below the path of its issuer URL.
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+	oidcAuthorizePath = "/oidc/authorize"
+	oidcTokenPath     = "/oidc/token"
+	oidcUserInfoPath  = "/oidc/userinfo"
+	oidcJWKSPath      = "/oidc/jwks"
+)
+
+// OIDCClient is an app that users log in to with learn.
+type OIDCClient struct {
+	ID string `json:"id"`
+
+	// Secret authenticates a confidential client when it exchanges a code.
+	// Public clients, such as single page apps, have none, and rely on PKCE
+	// alone.
+	Secret string `json:"secret,omitempty"`
+
+	// RedirectURIs are the URIs users may be sent back to with a code. A
+	// redirect URI must match one of them exactly.
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+// LoadOIDCClients reads the clients of a provider from a JSON file holding
+// an array of them.
+func LoadOIDCClients(path string) ([]OIDCClient, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var clients []OIDCClient
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+// OIDCConfig configures an OIDCProvider.
+type OIDCConfig struct {
+	// Issuer is the URL the provider is reached at, which its tokens carry as
+	// their iss claim. Its endpoints are served below its path.
+	Issuer string
+
+	Clients []OIDCClient
+
+	// TokenTTL is how long the access and ID tokens last. If zero, it is an
+	// hour.
+	TokenTTL time.Duration
+
+	// Now returns the current time, against which codes expire, and which
+	// tokens are issued at. If nil, it is time.Now.
+	Now func() time.Time
+}
+
+// OIDCProvider is a minimal OpenID Connect provider, which lets users log in
+// to other apps with their learn password, and MFA if they are enrolled. It
+// only supports the authorization code flow, with PKCE required of every
+// client.
+type OIDCProvider struct {
+	users   UserService
+	auth    AuthService
+	signer  *Issuer
+	keys    Keys
+	config  OIDCConfig
+	clients map[string]OIDCClient
+	path    string
+
+	mtx   sync.Mutex
+	codes map[string]*oidcCode
+}
+
+// oidcCode is an authorization code, waiting to be exchanged for tokens.
+type oidcCode struct {
+	client      string
+	redirectURI string
+	userID      string
+	scope       string
+	nonce       string
+	challenge   string
+	authTime    time.Time
+	expires     time.Time
+}
+
+// NewOIDCProvider returns a provider of users, who log in with auth, and
+// whose tokens are signed with the key of signer. The signer must have a
+// private key with a key id, which is published in the JWKS of the
+// provider. Codes are only kept in memory, by this instance.
+//
+// The tokens of the provider are meant for other apps. A Verifier trusting
+// the same key should RejectIssuer the issuer of the provider.
+func NewOIDCProvider(users UserService, auth AuthService, signer *Issuer, config OIDCConfig) (*OIDCProvider, error) {
+	if _, ok := signer.jsonWebKey(); !ok || signer.kid == "" {
+		return nil, ErrOIDCSigningKey
+	}
+	u, err := url.Parse(config.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.New("OIDC issuer must be an absolute URL without query or fragment")
+	}
+	if config.TokenTTL <= 0 {
+		config.TokenTTL = time.Hour
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	clients := map[string]OIDCClient{}
+	for _, c := range config.Clients {
+		clients[c.ID] = c
+	}
+	// The tokens of the provider carry its own issuer, and their audience
+	// set per token.
+	signer = &Issuer{method: signer.method, kid: signer.kid, key: signer.key, issuer: config.Issuer}
+	return &OIDCProvider{
+		users:   users,
+		auth:    auth,
+		signer:  signer,
+		keys:    signer.Keys(noKeys{}),
+		config:  config,
+		clients: clients,
+		path:    strings.TrimSuffix(u.Path, "/"),
+		codes:   map[string]*oidcCode{},
+	}, nil
+}
+
+// noKeys has no keys at all.
+type noKeys struct{}
+
+func (noKeys) Key(kid string) (interface{}, error) {
+	return nil, errors.New("unknown key id")
+}
+
+// Path is the path of the issuer URL, below which the endpoints of the
+// provider are served, without a trailing slash.
+func (p *OIDCProvider) Path() string {
+	return p.path
+}
+
+// endpoint returns the URL of the endpoint at path.
+func (p *OIDCProvider) endpoint(path string) string {
+	return strings.TrimSuffix(p.config.Issuer, "/") + path
+}
+
+func (p *OIDCProvider) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                p.config.Issuer,
+		"authorization_endpoint":                p.endpoint(oidcAuthorizePath),
+		"token_endpoint":                        p.endpoint(oidcTokenPath),
+		"userinfo_endpoint":                     p.endpoint(oidcUserInfoPath),
+		"jwks_uri":                              p.endpoint(oidcJWKSPath),
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{p.signer.method.Alg()},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "name", "given_name", "family_name", "preferred_username", "email"},
+	})
+}
+
+func (p *OIDCProvider) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	key, _ := p.signer.jsonWebKey()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{key}})
+}
+
+// oidcLoginParams are the parameters of an authorization request, which the
+// login form passes on.
+var oidcLoginParams = []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"}
+
+var oidcLoginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Log in</title></head>
+<body>
+<form method="post">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}{{if .Error}}<p>{{.Error}}</p>
+{{end}}{{if .Challenge}}<input type="hidden" name="challenge" value="{{.Challenge}}">
+<label>Authentication code <input name="code" autocomplete="one-time-code" autofocus></label>
+{{else}}<label>Username <input name="username" autocomplete="username" autofocus></label>
+<label>Password <input type="password" name="password" autocomplete="current-password"></label>
+{{end}}<button type="submit">Log in</button>
+</form>
+</body>
+</html>
+`))
+
+type oidcLoginPage struct {
+	Params    map[string]string
+	Challenge string
+	Error     string
+}
+
+// serveAuthorize shows the login form for an authorization request, and
+// sends the user back to the client with a code once they log in.
+func (p *OIDCProvider) serveAuthorize(ctx context.Context, w http.ResponseWriter, r *http.Request, logger log.Logger) {
+	if r.Method != "GET" && r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	form := r.Form
+
+	// Until the redirect URI is known to be the client's, errors are shown
+	// to the user rather than sent to it.
+	client, ok := p.clients[form.Get("client_id")]
+	if !ok {
+		http.Error(w, "Unknown client", http.StatusBadRequest)
+		return
+	}
+	redirectURI := form.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !contains(client.RedirectURIs, redirectURI) {
+		http.Error(w, "Redirect URI is not registered for the client", http.StatusBadRequest)
+		return
+	}
+	redirect := func(params url.Values) {
+		u, _ := url.Parse(redirectURI)
+		q := u.Query()
+		for k, v := range params {
+			q[k] = v
+		}
+		if state := form.Get("state"); state != "" {
+			q.Set("state", state)
+		}
+		u.RawQuery = q.Encode()
+		http.Redirect(w, r, u.String(), http.StatusFound)
+	}
+	fail := func(code, description string) {
+		redirect(url.Values{"error": {code}, "error_description": {description}})
+	}
+
+	switch {
+	case form.Get("response_type") != "code":
+		fail("unsupported_response_type", "only the code response type is supported")
+		return
+	case !contains(strings.Fields(form.Get("scope")), "openid"):
+		fail("invalid_scope", "the openid scope is required")
+		return
+	case form.Get("code_challenge") == "" || form.Get("code_challenge_method") != "S256":
+		fail("invalid_request", "PKCE with the S256 method is required")
+		return
+	}
+
+	page := oidcLoginPage{Params: map[string]string{}}
+	for _, name := range oidcLoginParams {
+		if v := form.Get(name); v != "" {
+			page.Params[name] = v
+		}
+	}
+	page.Params["redirect_uri"] = redirectURI
+	render := func(status int) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.WriteHeader(status)
+		oidcLoginTemplate.Execute(w, page)
+	}
+	if r.Method == "GET" {
+		render(http.StatusOK)
+		return
+	}
+
+	// Logins through the provider are throttled like any other.
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ctx = WithClientIP(ctx, host)
+	}
+	var tokens *Tokens
+	var err error
+	if challenge := form.Get("challenge"); challenge != "" {
+		tokens, err = p.auth.VerifyMFA(ctx, challenge, form.Get("code"))
+	} else {
+		tokens, err = p.auth.Authenticate(ctx, form.Get("username"), form.Get("password"))
+	}
+	switch {
+	case err == ErrMFAInvalidCode:
+		page.Challenge, page.Error = form.Get("challenge"), err.Error()
+		render(http.StatusUnauthorized)
+		return
+	case err == ErrLockedOut:
+		page.Error = err.Error()
+		render(http.StatusTooManyRequests)
+		return
+	case IsServiceError(err):
+		page.Error = err.Error()
+		render(http.StatusUnauthorized)
+		return
+	case err != nil:
+		logger.Log("path", r.URL.Path, "err", err)
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	case tokens.Challenge != "":
+		page.Challenge = tokens.Challenge
+		render(http.StatusOK)
+		return
+	}
+
+	// The user only needs the session for as long as it takes to find out
+	// who they are. Its token comes straight from auth, so it needn't be
+	// verified.
+	claims := stdjwt.MapClaims{}
+	if _, _, err := new(stdjwt.Parser).ParseUnverified(tokens.AccessToken, claims); err != nil {
+		logger.Log("path", r.URL.Path, "err", err)
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+	userID, _ := claims["sub"].(string)
+	user := WithPrincipal(ctx, &Principal{ID: userID, Kind: UserPrincipal})
+	if err := p.auth.RevokeSession(user, userID, tokens.SessionID); err != nil {
+		logger.Log("path", r.URL.Path, "id", userID, "err", err)
+	}
+
+	code, err := randomID()
+	if err != nil {
+		logger.Log("path", r.URL.Path, "err", err)
+		http.Error(w, "Login failed", http.StatusInternalServerError)
+		return
+	}
+	now := p.config.Now()
+	p.mtx.Lock()
+	for c, pending := range p.codes {
+		if now.After(pending.expires) {
+			delete(p.codes, c)
+		}
+	}
+	p.codes[code] = &oidcCode{
+		client:      client.ID,
+		redirectURI: redirectURI,
+		userID:      userID,
+		scope:       form.Get("scope"),
+		nonce:       form.Get("nonce"),
+		challenge:   form.Get("code_challenge"),
+		authTime:    now,
+		expires:     now.Add(oidcCodeTTL),
+	}
+	p.mtx.Unlock()
+	redirect(url.Values{"code": {code}})
+}
+
+// serveToken exchanges an authorization code for an access token and an ID
+// token.
+func (p *OIDCProvider) serveToken(ctx context.Context, w http.ResponseWriter, r *http.Request, logger log.Logger) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	r.ParseForm()
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	id, secret, basic := r.BasicAuth()
+	if !basic {
+		id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	client, ok := p.clients[id]
+	if !ok || (client.Secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) != 1) {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="learn"`)
+		}
+		oauthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		oauthError(w, http.StatusBadRequest, "unsupported_grant_type", "only the authorization_code grant is supported")
+		return
+	}
+
+	// A code is used up by the first attempt to exchange it, right or wrong.
+	p.mtx.Lock()
+	code, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mtx.Unlock()
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || p.config.Now().After(code.expires) || code.client != client.ID:
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the code is invalid or expired")
+		return
+	case r.PostForm.Get("redirect_uri") != code.redirectURI:
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the redirect URI doesn't match")
+		return
+	case subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(verifier[:])), []byte(code.challenge)) != 1:
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the code verifier doesn't match")
+		return
+	}
+
+	user, err := p.users.GetUser(ctx, code.userID)
+	if err == ErrNotFound {
+		oauthError(w, http.StatusBadRequest, "invalid_grant", "the user no longer exists")
+		return
+	}
+	if err != nil {
+		logger.Log("path", r.URL.Path, "id", code.userID, "err", err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	now := p.config.Now()
+	access, exp, err := p.signer.issueAt(now, user.Id, p.config.TokenTTL, stdjwt.MapClaims{
+		"aud":       p.config.Issuer,
+		"scope":     code.scope,
+		"client_id": client.ID,
+	})
+	if err != nil {
+		logger.Log("path", r.URL.Path, "id", code.userID, "err", err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+	claims := userClaims(user, code.scope)
+	claims["aud"] = client.ID
+	claims["auth_time"] = code.authTime.Unix()
+	if code.nonce != "" {
+		claims["nonce"] = code.nonce
+	}
+	idToken, _, err := p.signer.issueAt(now, user.Id, p.config.TokenTTL, claims)
+	if err != nil {
+		logger.Log("path", r.URL.Path, "id", code.userID, "err", err)
+		oauthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": access,
+		"token_type":   "Bearer",
+		"expires_in":   exp.Unix() - now.Unix(),
+		"id_token":     idToken,
+		"scope":        code.scope,
+	})
+}
+
+// serveUserInfo returns the claims of the user of an access token, as
+// allowed by its scope.
+func (p *OIDCProvider) serveUserInfo(ctx context.Context, w http.ResponseWriter, r *http.Request, logger log.Logger) {
+	if r.Method != "GET" && r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var claims stdjwt.MapClaims
+	err := errors.New("missing bearer token")
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != r.Header.Get("Authorization") {
+		// Only access tokens are meant for the provider itself; ID tokens
+		// are meant for clients.
+		v := NewVerifier(p.keys, p.config.Issuer, p.config.Issuer, 0)
+		v.now = p.config.Now
+		claims, err = v.Verify(token)
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	sub, _ := claims["sub"].(string)
+	scope, _ := claims["scope"].(string)
+	user, err := p.users.GetUser(ctx, sub)
+	if err == ErrNotFound {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		logger.Log("path", r.URL.Path, "id", sub, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(userClaims(user, scope))
+}
+
+// userClaims returns the standard claims of user that scope allows.
+func userClaims(user *User, scope string) stdjwt.MapClaims {
+	claims := stdjwt.MapClaims{"sub": user.Id}
+	scopes := strings.Fields(scope)
+	if contains(scopes, "profile") {
+		claims["name"] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		claims["given_name"] = user.FirstName
+		claims["family_name"] = user.LastName
+		claims["preferred_username"] = user.Username
+	}
+	if contains(scopes, "email") && user.Email != "" {
+		claims["email"] = user.Email
+	}
+	return claims
+}
+
+// oauthError writes an error response of the token endpoint, as described
+// by RFC 6749.
+func oauthError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	resp := map[string]string{"error": code}
+	if description != "" {
+		resp["error_description"] = description
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
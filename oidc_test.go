@@ -0,0 +1,396 @@
+package learn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+)
+
+// testOIDC is an OIDCProvider served over HTTP, with the confidential client
+// web and the public client spa, for the users ann (u1) and bob (u2). As in
+// learnd, the provider signs with the key of the AuthService.
+type testOIDC struct {
+	AuthService
+	provider *OIDCProvider
+	signer   *Issuer
+	clock    *testClock
+	server   *httptest.Server
+	issuer   string
+}
+
+const testVerifier = "a-code-verifier-of-at-least-43-characters-0123456789"
+
+// testChallenge is the S256 PKCE challenge of testVerifier.
+func testChallenge() string {
+	sum := sha256.Sum256([]byte(testVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func newTestOIDC(t *testing.T) *testOIDC {
+	ctx := context.Background()
+	// Tokens are checked against the real time, so the clock starts there.
+	clock := &testClock{now: time.Now()}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewIssuer(key, "k1", "learn", "apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := NewBasicService()
+	users.CreateUser(ctx, &User{Id: "u1", Username: "ann", FirstName: "Ann", LastName: "Lee", Email: "ann@example.com"})
+	users.CreateUser(ctx, &User{Id: "u2", Username: "bob"})
+	auth := NewAuthService(users, AuthConfig{Issuer: signer, AccessTTL: time.Minute, RefreshTTL: time.Hour, Now: clock.Now})
+	auth.SetPassword(adminContext, "u1", "correct horse")
+	auth.SetPassword(adminContext, "u2", "battery staple")
+
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	issuer := srv.URL + "/idp"
+	p, err := NewOIDCProvider(users, auth, signer, OIDCConfig{
+		Issuer: issuer,
+		Clients: []OIDCClient{
+			{ID: "web", Secret: "web secret", RedirectURIs: []string{"https://app/cb"}},
+			{ID: "spa", RedirectURIs: []string{"https://spa/cb", "https://spa/cb2"}},
+		},
+		TokenTTL: time.Hour,
+		Now:      clock.Now,
+	})
+	if err != nil {
+		srv.Close()
+		t.Fatal(err)
+	}
+	h := MakeOIDCHTTPHandler(ctx, p, log.NewNopLogger())
+	mux.Handle(p.Path()+"/.well-known/openid-configuration", h)
+	mux.Handle(p.Path()+"/oidc/", h)
+	return &testOIDC{AuthService: auth, provider: p, signer: signer, clock: clock, server: srv, issuer: issuer}
+}
+
+// noRedirect is a client that returns redirects rather than follow them.
+var noRedirect = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+// authorize posts the login form of an authorization request with params,
+// and returns the response.
+func (o *testOIDC) authorize(t *testing.T, params, form url.Values) *http.Response {
+	v := url.Values{}
+	for k, vs := range params {
+		v[k] = vs
+	}
+	for k, vs := range form {
+		v[k] = vs
+	}
+	resp, err := noRedirect.PostForm(o.issuer+oidcAuthorizePath, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// token makes a token request with form, authenticating as client with
+// secret if client isn't empty, and returns the status and response.
+func (o *testOIDC) token(t *testing.T, form url.Values, client, secret string) (int, map[string]interface{}) {
+	r, _ := http.NewRequest("POST", o.issuer+oidcTokenPath, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if client != "" {
+		r.SetBasicAuth(client, secret)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+// userInfo calls the userinfo endpoint with token, and returns the status,
+// claims and WWW-Authenticate header of the response.
+func (o *testOIDC) userInfo(t *testing.T, token string) (int, map[string]interface{}, string) {
+	r, _ := http.NewRequest("GET", o.issuer+oidcUserInfoPath, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var claims map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&claims)
+	return resp.StatusCode, claims, resp.Header.Get("WWW-Authenticate")
+}
+
+func TestOIDCSigningKey(t *testing.T) {
+	secret, _ := NewIssuer(SharedSecret("secret"), "", "", "")
+	if _, err := NewOIDCProvider(NewBasicService(), nil, secret, OIDCConfig{Issuer: "https://idp"}); err != ErrOIDCSigningKey {
+		t.Errorf("provider signing with a shared secret = %v, want ErrOIDCSigningKey", err)
+	}
+}
+
+func TestOIDCCodeFlow(t *testing.T) {
+	o := newTestOIDC(t)
+	defer o.server.Close()
+
+	// Discovery, and the JWKS loaded the way learnd loads key files.
+	resp, err := http.Get(o.issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var discovery map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&discovery)
+	resp.Body.Close()
+	for name, want := range map[string]string{
+		"issuer":                 o.issuer,
+		"authorization_endpoint": o.issuer + oidcAuthorizePath,
+		"token_endpoint":         o.issuer + oidcTokenPath,
+		"userinfo_endpoint":      o.issuer + oidcUserInfoPath,
+		"jwks_uri":               o.issuer + oidcJWKSPath,
+	} {
+		if discovery[name] != want {
+			t.Errorf("%s = %v, want %s", name, discovery[name], want)
+		}
+	}
+	resp, err = http.Get(o.issuer + oidcJWKSPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	dir, err := ioutil.TempDir("", "oidc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "jwks.json"), jwks, 0600)
+	keys, err := NewKeySet([]string{filepath.Join(dir, "jwks.json")}, time.Hour, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keys.Stop()
+
+	// The authorization request, with PKCE, gets the login form.
+	params := url.Values{
+		"client_id":             {"web"},
+		"redirect_uri":          {"https://app/cb"},
+		"response_type":         {"code"},
+		"scope":                 {"openid profile email"},
+		"state":                 {"st"},
+		"nonce":                 {"n1"},
+		"code_challenge":        {testChallenge()},
+		"code_challenge_method": {"S256"},
+	}
+	resp, err = noRedirect.Get(o.issuer + oidcAuthorizePath + "?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), `name="password"`) || !strings.Contains(string(page), `value="st"`) {
+		t.Fatalf("authorize = %s %s, want the login form", resp.Status, page)
+	}
+	if resp.Header.Get("X-Frame-Options") != "DENY" {
+		t.Error("the login form may be framed")
+	}
+
+	// Logging in redirects back with a code, leaving no session behind.
+	resp = o.authorize(t, params, url.Values{"username": {"ann"}, "password": {"wrong"}})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong password = %s, want 401", resp.Status)
+	}
+	resp = o.authorize(t, params, url.Values{"username": {"ann"}, "password": {"correct horse"}})
+	loc, _ := url.Parse(resp.Header.Get("Location"))
+	code := loc.Query().Get("code")
+	if resp.StatusCode != http.StatusFound || loc.Host != "app" || code == "" || loc.Query().Get("state") != "st" {
+		t.Fatalf("login = %s to %v, want a redirect to the app with a code", resp.Status, loc)
+	}
+	if sessions, _ := o.ListSessions(adminContext, "u1"); len(sessions) != 0 {
+		t.Errorf("sessions = %+v, want none left by the login", sessions)
+	}
+
+	// The code is exchanged once, by the client it was issued to.
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {"https://app/cb"},
+		"code_verifier": {testVerifier},
+	}
+	if status, body := o.token(t, exchange, "web", "wrong"); status != http.StatusUnauthorized || body["error"] != "invalid_client" {
+		t.Errorf("wrong client secret = %d %v, want 401 invalid_client", status, body)
+	}
+	status, tokens := o.token(t, exchange, "web", "web secret")
+	if status != http.StatusOK || tokens["token_type"] != "Bearer" {
+		t.Fatalf("token = %d %v, want tokens", status, tokens)
+	}
+	if tokens["expires_in"] != float64(3600) {
+		t.Errorf("expires_in = %v, want 3600", tokens["expires_in"])
+	}
+	if status, body := o.token(t, exchange, "web", "web secret"); status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Errorf("reused code = %d %v, want 400 invalid_grant", status, body)
+	}
+	accessToken, _ := tokens["access_token"].(string)
+	idToken, _ := tokens["id_token"].(string)
+
+	// The ID token is for the client, and the access token for userinfo.
+	claims, err := NewVerifier(keys, o.issuer, "web", 0).Verify(idToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "u1" || claims["nonce"] != "n1" || claims["email"] != "ann@example.com" || claims["name"] != "Ann Lee" {
+		t.Errorf("ID token claims = %v, want those of ann", claims)
+	}
+	if _, err := NewVerifier(keys, o.issuer, "web", 0).Verify(accessToken); err == nil {
+		t.Error("access token accepted as an ID token")
+	}
+	status, info, _ := o.userInfo(t, accessToken)
+	if status != http.StatusOK || info["sub"] != "u1" || info["preferred_username"] != "ann" || info["email"] != "ann@example.com" {
+		t.Errorf("userinfo = %d %v, want the claims of ann", status, info)
+	}
+	if status, _, auth := o.userInfo(t, idToken); status != http.StatusUnauthorized || !strings.Contains(auth, "invalid_token") {
+		t.Errorf("userinfo with an ID token = %d %q, want 401 invalid_token", status, auth)
+	}
+
+	// An API trusting the same key doesn't take tokens meant for other
+	// apps.
+	api := NewVerifier(o.signer.Keys(SharedSecret(nil)), "", "", 0)
+	api.RejectIssuer(o.issuer)
+	for _, token := range []string{accessToken, idToken} {
+		if _, err := api.AuthenticateContext(context.WithValue(context.Background(), jwt.JWTTokenContextKey, token)); err != jwt.ErrTokenInvalid {
+			t.Errorf("OIDC token at the API = %v, want jwt.ErrTokenInvalid", err)
+		}
+	}
+	own, _, _ := o.signer.Issue("u1", time.Minute, nil)
+	if _, err := api.Verify(own); err != nil {
+		t.Errorf("token of the API's own issuer = %v", err)
+	}
+}
+
+func TestOIDCAuthorizeErrors(t *testing.T) {
+	o := newTestOIDC(t)
+	defer o.server.Close()
+	get := func(params url.Values) (*http.Response, url.Values) {
+		resp, err := noRedirect.Get(o.issuer + oidcAuthorizePath + "?" + params.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		loc, _ := url.Parse(resp.Header.Get("Location"))
+		return resp, loc.Query()
+	}
+
+	// Errors are shown until the redirect URI is trusted...
+	for _, params := range []url.Values{
+		{"client_id": {"web"}, "redirect_uri": {"https://evil/cb"}},
+		{"client_id": {"nobody"}},
+	} {
+		if resp, _ := get(params); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("authorize %v = %s, want 400", params, resp.Status)
+		}
+	}
+
+	// ...and redirected with the state after.
+	resp, query := get(url.Values{"client_id": {"web"}, "redirect_uri": {"https://app/cb"}, "response_type": {"code"}, "scope": {"openid"}, "state": {"s2"}})
+	if resp.StatusCode != http.StatusFound || query.Get("error") != "invalid_request" || query.Get("state") != "s2" {
+		t.Errorf("authorize without PKCE = %s %v, want a redirect with invalid_request", resp.Status, query)
+	}
+	resp, query = get(url.Values{"client_id": {"web"}, "response_type": {"code"}, "scope": {"profile"}, "code_challenge": {testChallenge()}, "code_challenge_method": {"S256"}})
+	if resp.StatusCode != http.StatusFound || query.Get("error") != "invalid_scope" {
+		t.Errorf("authorize without openid = %s %v, want a redirect with invalid_scope", resp.Status, query)
+	}
+}
+
+func TestOIDCPublicClient(t *testing.T) {
+	o := newTestOIDC(t)
+	defer o.server.Close()
+	secret, _ := enrollMFA(t, o, adminContext, "u1", o.clock.Now())
+	params := url.Values{
+		"client_id":             {"spa"},
+		"redirect_uri":          {"https://spa/cb2"},
+		"response_type":         {"code"},
+		"scope":                 {"openid"},
+		"code_challenge":        {testChallenge()},
+		"code_challenge_method": {"S256"},
+	}
+
+	// Users enrolled in MFA give a code after their password.
+	login := func() *http.Response {
+		resp := o.authorize(t, params, url.Values{"username": {"ann"}, "password": {"correct horse"}})
+		page, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		m := regexp.MustCompile(`name="challenge" value="([^"]+)"`).FindStringSubmatch(string(page))
+		if resp.StatusCode != http.StatusOK || m == nil {
+			t.Fatalf("login = %s %s, want the MFA form", resp.Status, page)
+		}
+		if resp := o.authorize(t, params, url.Values{"challenge": {m[1]}, "code": {"000000"}}); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("wrong MFA code = %s, want 401", resp.Status)
+		}
+		o.clock.Add(30 * time.Second)
+		code, _ := TOTP(secret, o.clock.Now())
+		return o.authorize(t, params, url.Values{"challenge": {m[1]}, "code": {code}})
+	}
+	resp := login()
+	loc, _ := url.Parse(resp.Header.Get("Location"))
+	if resp.StatusCode != http.StatusFound || loc.Host != "spa" || loc.Path != "/cb2" {
+		t.Fatalf("MFA = %s to %v, want a redirect to the second URI of spa", resp.Status, loc)
+	}
+
+	// Without a secret, the code verifier is all that proves the client.
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {loc.Query().Get("code")},
+		"redirect_uri":  {"https://spa/cb2"},
+		"code_verifier": {"wrong"},
+		"client_id":     {"spa"},
+	}
+	if status, body := o.token(t, exchange, "", ""); status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Errorf("wrong code verifier = %d %v, want 400 invalid_grant", status, body)
+	}
+	loc, _ = url.Parse(login().Header.Get("Location"))
+	exchange.Set("code", loc.Query().Get("code"))
+	exchange.Set("code_verifier", testVerifier)
+	status, tokens := o.token(t, exchange, "", "")
+	if status != http.StatusOK {
+		t.Fatalf("token = %d %v, want tokens", status, tokens)
+	}
+	// Tokens are issued by the clock of the provider.
+	claims, err := NewVerifier(o.signer.Keys(SharedSecret(nil)), o.issuer, o.issuer, time.Hour).Verify(tokens["access_token"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iat, _ := claimTime(claims, "iat"); iat.Unix() != o.clock.Now().Unix() {
+		t.Errorf("iat = %v, want %v", iat, o.clock.Now())
+	}
+
+	// The scope limits the claims.
+	if status, info, _ := o.userInfo(t, tokens["access_token"].(string)); status != http.StatusOK || info["sub"] != "u1" || info["email"] != nil {
+		t.Errorf("userinfo for the openid scope = %d %v, want only sub", status, info)
+	}
+
+	// Codes expire, and other grants aren't supported.
+	resp = o.authorize(t, params, url.Values{"username": {"bob"}, "password": {"battery staple"}})
+	loc, _ = url.Parse(resp.Header.Get("Location"))
+	o.clock.Add(2 * time.Minute)
+	exchange.Set("code", loc.Query().Get("code"))
+	if status, body := o.token(t, exchange, "", ""); status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Errorf("expired code = %d %v, want 400 invalid_grant", status, body)
+	}
+	if status, body := o.token(t, url.Values{"grant_type": {"password"}, "client_id": {"spa"}}, "", ""); status != http.StatusBadRequest || body["error"] != "unsupported_grant_type" {
+		t.Errorf("password grant = %d %v, want 400 unsupported_grant_type", status, body)
+	}
+}
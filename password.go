@@ -0,0 +1,275 @@
+package learn
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The rules of a PasswordPolicy, as reported by a PasswordViolation.
+const (
+	PasswordTooShort         = "too_short"
+	PasswordTooLong          = "too_long"
+	PasswordTooFewClasses    = "too_few_classes"
+	PasswordContainsUsername = "contains_username"
+	PasswordContainsEmail    = "contains_email"
+	PasswordBreached         = "breached"
+)
+
+// bcryptMaxLength is the most bytes of a password that bcrypt hashes. Longer
+// passwords are refused rather than silently cut short.
+const bcryptMaxLength = 72
+
+// userInfoMinLength is how long a username, or the local part of an email
+// address, must be for passwords containing it to be refused.
+const userInfoMinLength = 3
+
+// PasswordPolicy is what SetPassword requires of passwords. Its zero value
+// requires nothing beyond what bcrypt can hash.
+type PasswordPolicy struct {
+	// MinLength and MaxLength bound the number of characters. Passwords of
+	// more than 72 bytes are always refused.
+	MinLength int
+	MaxLength int
+
+	// MinClasses is how many character classes, of lower case letters, upper
+	// case letters, digits and everything else, a password must mix.
+	MinClasses int
+
+	// NoUserInfo refuses passwords containing the username, or the local
+	// part of the email address, of their user, ignoring case.
+	NoUserInfo bool
+
+	// Breached, if set, refuses the passwords it lists.
+	Breached BreachedPasswords
+}
+
+// PasswordViolation is a rule of a PasswordPolicy that a password breaks,
+// such as PasswordTooShort, with a message for the user.
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError is returned when setting a password that breaks the
+// password policy. It lists every rule broken.
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Message
+	}
+	return "Password rejected: " + strings.Join(messages, "; ")
+}
+
+// Check returns a *PasswordPolicyError if password, for user, breaks the
+// policy, or the error of looking it up in Breached.
+func (p PasswordPolicy) Check(password string, user *User) error {
+	var violations []PasswordViolation
+	violate := func(rule, format string, args ...interface{}) {
+		violations = append(violations, PasswordViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	n := utf8.RuneCountInString(password)
+	if n < p.MinLength {
+		violate(PasswordTooShort, "must be at least %d characters", p.MinLength)
+	}
+	if (p.MaxLength > 0 && n > p.MaxLength) || len(password) > bcryptMaxLength {
+		max := p.MaxLength
+		if max <= 0 || max > bcryptMaxLength {
+			max = bcryptMaxLength
+		}
+		violate(PasswordTooLong, "must be at most %d characters", max)
+	}
+	if classes := characterClasses(password); classes < p.MinClasses {
+		violate(PasswordTooFewClasses, "must mix at least %d of lower case letters, upper case letters, digits and symbols", p.MinClasses)
+	}
+
+	if p.NoUserInfo && user != nil {
+		lower := strings.ToLower(password)
+		if len(user.Username) >= userInfoMinLength && strings.Contains(lower, strings.ToLower(user.Username)) {
+			violate(PasswordContainsUsername, "must not contain the username")
+		}
+		local := user.Email
+		if i := strings.LastIndex(local, "@"); i >= 0 {
+			local = local[:i]
+		}
+		if len(local) >= userInfoMinLength && strings.Contains(lower, strings.ToLower(local)) {
+			violate(PasswordContainsEmail, "must not contain the email address")
+		}
+	}
+
+	if p.Breached != nil {
+		breached, err := isBreached(p.Breached, password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			violate(PasswordBreached, "appears in a list of breached passwords")
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// passwordViolations returns the violations of err, if it is a
+// *PasswordPolicyError, for sending over a transport.
+func passwordViolations(err error) []PasswordViolation {
+	if e, ok := err.(*PasswordPolicyError); ok {
+		return e.Violations
+	}
+	return nil
+}
+
+// withPasswordViolations turns err, received over a transport, back into a
+// *PasswordPolicyError if it came with violations.
+func withPasswordViolations(err error, violations []PasswordViolation) error {
+	if len(violations) == 0 {
+		return err
+	}
+	return &PasswordPolicyError{Violations: violations}
+}
+
+// BreachedPasswords lists breached passwords by their SHA-1 hashes, in the
+// k-anonymity range format of Have I Been Pwned: the hashes are looked up
+// by the first five hex digits they share, so that whole hashes of
+// passwords never need to be handed over.
+type BreachedPasswords interface {
+	// Range returns the remaining 35 hex digits, in upper case, of the
+	// hashes starting with prefix.
+	Range(prefix string) ([]string, error)
+}
+
+func isBreached(b BreachedPasswords, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes, err := b.Range(hash[:5])
+	if err != nil {
+		return false, err
+	}
+	for _, suffix := range suffixes {
+		if suffix == hash[5:] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// LoadBreachedPasswords returns the breached passwords listed at path. If
+// path is a directory, it holds a range file per prefix, named after it,
+// optionally with a .txt extension, which is read on every lookup. Each
+// line of a range file is a suffix, as served by the range API, with an
+// optional ":count". Otherwise path is a single file of whole hashes, one
+// per line with an optional ":count", which is read into memory. Lines with
+// a count of 0 pad ranges, and are skipped.
+func LoadBreachedPasswords(path string) (BreachedPasswords, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return rangeDir(path), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ranges := rangeMap{}
+	err = readRange(f, func(hash string) error {
+		if len(hash) != 2*sha1.Size {
+			return fmt.Errorf("%s: %q is not a SHA-1 hash", path, hash)
+		}
+		ranges[hash[:5]] = append(ranges[hash[:5]], hash[5:])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+// rangeMap holds the suffixes of each range in memory.
+type rangeMap map[string][]string
+
+func (m rangeMap) Range(prefix string) ([]string, error) {
+	return m[prefix], nil
+}
+
+// rangeDir reads ranges from a file per prefix.
+type rangeDir string
+
+func (d rangeDir) Range(prefix string) ([]string, error) {
+	f, err := os.Open(filepath.Join(string(d), prefix+".txt"))
+	if os.IsNotExist(err) {
+		f, err = os.Open(filepath.Join(string(d), prefix))
+	}
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var suffixes []string
+	err = readRange(f, func(suffix string) error {
+		suffixes = append(suffixes, suffix)
+		return nil
+	})
+	return suffixes, err
+}
+
+// readRange calls fn with the upper case hash, or suffix, of every line of
+// r, skipping the padding.
+func readRange(r io.Reader, fn func(string) error) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		hash, count := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			hash, count = line[:i], line[i+1:]
+		}
+		if count == "0" {
+			continue
+		}
+		if err := fn(strings.ToUpper(hash)); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
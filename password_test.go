@@ -0,0 +1,118 @@
+package learn
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// violatedRules returns the rules err says were broken, comma-separated.
+func violatedRules(t *testing.T, err error) string {
+	if err == nil {
+		return ""
+	}
+	e, ok := err.(*PasswordPolicyError)
+	if !ok {
+		t.Fatalf("error %v is a %T, want a *PasswordPolicyError", err, err)
+	}
+	rules := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		rules[i] = v.Rule
+	}
+	return strings.Join(rules, ",")
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	user := &User{Username: "annie", Email: "ann.smith@example.com"}
+	policy := PasswordPolicy{MinLength: 10, MaxLength: 20, MinClasses: 3, NoUserInfo: true}
+	al := &User{Username: "al", Email: "al@example.com"}
+	for _, tc := range []struct {
+		policy   PasswordPolicy
+		user     *User
+		password string
+		rules    string
+	}{
+		{policy, user, "Correct7Horse", ""},
+		{policy, user, "annie", "too_short,too_few_classes,contains_username"},
+		{policy, user, "xxANN.SMITH99", "contains_email"},
+		{policy, user, strings.Repeat("aB1", 10), "too_long"},
+		// Lengths are in characters, but bcrypt's limit is in bytes.
+		{PasswordPolicy{MinLength: 3}, nil, "éé", "too_short"},
+		{PasswordPolicy{}, nil, strings.Repeat("é", 40), "too_long"},
+		// Short user info would refuse too many passwords.
+		{PasswordPolicy{NoUserInfo: true}, al, "al-password", ""},
+	} {
+		if rules := violatedRules(t, tc.policy.Check(tc.password, tc.user)); rules != tc.rules {
+			t.Errorf("%q broke %q, want %q", tc.password, rules, tc.rules)
+		}
+	}
+}
+
+// sha1Hex returns the SHA-1 hash of a password as breach lists have it.
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "breached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A directory of range files, as the k-anonymity API serves them, and a
+	// single file of full hashes.
+	hash := sha1Hex("Password1!")
+	if err := os.Mkdir(filepath.Join(dir, "ranges"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	rangeFile := "0000000000000000000000000000000000A:0\r\n" + strings.ToLower(hash[5:]) + ":3861493\r\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "ranges", hash[:5]+".txt"), []byte(rangeFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "full.txt"), []byte(hash+":12\n"+sha1Hex("other")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"ranges", "full.txt"} {
+		breached, err := LoadBreachedPasswords(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy := PasswordPolicy{Breached: breached}
+		if rules := violatedRules(t, policy.Check("Password1!", nil)); rules != PasswordBreached {
+			t.Errorf("%s: breached password broke %q, want %q", name, rules, PasswordBreached)
+		}
+		if err := policy.Check("Unlisted1!", nil); err != nil {
+			t.Errorf("%s: unlisted password = %v", name, err)
+		}
+	}
+
+	if _, err := LoadBreachedPasswords(filepath.Join(dir, "missing")); err == nil {
+		t.Error("loaded a missing file")
+	}
+	ioutil.WriteFile(filepath.Join(dir, "bad.txt"), []byte("xyz\n"), 0600)
+	if _, err := LoadBreachedPasswords(filepath.Join(dir, "bad.txt")); err == nil {
+		t.Error("loaded a file that isn't of hashes")
+	}
+}
+
+func TestSetPasswordPolicy(t *testing.T) {
+	a := newTestAuth(t, AuthConfig{PasswordPolicy: PasswordPolicy{MinLength: 10, NoUserInfo: true}})
+	if rules := violatedRules(t, a.SetPassword(adminContext, "u1", "ann")); rules != "too_short,contains_username" {
+		t.Errorf("SetPassword broke %q, want too_short,contains_username", rules)
+	}
+	if _, err := a.Authenticate(context.Background(), "ann", "ann"); err != ErrInvalidCredentials {
+		t.Errorf("login with a refused password = %v, want ErrInvalidCredentials", err)
+	}
+	if err := a.SetPassword(adminContext, "u1", "correct horse"); err != nil {
+		t.Errorf("SetPassword = %v", err)
+	}
+}
@@ -11,8 +11,35 @@ It is generated from these files:
 It has these top-level messages:
 	GetRequest
 	CreateRequest
+	BatchGetRequest
+	BatchCreateRequest
+	ImportRequest
+	ImportStatusRequest
+	ExportRequest
+	ReplicateRequest
+	MerkleNodesRequest
+	MerkleBucketRequest
 	UserResponse
+	BatchResponse
+	BatchResult
+	ImportStatus
+	ImportFailure
+	ReplicationEntry
+	MerkleNodes
+	MerkleBucket
 	User
+	AuthenticateRequest
+	RefreshRequest
+	SetPasswordRequest
+	SessionsRequest
+	VerifyMFARequest
+	MFARequest
+	UnlockRequest
+	AuthResponse
+	Tokens
+	PasswordViolation
+	MFAEnrollment
+	Session
 */
 package pb
 
@@ -36,6 +63,48 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+type BatchMode int32
+
+const (
+	BatchMode_BEST_EFFORT    BatchMode = 0
+	BatchMode_ALL_OR_NOTHING BatchMode = 1
+)
+
+var BatchMode_name = map[int32]string{
+	0: "BEST_EFFORT",
+	1: "ALL_OR_NOTHING",
+}
+var BatchMode_value = map[string]int32{
+	"BEST_EFFORT":    0,
+	"ALL_OR_NOTHING": 1,
+}
+
+func (x BatchMode) String() string {
+	return proto.EnumName(BatchMode_name, int32(x))
+}
+func (BatchMode) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+type ChangeType int32
+
+const (
+	ChangeType_CREATED ChangeType = 0
+	ChangeType_EXPIRED ChangeType = 1
+)
+
+var ChangeType_name = map[int32]string{
+	0: "CREATED",
+	1: "EXPIRED",
+}
+var ChangeType_value = map[string]int32{
+	"CREATED": 0,
+	"EXPIRED": 1,
+}
+
+func (x ChangeType) String() string {
+	return proto.EnumName(ChangeType_name, int32(x))
+}
+func (ChangeType) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
 type GetRequest struct {
 	Id string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
 }
@@ -61,6 +130,99 @@ func (m *CreateRequest) GetUser() *User {
 	return nil
 }
 
+type BatchGetRequest struct {
+	Ids  []string  `protobuf:"bytes,1,rep,name=ids" json:"ids,omitempty"`
+	Mode BatchMode `protobuf:"varint,2,opt,name=mode,enum=pb.BatchMode" json:"mode,omitempty"`
+}
+
+func (m *BatchGetRequest) Reset()                    { *m = BatchGetRequest{} }
+func (m *BatchGetRequest) String() string            { return proto.CompactTextString(m) }
+func (*BatchGetRequest) ProtoMessage()               {}
+func (*BatchGetRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+type BatchCreateRequest struct {
+	Users []*User   `protobuf:"bytes,1,rep,name=users" json:"users,omitempty"`
+	Mode  BatchMode `protobuf:"varint,2,opt,name=mode,enum=pb.BatchMode" json:"mode,omitempty"`
+}
+
+func (m *BatchCreateRequest) Reset()                    { *m = BatchCreateRequest{} }
+func (m *BatchCreateRequest) String() string            { return proto.CompactTextString(m) }
+func (*BatchCreateRequest) ProtoMessage()               {}
+func (*BatchCreateRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *BatchCreateRequest) GetUsers() []*User {
+	if m != nil {
+		return m.Users
+	}
+	return nil
+}
+
+type ImportRequest struct {
+	ImportId string `protobuf:"bytes,1,opt,name=importId" json:"importId,omitempty"`
+	Offset   int64  `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
+	User     *User  `protobuf:"bytes,3,opt,name=user" json:"user,omitempty"`
+}
+
+func (m *ImportRequest) Reset()                    { *m = ImportRequest{} }
+func (m *ImportRequest) String() string            { return proto.CompactTextString(m) }
+func (*ImportRequest) ProtoMessage()               {}
+func (*ImportRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *ImportRequest) GetUser() *User {
+	if m != nil {
+		return m.User
+	}
+	return nil
+}
+
+type ImportStatusRequest struct {
+	ImportId string `protobuf:"bytes,1,opt,name=importId" json:"importId,omitempty"`
+}
+
+func (m *ImportStatusRequest) Reset()                    { *m = ImportStatusRequest{} }
+func (m *ImportStatusRequest) String() string            { return proto.CompactTextString(m) }
+func (*ImportStatusRequest) ProtoMessage()               {}
+func (*ImportStatusRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+type ExportRequest struct {
+	IdPrefix    string `protobuf:"bytes,1,opt,name=idPrefix" json:"idPrefix,omitempty"`
+	EmailDomain string `protobuf:"bytes,2,opt,name=emailDomain" json:"emailDomain,omitempty"`
+	After       string `protobuf:"bytes,3,opt,name=after" json:"after,omitempty"`
+}
+
+func (m *ExportRequest) Reset()                    { *m = ExportRequest{} }
+func (m *ExportRequest) String() string            { return proto.CompactTextString(m) }
+func (*ExportRequest) ProtoMessage()               {}
+func (*ExportRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
+
+type ReplicateRequest struct {
+	AfterSeq uint64 `protobuf:"varint,1,opt,name=afterSeq" json:"afterSeq,omitempty"`
+}
+
+func (m *ReplicateRequest) Reset()                    { *m = ReplicateRequest{} }
+func (m *ReplicateRequest) String() string            { return proto.CompactTextString(m) }
+func (*ReplicateRequest) ProtoMessage()               {}
+func (*ReplicateRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+type MerkleNodesRequest struct {
+	Level   uint32   `protobuf:"varint,1,opt,name=level" json:"level,omitempty"`
+	Indices []uint32 `protobuf:"varint,2,rep,packed,name=indices" json:"indices,omitempty"`
+}
+
+func (m *MerkleNodesRequest) Reset()                    { *m = MerkleNodesRequest{} }
+func (m *MerkleNodesRequest) String() string            { return proto.CompactTextString(m) }
+func (*MerkleNodesRequest) ProtoMessage()               {}
+func (*MerkleNodesRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+type MerkleBucketRequest struct {
+	Bucket uint32 `protobuf:"varint,1,opt,name=bucket" json:"bucket,omitempty"`
+}
+
+func (m *MerkleBucketRequest) Reset()                    { *m = MerkleBucketRequest{} }
+func (m *MerkleBucketRequest) String() string            { return proto.CompactTextString(m) }
+func (*MerkleBucketRequest) ProtoMessage()               {}
+func (*MerkleBucketRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
 type UserResponse struct {
 	User *User `protobuf:"bytes,1,opt,name=user" json:"user,omitempty"`
 }
@@ -68,7 +230,7 @@ type UserResponse struct {
 func (m *UserResponse) Reset()                    { *m = UserResponse{} }
 func (m *UserResponse) String() string            { return proto.CompactTextString(m) }
 func (*UserResponse) ProtoMessage()               {}
-func (*UserResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (*UserResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
 
 func (m *UserResponse) GetUser() *User {
 	if m != nil {
@@ -77,24 +239,330 @@ func (m *UserResponse) GetUser() *User {
 	return nil
 }
 
+type BatchResponse struct {
+	Results []*BatchResult `protobuf:"bytes,1,rep,name=results" json:"results,omitempty"`
+	Error   string         `protobuf:"bytes,2,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *BatchResponse) Reset()                    { *m = BatchResponse{} }
+func (m *BatchResponse) String() string            { return proto.CompactTextString(m) }
+func (*BatchResponse) ProtoMessage()               {}
+func (*BatchResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *BatchResponse) GetResults() []*BatchResult {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+type BatchResult struct {
+	User  *User  `protobuf:"bytes,1,opt,name=user" json:"user,omitempty"`
+	Error string `protobuf:"bytes,2,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *BatchResult) Reset()                    { *m = BatchResult{} }
+func (m *BatchResult) String() string            { return proto.CompactTextString(m) }
+func (*BatchResult) ProtoMessage()               {}
+func (*BatchResult) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *BatchResult) GetUser() *User {
+	if m != nil {
+		return m.User
+	}
+	return nil
+}
+
+type ImportStatus struct {
+	ImportId string           `protobuf:"bytes,1,opt,name=importId" json:"importId,omitempty"`
+	Acked    int64            `protobuf:"varint,2,opt,name=acked" json:"acked,omitempty"`
+	Applied  int64            `protobuf:"varint,3,opt,name=applied" json:"applied,omitempty"`
+	Failed   int64            `protobuf:"varint,4,opt,name=failed" json:"failed,omitempty"`
+	Failures []*ImportFailure `protobuf:"bytes,5,rep,name=failures" json:"failures,omitempty"`
+}
+
+func (m *ImportStatus) Reset()                    { *m = ImportStatus{} }
+func (m *ImportStatus) String() string            { return proto.CompactTextString(m) }
+func (*ImportStatus) ProtoMessage()               {}
+func (*ImportStatus) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *ImportStatus) GetFailures() []*ImportFailure {
+	if m != nil {
+		return m.Failures
+	}
+	return nil
+}
+
+type ImportFailure struct {
+	Offset int64  `protobuf:"varint,1,opt,name=offset" json:"offset,omitempty"`
+	Id     string `protobuf:"bytes,2,opt,name=id" json:"id,omitempty"`
+	Error  string `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *ImportFailure) Reset()                    { *m = ImportFailure{} }
+func (m *ImportFailure) String() string            { return proto.CompactTextString(m) }
+func (*ImportFailure) ProtoMessage()               {}
+func (*ImportFailure) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+type ReplicationEntry struct {
+	Seq     uint64     `protobuf:"varint,1,opt,name=seq" json:"seq,omitempty"`
+	Type    ChangeType `protobuf:"varint,2,opt,name=type,enum=pb.ChangeType" json:"type,omitempty"`
+	User    *User      `protobuf:"bytes,3,opt,name=user" json:"user,omitempty"`
+	Time    int64      `protobuf:"varint,4,opt,name=time" json:"time,omitempty"`
+	Hard    bool       `protobuf:"varint,5,opt,name=hard" json:"hard,omitempty"`
+	HeadSeq uint64     `protobuf:"varint,6,opt,name=headSeq" json:"headSeq,omitempty"`
+}
+
+func (m *ReplicationEntry) Reset()                    { *m = ReplicationEntry{} }
+func (m *ReplicationEntry) String() string            { return proto.CompactTextString(m) }
+func (*ReplicationEntry) ProtoMessage()               {}
+func (*ReplicationEntry) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+func (m *ReplicationEntry) GetUser() *User {
+	if m != nil {
+		return m.User
+	}
+	return nil
+}
+
+type MerkleNodes struct {
+	Hashes [][]byte `protobuf:"bytes,1,rep,name=hashes" json:"hashes,omitempty"`
+}
+
+func (m *MerkleNodes) Reset()                    { *m = MerkleNodes{} }
+func (m *MerkleNodes) String() string            { return proto.CompactTextString(m) }
+func (*MerkleNodes) ProtoMessage()               {}
+func (*MerkleNodes) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{16} }
+
+type MerkleBucket struct {
+	Live    []*User `protobuf:"bytes,1,rep,name=live" json:"live,omitempty"`
+	Deleted []*User `protobuf:"bytes,2,rep,name=deleted" json:"deleted,omitempty"`
+}
+
+func (m *MerkleBucket) Reset()                    { *m = MerkleBucket{} }
+func (m *MerkleBucket) String() string            { return proto.CompactTextString(m) }
+func (*MerkleBucket) ProtoMessage()               {}
+func (*MerkleBucket) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{17} }
+
+func (m *MerkleBucket) GetLive() []*User {
+	if m != nil {
+		return m.Live
+	}
+	return nil
+}
+
+func (m *MerkleBucket) GetDeleted() []*User {
+	if m != nil {
+		return m.Deleted
+	}
+	return nil
+}
+
 type User struct {
 	Id        string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
 	FirstName string `protobuf:"bytes,2,opt,name=firstName" json:"firstName,omitempty"`
 	LastName  string `protobuf:"bytes,3,opt,name=lastName" json:"lastName,omitempty"`
 	Email     string `protobuf:"bytes,4,opt,name=email" json:"email,omitempty"`
 	Username  string `protobuf:"bytes,5,opt,name=username" json:"username,omitempty"`
+	ExpiresAt int64  `protobuf:"varint,6,opt,name=expiresAt" json:"expiresAt,omitempty"`
 }
 
 func (m *User) Reset()                    { *m = User{} }
 func (m *User) String() string            { return proto.CompactTextString(m) }
 func (*User) ProtoMessage()               {}
-func (*User) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (*User) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+type AuthenticateRequest struct {
+	Username string `protobuf:"bytes,1,opt,name=username" json:"username,omitempty"`
+	Password string `protobuf:"bytes,2,opt,name=password" json:"password,omitempty"`
+}
+
+func (m *AuthenticateRequest) Reset()                    { *m = AuthenticateRequest{} }
+func (m *AuthenticateRequest) String() string            { return proto.CompactTextString(m) }
+func (*AuthenticateRequest) ProtoMessage()               {}
+func (*AuthenticateRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+type RefreshRequest struct {
+	RefreshToken string `protobuf:"bytes,1,opt,name=refreshToken" json:"refreshToken,omitempty"`
+}
+
+func (m *RefreshRequest) Reset()                    { *m = RefreshRequest{} }
+func (m *RefreshRequest) String() string            { return proto.CompactTextString(m) }
+func (*RefreshRequest) ProtoMessage()               {}
+func (*RefreshRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
+
+type SetPasswordRequest struct {
+	UserId   string `protobuf:"bytes,1,opt,name=userId" json:"userId,omitempty"`
+	Password string `protobuf:"bytes,2,opt,name=password" json:"password,omitempty"`
+}
+
+func (m *SetPasswordRequest) Reset()                    { *m = SetPasswordRequest{} }
+func (m *SetPasswordRequest) String() string            { return proto.CompactTextString(m) }
+func (*SetPasswordRequest) ProtoMessage()               {}
+func (*SetPasswordRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{21} }
+
+type SessionsRequest struct {
+	UserId    string `protobuf:"bytes,1,opt,name=userId" json:"userId,omitempty"`
+	SessionId string `protobuf:"bytes,2,opt,name=sessionId" json:"sessionId,omitempty"`
+}
+
+func (m *SessionsRequest) Reset()                    { *m = SessionsRequest{} }
+func (m *SessionsRequest) String() string            { return proto.CompactTextString(m) }
+func (*SessionsRequest) ProtoMessage()               {}
+func (*SessionsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{22} }
+
+type VerifyMFARequest struct {
+	Challenge string `protobuf:"bytes,1,opt,name=challenge" json:"challenge,omitempty"`
+	Code      string `protobuf:"bytes,2,opt,name=code" json:"code,omitempty"`
+}
+
+func (m *VerifyMFARequest) Reset()                    { *m = VerifyMFARequest{} }
+func (m *VerifyMFARequest) String() string            { return proto.CompactTextString(m) }
+func (*VerifyMFARequest) ProtoMessage()               {}
+func (*VerifyMFARequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{23} }
+
+type MFARequest struct {
+	UserId string `protobuf:"bytes,1,opt,name=userId" json:"userId,omitempty"`
+	Code   string `protobuf:"bytes,2,opt,name=code" json:"code,omitempty"`
+}
+
+func (m *MFARequest) Reset()                    { *m = MFARequest{} }
+func (m *MFARequest) String() string            { return proto.CompactTextString(m) }
+func (*MFARequest) ProtoMessage()               {}
+func (*MFARequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{24} }
+
+type UnlockRequest struct {
+	Username string `protobuf:"bytes,1,opt,name=username" json:"username,omitempty"`
+	Ip       string `protobuf:"bytes,2,opt,name=ip" json:"ip,omitempty"`
+}
+
+func (m *UnlockRequest) Reset()                    { *m = UnlockRequest{} }
+func (m *UnlockRequest) String() string            { return proto.CompactTextString(m) }
+func (*UnlockRequest) ProtoMessage()               {}
+func (*UnlockRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{25} }
+
+type AuthResponse struct {
+	Tokens        *Tokens              `protobuf:"bytes,1,opt,name=tokens" json:"tokens,omitempty"`
+	Sessions      []*Session           `protobuf:"bytes,2,rep,name=sessions" json:"sessions,omitempty"`
+	Error         string               `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+	Enrollment    *MFAEnrollment       `protobuf:"bytes,4,opt,name=enrollment" json:"enrollment,omitempty"`
+	RecoveryCodes []string             `protobuf:"bytes,5,rep,name=recoveryCodes" json:"recoveryCodes,omitempty"`
+	Violations    []*PasswordViolation `protobuf:"bytes,6,rep,name=violations" json:"violations,omitempty"`
+}
+
+func (m *AuthResponse) Reset()                    { *m = AuthResponse{} }
+func (m *AuthResponse) String() string            { return proto.CompactTextString(m) }
+func (*AuthResponse) ProtoMessage()               {}
+func (*AuthResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{26} }
+
+func (m *AuthResponse) GetTokens() *Tokens {
+	if m != nil {
+		return m.Tokens
+	}
+	return nil
+}
+
+func (m *AuthResponse) GetSessions() []*Session {
+	if m != nil {
+		return m.Sessions
+	}
+	return nil
+}
+
+func (m *AuthResponse) GetEnrollment() *MFAEnrollment {
+	if m != nil {
+		return m.Enrollment
+	}
+	return nil
+}
+
+func (m *AuthResponse) GetViolations() []*PasswordViolation {
+	if m != nil {
+		return m.Violations
+	}
+	return nil
+}
+
+type Tokens struct {
+	AccessToken  string `protobuf:"bytes,1,opt,name=accessToken" json:"accessToken,omitempty"`
+	RefreshToken string `protobuf:"bytes,2,opt,name=refreshToken" json:"refreshToken,omitempty"`
+	SessionId    string `protobuf:"bytes,3,opt,name=sessionId" json:"sessionId,omitempty"`
+	ExpiresAt    int64  `protobuf:"varint,4,opt,name=expiresAt" json:"expiresAt,omitempty"`
+	Challenge    string `protobuf:"bytes,5,opt,name=challenge" json:"challenge,omitempty"`
+}
+
+func (m *Tokens) Reset()                    { *m = Tokens{} }
+func (m *Tokens) String() string            { return proto.CompactTextString(m) }
+func (*Tokens) ProtoMessage()               {}
+func (*Tokens) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{27} }
+
+type PasswordViolation struct {
+	Rule    string `protobuf:"bytes,1,opt,name=rule" json:"rule,omitempty"`
+	Message string `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+}
+
+func (m *PasswordViolation) Reset()                    { *m = PasswordViolation{} }
+func (m *PasswordViolation) String() string            { return proto.CompactTextString(m) }
+func (*PasswordViolation) ProtoMessage()               {}
+func (*PasswordViolation) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{28} }
+
+type MFAEnrollment struct {
+	Secret string `protobuf:"bytes,1,opt,name=secret" json:"secret,omitempty"`
+	Uri    string `protobuf:"bytes,2,opt,name=uri" json:"uri,omitempty"`
+}
+
+func (m *MFAEnrollment) Reset()                    { *m = MFAEnrollment{} }
+func (m *MFAEnrollment) String() string            { return proto.CompactTextString(m) }
+func (*MFAEnrollment) ProtoMessage()               {}
+func (*MFAEnrollment) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{29} }
+
+type Session struct {
+	Id        string `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	UserId    string `protobuf:"bytes,2,opt,name=userId" json:"userId,omitempty"`
+	Created   int64  `protobuf:"varint,3,opt,name=created" json:"created,omitempty"`
+	LastUsed  int64  `protobuf:"varint,4,opt,name=lastUsed" json:"lastUsed,omitempty"`
+	ExpiresAt int64  `protobuf:"varint,5,opt,name=expiresAt" json:"expiresAt,omitempty"`
+}
+
+func (m *Session) Reset()                    { *m = Session{} }
+func (m *Session) String() string            { return proto.CompactTextString(m) }
+func (*Session) ProtoMessage()               {}
+func (*Session) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{30} }
 
 func init() {
 	proto.RegisterType((*GetRequest)(nil), "pb.GetRequest")
 	proto.RegisterType((*CreateRequest)(nil), "pb.CreateRequest")
+	proto.RegisterType((*BatchGetRequest)(nil), "pb.BatchGetRequest")
+	proto.RegisterType((*BatchCreateRequest)(nil), "pb.BatchCreateRequest")
+	proto.RegisterType((*ImportRequest)(nil), "pb.ImportRequest")
+	proto.RegisterType((*ImportStatusRequest)(nil), "pb.ImportStatusRequest")
+	proto.RegisterType((*ExportRequest)(nil), "pb.ExportRequest")
+	proto.RegisterType((*ReplicateRequest)(nil), "pb.ReplicateRequest")
+	proto.RegisterType((*MerkleNodesRequest)(nil), "pb.MerkleNodesRequest")
+	proto.RegisterType((*MerkleBucketRequest)(nil), "pb.MerkleBucketRequest")
 	proto.RegisterType((*UserResponse)(nil), "pb.UserResponse")
+	proto.RegisterType((*BatchResponse)(nil), "pb.BatchResponse")
+	proto.RegisterType((*BatchResult)(nil), "pb.BatchResult")
+	proto.RegisterType((*ImportStatus)(nil), "pb.ImportStatus")
+	proto.RegisterType((*ImportFailure)(nil), "pb.ImportFailure")
+	proto.RegisterType((*ReplicationEntry)(nil), "pb.ReplicationEntry")
+	proto.RegisterType((*MerkleNodes)(nil), "pb.MerkleNodes")
+	proto.RegisterType((*MerkleBucket)(nil), "pb.MerkleBucket")
 	proto.RegisterType((*User)(nil), "pb.User")
+	proto.RegisterType((*AuthenticateRequest)(nil), "pb.AuthenticateRequest")
+	proto.RegisterType((*RefreshRequest)(nil), "pb.RefreshRequest")
+	proto.RegisterType((*SetPasswordRequest)(nil), "pb.SetPasswordRequest")
+	proto.RegisterType((*SessionsRequest)(nil), "pb.SessionsRequest")
+	proto.RegisterType((*VerifyMFARequest)(nil), "pb.VerifyMFARequest")
+	proto.RegisterType((*MFARequest)(nil), "pb.MFARequest")
+	proto.RegisterType((*UnlockRequest)(nil), "pb.UnlockRequest")
+	proto.RegisterType((*AuthResponse)(nil), "pb.AuthResponse")
+	proto.RegisterType((*Tokens)(nil), "pb.Tokens")
+	proto.RegisterType((*PasswordViolation)(nil), "pb.PasswordViolation")
+	proto.RegisterType((*MFAEnrollment)(nil), "pb.MFAEnrollment")
+	proto.RegisterType((*Session)(nil), "pb.Session")
+	proto.RegisterEnum("pb.BatchMode", BatchMode_name, BatchMode_value)
+	proto.RegisterEnum("pb.ChangeType", ChangeType_name, ChangeType_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -110,6 +578,14 @@ const _ = grpc.SupportPackageIsVersion3
 type UserServiceClient interface {
 	GetUser(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*UserResponse, error)
 	CreateUser(ctx context.Context, in *CreateRequest, opts ...grpc.CallOption) (*UserResponse, error)
+	BatchGetUsers(ctx context.Context, in *BatchGetRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	BatchCreateUsers(ctx context.Context, in *BatchCreateRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	ImportUsers(ctx context.Context, opts ...grpc.CallOption) (UserService_ImportUsersClient, error)
+	GetImportStatus(ctx context.Context, in *ImportStatusRequest, opts ...grpc.CallOption) (*ImportStatus, error)
+	ExportUsers(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (UserService_ExportUsersClient, error)
+	Replicate(ctx context.Context, in *ReplicateRequest, opts ...grpc.CallOption) (UserService_ReplicateClient, error)
+	GetMerkleNodes(ctx context.Context, in *MerkleNodesRequest, opts ...grpc.CallOption) (*MerkleNodes, error)
+	GetMerkleBucket(ctx context.Context, in *MerkleBucketRequest, opts ...grpc.CallOption) (*MerkleBucket, error)
 }
 
 type userServiceClient struct {
@@ -138,11 +614,162 @@ func (c *userServiceClient) CreateUser(ctx context.Context, in *CreateRequest, o
 	return out, nil
 }
 
+func (c *userServiceClient) BatchGetUsers(ctx context.Context, in *BatchGetRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := grpc.Invoke(ctx, "/pb.UserService/BatchGetUsers", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userServiceClient) BatchCreateUsers(ctx context.Context, in *BatchCreateRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := grpc.Invoke(ctx, "/pb.UserService/BatchCreateUsers", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userServiceClient) ImportUsers(ctx context.Context, opts ...grpc.CallOption) (UserService_ImportUsersClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_UserService_serviceDesc.Streams[0], c.cc, "/pb.UserService/ImportUsers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userServiceImportUsersClient{stream}
+	return x, nil
+}
+
+type UserService_ImportUsersClient interface {
+	Send(*ImportRequest) error
+	CloseAndRecv() (*ImportStatus, error)
+	grpc.ClientStream
+}
+
+type userServiceImportUsersClient struct {
+	grpc.ClientStream
+}
+
+func (x *userServiceImportUsersClient) Send(m *ImportRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *userServiceImportUsersClient) CloseAndRecv() (*ImportStatus, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportStatus)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *userServiceClient) GetImportStatus(ctx context.Context, in *ImportStatusRequest, opts ...grpc.CallOption) (*ImportStatus, error) {
+	out := new(ImportStatus)
+	err := grpc.Invoke(ctx, "/pb.UserService/GetImportStatus", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userServiceClient) ExportUsers(ctx context.Context, in *ExportRequest, opts ...grpc.CallOption) (UserService_ExportUsersClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_UserService_serviceDesc.Streams[1], c.cc, "/pb.UserService/ExportUsers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userServiceExportUsersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type UserService_ExportUsersClient interface {
+	Recv() (*User, error)
+	grpc.ClientStream
+}
+
+type userServiceExportUsersClient struct {
+	grpc.ClientStream
+}
+
+func (x *userServiceExportUsersClient) Recv() (*User, error) {
+	m := new(User)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *userServiceClient) Replicate(ctx context.Context, in *ReplicateRequest, opts ...grpc.CallOption) (UserService_ReplicateClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_UserService_serviceDesc.Streams[2], c.cc, "/pb.UserService/Replicate", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userServiceReplicateClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type UserService_ReplicateClient interface {
+	Recv() (*ReplicationEntry, error)
+	grpc.ClientStream
+}
+
+type userServiceReplicateClient struct {
+	grpc.ClientStream
+}
+
+func (x *userServiceReplicateClient) Recv() (*ReplicationEntry, error) {
+	m := new(ReplicationEntry)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *userServiceClient) GetMerkleNodes(ctx context.Context, in *MerkleNodesRequest, opts ...grpc.CallOption) (*MerkleNodes, error) {
+	out := new(MerkleNodes)
+	err := grpc.Invoke(ctx, "/pb.UserService/GetMerkleNodes", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userServiceClient) GetMerkleBucket(ctx context.Context, in *MerkleBucketRequest, opts ...grpc.CallOption) (*MerkleBucket, error) {
+	out := new(MerkleBucket)
+	err := grpc.Invoke(ctx, "/pb.UserService/GetMerkleBucket", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for UserService service
 
 type UserServiceServer interface {
 	GetUser(context.Context, *GetRequest) (*UserResponse, error)
 	CreateUser(context.Context, *CreateRequest) (*UserResponse, error)
+	BatchGetUsers(context.Context, *BatchGetRequest) (*BatchResponse, error)
+	BatchCreateUsers(context.Context, *BatchCreateRequest) (*BatchResponse, error)
+	ImportUsers(UserService_ImportUsersServer) error
+	GetImportStatus(context.Context, *ImportStatusRequest) (*ImportStatus, error)
+	ExportUsers(*ExportRequest, UserService_ExportUsersServer) error
+	Replicate(*ReplicateRequest, UserService_ReplicateServer) error
+	GetMerkleNodes(context.Context, *MerkleNodesRequest) (*MerkleNodes, error)
+	GetMerkleBucket(context.Context, *MerkleBucketRequest) (*MerkleBucket, error)
 }
 
 func RegisterUserServiceServer(s *grpc.Server, srv UserServiceServer) {
@@ -185,17 +812,605 @@ func _UserService_CreateUser_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
-var _UserService_serviceDesc = grpc.ServiceDesc{
-	ServiceName: "pb.UserService",
-	HandlerType: (*UserServiceServer)(nil),
-	Methods: []grpc.MethodDesc{
-		{
-			MethodName: "GetUser",
-			Handler:    _UserService_GetUser_Handler,
-		},
-		{
-			MethodName: "CreateUser",
-			Handler:    _UserService_CreateUser_Handler,
+func _UserService_BatchGetUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchGetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).BatchGetUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UserService/BatchGetUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).BatchGetUsers(ctx, req.(*BatchGetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserService_BatchCreateUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchCreateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).BatchCreateUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UserService/BatchCreateUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).BatchCreateUsers(ctx, req.(*BatchCreateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserService_ImportUsers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(UserServiceServer).ImportUsers(&userServiceImportUsersServer{stream})
+}
+
+type UserService_ImportUsersServer interface {
+	SendAndClose(*ImportStatus) error
+	Recv() (*ImportRequest, error)
+	grpc.ServerStream
+}
+
+type userServiceImportUsersServer struct {
+	grpc.ServerStream
+}
+
+func (x *userServiceImportUsersServer) SendAndClose(m *ImportStatus) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *userServiceImportUsersServer) Recv() (*ImportRequest, error) {
+	m := new(ImportRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _UserService_GetImportStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImportStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).GetImportStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UserService/GetImportStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).GetImportStatus(ctx, req.(*ImportStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserService_ExportUsers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UserServiceServer).ExportUsers(m, &userServiceExportUsersServer{stream})
+}
+
+type UserService_ExportUsersServer interface {
+	Send(*User) error
+	grpc.ServerStream
+}
+
+type userServiceExportUsersServer struct {
+	grpc.ServerStream
+}
+
+func (x *userServiceExportUsersServer) Send(m *User) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _UserService_Replicate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReplicateRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UserServiceServer).Replicate(m, &userServiceReplicateServer{stream})
+}
+
+type UserService_ReplicateServer interface {
+	Send(*ReplicationEntry) error
+	grpc.ServerStream
+}
+
+type userServiceReplicateServer struct {
+	grpc.ServerStream
+}
+
+func (x *userServiceReplicateServer) Send(m *ReplicationEntry) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _UserService_GetMerkleNodes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MerkleNodesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).GetMerkleNodes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UserService/GetMerkleNodes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).GetMerkleNodes(ctx, req.(*MerkleNodesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserService_GetMerkleBucket_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MerkleBucketRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserServiceServer).GetMerkleBucket(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UserService/GetMerkleBucket",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserServiceServer).GetMerkleBucket(ctx, req.(*MerkleBucketRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _UserService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "pb.UserService",
+	HandlerType: (*UserServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetUser",
+			Handler:    _UserService_GetUser_Handler,
+		},
+		{
+			MethodName: "CreateUser",
+			Handler:    _UserService_CreateUser_Handler,
+		},
+		{
+			MethodName: "BatchGetUsers",
+			Handler:    _UserService_BatchGetUsers_Handler,
+		},
+		{
+			MethodName: "BatchCreateUsers",
+			Handler:    _UserService_BatchCreateUsers_Handler,
+		},
+		{
+			MethodName: "GetImportStatus",
+			Handler:    _UserService_GetImportStatus_Handler,
+		},
+		{
+			MethodName: "GetMerkleNodes",
+			Handler:    _UserService_GetMerkleNodes_Handler,
+		},
+		{
+			MethodName: "GetMerkleBucket",
+			Handler:    _UserService_GetMerkleBucket_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ImportUsers",
+			Handler:       _UserService_ImportUsers_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ExportUsers",
+			Handler:       _UserService_ExportUsers_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Replicate",
+			Handler:       _UserService_Replicate_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: fileDescriptor0,
+}
+
+// Client API for AuthService service
+
+type AuthServiceClient interface {
+	Authenticate(ctx context.Context, in *AuthenticateRequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	Refresh(ctx context.Context, in *RefreshRequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	SetPassword(ctx context.Context, in *SetPasswordRequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	ListSessions(ctx context.Context, in *SessionsRequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	RevokeSession(ctx context.Context, in *SessionsRequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	RevokeSessions(ctx context.Context, in *SessionsRequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	VerifyMFA(ctx context.Context, in *VerifyMFARequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	EnrollMFA(ctx context.Context, in *MFARequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	ConfirmMFA(ctx context.Context, in *MFARequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	DisableMFA(ctx context.Context, in *MFARequest, opts ...grpc.CallOption) (*AuthResponse, error)
+	Unlock(ctx context.Context, in *UnlockRequest, opts ...grpc.CallOption) (*AuthResponse, error)
+}
+
+type authServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAuthServiceClient(cc *grpc.ClientConn) AuthServiceClient {
+	return &authServiceClient{cc}
+}
+
+func (c *authServiceClient) Authenticate(ctx context.Context, in *AuthenticateRequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := grpc.Invoke(ctx, "/pb.AuthService/Authenticate", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) Refresh(ctx context.Context, in *RefreshRequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := grpc.Invoke(ctx, "/pb.AuthService/Refresh", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) SetPassword(ctx context.Context, in *SetPasswordRequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := grpc.Invoke(ctx, "/pb.AuthService/SetPassword", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) ListSessions(ctx context.Context, in *SessionsRequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := grpc.Invoke(ctx, "/pb.AuthService/ListSessions", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) RevokeSession(ctx context.Context, in *SessionsRequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := grpc.Invoke(ctx, "/pb.AuthService/RevokeSession", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) RevokeSessions(ctx context.Context, in *SessionsRequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := grpc.Invoke(ctx, "/pb.AuthService/RevokeSessions", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) VerifyMFA(ctx context.Context, in *VerifyMFARequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := grpc.Invoke(ctx, "/pb.AuthService/VerifyMFA", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) EnrollMFA(ctx context.Context, in *MFARequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := grpc.Invoke(ctx, "/pb.AuthService/EnrollMFA", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) ConfirmMFA(ctx context.Context, in *MFARequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := grpc.Invoke(ctx, "/pb.AuthService/ConfirmMFA", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) DisableMFA(ctx context.Context, in *MFARequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := grpc.Invoke(ctx, "/pb.AuthService/DisableMFA", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *authServiceClient) Unlock(ctx context.Context, in *UnlockRequest, opts ...grpc.CallOption) (*AuthResponse, error) {
+	out := new(AuthResponse)
+	err := grpc.Invoke(ctx, "/pb.AuthService/Unlock", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for AuthService service
+
+type AuthServiceServer interface {
+	Authenticate(context.Context, *AuthenticateRequest) (*AuthResponse, error)
+	Refresh(context.Context, *RefreshRequest) (*AuthResponse, error)
+	SetPassword(context.Context, *SetPasswordRequest) (*AuthResponse, error)
+	ListSessions(context.Context, *SessionsRequest) (*AuthResponse, error)
+	RevokeSession(context.Context, *SessionsRequest) (*AuthResponse, error)
+	RevokeSessions(context.Context, *SessionsRequest) (*AuthResponse, error)
+	VerifyMFA(context.Context, *VerifyMFARequest) (*AuthResponse, error)
+	EnrollMFA(context.Context, *MFARequest) (*AuthResponse, error)
+	ConfirmMFA(context.Context, *MFARequest) (*AuthResponse, error)
+	DisableMFA(context.Context, *MFARequest) (*AuthResponse, error)
+	Unlock(context.Context, *UnlockRequest) (*AuthResponse, error)
+}
+
+func RegisterAuthServiceServer(s *grpc.Server, srv AuthServiceServer) {
+	s.RegisterService(&_AuthService_serviceDesc, srv)
+}
+
+func _AuthService_Authenticate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuthenticateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).Authenticate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AuthService/Authenticate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).Authenticate(ctx, req.(*AuthenticateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_Refresh_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefreshRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).Refresh(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AuthService/Refresh",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).Refresh(ctx, req.(*RefreshRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_SetPassword_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetPasswordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).SetPassword(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AuthService/SetPassword",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).SetPassword(ctx, req.(*SetPasswordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_ListSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).ListSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AuthService/ListSessions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).ListSessions(ctx, req.(*SessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_RevokeSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).RevokeSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AuthService/RevokeSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).RevokeSession(ctx, req.(*SessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_RevokeSessions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SessionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).RevokeSessions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AuthService/RevokeSessions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).RevokeSessions(ctx, req.(*SessionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_VerifyMFA_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyMFARequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).VerifyMFA(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AuthService/VerifyMFA",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).VerifyMFA(ctx, req.(*VerifyMFARequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_EnrollMFA_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MFARequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).EnrollMFA(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AuthService/EnrollMFA",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).EnrollMFA(ctx, req.(*MFARequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_ConfirmMFA_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MFARequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).ConfirmMFA(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AuthService/ConfirmMFA",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).ConfirmMFA(ctx, req.(*MFARequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_DisableMFA_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MFARequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).DisableMFA(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AuthService/DisableMFA",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).DisableMFA(ctx, req.(*MFARequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AuthService_Unlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AuthServiceServer).Unlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.AuthService/Unlock",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AuthServiceServer).Unlock(ctx, req.(*UnlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _AuthService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "pb.AuthService",
+	HandlerType: (*AuthServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Authenticate",
+			Handler:    _AuthService_Authenticate_Handler,
+		},
+		{
+			MethodName: "Refresh",
+			Handler:    _AuthService_Refresh_Handler,
+		},
+		{
+			MethodName: "SetPassword",
+			Handler:    _AuthService_SetPassword_Handler,
+		},
+		{
+			MethodName: "ListSessions",
+			Handler:    _AuthService_ListSessions_Handler,
+		},
+		{
+			MethodName: "RevokeSession",
+			Handler:    _AuthService_RevokeSession_Handler,
+		},
+		{
+			MethodName: "RevokeSessions",
+			Handler:    _AuthService_RevokeSessions_Handler,
+		},
+		{
+			MethodName: "VerifyMFA",
+			Handler:    _AuthService_VerifyMFA_Handler,
+		},
+		{
+			MethodName: "EnrollMFA",
+			Handler:    _AuthService_EnrollMFA_Handler,
+		},
+		{
+			MethodName: "ConfirmMFA",
+			Handler:    _AuthService_ConfirmMFA_Handler,
+		},
+		{
+			MethodName: "DisableMFA",
+			Handler:    _AuthService_DisableMFA_Handler,
+		},
+		{
+			MethodName: "Unlock",
+			Handler:    _AuthService_Unlock_Handler,
 		},
 	},
 	Streams:  []grpc.StreamDesc{},
@@ -205,20 +1420,96 @@ var _UserService_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("user.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 237 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x09, 0x6e, 0x88, 0x02, 0xff, 0xe2, 0xe2, 0x2a, 0x2d, 0x4e, 0x2d,
-	0xd2, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x2a, 0x48, 0x52, 0x92, 0xe1, 0xe2, 0x72, 0x4f,
-	0x2d, 0x09, 0x4a, 0x2d, 0x2c, 0x4d, 0x2d, 0x2e, 0x11, 0xe2, 0xe3, 0x62, 0xca, 0x4c, 0x91, 0x60,
-	0x54, 0x60, 0xd4, 0xe0, 0x0c, 0x02, 0xb2, 0x94, 0x74, 0xb9, 0x78, 0x9d, 0x8b, 0x52, 0x13, 0x4b,
-	0x52, 0x61, 0x0a, 0x64, 0xb8, 0x58, 0x40, 0x06, 0x80, 0x95, 0x70, 0x1b, 0x71, 0xe8, 0x15, 0x24,
-	0xe9, 0x85, 0x02, 0xf9, 0x41, 0x60, 0x51, 0x25, 0x1d, 0x2e, 0x1e, 0x30, 0x2f, 0xb5, 0xb8, 0x20,
-	0x3f, 0xaf, 0x38, 0x95, 0x80, 0xea, 0x26, 0x46, 0x2e, 0x16, 0x10, 0x17, 0xdd, 0x56, 0xa0, 0x36,
-	0xce, 0xb4, 0xcc, 0xa2, 0xe2, 0x12, 0xbf, 0xc4, 0xdc, 0x54, 0x09, 0x26, 0xb0, 0x30, 0x42, 0x40,
-	0x48, 0x8a, 0x8b, 0x23, 0x27, 0x11, 0x2a, 0xc9, 0x0c, 0x96, 0x84, 0xf3, 0x85, 0x44, 0xb8, 0x58,
-	0x53, 0x73, 0x13, 0x33, 0x73, 0x24, 0x58, 0xc0, 0x12, 0x10, 0x0e, 0x48, 0x07, 0xc8, 0xc2, 0x3c,
-	0x90, 0x0e, 0x56, 0x88, 0x0e, 0x18, 0xdf, 0xa8, 0x90, 0x8b, 0x1b, 0xe4, 0x86, 0xe0, 0xd4, 0xa2,
-	0xb2, 0xcc, 0xe4, 0x54, 0x21, 0x5d, 0x2e, 0x76, 0x60, 0x70, 0x40, 0x5c, 0x05, 0x72, 0x2e, 0x22,
-	0x6c, 0xa4, 0x04, 0xe0, 0xce, 0x87, 0x7a, 0x4f, 0x89, 0x41, 0xc8, 0x98, 0x8b, 0x0b, 0x12, 0x3e,
-	0x60, 0x1d, 0x82, 0x20, 0x15, 0x28, 0xe1, 0x85, 0x4d, 0x53, 0x12, 0x1b, 0x38, 0xf4, 0x8d, 0x01,
-	0x01, 0x00, 0x00, 0xff, 0xff, 0xea, 0xd6, 0x95, 0x9e, 0x8b, 0x01, 0x00, 0x00,
+	// 1449 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x57, 0xdb, 0x6e, 0xdb, 0x46,
+	0x13, 0x36, 0x75, 0xb4, 0x46, 0x07, 0x2b, 0xeb, 0xfc, 0x89, 0x20, 0x18, 0x3f, 0xdc, 0x45, 0x0f,
+	0x6e, 0x50, 0x3b, 0x89, 0xd3, 0xb4, 0x31, 0x02, 0xb4, 0x50, 0x6c, 0xd9, 0x31, 0x10, 0x3b, 0xc6,
+	0xda, 0x49, 0x7b, 0x17, 0xd0, 0xe4, 0x28, 0x22, 0x4c, 0x91, 0x0c, 0x97, 0x72, 0xed, 0xcb, 0x5e,
+	0xf4, 0x3d, 0xda, 0xbb, 0xbe, 0x4d, 0x1f, 0xa2, 0x2f, 0x52, 0xec, 0x81, 0x2b, 0x92, 0x52, 0x1c,
+	0xe7, 0x8e, 0x73, 0xde, 0x99, 0xfd, 0x76, 0x66, 0x08, 0x30, 0xe5, 0x18, 0x6f, 0x45, 0x71, 0x98,
+	0x84, 0xa4, 0x14, 0x9d, 0xd3, 0x35, 0x80, 0x03, 0x4c, 0x18, 0x7e, 0x98, 0x22, 0x4f, 0x48, 0x07,
+	0x4a, 0x9e, 0xdb, 0xb3, 0xd6, 0xad, 0x8d, 0x06, 0x2b, 0x79, 0x2e, 0xdd, 0x84, 0xf6, 0x6e, 0x8c,
+	0x76, 0x82, 0xa9, 0xc2, 0x1a, 0x54, 0x84, 0x03, 0xa9, 0xd2, 0xdc, 0x5e, 0xde, 0x8a, 0xce, 0xb7,
+	0xde, 0x70, 0x8c, 0x99, 0xe4, 0xd2, 0x7d, 0x58, 0x79, 0x61, 0x27, 0xce, 0x38, 0xe3, 0xb1, 0x0b,
+	0x65, 0xcf, 0xe5, 0x3d, 0x6b, 0xbd, 0xbc, 0xd1, 0x60, 0xe2, 0x93, 0x7c, 0x01, 0x95, 0x49, 0xe8,
+	0x62, 0xaf, 0xb4, 0x6e, 0x6d, 0x74, 0xb6, 0xdb, 0xc2, 0x85, 0x34, 0x3a, 0x0a, 0x5d, 0x64, 0x52,
+	0x44, 0x7f, 0x01, 0x22, 0x59, 0xf9, 0xd8, 0xff, 0x87, 0xaa, 0x88, 0xa2, 0x9c, 0x65, 0x83, 0x2b,
+	0xf6, 0x6d, 0x1c, 0xdb, 0xd0, 0x3e, 0x9c, 0x44, 0x61, 0x6c, 0x8e, 0xd7, 0x87, 0x65, 0x4f, 0x32,
+	0x0e, 0xd3, 0xb4, 0x0d, 0x4d, 0xee, 0x41, 0x2d, 0x1c, 0x8d, 0x38, 0x26, 0xd2, 0x63, 0x99, 0x69,
+	0xca, 0xd4, 0xa0, 0xbc, 0xb0, 0x06, 0x8f, 0x61, 0x55, 0x85, 0x38, 0x4d, 0xec, 0x64, 0xca, 0x6f,
+	0x11, 0x88, 0x3a, 0xd0, 0x1e, 0x5e, 0x15, 0x4f, 0xe5, 0x9e, 0xc4, 0x38, 0xf2, 0xae, 0x8c, 0xb2,
+	0xa6, 0xc9, 0x3a, 0x34, 0x71, 0x62, 0x7b, 0xfe, 0x5e, 0x38, 0xb1, 0xbd, 0x40, 0x1e, 0xad, 0xc1,
+	0xb2, 0x2c, 0x72, 0x17, 0xaa, 0xf6, 0x28, 0xd1, 0x07, 0x6c, 0x30, 0x45, 0xd0, 0x2d, 0xe8, 0x32,
+	0x8c, 0x7c, 0xcf, 0xc9, 0x54, 0xb4, 0x0f, 0xcb, 0x52, 0x78, 0x8a, 0x1f, 0x64, 0x9c, 0x0a, 0x33,
+	0x34, 0xdd, 0x03, 0x72, 0x84, 0xf1, 0x85, 0x8f, 0xc7, 0xa1, 0x8b, 0x26, 0x8d, 0xbb, 0x50, 0xf5,
+	0xf1, 0x12, 0x7d, 0xa9, 0xde, 0x66, 0x8a, 0x20, 0x3d, 0xa8, 0x7b, 0x81, 0xeb, 0x39, 0xc8, 0x7b,
+	0xa5, 0xf5, 0xf2, 0x46, 0x9b, 0xa5, 0x24, 0xdd, 0x84, 0x55, 0xe5, 0xe5, 0xc5, 0xd4, 0xb9, 0x98,
+	0xa1, 0xe2, 0x1e, 0xd4, 0xce, 0x25, 0x43, 0xfb, 0xd1, 0x14, 0xfd, 0x0e, 0x5a, 0xb2, 0x94, 0xc8,
+	0xa3, 0x30, 0xe0, 0xf8, 0x09, 0xb8, 0x9d, 0x40, 0x5b, 0x5e, 0xb0, 0x51, 0xff, 0x16, 0xea, 0x31,
+	0xf2, 0xa9, 0x9f, 0xa4, 0x18, 0x59, 0x31, 0x20, 0x60, 0x92, 0xcf, 0x52, 0xb9, 0x48, 0x04, 0xe3,
+	0x38, 0x8c, 0x75, 0x01, 0x15, 0x41, 0x07, 0xd0, 0xcc, 0x68, 0xdf, 0x1c, 0xfe, 0x23, 0x2e, 0xfe,
+	0xb2, 0xa0, 0x95, 0x05, 0xc0, 0x8d, 0x10, 0x13, 0x57, 0xe5, 0x5c, 0xa0, 0xab, 0x11, 0xa6, 0x08,
+	0x51, 0x4e, 0x3b, 0x8a, 0x7c, 0x0f, 0x5d, 0x79, 0x85, 0x65, 0x96, 0x92, 0xa2, 0x6e, 0x23, 0xdb,
+	0xf3, 0xd1, 0xed, 0x55, 0x14, 0x24, 0x15, 0x45, 0x36, 0x61, 0x59, 0x7c, 0x4d, 0x63, 0xe4, 0xbd,
+	0xaa, 0xcc, 0xfc, 0x8e, 0x38, 0xac, 0x3a, 0xc7, 0xbe, 0x92, 0x30, 0xa3, 0x42, 0x8f, 0xa0, 0x9d,
+	0x13, 0x65, 0xa0, 0x6e, 0xe5, 0xa0, 0xae, 0xfa, 0x41, 0x29, 0xed, 0x07, 0xb3, 0x94, 0xcb, 0xd9,
+	0x94, 0xff, 0xb6, 0x66, 0xd8, 0xf2, 0xc2, 0x60, 0x18, 0x24, 0xf1, 0xb5, 0x78, 0xf8, 0xdc, 0xc0,
+	0x4a, 0x7c, 0x12, 0x0a, 0x95, 0xe4, 0x3a, 0x4a, 0xdf, 0x67, 0x47, 0x1c, 0x70, 0x77, 0x6c, 0x07,
+	0xef, 0xf1, 0xec, 0x3a, 0x42, 0x26, 0x65, 0x37, 0xbf, 0x2d, 0x42, 0xa0, 0x92, 0x78, 0x13, 0xd4,
+	0xc9, 0xcb, 0x6f, 0xc1, 0x1b, 0xdb, 0xb1, 0xdb, 0xab, 0xae, 0x5b, 0x1b, 0xcb, 0x4c, 0x7e, 0x8b,
+	0x02, 0x8e, 0xd1, 0x76, 0x05, 0xac, 0x6b, 0x32, 0x7e, 0x4a, 0xd2, 0xaf, 0xa0, 0x99, 0x41, 0xb5,
+	0xc8, 0x7b, 0x6c, 0xf3, 0x31, 0x2a, 0xbc, 0xb4, 0x98, 0xa6, 0xe8, 0x09, 0xb4, 0xb2, 0xb0, 0x15,
+	0xc7, 0xf2, 0xbd, 0x4b, 0x9c, 0xeb, 0x3c, 0x92, 0x4b, 0x28, 0xd4, 0x5d, 0xf4, 0x31, 0x91, 0xf7,
+	0x98, 0x57, 0x48, 0x05, 0xf4, 0x4f, 0x0b, 0x2a, 0x82, 0x53, 0x6c, 0xb1, 0x64, 0x0d, 0x1a, 0x23,
+	0x2f, 0xe6, 0xc9, 0xb1, 0x3d, 0x41, 0x5d, 0xe9, 0x19, 0x43, 0x80, 0xc7, 0xb7, 0xb5, 0x50, 0xd5,
+	0xdc, 0xd0, 0xf2, 0x32, 0xc4, 0xb3, 0xef, 0x55, 0xf4, 0x65, 0x08, 0x42, 0x58, 0x88, 0x5a, 0x05,
+	0xc2, 0xa2, 0xaa, 0x2c, 0x52, 0x5a, 0xc4, 0xc2, 0xab, 0xc8, 0x8b, 0x91, 0x0f, 0x12, 0x59, 0x99,
+	0x32, 0x9b, 0x31, 0xe8, 0x11, 0xac, 0x0e, 0xa6, 0xc9, 0x18, 0x83, 0xa4, 0xd8, 0x24, 0x8c, 0x43,
+	0xab, 0xe0, 0xb0, 0x0f, 0xcb, 0x91, 0xcd, 0xf9, 0x6f, 0x61, 0x9c, 0xa2, 0xc4, 0xd0, 0xf4, 0x7b,
+	0xe8, 0x30, 0x1c, 0xc5, 0xc8, 0xc7, 0xa9, 0x27, 0x0a, 0xad, 0x58, 0x71, 0xce, 0xc2, 0x0b, 0x0c,
+	0xb4, 0xb7, 0x1c, 0x8f, 0xbe, 0x04, 0x72, 0x8a, 0xc9, 0x89, 0x76, 0x92, 0xe9, 0x17, 0x22, 0xa6,
+	0x79, 0x41, 0x9a, 0xba, 0x31, 0xfe, 0x01, 0xac, 0x9c, 0x22, 0xe7, 0x5e, 0x18, 0xf0, 0x4f, 0xb9,
+	0x59, 0x83, 0x06, 0x57, 0xaa, 0x87, 0xa9, 0x9f, 0x19, 0x83, 0xee, 0x41, 0xf7, 0x2d, 0xc6, 0xde,
+	0xe8, 0xfa, 0x68, 0x7f, 0x30, 0x9b, 0x83, 0x0d, 0x67, 0x6c, 0xfb, 0x3e, 0x06, 0xef, 0xd3, 0xaa,
+	0xcc, 0x18, 0x02, 0x93, 0x4e, 0x3a, 0x89, 0x1a, 0x4c, 0x7e, 0xd3, 0x67, 0x00, 0x19, 0xfb, 0x8f,
+	0x9d, 0x64, 0x91, 0xe5, 0x73, 0x68, 0xbf, 0x09, 0xfc, 0xd0, 0xb9, 0xb8, 0xcd, 0x8d, 0x08, 0x78,
+	0x45, 0xe6, 0xc5, 0x46, 0xf4, 0xf7, 0x12, 0xb4, 0xc4, 0xad, 0x9a, 0x1e, 0x49, 0xa1, 0x96, 0x88,
+	0x4a, 0x73, 0xdd, 0xd5, 0x40, 0x60, 0x55, 0xd6, 0x9e, 0x33, 0x2d, 0x21, 0xdf, 0xc0, 0xb2, 0x4e,
+	0x9f, 0x6b, 0x44, 0x37, 0x85, 0x96, 0x2e, 0x27, 0x33, 0xc2, 0xc5, 0xfd, 0x80, 0x3c, 0x06, 0xc0,
+	0x20, 0x0e, 0x7d, 0x7f, 0x82, 0x41, 0x22, 0xd1, 0xa9, 0xfb, 0xd1, 0xd1, 0xfe, 0x60, 0x68, 0x04,
+	0x2c, 0xa3, 0x44, 0xbe, 0x84, 0x76, 0x8c, 0x4e, 0x78, 0x89, 0xf1, 0xf5, 0x6e, 0xe8, 0xea, 0x2e,
+	0xd6, 0x60, 0x79, 0x26, 0x79, 0x0a, 0x70, 0xe9, 0x85, 0xbe, 0xec, 0x32, 0xbc, 0x57, 0x93, 0x27,
+	0xfb, 0x9f, 0x70, 0x9c, 0xe2, 0xe5, 0x6d, 0x2a, 0x65, 0x19, 0x45, 0xd1, 0x9f, 0x6a, 0x2a, 0x43,
+	0x31, 0x3d, 0x6d, 0xc7, 0x41, 0xce, 0xb3, 0x08, 0xcc, 0xb2, 0xe6, 0x40, 0x5a, 0x9a, 0x07, 0x69,
+	0x1e, 0x2f, 0xe5, 0x02, 0x5e, 0xf2, 0xaf, 0xac, 0x52, 0x78, 0x65, 0x79, 0xe4, 0x54, 0x0b, 0xc8,
+	0xa1, 0x03, 0xb8, 0x33, 0x97, 0x8b, 0x00, 0x45, 0x3c, 0xf5, 0xd3, 0xbb, 0x96, 0xdf, 0xa2, 0xc5,
+	0x4d, 0x90, 0x73, 0xfb, 0x7d, 0x8a, 0x95, 0x94, 0xa4, 0x3b, 0xd0, 0xce, 0xd5, 0x59, 0x60, 0x8d,
+	0xa3, 0x13, 0xeb, 0xe6, 0xde, 0x60, 0x9a, 0x12, 0x1d, 0x7a, 0x1a, 0x7b, 0xda, 0x5c, 0x7c, 0xd2,
+	0x3f, 0x2c, 0xa8, 0xeb, 0x4b, 0x9e, 0xeb, 0x53, 0x33, 0xc4, 0x96, 0x72, 0x88, 0xed, 0x41, 0xdd,
+	0x91, 0x6b, 0x9a, 0x19, 0x56, 0x9a, 0x4c, 0x7b, 0xd7, 0x1b, 0x6e, 0xc6, 0x95, 0xa1, 0xf3, 0x35,
+	0xaa, 0x16, 0x6a, 0xf4, 0xe0, 0x11, 0x34, 0xcc, 0xe6, 0x46, 0x56, 0xa0, 0xf9, 0x62, 0x78, 0x7a,
+	0xf6, 0x6e, 0xb8, 0xbf, 0xff, 0x9a, 0x9d, 0x75, 0x97, 0x08, 0x81, 0xce, 0xe0, 0xd5, 0xab, 0x77,
+	0xaf, 0xd9, 0xbb, 0xe3, 0xd7, 0x67, 0x2f, 0x0f, 0x8f, 0x0f, 0xba, 0xd6, 0x83, 0xaf, 0x01, 0x66,
+	0xb3, 0x84, 0x34, 0xa1, 0xbe, 0xcb, 0x86, 0x83, 0xb3, 0xe1, 0x5e, 0x77, 0x49, 0x10, 0xc3, 0x5f,
+	0x4f, 0x0e, 0xd9, 0x70, 0xaf, 0x6b, 0x6d, 0xff, 0x53, 0x81, 0xa6, 0x68, 0xc3, 0xa7, 0x18, 0x5f,
+	0x7a, 0x0e, 0x92, 0x4d, 0xa8, 0x1f, 0x60, 0xa2, 0x1a, 0xb3, 0x00, 0xd2, 0x6c, 0x73, 0xed, 0x77,
+	0x4d, 0x13, 0xd7, 0x4f, 0x87, 0x2e, 0x91, 0x27, 0x00, 0x6a, 0x27, 0x95, 0x16, 0x12, 0xd3, 0xb9,
+	0x1d, 0x75, 0xa1, 0xd1, 0x8e, 0x5e, 0x53, 0x74, 0x20, 0x4e, 0x56, 0xcd, 0x56, 0x92, 0x09, 0x77,
+	0x27, 0xbb, 0xaa, 0xa4, 0xa6, 0x3f, 0x43, 0x37, 0xb3, 0x08, 0x2b, 0xeb, 0x7b, 0x46, 0x31, 0x1f,
+	0x7a, 0xa1, 0x83, 0x1f, 0xa0, 0xa9, 0x26, 0xbd, 0xb2, 0xcd, 0x6c, 0x05, 0xb9, 0x13, 0x67, 0x17,
+	0x16, 0xba, 0xb4, 0x61, 0x91, 0x9f, 0x60, 0xe5, 0x00, 0x93, 0x2c, 0x9b, 0xdc, 0x2f, 0x2a, 0xde,
+	0xe0, 0x81, 0x6c, 0x41, 0x73, 0x78, 0x55, 0x88, 0x9b, 0xdb, 0x71, 0xfb, 0x66, 0x46, 0xd2, 0xa5,
+	0x47, 0x16, 0x79, 0x0e, 0x0d, 0xb3, 0x9d, 0x92, 0xbb, 0x42, 0x54, 0x5c, 0x56, 0xfb, 0x39, 0x6e,
+	0xba, 0x66, 0x68, 0xe3, 0xce, 0x01, 0x26, 0xb9, 0xb9, 0x2e, 0xbb, 0xcd, 0xdc, 0xfa, 0xda, 0x5f,
+	0x29, 0xf0, 0xe9, 0x92, 0xce, 0x34, 0x37, 0xed, 0xef, 0xcf, 0xb4, 0x72, 0x6b, 0x6b, 0xbf, 0x5b,
+	0x14, 0xd0, 0xa5, 0xed, 0x7f, 0x2b, 0xd0, 0x14, 0x0d, 0x36, 0x45, 0xd4, 0x73, 0x68, 0x65, 0xa7,
+	0xa8, 0x72, 0xb6, 0x60, 0xae, 0xf6, 0xbb, 0xa9, 0x20, 0x73, 0x5d, 0x8f, 0xa1, 0xae, 0x67, 0x26,
+	0x21, 0x2a, 0xdd, 0xec, 0x00, 0x5d, 0x68, 0xb2, 0x03, 0xcd, 0xcc, 0xc0, 0x54, 0x99, 0xcf, 0x4f,
+	0xd0, 0x85, 0xa6, 0x3f, 0x42, 0xeb, 0x95, 0xc7, 0x93, 0x74, 0x4a, 0x2a, 0x5c, 0x16, 0x66, 0xe6,
+	0x42, 0xc3, 0x67, 0xd0, 0x66, 0x78, 0x19, 0x5e, 0xa0, 0x56, 0xbe, 0xbd, 0xe5, 0x0e, 0x74, 0x72,
+	0x96, 0x9f, 0x11, 0xf4, 0x29, 0x34, 0xcc, 0x18, 0x56, 0x10, 0x29, 0x4e, 0xe5, 0x85, 0x66, 0x0f,
+	0xa1, 0xa1, 0x7a, 0xa1, 0x30, 0xeb, 0xe8, 0x29, 0x74, 0x93, 0xc1, 0x23, 0x80, 0xdd, 0x30, 0x18,
+	0x79, 0xf1, 0xe4, 0x33, 0x2c, 0xf6, 0x3c, 0x6e, 0x9f, 0xfb, 0x78, 0x5b, 0x8b, 0x87, 0x50, 0x53,
+	0x23, 0x5d, 0xbd, 0x8c, 0xdc, 0x78, 0x5f, 0x64, 0x70, 0x5e, 0x93, 0x7f, 0xec, 0x4f, 0xfe, 0x1b,
+	0x00, 0x72, 0x48, 0x3c, 0xdd, 0xbf, 0x0f, 0x00, 0x00,
 }
@@ -0,0 +1,347 @@
+package learn
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+)
+
+var (
+	// ErrNotLeader is returned for writes made on a follower that doesn't
+	// forward them to its leader.
+	ErrNotLeader = errors.New("Writes must be made on the leader")
+
+	// ErrReplicationGap is returned when a replication log can't continue
+	// from the change a follower asked for, or when a follower is handed a
+	// change that doesn't follow the last one it applied. Either way the
+	// follower has fallen too far behind, or diverged from its leader, and
+	// must bootstrap from a snapshot of the leader to catch up again.
+	ErrReplicationGap = errors.New("Replication log does not continue from the requested change")
+)
+
+const (
+	// maxReplicationBatch bounds the changes handed out by a single call to
+	// ReplicationLog.Since.
+	maxReplicationBatch = 1000
+
+	// replicationHeartbeat is how often an idle replication stream tells its
+	// follower the latest seq of the leader, so that lag stays current.
+	replicationHeartbeat = time.Second
+
+	// ReplicationSeqMetadata is the gRPC header in which ExportUsers reports
+	// the latest seq of the replication log, which its snapshot is no older
+	// than.
+	ReplicationSeqMetadata = "x-replication-seq"
+)
+
+// Replica is implemented by services that can apply the changes of another
+// instance, in order, to follow it.
+type Replica interface {
+	// LastSeq returns the seq of the last change made to the store.
+	LastSeq() uint64
+
+	// ApplyChange makes a change that was published by another store. Changes
+	// that were already applied are ignored, and a change that doesn't
+	// directly follow the last one fails with ErrReplicationGap. The change
+	// is published again, with its seq unchanged.
+	ApplyChange(c Change) error
+
+	// Bootstrap replaces the users of the store with users, a snapshot of
+	// another store taken no earlier than its change numbered seq, and
+	// continues from seq. The users that differ are published as repairs.
+	Bootstrap(users []*User, seq uint64)
+}
+
+func (s *basicService) LastSeq() uint64 {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.seq
+}
+
+func (s *basicService) ApplyChange(c Change) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if c.Seq <= s.seq {
+		return nil
+	}
+	if c.Seq != s.seq+1 {
+		return ErrReplicationGap
+	}
+
+	id := c.User.Id
+	switch c.Type {
+	case UserCreated:
+		s.Users[id] = c.User
+		delete(s.deleted, id)
+	case UserExpired:
+		delete(s.Users, id)
+		if !c.Hard {
+			s.deleted[id] = c.User
+		}
+	}
+	s.publish(c)
+
+	return nil
+}
+
+func (s *basicService) Bootstrap(users []*User, seq uint64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.repair(func(string) bool { return true }, users, nil)
+	s.seq = seq
+}
+
+// ReplicationLog records the changes published by a store, so that
+// followers can read them from wherever they left off. The log is kept in
+// memory, and only its latest changes are retained.
+type ReplicationLog struct {
+	mtx     sync.Mutex
+	changes []Change
+	head    uint64
+	retain  int
+
+	// wait is closed, and replaced, whenever a change is appended.
+	wait chan struct{}
+}
+
+// NewReplicationLog returns a log of the changes published by p. It must be
+// made before any change is made to the store, since it only sees the
+// changes published after it subscribes. The log keeps at least the latest
+// retain changes, and never more than twice as many, or every change if
+// retain is zero. Followers further behind must bootstrap.
+func NewReplicationLog(p Publisher, retain int) *ReplicationLog {
+	l := &ReplicationLog{retain: retain, wait: make(chan struct{})}
+	p.Subscribe(l.append)
+	return l
+}
+
+func (l *ReplicationLog) append(c Change) {
+	// Repairs aren't part of the history of the store.
+	if c.Repair {
+		return
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	// Trimming copies the retained changes, so it is only done once twice
+	// as many have built up, to keep appends cheap.
+	if l.retain > 0 && len(l.changes) >= 2*l.retain {
+		l.changes = append(make([]Change, 0, 2*l.retain), l.changes[len(l.changes)-l.retain:]...)
+	}
+	l.changes = append(l.changes, c)
+	l.head = c.Seq
+	close(l.wait)
+	l.wait = make(chan struct{})
+}
+
+// Head returns the seq of the latest change in the log.
+func (l *ReplicationLog) Head() uint64 {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.head
+}
+
+// Since returns the changes that follow the change numbered after, along
+// with the latest seq in the log. If there are none yet, wait is closed once
+// there are.
+func (l *ReplicationLog) Since(after uint64) (changes []Change, head uint64, wait <-chan struct{}, err error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if after > l.head {
+		return nil, l.head, nil, ErrReplicationGap
+	}
+	if len(l.changes) == 0 || after == l.head {
+		return nil, l.head, l.wait, nil
+	}
+
+	first := l.changes[0].Seq
+	if after+1 < first {
+		return nil, l.head, nil, ErrReplicationGap
+	}
+
+	i := int(after + 1 - first)
+	j := len(l.changes)
+	if j-i > maxReplicationBatch {
+		j = i + maxReplicationBatch
+	}
+
+	// Changes are never modified once appended, so the slice can be shared.
+	return l.changes[i:j:j], l.head, nil, nil
+}
+
+// ChangeSource streams the changes of a leader that follow the change
+// numbered after, calling fn with each of them and the latest seq of the
+// leader. A Change with a zero Seq carries no change, and only reports the
+// latest seq. It returns once ctx is done, the stream breaks, or fn returns
+// an error.
+type ChangeSource func(ctx context.Context, after uint64, fn func(c Change, head uint64) error) error
+
+// SnapshotSource returns the live users of a leader, along with the seq of a
+// change of the leader that the snapshot is no older than.
+type SnapshotSource func(ctx context.Context) (users []*User, seq uint64, err error)
+
+// Follower keeps a Replica up to date with the changes of a leader.
+type Follower struct {
+	replica  Replica
+	source   ChangeSource
+	snapshot SnapshotSource
+	lag      metrics.Gauge
+	lagTime  metrics.Gauge
+	logger   log.Logger
+
+	mtx    sync.Mutex
+	behind uint64
+	age    time.Duration
+}
+
+// NewFollower returns a Follower applying the changes read from source to r.
+// Whenever source can't continue from the last change applied, r is
+// bootstrapped from snapshot instead, unless it is nil. The follower reports
+// how many changes it is behind the leader in lag, and how old the change it
+// last applied was, in seconds, in lagTime. Once caught up both are zero.
+func NewFollower(r Replica, source ChangeSource, snapshot SnapshotSource, lag, lagTime metrics.Gauge, logger log.Logger) *Follower {
+	return &Follower{
+		replica:  r,
+		source:   source,
+		snapshot: snapshot,
+		lag:      lag,
+		lagTime:  lagTime,
+		logger:   logger,
+	}
+}
+
+// Run follows the leader until the context is done, reconnecting with
+// exponential backoff whenever the stream breaks.
+func (f *Follower) Run(ctx context.Context) {
+	backoff := 100 * time.Millisecond
+	for {
+		from := f.replica.LastSeq()
+		f.logger.Log("msg", "following leader", "after", from)
+
+		applied := false
+		err := f.source(ctx, from, func(c Change, head uint64) error {
+			applied = true
+			return f.apply(c, head)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		f.logger.Log("msg", "replication stream broken", "error", err)
+
+		if err == ErrReplicationGap && f.snapshot != nil {
+			if err = f.bootstrap(ctx); err == nil {
+				backoff = 100 * time.Millisecond
+				continue
+			}
+			f.logger.Log("msg", "bootstrap failed", "error", err)
+		}
+		if applied {
+			backoff = 100 * time.Millisecond
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > 5*time.Second {
+			backoff = 5 * time.Second
+		}
+	}
+}
+
+// bootstrap replaces the users of the replica with a snapshot of the leader.
+func (f *Follower) bootstrap(ctx context.Context) error {
+	users, seq, err := f.snapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	f.replica.Bootstrap(users, seq)
+	f.logger.Log("msg", "bootstrapped from leader", "users", len(users), "seq", seq)
+	return nil
+}
+
+func (f *Follower) apply(c Change, head uint64) error {
+	if c.Seq != 0 {
+		if err := f.replica.ApplyChange(c); err != nil {
+			return err
+		}
+	}
+
+	var behind uint64
+	var age time.Duration
+	if last := f.replica.LastSeq(); head > last {
+		behind = head - last
+		if c.Seq != 0 {
+			age = time.Since(c.Time)
+		}
+	}
+
+	f.mtx.Lock()
+	f.behind, f.age = behind, age
+	f.mtx.Unlock()
+
+	f.lag.Set(float64(behind))
+	f.lagTime.Set(age.Seconds())
+	return nil
+}
+
+// Lag returns how many changes the follower is behind its leader, and how
+// old the change it last applied was. Both are zero once it has caught up.
+func (f *Follower) Lag() (behind uint64, age time.Duration) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return f.behind, f.age
+}
+
+// ServiceFollowerMiddleware returns a middleware for the service of a
+// follower. Reads are served by the next service. Writes are forwarded to
+// leader, or rejected with ErrNotLeader if leader is nil. A forwarded write
+// isn't seen by reads on the follower until it has been replicated back.
+func ServiceFollowerMiddleware(leader UserService) Middleware {
+	return func(next UserService) UserService {
+		return serviceFollowerMiddleware{
+			leader: leader,
+			next:   next,
+		}
+	}
+}
+
+type serviceFollowerMiddleware struct {
+	leader UserService
+	next   UserService
+}
+
+func (mw serviceFollowerMiddleware) CreateUser(ctx context.Context, u *User) (*User, error) {
+	if mw.leader == nil {
+		return nil, ErrNotLeader
+	}
+	return mw.leader.CreateUser(ctx, u)
+}
+
+func (mw serviceFollowerMiddleware) GetUser(ctx context.Context, id string) (*User, error) {
+	return mw.next.GetUser(ctx, id)
+}
+
+func (mw serviceFollowerMiddleware) BatchCreateUsers(ctx context.Context, users []*User, mode BatchMode) ([]BatchResult, error) {
+	if mw.leader == nil {
+		return nil, ErrNotLeader
+	}
+	return mw.leader.BatchCreateUsers(ctx, users, mode)
+}
+
+func (mw serviceFollowerMiddleware) BatchGetUsers(ctx context.Context, ids []string, mode BatchMode) ([]BatchResult, error) {
+	return mw.next.BatchGetUsers(ctx, ids, mode)
+}
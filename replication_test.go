@@ -0,0 +1,94 @@
+package learn
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestReplicationLogRetain(t *testing.T) {
+	ctx := context.Background()
+	s := NewBasicService()
+	l := NewReplicationLog(s.(Publisher), 10)
+	for i := 0; i < 25; i++ {
+		s.CreateUser(ctx, &User{Id: "a"})
+	}
+
+	if l.Head() != 25 {
+		t.Errorf("Head = %d, want 25", l.Head())
+	}
+	if n := len(l.changes); n < 10 || n > 20 {
+		t.Errorf("log holds %d changes, want between 10 and 20", n)
+	}
+
+	// Followers behind the retained changes must bootstrap.
+	if _, _, _, err := l.Since(0); err != ErrReplicationGap {
+		t.Errorf("Since(0) = %v, want %v", err, ErrReplicationGap)
+	}
+	if _, _, _, err := l.Since(30); err != ErrReplicationGap {
+		t.Errorf("Since(30) = %v, want %v", err, ErrReplicationGap)
+	}
+	changes, head, _, err := l.Since(20)
+	if err != nil || head != 25 || len(changes) != 5 || changes[0].Seq != 21 {
+		t.Errorf("Since(20) = %d changes from %v, head %d, %v", len(changes), changes, head, err)
+	}
+	if _, _, wait, err := l.Since(25); err != nil || wait == nil {
+		t.Errorf("Since(head) = %v, %v, want a wait", wait, err)
+	}
+}
+
+func TestApplyChange(t *testing.T) {
+	s := NewBasicService()
+	r := s.(Replica)
+
+	if err := r.ApplyChange(Change{Seq: 2, Type: UserCreated, User: &User{Id: "b"}}); err != ErrReplicationGap {
+		t.Errorf("ApplyChange out of order = %v, want %v", err, ErrReplicationGap)
+	}
+	for _, c := range []Change{
+		{Seq: 1, Type: UserCreated, User: &User{Id: "a"}},
+		{Seq: 2, Type: UserCreated, User: &User{Id: "b"}},
+		{Seq: 1, Type: UserExpired, User: &User{Id: "a"}},
+		{Seq: 3, Type: UserExpired, User: &User{Id: "b"}},
+	} {
+		if err := r.ApplyChange(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	if _, err := s.GetUser(ctx, "a"); err != nil {
+		t.Errorf("a change applied twice: %v", err)
+	}
+	if _, err := s.GetUser(ctx, "b"); err != ErrNotFound {
+		t.Errorf("GetUser of an expired user = %v, want %v", err, ErrNotFound)
+	}
+	if r.LastSeq() != 3 {
+		t.Errorf("LastSeq = %d, want 3", r.LastSeq())
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	ctx := context.Background()
+	s := NewBasicService()
+	s.CreateUser(ctx, &User{Id: "stale"})
+	s.CreateUser(ctx, &User{Id: "same"})
+	var changes []Change
+	s.(Publisher).Subscribe(func(c Change) { changes = append(changes, c) })
+
+	s.(Replica).Bootstrap([]*User{{Id: "same"}, {Id: "new"}}, 40)
+
+	if _, err := s.GetUser(ctx, "stale"); err != ErrNotFound {
+		t.Errorf("GetUser of a user missing from the snapshot = %v", err)
+	}
+	if _, err := s.GetUser(ctx, "new"); err != nil {
+		t.Error(err)
+	}
+	if len(changes) != 2 || !changes[0].Repair || !changes[1].Repair {
+		t.Errorf("changes = %+v, want two repairs", changes)
+	}
+
+	// Replication continues from the seq of the snapshot.
+	if err := s.(Replica).ApplyChange(Change{Seq: 41, Type: UserCreated, User: &User{Id: "next"}}); err != nil {
+		t.Error(err)
+	}
+}
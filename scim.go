@@ -0,0 +1,744 @@
+package learn
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"golang.org/x/net/context"
+)
+
+// The schemas of SCIM 2.0, as defined by RFC 7643 and RFC 7644.
+const (
+	scimUserSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimSPConfigSchema     = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	scimResourceTypeSchema = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	scimSchemaSchema       = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	scimListResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+const (
+	// scimPath is where the SCIM endpoints are served.
+	scimPath        = "/scim/v2"
+	scimContentType = "application/scim+json"
+
+	// scimDefaultCount and scimMaxCount are the default and largest number
+	// of users in a page of a list.
+	scimDefaultCount = 100
+	scimMaxCount     = 1000
+)
+
+// scimUser is a User as a resource of the SCIM core User schema. Users have
+// no state of their own beyond existing, so a user is active until it is
+// deactivated, which deletes it.
+type scimUser struct {
+	Schemas  []string    `json:"schemas"`
+	ID       string      `json:"id,omitempty"`
+	UserName string      `json:"userName"`
+	Name     *scimName   `json:"name,omitempty"`
+	Emails   []scimEmail `json:"emails,omitempty"`
+	Active   *bool       `json:"active,omitempty"`
+	Meta     *scimMeta   `json:"meta,omitempty"`
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location"`
+}
+
+// newSCIMUser returns user as a resource located below base.
+func newSCIMUser(user *User, base string) scimUser {
+	active := true
+	su := scimUser{
+		Schemas:  []string{scimUserSchema},
+		ID:       user.Id,
+		UserName: user.Username,
+		Active:   &active,
+		Meta:     &scimMeta{ResourceType: "User", Location: base + "/Users/" + user.Id},
+	}
+	if user.FirstName != "" || user.LastName != "" {
+		su.Name = &scimName{
+			Formatted:  strings.TrimSpace(user.FirstName + " " + user.LastName),
+			GivenName:  user.FirstName,
+			FamilyName: user.LastName,
+		}
+	}
+	if user.Email != "" {
+		su.Emails = []scimEmail{{Value: user.Email, Type: "work", Primary: true}}
+	}
+	return su
+}
+
+// user returns the User with id that su describes. It only keeps the primary
+// email address, or the first if none is primary.
+func (su scimUser) user(id string) (*User, error) {
+	if strings.TrimSpace(su.UserName) == "" {
+		return nil, &scimError{status: http.StatusBadRequest, scimType: "invalidValue", detail: "userName is required"}
+	}
+	user := &User{Id: id, Username: su.UserName}
+	if su.Name != nil {
+		user.FirstName, user.LastName = su.Name.GivenName, su.Name.FamilyName
+	}
+	for i, e := range su.Emails {
+		if i == 0 || e.Primary {
+			user.Email = e.Value
+		}
+		if e.Primary {
+			break
+		}
+	}
+	return user, nil
+}
+
+// active reports whether su is to stay active, as it is unless it says
+// otherwise.
+func (su scimUser) active() bool {
+	return su.Active == nil || *su.Active
+}
+
+// scimError is an error response, with the status and, for a bad request,
+// the scimType of RFC 7644.
+type scimError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *scimError) Error() string {
+	return e.detail
+}
+
+// scimServer serves Users over SCIM, backed by a UserService and an Exporter
+// of the same users to list and filter them.
+type scimServer struct {
+	users    UserService
+	exporter Exporter
+	auth     Authenticator
+	logger   log.Logger
+
+	// mtx serializes writes, so that two users can't be given the same
+	// userName by this instance at once. Instances sharing a store don't
+	// share it, so only one of them should serve SCIM.
+	mtx sync.Mutex
+}
+
+// authenticate returns ctx carrying the principal that s.auth authenticates
+// from the credentials of r, which must be an admin or a service account, as
+// identity providers are.
+func (s *scimServer) authenticate(ctx context.Context, r *http.Request) (context.Context, error) {
+	for _, before := range []httptransport.RequestFunc{jwt.ToHTTPContext(), APIKeyToHTTPContext(), CertificateToHTTPContext()} {
+		ctx = before(ctx, r)
+	}
+	if s.auth == nil {
+		return ctx, nil
+	}
+	ctx, err := s.auth.AuthenticateContext(ctx)
+	if err != nil {
+		return nil, &scimError{status: http.StatusUnauthorized, detail: err.Error()}
+	}
+	if err := authorizeProvisioning(ctx); err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// serveUsers serves /Users, to list users and create them, and /Users/{id},
+// to read, replace, patch and delete a user.
+func (s *scimServer) serveUsers(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	ctx, err := s.authenticate(ctx, r)
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, scimPath+"/Users"), "/")
+
+	var (
+		status = http.StatusOK
+		resp   interface{}
+	)
+	switch {
+	case id == "" && r.Method == "GET":
+		resp, err = s.listUsers(ctx, r)
+	case id == "" && r.Method == "POST":
+		status = http.StatusCreated
+		resp, err = s.createUser(ctx, r)
+	case id != "" && r.Method == "GET":
+		resp, err = s.getUser(ctx, r, id)
+	case id != "" && r.Method == "PUT":
+		resp, err = s.replaceUser(ctx, r, id)
+	case id != "" && r.Method == "PATCH":
+		resp, err = s.patchUser(ctx, r, id)
+	case id != "" && r.Method == "DELETE":
+		status = http.StatusNoContent
+		err = s.deleteUser(ctx, id)
+	default:
+		err = &scimError{status: http.StatusMethodNotAllowed, detail: "method not allowed"}
+	}
+	if err != nil {
+		s.fail(w, r, err)
+		return
+	}
+
+	if status == http.StatusNoContent {
+		w.WriteHeader(status)
+		return
+	}
+	if status == http.StatusCreated {
+		w.Header().Set("Location", resp.(scimUser).Meta.Location)
+	}
+	writeSCIM(w, status, resp)
+}
+
+func (s *scimServer) listUsers(ctx context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	match := func(*User) bool { return true }
+	if filter := q.Get("filter"); filter != "" {
+		var err error
+		if match, err = parseSCIMFilter(filter); err != nil {
+			return nil, err
+		}
+	}
+	start, err := scimIndex(q.Get("startIndex"), 1)
+	if err != nil {
+		return nil, err
+	}
+	count, err := scimIndex(q.Get("count"), scimDefaultCount)
+	if err != nil {
+		return nil, err
+	}
+	if count > scimMaxCount {
+		count = scimMaxCount
+	}
+	if start < 1 {
+		start = 1
+	}
+
+	// Users are exported in id order, so pages are stable while the users
+	// don't change.
+	base := scimBaseURL(r)
+	resources := []scimUser{}
+	total := 0
+	err = s.scan(ctx, func(user *User) bool {
+		if !match(user) {
+			return true
+		}
+		total++
+		if total >= start && len(resources) < count {
+			resources = append(resources, newSCIMUser(user, base))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scimListResponse(total, start, len(resources), resources), nil
+}
+
+func (s *scimServer) createUser(ctx context.Context, r *http.Request) (interface{}, error) {
+	var su scimUser
+	if err := decodeSCIM(r.Body, &su); err != nil {
+		return nil, err
+	}
+	if !su.active() {
+		return nil, &scimError{status: http.StatusBadRequest, scimType: "invalidValue", detail: "users can't be created inactive"}
+	}
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	user, err := su.user(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.checkUserName(ctx, user); err != nil {
+		return nil, err
+	}
+	if _, err := s.users.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	return newSCIMUser(user, scimBaseURL(r)), nil
+}
+
+func (s *scimServer) getUser(ctx context.Context, r *http.Request, id string) (interface{}, error) {
+	user, err := s.users.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return newSCIMUser(user, scimBaseURL(r)), nil
+}
+
+func (s *scimServer) replaceUser(ctx context.Context, r *http.Request, id string) (interface{}, error) {
+	var su scimUser
+	if err := decodeSCIM(r.Body, &su); err != nil {
+		return nil, err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	old, err := s.users.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.put(ctx, r, old, su)
+}
+
+// scimPatch is the body of a PATCH request.
+type scimPatch struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []scimPatchOperation `json:"Operations"`
+}
+
+type scimPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+func (s *scimServer) patchUser(ctx context.Context, r *http.Request, id string) (interface{}, error) {
+	var patch scimPatch
+	if err := decodeSCIM(r.Body, &patch); err != nil {
+		return nil, err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	old, err := s.users.GetUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	su := newSCIMUser(old, "")
+	for _, op := range patch.Operations {
+		if err := su.patch(op); err != nil {
+			return nil, err
+		}
+	}
+	return s.put(ctx, r, old, su)
+}
+
+// put replaces old with the user su describes. A user that is no longer
+// active is deleted. s.mtx must be held.
+func (s *scimServer) put(ctx context.Context, r *http.Request, old *User, su scimUser) (interface{}, error) {
+	user, err := su.user(old.Id)
+	if err != nil {
+		return nil, err
+	}
+	// SCIM doesn't know of expiry, so a temporary user stays temporary.
+	user.ExpiresAt = old.ExpiresAt
+	if !su.active() {
+		user.ExpiresAt = time.Now()
+	} else if err := s.checkUserName(ctx, user); err != nil {
+		return nil, err
+	}
+	if _, err := s.users.CreateUser(ctx, user); err != nil {
+		return nil, err
+	}
+
+	resp := newSCIMUser(user, scimBaseURL(r))
+	resp.Active = su.Active
+	return resp, nil
+}
+
+// deleteUser deletes the user with id by expiring it, so that it is
+// replicated, and reaped, as any other expired user.
+func (s *scimServer) deleteUser(ctx context.Context, id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	user, err := s.users.GetUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	expired := *user
+	expired.ExpiresAt = time.Now()
+	_, err = s.users.CreateUser(ctx, &expired)
+	return err
+}
+
+// checkUserName returns a uniqueness error if another user than user has
+// its username, ignoring case as SCIM does.
+func (s *scimServer) checkUserName(ctx context.Context, user *User) error {
+	taken := false
+	err := s.scan(ctx, func(u *User) bool {
+		taken = u.Id != user.Id && strings.EqualFold(u.Username, user.Username)
+		return !taken
+	})
+	if err != nil {
+		return err
+	}
+	if taken {
+		return &scimError{status: http.StatusConflict, scimType: "uniqueness", detail: "userName is already taken"}
+	}
+	return nil
+}
+
+// scan calls fn with every user in id order, until it returns false.
+func (s *scimServer) scan(ctx context.Context, fn func(*User) bool) error {
+	users, err := s.exporter.ExportUsers(ctx, ExportFilter{})
+	if err != nil {
+		return err
+	}
+	defer users.Close()
+	for {
+		user, err := users.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(user) {
+			return nil
+		}
+	}
+}
+
+// patch applies a PATCH operation to su. Only the attributes of the schema
+// may be patched, and value filters on emails are taken to mean the one
+// email address that users have.
+func (su *scimUser) patch(op scimPatchOperation) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidSyntax", detail: "unknown op " + strconv.Quote(op.Op)}
+	}
+	path := scimAttribute(op.Path)
+	if path != "" {
+		if kind == "remove" {
+			return su.remove(path)
+		}
+		return su.set(path, op.Value)
+	}
+
+	// Without a path, the value holds the attributes to set.
+	values, ok := op.Value.(map[string]interface{})
+	if kind == "remove" || !ok {
+		return &scimError{status: http.StatusBadRequest, scimType: "noTarget", detail: "op without a path needs an object value"}
+	}
+	for attr, value := range values {
+		if err := su.set(scimAttribute(attr), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (su *scimUser) set(path string, value interface{}) error {
+	name := su.Name
+	if name == nil {
+		name = &scimName{}
+	}
+	var err error
+	switch {
+	case path == "username":
+		err = decodeSCIMValue(value, &su.UserName)
+	case path == "name":
+		err = decodeSCIMValue(value, name)
+		su.Name = name
+	case path == "name.givenname":
+		err = decodeSCIMValue(value, &name.GivenName)
+		su.Name = name
+	case path == "name.familyname":
+		err = decodeSCIMValue(value, &name.FamilyName)
+		su.Name = name
+	case path == "name.formatted":
+		// Derived from the given and family names.
+	case path == "emails":
+		var emails []scimEmail
+		if err = decodeSCIMValue(value, &emails); err == nil {
+			su.Emails = emails
+		}
+	case strings.HasPrefix(path, "emails["):
+		var email string
+		if err = decodeSCIMValue(value, &email); err == nil {
+			su.Emails = []scimEmail{{Value: email, Primary: true}}
+		}
+	case path == "active":
+		var active bool
+		if s, ok := value.(string); ok {
+			// Some clients send booleans as strings.
+			active, err = strconv.ParseBool(s)
+		} else {
+			err = decodeSCIMValue(value, &active)
+		}
+		su.Active = &active
+	default:
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidPath", detail: "unknown attribute " + strconv.Quote(path)}
+	}
+	if err != nil {
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidValue", detail: "bad value for " + path + ": " + err.Error()}
+	}
+	return nil
+}
+
+func (su *scimUser) remove(path string) error {
+	switch {
+	case path == "username":
+		return &scimError{status: http.StatusBadRequest, scimType: "mutability", detail: "userName is required"}
+	case path == "name":
+		su.Name = nil
+	case path == "name.givenname" && su.Name != nil:
+		su.Name.GivenName = ""
+	case path == "name.familyname" && su.Name != nil:
+		su.Name.FamilyName = ""
+	case path == "emails" || strings.HasPrefix(path, "emails["):
+		su.Emails = nil
+	case path == "active":
+		su.Active = nil
+	case path == "name.givenname" || path == "name.familyname" || path == "name.formatted":
+	default:
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidPath", detail: "unknown attribute " + strconv.Quote(path)}
+	}
+	return nil
+}
+
+// scimAttribute returns the path of an attribute in lower case, as SCIM
+// attribute names ignore case, without the URN of the User schema.
+func scimAttribute(path string) string {
+	path = strings.ToLower(strings.TrimSpace(path))
+	return strings.TrimPrefix(path, strings.ToLower(scimUserSchema)+":")
+}
+
+// scimFilterPattern matches the one kind of filter supported, an attribute
+// equal to a string.
+var scimFilterPattern = regexp.MustCompile(`(?i)^\s*([a-z0-9.:]+)\s+eq\s+("(?:[^"\\]|\\.)*")\s*$`)
+
+// parseSCIMFilter returns a function that reports whether a user matches
+// filter, which compares userName, id or emails.value with eq.
+func parseSCIMFilter(filter string) (func(*User) bool, error) {
+	m := scimFilterPattern.FindStringSubmatch(filter)
+	if m == nil {
+		return nil, &scimError{status: http.StatusBadRequest, scimType: "invalidFilter", detail: `only filters of the form attribute eq "value" are supported`}
+	}
+	value, err := strconv.Unquote(m[2])
+	if err != nil {
+		return nil, &scimError{status: http.StatusBadRequest, scimType: "invalidFilter", detail: "bad string " + m[2]}
+	}
+	switch scimAttribute(m[1]) {
+	case "username":
+		return func(u *User) bool { return strings.EqualFold(u.Username, value) }, nil
+	case "id":
+		return func(u *User) bool { return u.Id == value }, nil
+	case "emails", "emails.value":
+		return func(u *User) bool { return strings.EqualFold(u.Email, value) }, nil
+	}
+	return nil, &scimError{status: http.StatusBadRequest, scimType: "invalidFilter", detail: "can't filter by " + m[1]}
+}
+
+// scimIndex parses the startIndex or count parameter of a list request.
+func scimIndex(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, &scimError{status: http.StatusBadRequest, scimType: "invalidValue", detail: "bad number " + strconv.Quote(s)}
+	}
+	if n < 0 {
+		n = 0
+	}
+	return n, nil
+}
+
+// scimBaseURL returns the URL that the SCIM endpoints are reached at with r.
+func scimBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + scimPath
+}
+
+// scimListResponse returns a page of n resources, of total, starting at the
+// one-based index start.
+func scimListResponse(total, start, n int, resources interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":      []string{scimListResponseSchema},
+		"totalResults": total,
+		"startIndex":   start,
+		"itemsPerPage": n,
+		"Resources":    resources,
+	}
+}
+
+// serveSCIMServiceProviderConfig describes the SCIM features supported.
+func serveSCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{scimSPConfigSchema},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]string{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "A JWT access token, or an API key of a service account, as a bearer token",
+		}},
+		"meta": map[string]string{"resourceType": "ServiceProviderConfig", "location": scimBaseURL(r) + "/ServiceProviderConfig"},
+	})
+}
+
+// scimUserResourceType describes /Users.
+func scimUserResourceType(base string) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":     []string{scimResourceTypeSchema},
+		"id":          "User",
+		"name":        "User",
+		"endpoint":    "/Users",
+		"description": "User Account",
+		"schema":      scimUserSchema,
+		"meta":        map[string]string{"resourceType": "ResourceType", "location": base + "/ResourceTypes/User"},
+	}
+}
+
+// serveSCIMResourceTypes lists the resource types, of which there is only
+// User; there are no groups.
+func serveSCIMResourceTypes(w http.ResponseWriter, r *http.Request) {
+	base := scimBaseURL(r)
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, scimPath+"/ResourceTypes"), "/") {
+	case "":
+		writeSCIM(w, http.StatusOK, scimListResponse(1, 1, 1, []map[string]interface{}{scimUserResourceType(base)}))
+	case "User":
+		writeSCIM(w, http.StatusOK, scimUserResourceType(base))
+	default:
+		writeSCIMError(w, &scimError{status: http.StatusNotFound, detail: "unknown resource type"})
+	}
+}
+
+// scimAttr describes an attribute of a schema.
+func scimAttr(name, typ string, required bool, extra map[string]interface{}) map[string]interface{} {
+	attr := map[string]interface{}{
+		"name":        name,
+		"type":        typ,
+		"multiValued": false,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  "readWrite",
+		"returned":    "default",
+		"uniqueness":  "none",
+	}
+	for k, v := range extra {
+		attr[k] = v
+	}
+	return attr
+}
+
+// scimUserSchemaResource describes the attributes of the User schema that
+// users have.
+func scimUserSchemaResource(base string) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":     []string{scimSchemaSchema},
+		"id":          scimUserSchema,
+		"name":        "User",
+		"description": "User Account",
+		"attributes": []map[string]interface{}{
+			scimAttr("userName", "string", true, map[string]interface{}{"uniqueness": "server"}),
+			scimAttr("name", "complex", false, map[string]interface{}{"subAttributes": []map[string]interface{}{
+				scimAttr("formatted", "string", false, map[string]interface{}{"mutability": "readOnly"}),
+				scimAttr("givenName", "string", false, nil),
+				scimAttr("familyName", "string", false, nil),
+			}}),
+			scimAttr("emails", "complex", false, map[string]interface{}{"multiValued": true, "subAttributes": []map[string]interface{}{
+				scimAttr("value", "string", false, nil),
+				scimAttr("type", "string", false, nil),
+				scimAttr("primary", "boolean", false, nil),
+			}}),
+			scimAttr("active", "boolean", false, nil),
+		},
+		"meta": map[string]string{"resourceType": "Schema", "location": base + "/Schemas/" + scimUserSchema},
+	}
+}
+
+func serveSCIMSchemas(w http.ResponseWriter, r *http.Request) {
+	base := scimBaseURL(r)
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, scimPath+"/Schemas"), "/") {
+	case "":
+		writeSCIM(w, http.StatusOK, scimListResponse(1, 1, 1, []map[string]interface{}{scimUserSchemaResource(base)}))
+	case scimUserSchema:
+		writeSCIM(w, http.StatusOK, scimUserSchemaResource(base))
+	default:
+		writeSCIMError(w, &scimError{status: http.StatusNotFound, detail: "unknown schema"})
+	}
+}
+
+// fail writes err as a SCIM error response, logging it unless it is the
+// fault of the client.
+func (s *scimServer) fail(w http.ResponseWriter, r *http.Request, err error) {
+	switch e := err.(type) {
+	case *scimError:
+		writeSCIMError(w, e)
+		return
+	case *PasswordPolicyError:
+		writeSCIMError(w, &scimError{status: http.StatusBadRequest, scimType: "invalidValue", detail: e.Error()})
+		return
+	}
+	switch {
+	case err == ErrNotFound:
+		writeSCIMError(w, &scimError{status: http.StatusNotFound, detail: "user not found"})
+	case isAuthError(err):
+		writeSCIMError(w, &scimError{status: http.StatusUnauthorized, detail: err.Error()})
+	case err == ErrForbidden:
+		writeSCIMError(w, &scimError{status: http.StatusForbidden, detail: err.Error()})
+	default:
+		s.logger.Log("method", r.Method, "path", r.URL.Path, "err", err)
+		writeSCIMError(w, &scimError{status: http.StatusInternalServerError, detail: err.Error()})
+	}
+}
+
+func writeSCIMError(w http.ResponseWriter, e *scimError) {
+	resp := map[string]interface{}{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(e.status),
+		"detail":  e.detail,
+	}
+	if e.scimType != "" {
+		resp["scimType"] = e.scimType
+	}
+	writeSCIM(w, e.status, resp)
+}
+
+func writeSCIM(w http.ResponseWriter, status int, resp interface{}) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// decodeSCIM decodes a request body into v.
+func decodeSCIM(r io.Reader, v interface{}) error {
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return &scimError{status: http.StatusBadRequest, scimType: "invalidSyntax", detail: err.Error()}
+	}
+	return nil
+}
+
+// decodeSCIMValue decodes the value of a PATCH operation into v.
+func decodeSCIMValue(value interface{}, v interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,253 @@
+package learn
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+)
+
+// testSCIM is a SCIM handler served over HTTP for users, which authenticates
+// tokens of issuer and the API keys of accounts.
+type testSCIM struct {
+	users    UserService
+	issuer   *Issuer
+	accounts *ServiceAccounts
+	server   *httptest.Server
+	admin    string
+}
+
+func newTestSCIM(t *testing.T) *testSCIM {
+	ctx := context.Background()
+	users := NewBasicService()
+	issuer, err := NewIssuer(SharedSecret("secret"), "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts, err := NewServiceAccounts("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := APIKeysOr(accounts, NewVerifier(SharedSecret("secret"), "", "", 0))
+	admin, _, err := issuer.Issue("ops", time.Minute, stdjwt.MapClaims{"roles": []string{AdminRole}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(MakeSCIMHTTPHandler(ctx, users, users.(Exporter), auth, log.NewNopLogger()))
+	return &testSCIM{users: users, issuer: issuer, accounts: accounts, server: srv, admin: admin}
+}
+
+// do makes a request of the SCIM endpoint at path with the headers and body,
+// and returns the status, decoded body and headers of the response.
+func (s *testSCIM) do(t *testing.T, method, path string, header http.Header, body interface{}) (int, map[string]interface{}, http.Header) {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+	r, _ := http.NewRequest(method, s.server.URL+scimPath+path, &buf)
+	r.Header.Set("Content-Type", scimContentType)
+	for k, vs := range header {
+		r.Header[k] = vs
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out, resp.Header
+}
+
+// asAdmin makes a request as an admin.
+func (s *testSCIM) asAdmin(t *testing.T, method, path string, body interface{}) (int, map[string]interface{}) {
+	status, out, _ := s.do(t, method, path, http.Header{"Authorization": {"Bearer " + s.admin}}, body)
+	return status, out
+}
+
+// create creates a user named userName as an admin, returning its ID.
+func (s *testSCIM) create(t *testing.T, userName string) string {
+	status, body := s.asAdmin(t, "POST", "/Users", map[string]interface{}{"schemas": []string{scimUserSchema}, "userName": userName})
+	id, _ := body["id"].(string)
+	if status != http.StatusCreated || id == "" {
+		t.Fatalf("creating %s = %d %v, want 201", userName, status, body)
+	}
+	return id
+}
+
+// patchOp returns a PATCH request of ops.
+func patchOp(ops ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:PatchOp"}, "Operations": ops}
+}
+
+func TestSCIMAuthorization(t *testing.T) {
+	s := newTestSCIM(t)
+	defer s.server.Close()
+	user, _, _ := s.issuer.Issue("u1", time.Minute, stdjwt.MapClaims{"roles": []string{"reader"}})
+	s.accounts.CreateAccount("idp", nil)
+	key, _, _ := s.accounts.IssueKey("idp", time.Hour)
+
+	// Only admins and service accounts provision users.
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"user", http.Header{"Authorization": {"Bearer " + user}}, http.StatusForbidden},
+		{"admin", http.Header{"Authorization": {"Bearer " + s.admin}}, http.StatusOK},
+		{"service account", http.Header{APIKeyHeader: {key}}, http.StatusOK},
+	} {
+		if status, body, _ := s.do(t, "GET", "/Users", tc.header, nil); status != tc.status {
+			t.Errorf("%s: list = %d %v, want %d", tc.name, status, body, tc.status)
+		}
+	}
+	if status, _, _ := s.do(t, "POST", "/Users", http.Header{"Authorization": {"Bearer " + user}}, map[string]interface{}{"userName": "eve"}); status != http.StatusForbidden {
+		t.Errorf("user: create = %d, want 403", status)
+	}
+
+	// Discovery is public.
+	if status, body, _ := s.do(t, "GET", "/ServiceProviderConfig", nil, nil); status != http.StatusOK || body["patch"].(map[string]interface{})["supported"] != true {
+		t.Errorf("service provider config = %d %v", status, body)
+	}
+	if status, body, _ := s.do(t, "GET", "/Schemas/"+scimUserSchema, nil, nil); status != http.StatusOK || body["id"] != scimUserSchema {
+		t.Errorf("user schema = %d %v", status, body)
+	}
+	if status, _ := s.asAdmin(t, "GET", "/Groups", nil); status != http.StatusNotFound {
+		t.Errorf("groups = %d, want 404", status)
+	}
+}
+
+func TestSCIMCreate(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSCIM(t)
+	defer s.server.Close()
+
+	// The primary email address is the one users keep.
+	status, created, header := s.do(t, "POST", "/Users", http.Header{"Authorization": {"Bearer " + s.admin}}, map[string]interface{}{
+		"schemas":  []string{scimUserSchema},
+		"userName": "ann@example.com",
+		"name":     map[string]string{"givenName": "Ann", "familyName": "Lee"},
+		"emails":   []map[string]interface{}{{"value": "home@example.com"}, {"value": "ann@example.com", "primary": true}},
+	})
+	id, _ := created["id"].(string)
+	if status != http.StatusCreated || id == "" || header.Get("Location") != s.server.URL+scimPath+"/Users/"+id || header.Get("Content-Type") != scimContentType {
+		t.Fatalf("create = %d %v %v, want 201 at the user", status, created, header)
+	}
+	if u, err := s.users.GetUser(ctx, id); err != nil || u.Username != "ann@example.com" || u.Email != "ann@example.com" || u.FirstName != "Ann" || u.LastName != "Lee" {
+		t.Errorf("created user = %+v, %v", u, err)
+	}
+
+	// userNames are unique ignoring case, and required.
+	if status, body := s.asAdmin(t, "POST", "/Users", map[string]interface{}{"userName": "ANN@example.com"}); status != http.StatusConflict || body["scimType"] != "uniqueness" {
+		t.Errorf("taken userName = %d %v, want 409 uniqueness", status, body)
+	}
+	if status, body := s.asAdmin(t, "POST", "/Users", map[string]interface{}{"name": map[string]string{"givenName": "x"}}); status != http.StatusBadRequest || body["scimType"] != "invalidValue" {
+		t.Errorf("missing userName = %d %v, want 400 invalidValue", status, body)
+	}
+}
+
+func TestSCIMFilter(t *testing.T) {
+	s := newTestSCIM(t)
+	defer s.server.Close()
+	ann := s.create(t, "ann@example.com")
+	s.create(t, "bob")
+	s.create(t, "cat")
+
+	status, list := s.asAdmin(t, "GET", "/Users?filter="+url.QueryEscape(`userName eq "Ann@Example.com"`), nil)
+	res, _ := list["Resources"].([]interface{})
+	if status != http.StatusOK || list["totalResults"] != float64(1) || len(res) != 1 || res[0].(map[string]interface{})["id"] != ann {
+		t.Errorf("filter = %d %v, want ann", status, list)
+	}
+	status, list = s.asAdmin(t, "GET", "/Users?startIndex=2&count=1", nil)
+	res, _ = list["Resources"].([]interface{})
+	if status != http.StatusOK || list["totalResults"] != float64(3) || len(res) != 1 || list["startIndex"] != float64(2) {
+		t.Errorf("second page = %d %v, want 1 of 3 users", status, list)
+	}
+	if status, body := s.asAdmin(t, "GET", "/Users?filter="+url.QueryEscape(`userName sw "a"`), nil); status != http.StatusBadRequest || body["scimType"] != "invalidFilter" {
+		t.Errorf("unsupported filter = %d %v, want 400 invalidFilter", status, body)
+	}
+}
+
+func TestSCIMReplaceAndPatch(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSCIM(t)
+	defer s.server.Close()
+	id := s.create(t, "ann@example.com")
+	s.create(t, "bob")
+
+	if status, body := s.asAdmin(t, "GET", "/Users/"+id, nil); status != http.StatusOK || body["userName"] != "ann@example.com" || body["active"] != true {
+		t.Errorf("get = %d %v, want ann", status, body)
+	}
+	if status, _ := s.asAdmin(t, "GET", "/Users/nope", nil); status != http.StatusNotFound {
+		t.Errorf("get of a missing user = %d, want 404", status)
+	}
+
+	// Replacing drops what the request leaves out.
+	if status, body := s.asAdmin(t, "PUT", "/Users/"+id, map[string]interface{}{"userName": "bob"}); status != http.StatusConflict {
+		t.Errorf("replace with a taken userName = %d %v, want 409", status, body)
+	}
+	if status, body := s.asAdmin(t, "PUT", "/Users/"+id, map[string]interface{}{"userName": "ann", "name": map[string]string{"givenName": "Anna"}}); status != http.StatusOK || body["userName"] != "ann" || body["emails"] != nil {
+		t.Errorf("replace = %d %v, want ann without emails", status, body)
+	}
+
+	// Patching changes only what it names, however identity providers name
+	// it.
+	status, body := s.asAdmin(t, "PATCH", "/Users/"+id, patchOp(
+		map[string]interface{}{"op": "Replace", "path": "name.familyName", "value": "Lee"},
+		map[string]interface{}{"op": "add", "path": `emails[type eq "work"].value`, "value": "anna@example.com"},
+		map[string]interface{}{"op": "replace", "value": map[string]interface{}{scimUserSchema + ":userName": "anna"}},
+	))
+	if u, _ := s.users.GetUser(ctx, id); status != http.StatusOK || u.Username != "anna" || u.FirstName != "Anna" || u.LastName != "Lee" || u.Email != "anna@example.com" {
+		t.Errorf("patch = %d %v, user %+v", status, body, u)
+	}
+	if status, body := s.asAdmin(t, "PATCH", "/Users/"+id, patchOp(map[string]interface{}{"op": "replace", "path": "nickName", "value": "x"})); status != http.StatusBadRequest || body["scimType"] != "invalidPath" {
+		t.Errorf("patch of an unknown attribute = %d %v, want 400 invalidPath", status, body)
+	}
+	if status, _ := s.asAdmin(t, "PATCH", "/Users/"+id, patchOp(map[string]interface{}{"op": "remove", "path": "userName"})); status != http.StatusBadRequest {
+		t.Errorf("removing the userName = %d, want 400", status)
+	}
+
+	// Temporary users stay temporary.
+	s.users.CreateUser(ctx, &User{Id: "temp", Username: "temp", ExpiresAt: time.Now().Add(time.Hour)})
+	s.asAdmin(t, "PUT", "/Users/temp", map[string]interface{}{"userName": "temp2"})
+	if u, _ := s.users.GetUser(ctx, "temp"); u == nil || u.Username != "temp2" || u.ExpiresAt.IsZero() {
+		t.Errorf("replaced temporary user = %+v, want it to keep its expiry", u)
+	}
+}
+
+func TestSCIMDelete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestSCIM(t)
+	defer s.server.Close()
+	ann := s.create(t, "ann")
+	bob := s.create(t, "bob")
+
+	// Deactivating a user deletes it, as does DELETE.
+	status, body := s.asAdmin(t, "PATCH", "/Users/"+ann, patchOp(map[string]interface{}{"op": "replace", "value": map[string]interface{}{"active": "False"}}))
+	if status != http.StatusOK || body["active"] != false {
+		t.Errorf("deactivate = %d %v, want an inactive user", status, body)
+	}
+	if _, err := s.users.GetUser(ctx, ann); err != ErrNotFound {
+		t.Errorf("deactivated user = %v, want ErrNotFound", err)
+	}
+	if status, _ := s.asAdmin(t, "DELETE", "/Users/"+bob, nil); status != http.StatusNoContent {
+		t.Errorf("delete = %d, want 204", status)
+	}
+	if status, _ := s.asAdmin(t, "DELETE", "/Users/"+bob, nil); status != http.StatusNotFound {
+		t.Errorf("delete again = %d, want 404", status)
+	}
+
+	// The userNames of deleted users are free again.
+	s.create(t, "ann")
+	if status, list := s.asAdmin(t, "GET", "/Users", nil); status != http.StatusOK || list["totalResults"] != float64(1) {
+		t.Errorf("list = %d %v, want the new ann only", status, list)
+	}
+}
@@ -3,6 +3,7 @@ package learn
 import (
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -13,33 +14,179 @@ import (
 type UserService interface {
 	CreateUser(cxt context.Context, user *User) (*User, error)
 	GetUser(cxt context.Context, id string) (*User, error)
+	BatchCreateUsers(ctx context.Context, users []*User, mode BatchMode) ([]BatchResult, error)
+	BatchGetUsers(ctx context.Context, ids []string, mode BatchMode) ([]BatchResult, error)
+}
+
+var (
+	// ErrNotFound is returned when no user exists with the requested id.
+	ErrNotFound = errors.New("Could not find user")
+
+	// ErrMissingID is returned for a batch item that has no user id.
+	ErrMissingID = errors.New("User id is required")
+
+	// ErrDuplicateID is returned for a batch item whose id already appeared
+	// earlier in the same batch.
+	ErrDuplicateID = errors.New("Duplicate user id in batch")
+
+	// ErrBatchAborted is returned by an AllOrNothing batch in which at least
+	// one item failed. It is also set on every item that would otherwise have
+	// succeeded, since none of them were applied.
+	ErrBatchAborted = errors.New("Batch aborted, no items were applied")
+)
+
+// BatchMode controls how a batch operation treats failures of individual
+// items.
+type BatchMode int
+
+const (
+	// BestEffort applies every item that can be applied and reports an error
+	// for each item that can't.
+	BestEffort BatchMode = iota
+
+	// AllOrNothing applies the batch only if every item succeeds.
+	AllOrNothing
+)
+
+func (m BatchMode) String() string {
+	if m == AllOrNothing {
+		return "all-or-nothing"
+	}
+
+	return "best-effort"
+}
+
+// BatchResult is the outcome of a single item in a batch operation. Results
+// are returned in the same order as the items in the request.
+type BatchResult struct {
+	User *User
+	Err  error
 }
 
 type basicService struct {
+	mtx   sync.RWMutex
 	Users map[string]*User
+
+	// deleted holds users that expired and were soft deleted. They are
+	// invisible to reads, and are dropped if the id is created again.
+	deleted map[string]*User
+
+	seq         uint64
+	subscribers map[int]func(Change)
+	nextSub     int
 }
 
 func NewBasicService() UserService {
-	return basicService{
-		make(map[string]*User),
+	return &basicService{
+		Users:       make(map[string]*User),
+		deleted:     make(map[string]*User),
+		subscribers: make(map[int]func(Change)),
 	}
 }
 
-func (s basicService) CreateUser(_ context.Context, user *User) (*User, error) {
-	s.Users[user.Id] = user
+func (s *basicService) CreateUser(_ context.Context, user *User) (*User, error) {
+	if user == nil || user.Id == "" {
+		return nil, ErrMissingID
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.put(user)
 
 	return user, nil
 }
 
-func (s basicService) GetUser(_ context.Context, id string) (*User, error) {
+func (s *basicService) GetUser(_ context.Context, id string) (*User, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
 	user, ok := s.Users[id]
-	if !ok {
-		return nil, errors.New("Could not find user")
+	if !ok || user.Expired(time.Now()) {
+		return nil, ErrNotFound
 	}
 
 	return user, nil
 }
 
+func (s *basicService) BatchCreateUsers(_ context.Context, users []*User, mode BatchMode) ([]BatchResult, error) {
+	results := make([]BatchResult, len(users))
+	seen := make(map[string]bool, len(users))
+	failed := false
+	for i, user := range users {
+		switch {
+		case user == nil || user.Id == "":
+			results[i].Err = ErrMissingID
+		case seen[user.Id]:
+			results[i].Err = ErrDuplicateID
+		default:
+			seen[user.Id] = true
+			results[i].User = user
+			continue
+		}
+		failed = true
+	}
+
+	if failed && mode == AllOrNothing {
+		return abortBatch(results), ErrBatchAborted
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, r := range results {
+		if r.Err == nil {
+			s.put(r.User)
+		}
+	}
+
+	return results, nil
+}
+
+func (s *basicService) BatchGetUsers(_ context.Context, ids []string, mode BatchMode) ([]BatchResult, error) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	now := time.Now()
+	results := make([]BatchResult, len(ids))
+	failed := false
+	for i, id := range ids {
+		user, ok := s.Users[id]
+		if !ok || user.Expired(now) {
+			results[i].Err = ErrNotFound
+			failed = true
+			continue
+		}
+		results[i].User = user
+	}
+
+	if failed && mode == AllOrNothing {
+		return abortBatch(results), ErrBatchAborted
+	}
+
+	return results, nil
+}
+
+// put stores a user and publishes its creation. It must be called with the
+// mutex held.
+func (s *basicService) put(user *User) {
+	s.Users[user.Id] = user
+	delete(s.deleted, user.Id)
+	s.publish(Change{Type: UserCreated, User: user})
+}
+
+// abortBatch marks every successful result in an AllOrNothing batch as
+// aborted, leaving the errors of the items that caused the abort in place.
+func abortBatch(results []BatchResult) []BatchResult {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i] = BatchResult{Err: ErrBatchAborted}
+		}
+	}
+
+	return results
+}
+
 type Middleware func(UserService) UserService
 
 func ServiceLoggingMiddleware(logger log.Logger) Middleware {
@@ -80,6 +227,41 @@ func (mw serviceLoggingMiddleware) GetUser(ctx context.Context, id string) (user
 	return mw.next.GetUser(ctx, id)
 }
 
+func (mw serviceLoggingMiddleware) BatchCreateUsers(ctx context.Context, users []*User, mode BatchMode) (results []BatchResult, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "BatchCreateUsers",
+			"count", len(users), "mode", mode, "failed", countFailed(results), "error", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+
+	return mw.next.BatchCreateUsers(ctx, users, mode)
+}
+
+func (mw serviceLoggingMiddleware) BatchGetUsers(ctx context.Context, ids []string, mode BatchMode) (results []BatchResult, err error) {
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "BatchGetUsers",
+			"count", len(ids), "mode", mode, "failed", countFailed(results), "error", err,
+			"took", time.Since(begin),
+		)
+	}(time.Now())
+
+	return mw.next.BatchGetUsers(ctx, ids, mode)
+}
+
+func countFailed(results []BatchResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Err != nil {
+			n++
+		}
+	}
+
+	return n
+}
+
 func ServiceMetricsMiddleware(gets metrics.Counter, creates metrics.Counter) Middleware {
 	return func(next UserService) UserService {
 		return serviceMetricsMiddleware{
@@ -106,10 +288,30 @@ func (mw serviceMetricsMiddleware) GetUser(ctx context.Context, id string) (*Use
 	return mw.next.GetUser(ctx, id)
 }
 
+func (mw serviceMetricsMiddleware) BatchCreateUsers(ctx context.Context, users []*User, mode BatchMode) ([]BatchResult, error) {
+	defer mw.creates.Add(uint64(len(users)))
+	return mw.next.BatchCreateUsers(ctx, users, mode)
+}
+
+func (mw serviceMetricsMiddleware) BatchGetUsers(ctx context.Context, ids []string, mode BatchMode) ([]BatchResult, error) {
+	defer mw.gets.Add(uint64(len(ids)))
+	return mw.next.BatchGetUsers(ctx, ids, mode)
+}
+
 type User struct {
 	Id        string
 	FirstName string
 	LastName  string
 	Email     string
 	Username  string
+
+	// ExpiresAt is when a temporary user stops existing. Expired users
+	// can't be read, and are deleted by the Reaper. The zero time means the
+	// user never expires.
+	ExpiresAt time.Time
+}
+
+// Expired reports whether the user has an expiry time that is not after now.
+func (u *User) Expired(now time.Time) bool {
+	return !u.ExpiresAt.IsZero() && !u.ExpiresAt.After(now)
 }
@@ -0,0 +1,102 @@
+package learn
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestCreateUserMissingID(t *testing.T) {
+	s := NewBasicService()
+	for _, u := range []*User{nil, {FirstName: "Ann"}} {
+		if _, err := s.CreateUser(context.Background(), u); err != ErrMissingID {
+			t.Errorf("CreateUser(%+v) = %v, want %v", u, err, ErrMissingID)
+		}
+	}
+	if n := len(s.(*basicService).Users); n != 0 {
+		t.Errorf("stored %d users", n)
+	}
+}
+
+func TestBatchCreateUsers(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		users   []*User
+		mode    BatchMode
+		err     error
+		results []error
+		stored  []string
+	}{
+		{
+			name:    "best effort applies the valid items",
+			users:   []*User{{Id: "a"}, {Id: ""}, {Id: "a"}, nil, {Id: "b"}},
+			mode:    BestEffort,
+			results: []error{nil, ErrMissingID, ErrDuplicateID, ErrMissingID, nil},
+			stored:  []string{"a", "b"},
+		},
+		{
+			name:    "all or nothing applies none if any fails",
+			users:   []*User{{Id: "a"}, {Id: ""}, {Id: "b"}},
+			mode:    AllOrNothing,
+			err:     ErrBatchAborted,
+			results: []error{ErrBatchAborted, ErrMissingID, ErrBatchAborted},
+		},
+		{
+			name:    "all or nothing applies all if none fails",
+			users:   []*User{{Id: "a"}, {Id: "b"}},
+			mode:    AllOrNothing,
+			results: []error{nil, nil},
+			stored:  []string{"a", "b"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewBasicService()
+			results, err := s.BatchCreateUsers(ctx, tc.users, tc.mode)
+			if err != tc.err {
+				t.Fatalf("err = %v, want %v", err, tc.err)
+			}
+			if len(results) != len(tc.results) {
+				t.Fatalf("got %d results, want %d", len(results), len(tc.results))
+			}
+			for i, want := range tc.results {
+				if results[i].Err != want {
+					t.Errorf("result %d: err = %v, want %v", i, results[i].Err, want)
+				}
+			}
+
+			stored := map[string]bool{}
+			for _, id := range tc.stored {
+				stored[id] = true
+			}
+			for _, id := range []string{"a", "b"} {
+				_, err := s.GetUser(ctx, id)
+				if stored[id] != (err == nil) {
+					t.Errorf("user %s stored = %v, want %v", id, err == nil, stored[id])
+				}
+			}
+		})
+	}
+}
+
+func TestBatchGetUsers(t *testing.T) {
+	ctx := context.Background()
+	s := NewBasicService()
+	s.CreateUser(ctx, &User{Id: "a", FirstName: "Ann"})
+
+	results, err := s.BatchGetUsers(ctx, []string{"a", "missing"}, BestEffort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].User == nil || results[0].User.FirstName != "Ann" || results[1].Err != ErrNotFound {
+		t.Errorf("got %+v", results)
+	}
+
+	results, err = s.BatchGetUsers(ctx, []string{"a", "missing"}, AllOrNothing)
+	if err != ErrBatchAborted {
+		t.Fatalf("err = %v, want %v", err, ErrBatchAborted)
+	}
+	if results[0].Err != ErrBatchAborted || results[1].Err != ErrNotFound {
+		t.Errorf("got %+v", results)
+	}
+}
@@ -0,0 +1,394 @@
+package learn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/go-kit/kit/log"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+)
+
+// TLSFiles keeps a certificate, and the CAs that the certificates of peers
+// are verified with, loaded from PEM files. The files are loaded again
+// whenever they change, so that certificates can be renewed without a
+// restart. Every new connection uses the files loaded last.
+type TLSFiles struct {
+	certFile, keyFile, caFile string
+	logger                    log.Logger
+	quit                      chan struct{}
+
+	mtx    sync.RWMutex
+	cert   *tls.Certificate
+	pool   *x509.CertPool
+	stamps []fileStamp
+}
+
+// NewTLSFiles returns TLSFiles with the certificate and key in certFile and
+// keyFile, and the CAs in caFile, checking every interval whether they
+// changed until it is stopped. Clients may go without a certificate, with
+// empty certFile and keyFile, and without caFile they verify servers with
+// the CAs of the system. A file that fails to load on a reload is logged,
+// and the files loaded last are kept.
+func NewTLSFiles(certFile, keyFile, caFile string, interval time.Duration, logger log.Logger) (*TLSFiles, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("a certificate needs both a certificate and a key file")
+	}
+
+	f := &TLSFiles{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		logger:   logger,
+		quit:     make(chan struct{}),
+	}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	go f.loop(interval)
+	return f, nil
+}
+
+func (f *TLSFiles) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.load(); err != nil {
+				f.logger.Log("err", err)
+			}
+		case <-f.quit:
+			return
+		}
+	}
+}
+
+func (f *TLSFiles) files() []string {
+	var files []string
+	for _, file := range []string{f.certFile, f.keyFile, f.caFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// load loads the files if any of them changed since they were loaded last.
+func (f *TLSFiles) load() error {
+	files := f.files()
+	stamps := make([]fileStamp, len(files))
+	for i, file := range files {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		stamps[i] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+	}
+
+	f.mtx.RLock()
+	changed := f.stamps == nil
+	for i := 0; !changed && i < len(stamps); i++ {
+		changed = !stamps[i].modTime.Equal(f.stamps[i].modTime) || stamps[i].size != f.stamps[i].size
+	}
+	f.mtx.RUnlock()
+	if !changed {
+		return nil
+	}
+
+	var cert *tls.Certificate
+	if f.certFile != "" {
+		c, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if f.caFile != "" {
+		data, err := ioutil.ReadFile(f.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("%s: no certificates found", f.caFile)
+		}
+	}
+
+	f.mtx.Lock()
+	reload := f.stamps != nil
+	f.cert, f.pool, f.stamps = cert, pool, stamps
+	f.mtx.Unlock()
+	if reload {
+		f.logger.Log("msg", "reloaded TLS files")
+	}
+	return nil
+}
+
+// Stop stops checking the files for changes. The files loaded last are
+// kept.
+func (f *TLSFiles) Stop() {
+	close(f.quit)
+}
+
+// ServerConfig returns the TLS config of a server. Clients must present a
+// certificate signed by one of the CAs if clientAuth requires it, such as
+// tls.RequireAndVerifyClientCert for mutual TLS, but only if there is a CA
+// file.
+func (f *TLSFiles) ServerConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return f.serverConfig(clientAuth), nil
+		},
+	}
+}
+
+func (f *TLSFiles) serverConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if f.cert != nil {
+		config.Certificates = []tls.Certificate{*f.cert}
+	}
+	if f.pool != nil {
+		config.ClientCAs = f.pool
+		config.ClientAuth = clientAuth
+	}
+	return config
+}
+
+// ClientConfig returns the TLS config of a client connecting to serverName,
+// presenting the certificate, if there is one, to servers that ask for it.
+func (f *TLSFiles) ClientConfig(serverName string) *tls.Config {
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		RootCAs:    f.pool,
+	}
+	if f.cert != nil {
+		config.Certificates = []tls.Certificate{*f.cert}
+	}
+	return config
+}
+
+// DialTLS dials addr over TLS, verifying the server against the host of
+// addr. It suits the DialTLS of an http.Transport.
+func (f *TLSFiles) DialTLS(network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.Dial(network, addr, f.ClientConfig(host))
+}
+
+// ServerCredentials returns the TransportCredentials of a gRPC server, as
+// described by ServerConfig. Unlike those of credentials.NewTLS, they pick
+// up reloaded files.
+func (f *TLSFiles) ServerCredentials(clientAuth tls.ClientAuthType) credentials.TransportCredentials {
+	return &tlsCredentials{files: f, clientAuth: clientAuth}
+}
+
+// ClientCredentials returns the TransportCredentials of a gRPC client, as
+// described by ClientConfig. If serverName is empty, servers are verified
+// against the host they are dialed at.
+func (f *TLSFiles) ClientCredentials(serverName string) credentials.TransportCredentials {
+	return &tlsCredentials{files: f, serverName: serverName}
+}
+
+// tlsCredentials are the TLS TransportCredentials of TLSFiles, which make a
+// new config for every handshake. The credentials.NewTLS of this gRPC
+// version copies its config field by field, losing GetConfigForClient.
+type tlsCredentials struct {
+	files      *TLSFiles
+	clientAuth tls.ClientAuthType
+	serverName string
+}
+
+func (c *tlsCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{
+		SecurityProtocol: "tls",
+		SecurityVersion:  "1.2",
+		ServerName:       c.serverName,
+	}
+}
+
+func (c *tlsCredentials) ClientHandshake(ctx context.Context, addr string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	serverName := c.serverName
+	if serverName == "" {
+		serverName = addr
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			serverName = host
+		}
+	}
+	config := c.files.ClientConfig(serverName)
+	config.NextProtos = []string{"h2"}
+
+	conn := tls.Client(rawConn, config)
+	errc := make(chan error, 1)
+	go func() { errc <- conn.Handshake() }()
+	select {
+	case err := <-errc:
+		if err != nil {
+			return nil, nil, err
+		}
+	case <-ctx.Done():
+		// Closing the connection also ends the handshake.
+		rawConn.Close()
+		return nil, nil, ctx.Err()
+	}
+	return conn, credentials.TLSInfo{State: conn.ConnectionState()}, nil
+}
+
+func (c *tlsCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	config := c.files.serverConfig(c.clientAuth)
+	config.NextProtos = []string{"h2"}
+
+	conn := tls.Server(rawConn, config)
+	if err := conn.Handshake(); err != nil {
+		return nil, nil, err
+	}
+	return conn, credentials.TLSInfo{State: conn.ConnectionState()}, nil
+}
+
+func (c *tlsCredentials) Clone() credentials.TransportCredentials {
+	clone := *c
+	return &clone
+}
+
+func (c *tlsCredentials) OverrideServerName(serverName string) error {
+	c.serverName = serverName
+	return nil
+}
+
+type clientCertificateContextKey struct{}
+
+// ClientCertificate returns the certificate the caller of a call presented
+// over mutual TLS, if it presented one that was verified, for authorization.
+// CertificateToHTTPContext and CertificateToGRPCContext put it in the
+// context.
+func ClientCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	cert, ok := ctx.Value(clientCertificateContextKey{}).(*x509.Certificate)
+	return cert, ok
+}
+
+// CertificateIdentity returns the identity a certificate was issued to: its
+// first URI name, such as a SPIFFE ID, else its first DNS name, else its
+// common name.
+func CertificateIdentity(cert *x509.Certificate) string {
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.CommonName
+}
+
+// CertificateToHTTPContext moves the verified client certificate of a
+// request to the context. Primarily useful in a server.
+func CertificateToHTTPContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if r.TLS == nil {
+			return ctx
+		}
+		return withVerifiedCertificate(ctx, r.TLS.VerifiedChains)
+	}
+}
+
+// peerCertificateMetadata is the metadata key under which PeerToGRPCMetadata
+// puts the verified client certificate of a call, DER encoded.
+const peerCertificateMetadata = "learn-peer-certificate-bin"
+
+// PeerToGRPCMetadata returns the context of a gRPC call with what its
+// connection tells about the caller copied into its metadata, replacing
+// anything the caller sent under the same keys. A transport/grpc.Server
+// hands its RequestFuncs its own context, so they can't find the peer of the
+// call, only the metadata. MakeGRPCServer and MakeGRPCAuthServer call it on
+// every call. Primarily useful in a server.
+func PeerToGRPCMetadata(ctx context.Context) context.Context {
+	md, ok := metadata.FromContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	delete(md, peerCertificateMetadata)
+	delete(md, peerIPMetadata)
+
+	if p, ok := peer.FromContext(ctx); ok {
+		if p.Addr != nil {
+			if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+				md[peerIPMetadata] = []string{host}
+			}
+		}
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			if chains := info.State.VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 {
+				md[peerCertificateMetadata] = []string{string(chains[0][0].Raw)}
+			}
+		}
+	}
+	return metadata.NewContext(ctx, md)
+}
+
+// CertificateToGRPCContext moves the verified client certificate of the
+// connection of a call, which PeerToGRPCMetadata copied to its metadata, to
+// the context. Primarily useful in a server.
+func CertificateToGRPCContext() grpctransport.RequestFunc {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		raw := (*md)[peerCertificateMetadata]
+		if len(raw) == 0 {
+			return ctx
+		}
+		cert, err := x509.ParseCertificate([]byte(raw[0]))
+		if err != nil {
+			return ctx
+		}
+		return context.WithValue(ctx, clientCertificateContextKey{}, cert)
+	}
+}
+
+func withVerifiedCertificate(ctx context.Context, chains [][]*x509.Certificate) context.Context {
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, clientCertificateContextKey{}, chains[0][0])
+}
+
+// ParseClientAuth parses the client authentication of a server: "none",
+// "optional", which verifies the certificates clients present, or
+// "require".
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(s) {
+	case "none":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("unknown client auth %q", s)
+}
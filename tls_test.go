@@ -0,0 +1,379 @@
+package learn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/briankassouf/learn/pb"
+	"github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/log"
+)
+
+// testCA issues certificates into the files of a directory.
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCA returns a CA whose certificate is in ca.pem in a new directory.
+func newTestCA(t *testing.T) *testCA {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{dir: dir, cert: cert, key: key}
+}
+
+func (ca *testCA) file() string { return filepath.Join(ca.dir, "ca.pem") }
+
+func (ca *testCA) Close() { os.RemoveAll(ca.dir) }
+
+// issue writes a certificate for 127.0.0.1 and dnsName, with serial, to
+// name.crt and name.key, replacing earlier ones atomically. Files of later
+// serials are stamped later, so that reloads see them on file systems with
+// coarse times.
+func (ca *testCA) issue(t *testing.T, name string, serial int64, dnsName string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{dnsName},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(ca.dir, name+".crt"), filepath.Join(ca.dir, name+".key")
+	stamp := time.Now().Add(time.Duration(serial) * time.Second)
+	for _, f := range []struct {
+		path  string
+		block *pem.Block
+	}{
+		{keyFile, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}},
+		{certFile, &pem.Block{Type: "CERTIFICATE", Bytes: der}},
+	} {
+		if err := ioutil.WriteFile(f.path+".tmp", pem.EncodeToMemory(f.block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(f.path+".tmp", stamp, stamp)
+		if err := os.Rename(f.path+".tmp", f.path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+// tlsFiles returns TLSFiles for a certificate issued by ca, or with no
+// certificate if name is empty.
+func (ca *testCA) tlsFiles(t *testing.T, name, dnsName string, interval time.Duration) *TLSFiles {
+	var certFile, keyFile string
+	if name != "" {
+		certFile, keyFile = ca.issue(t, name, 10, dnsName)
+	}
+	files, err := NewTLSFiles(certFile, keyFile, ca.file(), interval, log.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// recordingAuth authenticates with Authenticator, and sends the principals
+// it establishes on principals.
+type recordingAuth struct {
+	Authenticator
+	principals chan<- *Principal
+}
+
+func (a recordingAuth) AuthenticateContext(ctx context.Context) (context.Context, error) {
+	ctx, err := a.Authenticator.AuthenticateContext(ctx)
+	if err == nil {
+		p, _ := PrincipalFromContext(ctx)
+		a.principals <- p
+	}
+	return ctx, err
+}
+
+func TestTLSHTTP(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	server := ca.tlsFiles(t, "server", "server.learn", time.Hour)
+	defer server.Stop()
+	client := ca.tlsFiles(t, "client", "svc.learn", time.Hour)
+	defer client.Stop()
+	anonymous := ca.tlsFiles(t, "", "", time.Hour)
+	defer anonymous.Stop()
+
+	principals := make(chan *Principal, 1)
+	auth := recordingAuth{NewVerifier(SharedSecret("secret"), "", "", 0), principals}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := MakeHTTPHandler(context.Background(), newTestEndpoints(NewBasicService()), nil, auth, log.NewNopLogger())
+	go http.Serve(tls.NewListener(ln, server.ServerConfig(tls.VerifyClientCertIfGiven)), handler)
+	defer ln.Close()
+
+	create := func(files *TLSFiles) int {
+		c := &http.Client{Transport: &http.Transport{DialTLS: files.DialTLS}}
+		resp, err := c.Post("https://"+ln.Addr().String()+"/create", "application/json", strings.NewReader(`{"user":{"id":"a"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The client certificate authenticates calls without a token, though
+	// only admins and service accounts may create users.
+	if code := create(client); code != http.StatusForbidden {
+		t.Fatalf("create with a client certificate = %d, want 403", code)
+	}
+	p := <-principals
+	if p == nil || p.Kind != CertificatePrincipal || p.ID != "svc.learn" {
+		t.Errorf("principal = %+v, want the certificate of svc.learn", p)
+	}
+	if code := create(anonymous); code != http.StatusUnauthorized {
+		t.Errorf("create without a certificate or token = %d, want 401", code)
+	}
+}
+
+func TestTLSGRPC(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	server := ca.tlsFiles(t, "server", "server.learn", time.Hour)
+	defer server.Stop()
+	client := ca.tlsFiles(t, "client", "svc.learn", time.Hour)
+	defer client.Stop()
+	anonymous := ca.tlsFiles(t, "", "", time.Hour)
+	defer anonymous.Stop()
+
+	ctx := context.Background()
+	principals := make(chan *Principal, 1)
+	auth := recordingAuth{NewVerifier(SharedSecret("secret"), "", "", 0), principals}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(grpc.Creds(server.ServerCredentials(tls.RequireAndVerifyClientCert)))
+	pb.RegisterUserServiceServer(s, MakeGRPCServer(ctx, newTestEndpoints(NewBasicService()), nil, nil, nil, nil, auth, log.NewNopLogger()))
+	go s.Serve(ln)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithTransportCredentials(client.ClientCredentials("server.learn")), grpc.WithBlock(), grpc.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := pb.NewUserServiceClient(conn).CreateUser(ctx, &pb.CreateRequest{User: &pb.User{Id: "a"}}); grpc.ErrorDesc(err) != ErrForbidden.Error() {
+		t.Errorf("create with a client certificate = %v, want %v", err, ErrForbidden)
+	}
+	if p := <-principals; p == nil || p.ID != "svc.learn" {
+		t.Errorf("principal = %+v, want the certificate of svc.learn", p)
+	}
+
+	// Certificates sent in the metadata of a call are ignored.
+	forged := metadata.NewContext(ctx, metadata.Pairs(peerCertificateMetadata, string(ca.cert.Raw)))
+	pb.NewUserServiceClient(conn).CreateUser(forged, &pb.CreateRequest{User: &pb.User{Id: "a"}})
+	if p := <-principals; p == nil || p.ID != "svc.learn" {
+		t.Errorf("principal with a certificate in the metadata = %+v, want the certificate of svc.learn", p)
+	}
+
+	// Clients without a certificate are refused, during the handshake or,
+	// with TLS 1.3, on their first call.
+	conn, err = grpc.Dial(ln.Addr().String(), grpc.WithTransportCredentials(anonymous.ClientCredentials("server.learn")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	callCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err := pb.NewUserServiceClient(conn).CreateUser(callCtx, &pb.CreateRequest{User: &pb.User{Id: "b"}}); err == nil {
+		t.Error("call made without a client certificate")
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	server := ca.tlsFiles(t, "server", "server.learn", 10*time.Millisecond)
+	defer server.Stop()
+	client := ca.tlsFiles(t, "", "", time.Hour)
+	defer client.Stop()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", server.ServerConfig(tls.NoClientCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	serial := func() int64 {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), client.ClientConfig("server.learn"))
+		if err != nil {
+			return 0
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if n := serial(); n != 10 {
+		t.Fatalf("serial = %d, want 10", n)
+	}
+
+	// New connections get the renewed certificate.
+	ca.issue(t, "server", 20, "server.learn")
+	deadline := time.Now().Add(5 * time.Second)
+	for serial() != 20 {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate not reloaded after 5s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientHandshakeCancel(t *testing.T) {
+	ca := newTestCA(t)
+	defer ca.Close()
+	client := ca.tlsFiles(t, "", "", time.Hour)
+	defer client.Stop()
+
+	// The server accepts, but never answers the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			defer conn.Close()
+			ioutil.ReadAll(conn)
+		}
+	}()
+
+	rawConn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, _, err := client.ClientCredentials("").ClientHandshake(ctx, ln.Addr().String(), rawConn); err != context.DeadlineExceeded {
+		t.Errorf("handshake = %v, want context.DeadlineExceeded", err)
+	}
+	if _, err := rawConn.Write([]byte("x")); err == nil {
+		t.Error("connection left open after the handshake was abandoned")
+	}
+}
+
+func TestCertificatePrincipal(t *testing.T) {
+	v := NewVerifier(SharedSecret("secret"), "", "", 0)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "svc"}, DNSNames: []string{"svc.learn"}}
+	ctx := withVerifiedCertificate(context.Background(), [][]*x509.Certificate{{cert}})
+
+	ctx2, err := v.AuthenticateContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := PrincipalFromContext(ctx2)
+	if p.ID != "svc.learn" || p.Kind != CertificatePrincipal || len(p.Roles) != 0 {
+		t.Errorf("principal = %+v, want the certificate of svc.learn without roles", p)
+	}
+
+	// A token takes precedence, and is still verified.
+	if _, err := v.AuthenticateContext(context.WithValue(ctx, jwt.JWTTokenContextKey, "garbage")); err != jwt.ErrTokenMalformed {
+		t.Errorf("bad token with a certificate = %v, want jwt.ErrTokenMalformed", err)
+	}
+
+	if _, err := v.AuthenticateContext(context.Background()); err != jwt.ErrTokenContextMissing {
+		t.Errorf("neither token nor certificate = %v, want jwt.ErrTokenContextMissing", err)
+	}
+}
+
+func TestPeerToGRPCMetadata(t *testing.T) {
+	// Without a peer, the certificate and address in the metadata of the
+	// call are dropped.
+	ca := newTestCA(t)
+	defer ca.Close()
+	forged := metadata.NewContext(context.Background(), metadata.MD{
+		peerCertificateMetadata: {string(ca.cert.Raw)},
+		peerIPMetadata:          {"10.0.0.1"},
+		"authorization":         {"Bearer x"},
+	})
+	ctx := PeerToGRPCMetadata(forged)
+	md, _ := metadata.FromContext(ctx)
+	if _, ok := ClientCertificate(CertificateToGRPCContext()(ctx, &md)); ok {
+		t.Error("certificate taken from the metadata of a call")
+	}
+	if _, ok := ClientIPFromContext(ClientIPToGRPCContext()(ctx, &md)); ok {
+		t.Error("client IP taken from the metadata of a call")
+	}
+	if md["authorization"][0] != "Bearer x" {
+		t.Errorf("metadata = %v, want the token kept", md)
+	}
+	if original, _ := metadata.FromContext(forged); len(original[peerCertificateMetadata]) != 1 {
+		t.Error("metadata of the call modified")
+	}
+}
@@ -4,7 +4,14 @@ package learn
 // It utilizes the transport/grpc.Server.
 
 import (
+	"io"
+	"strconv"
+	"time"
+
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/briankassouf/learn/pb"
 	"github.com/go-kit/kit/auth/jwt"
@@ -12,34 +19,67 @@ import (
 	grpctransport "github.com/go-kit/kit/transport/grpc"
 )
 
-func MakeGRPCServer(ctx context.Context, endpoints Endpoints, logger log.Logger) pb.UserServiceServer {
+// MakeGRPCServer makes a set of endpoints available as a gRPC UserServiceServer.
+// The streaming and replication RPCs have no go-kit endpoints: ImportUsers
+// applies its records through importer, ExportUsers reads from exporter,
+// Replicate follows replog, and the Merkle RPCs read from tree. Exports,
+// replication and the Merkle RPCs are only served to callers that auth
+// authenticates, with a token or an API key, and creates and imports only to
+// the admins and service accounts among them, unless it is nil.
+func MakeGRPCServer(ctx context.Context, endpoints Endpoints, importer *Importer, exporter Exporter, replog *ReplicationLog, tree *MerkleTree, auth Authenticator, logger log.Logger) pb.UserServiceServer {
 	options := []grpctransport.ServerOption{grpctransport.ServerErrorLogger(logger)}
 
 	return &grpcServer{
+		importer: importer,
+		exporter: exporter,
+		replog:   replog,
+		tree:     tree,
+		auth:     auth,
 		createUser: grpctransport.NewServer(
 			ctx,
-			endpoints.CreateUserEndpoint,
+			provisioning(auth, endpoints.CreateUserEndpoint),
 			DecodeGRPCCreateUserRequest,
 			EncodeGRPCCreateUserResponse,
-			append(options, grpctransport.ServerBefore(jwt.ToGRPCContext()))...,
+			append(options, grpctransport.ServerBefore(jwt.ToGRPCContext(), APIKeyToGRPCContext(), CertificateToGRPCContext(), IdempotencyKeyToGRPCContext()))...,
 		),
 		getUser: grpctransport.NewServer(
 			ctx,
 			endpoints.GetUserEndpoint,
 			DecodeGRPCGetUserRequest,
 			EncodeGRPCGetUserResponse,
-			options...,
+			append(options, grpctransport.ServerBefore(jwt.ToGRPCContext(), APIKeyToGRPCContext(), CertificateToGRPCContext()))...,
+		),
+		batchCreateUsers: grpctransport.NewServer(
+			ctx,
+			provisioning(auth, endpoints.BatchCreateUsersEndpoint),
+			DecodeGRPCBatchCreateUsersRequest,
+			EncodeGRPCBatchCreateUsersResponse,
+			append(options, grpctransport.ServerBefore(jwt.ToGRPCContext(), APIKeyToGRPCContext(), CertificateToGRPCContext()))...,
+		),
+		batchGetUsers: grpctransport.NewServer(
+			ctx,
+			endpoints.BatchGetUsersEndpoint,
+			DecodeGRPCBatchGetUsersRequest,
+			EncodeGRPCBatchGetUsersResponse,
+			append(options, grpctransport.ServerBefore(jwt.ToGRPCContext(), APIKeyToGRPCContext(), CertificateToGRPCContext()))...,
 		),
 	}
 }
 
 type grpcServer struct {
-	createUser grpctransport.Handler
-	getUser    grpctransport.Handler
+	importer         *Importer
+	exporter         Exporter
+	replog           *ReplicationLog
+	tree             *MerkleTree
+	auth             Authenticator
+	createUser       grpctransport.Handler
+	getUser          grpctransport.Handler
+	batchCreateUsers grpctransport.Handler
+	batchGetUsers    grpctransport.Handler
 }
 
 func (s *grpcServer) CreateUser(ctx context.Context, req *pb.CreateRequest) (*pb.UserResponse, error) {
-	_, rep, err := s.createUser.ServeGRPC(ctx, req)
+	_, rep, err := s.createUser.ServeGRPC(PeerToGRPCMetadata(ctx), req)
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +88,7 @@ func (s *grpcServer) CreateUser(ctx context.Context, req *pb.CreateRequest) (*pb
 }
 
 func (s *grpcServer) GetUser(ctx context.Context, req *pb.GetRequest) (*pb.UserResponse, error) {
-	_, rep, err := s.getUser.ServeGRPC(ctx, req)
+	_, rep, err := s.getUser.ServeGRPC(PeerToGRPCMetadata(ctx), req)
 	if err != nil {
 		return nil, err
 	}
@@ -56,18 +96,272 @@ func (s *grpcServer) GetUser(ctx context.Context, req *pb.GetRequest) (*pb.UserR
 	return rep.(*pb.UserResponse), nil
 }
 
+func (s *grpcServer) BatchCreateUsers(ctx context.Context, req *pb.BatchCreateRequest) (*pb.BatchResponse, error) {
+	_, rep, err := s.batchCreateUsers.ServeGRPC(PeerToGRPCMetadata(ctx), req)
+	if err != nil {
+		return nil, err
+	}
+
+	return rep.(*pb.BatchResponse), nil
+}
+
+func (s *grpcServer) BatchGetUsers(ctx context.Context, req *pb.BatchGetRequest) (*pb.BatchResponse, error) {
+	_, rep, err := s.batchGetUsers.ServeGRPC(PeerToGRPCMetadata(ctx), req)
+	if err != nil {
+		return nil, err
+	}
+
+	return rep.(*pb.BatchResponse), nil
+}
+
+// ImportUsers buffers the records of a client stream and applies them in
+// chunks. The final status is sent once the client closes its side of the
+// stream. If the stream breaks first, records that haven't been applied are
+// dropped, and the client resumes from the acknowledged offset.
+func (s *grpcServer) ImportUsers(stream pb.UserService_ImportUsersServer) error {
+	// The caller is checked once, so that a token can't expire halfway
+	// through.
+	ctx, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	if s.auth != nil {
+		if err := authorizeProvisioning(ctx); err != nil {
+			return grpc.Errorf(codes.PermissionDenied, "%s", err)
+		}
+	}
+
+	var (
+		importID string
+		offset   int64
+		chunk    []*User
+	)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		_, err := s.importer.Apply(ctx, importID, offset, chunk)
+		offset += int64(len(chunk))
+		chunk = nil
+		return err
+	}
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case importID == "":
+			importID, offset = req.ImportId, req.Offset
+		case req.ImportId != importID:
+			return grpc.Errorf(codes.InvalidArgument, "import id changed from %q to %q", importID, req.ImportId)
+		case req.Offset != offset+int64(len(chunk)):
+			return grpc.Errorf(codes.InvalidArgument, "expected offset %d, got %d", offset+int64(len(chunk)), req.Offset)
+		}
+
+		chunk = append(chunk, fromPBUser(req.User))
+		if len(chunk) >= s.importer.ChunkSize() {
+			if err := flush(); err != nil {
+				return grpcImportError(err)
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return grpcImportError(err)
+	}
+	status, err := s.importer.Status(importID)
+	if err != nil {
+		return grpcImportError(err)
+	}
+
+	return stream.SendAndClose(toPBImportStatus(status))
+}
+
+func (s *grpcServer) GetImportStatus(ctx context.Context, req *pb.ImportStatusRequest) (*pb.ImportStatus, error) {
+	if _, err := s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+
+	status, err := s.importer.Status(req.ImportId)
+	if err != nil {
+		return nil, grpcImportError(err)
+	}
+
+	return toPBImportStatus(status), nil
+}
+
+// ExportUsers streams a snapshot of the users matching the request. Send
+// blocks while the client's flow control window is full, so a slow client
+// holds the export back rather than having it buffered on the server. The
+// header carries the head of the replication log as of just before the
+// snapshot, for followers to bootstrap from.
+func (s *grpcServer) ExportUsers(req *pb.ExportRequest, stream pb.UserService_ExportUsersServer) error {
+	ctx, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	if s.replog != nil {
+		seq := strconv.FormatUint(s.replog.Head(), 10)
+		if err := stream.SendHeader(metadata.Pairs(ReplicationSeqMetadata, seq)); err != nil {
+			return err
+		}
+	}
+	r, err := s.exporter.ExportUsers(ctx, DecodeGRPCExportRequest(req))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for {
+		user, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := stream.Send(toPBUser(user)); err != nil {
+			return err
+		}
+	}
+}
+
+// Replicate streams the replication log to a follower, starting after the
+// change it last applied and then following the log as it grows. While
+// there are no new changes, heartbeats with a zero seq carry the latest seq
+// of the log instead.
+func (s *grpcServer) Replicate(req *pb.ReplicateRequest, stream pb.UserService_ReplicateServer) error {
+	if _, err := s.authenticate(stream.Context()); err != nil {
+		return err
+	}
+
+	heartbeat := time.NewTicker(replicationHeartbeat)
+	defer heartbeat.Stop()
+
+	after := req.AfterSeq
+	for {
+		changes, head, wait, err := s.replog.Since(after)
+		if err != nil {
+			return grpc.Errorf(codes.OutOfRange, "%s", err)
+		}
+		if len(changes) > 0 {
+			for _, c := range changes {
+				if err := stream.Send(toPBReplicationEntry(c, head)); err != nil {
+					return err
+				}
+				after = c.Seq
+			}
+			continue
+		}
+
+		select {
+		case <-wait:
+		case <-heartbeat.C:
+			if err := stream.Send(&pb.ReplicationEntry{HeadSeq: head}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// GetMerkleNodes returns hashes of the nodes of the Merkle tree of the
+// users, for a peer to compare with its own.
+func (s *grpcServer) GetMerkleNodes(ctx context.Context, req *pb.MerkleNodesRequest) (*pb.MerkleNodes, error) {
+	if _, err := s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+
+	indices := make([]int, len(req.Indices))
+	for i, index := range req.Indices {
+		indices[i] = int(index)
+	}
+
+	hashes, err := s.tree.MerkleNodes(ctx, int(req.Level), indices)
+	if err == ErrMerkleIndex {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.MerkleNodes{Hashes: hashes}, nil
+}
+
+// GetMerkleBucket returns the users in a bucket of the Merkle tree, soft
+// deleted ones included, for a peer whose bucket differs to repair its own.
+func (s *grpcServer) GetMerkleBucket(ctx context.Context, req *pb.MerkleBucketRequest) (*pb.MerkleBucket, error) {
+	if _, err := s.authenticate(ctx); err != nil {
+		return nil, err
+	}
+
+	live, deleted, err := s.tree.MerkleBucket(ctx, int(req.Bucket))
+	if err == ErrMerkleIndex {
+		return nil, grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	bucket := &pb.MerkleBucket{}
+	for _, u := range live {
+		bucket.Live = append(bucket.Live, toPBUser(u))
+	}
+	for _, u := range deleted {
+		bucket.Deleted = append(bucket.Deleted, toPBUser(u))
+	}
+	return bucket, nil
+}
+
+// authenticate checks the caller of an RPC that has no endpoint for
+// AuthenticationMiddleware to wrap, and returns ctx with its principal.
+// Without auth, every caller is let through.
+func (s *grpcServer) authenticate(ctx context.Context) (context.Context, error) {
+	ctx = PeerToGRPCMetadata(ctx)
+	md, _ := metadata.FromContext(ctx)
+	for _, before := range []grpctransport.RequestFunc{jwt.ToGRPCContext(), APIKeyToGRPCContext(), CertificateToGRPCContext()} {
+		ctx = before(ctx, &md)
+	}
+	if s.auth == nil {
+		return ctx, nil
+	}
+
+	ctx, err := s.auth.AuthenticateContext(ctx)
+	if err != nil {
+		return nil, grpc.Errorf(codes.Unauthenticated, "%s", err)
+	}
+	return ctx, nil
+}
+
+// grpcImportError gives import errors a status code, so that clients can
+// tell which ones are worth retrying.
+func grpcImportError(err error) error {
+	switch err {
+	case ErrMissingImportID, ErrImportOffset:
+		return grpc.Errorf(codes.InvalidArgument, "%s", err)
+	}
+	if isAuthError(err) {
+		return grpc.Errorf(codes.Unauthenticated, "%s", err)
+	}
+
+	return grpc.Errorf(codes.Unknown, "%s", err)
+}
+
 // DecodeGRPCCreateUserRequest is a transport/grpc.DecodeRequestFunc that converts a
 // gRPC create user request to a user-domain create user request. Primarily useful in a server.
 func DecodeGRPCCreateUserRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
 	req := grpcReq.(*pb.CreateRequest)
 	return CreateUserRequest{
-		User: &User{
-			req.User.Id,
-			req.User.FirstName,
-			req.User.LastName,
-			req.User.Email,
-			req.User.Username,
-		},
+		User: fromPBUser(req.User),
 	}, nil
 }
 
@@ -83,14 +377,8 @@ func DecodeGRPCGetUserRequest(_ context.Context, grpcReq interface{}) (interface
 func DecodeGRPCCreateUserResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
 	reply := grpcReply.(*pb.UserResponse)
 	return CreateUserResponse{
-		User: &User{
-			reply.User.Id,
-			reply.User.FirstName,
-			reply.User.LastName,
-			reply.User.Email,
-			reply.User.Username,
-		},
-		Err: nil,
+		User: fromPBUser(reply.User),
+		Err:  nil,
 	}, nil
 }
 
@@ -99,14 +387,8 @@ func DecodeGRPCCreateUserResponse(_ context.Context, grpcReply interface{}) (int
 func DecodeGRPCGetUserResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
 	reply := grpcReply.(*pb.UserResponse)
 	return GetUserResponse{
-		User: &User{
-			reply.User.Id,
-			reply.User.FirstName,
-			reply.User.LastName,
-			reply.User.Email,
-			reply.User.Username,
-		},
-		Err: nil,
+		User: fromPBUser(reply.User),
+		Err:  nil,
 	}, nil
 }
 
@@ -115,13 +397,7 @@ func DecodeGRPCGetUserResponse(_ context.Context, grpcReply interface{}) (interf
 func EncodeGRPCCreateUserResponse(_ context.Context, response interface{}) (interface{}, error) {
 	resp := response.(CreateUserResponse)
 	return &pb.UserResponse{
-		User: &pb.User{
-			resp.User.Id,
-			resp.User.FirstName,
-			resp.User.LastName,
-			resp.User.Email,
-			resp.User.Username,
-		},
+		User: toPBUser(resp.User),
 	}, nil
 }
 
@@ -130,13 +406,7 @@ func EncodeGRPCCreateUserResponse(_ context.Context, response interface{}) (inte
 func EncodeGRPCGetUserResponse(_ context.Context, response interface{}) (interface{}, error) {
 	resp := response.(GetUserResponse)
 	return &pb.UserResponse{
-		User: &pb.User{
-			resp.User.Id,
-			resp.User.FirstName,
-			resp.User.LastName,
-			resp.User.Email,
-			resp.User.Username,
-		},
+		User: toPBUser(resp.User),
 	}, nil
 }
 
@@ -145,13 +415,7 @@ func EncodeGRPCGetUserResponse(_ context.Context, response interface{}) (interfa
 func EncodeGRPCCreateUserRequest(_ context.Context, request interface{}) (interface{}, error) {
 	req := request.(CreateUserRequest)
 	return &pb.CreateRequest{
-		User: &pb.User{
-			req.User.Id,
-			req.User.FirstName,
-			req.User.LastName,
-			req.User.Email,
-			req.User.Username,
-		},
+		User: toPBUser(req.User),
 	}, nil
 }
 
@@ -163,3 +427,587 @@ func EncodeGRPCGetUserRequest(_ context.Context, request interface{}) (interface
 		Id: req.Id,
 	}, nil
 }
+
+// DecodeGRPCBatchCreateUsersRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC batch create request to a user-domain batch create request. Primarily useful in a server.
+func DecodeGRPCBatchCreateUsersRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.BatchCreateRequest)
+	users := make([]*User, len(req.Users))
+	for i, u := range req.Users {
+		users[i] = fromPBUser(u)
+	}
+	return BatchCreateUsersRequest{
+		Users: users,
+		Mode:  BatchMode(req.Mode),
+	}, nil
+}
+
+// DecodeGRPCBatchGetUsersRequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC batch get request to a user-domain batch get request. Primarily useful in a server.
+func DecodeGRPCBatchGetUsersRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.BatchGetRequest)
+	return BatchGetUsersRequest{
+		Ids:  req.Ids,
+		Mode: BatchMode(req.Mode),
+	}, nil
+}
+
+// DecodeGRPCBatchCreateUsersResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC batch response to a user-domain batch create response. Primarily useful in a client.
+func DecodeGRPCBatchCreateUsersResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.BatchResponse)
+	return BatchCreateUsersResponse{
+		Results: fromPBBatchResults(reply.Results),
+		Err:     errorFromString(reply.Error),
+	}, nil
+}
+
+// DecodeGRPCBatchGetUsersResponse is a transport/grpc.DecodeResponseFunc that converts a
+// gRPC batch response to a user-domain batch get response. Primarily useful in a client.
+func DecodeGRPCBatchGetUsersResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.BatchResponse)
+	return BatchGetUsersResponse{
+		Results: fromPBBatchResults(reply.Results),
+		Err:     errorFromString(reply.Error),
+	}, nil
+}
+
+// EncodeGRPCBatchCreateUsersResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain batch create response to a gRPC batch reply. Primarily useful in a server.
+func EncodeGRPCBatchCreateUsersResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(BatchCreateUsersResponse)
+	return &pb.BatchResponse{
+		Results: toPBBatchResults(resp.Results),
+		Error:   errorToString(resp.Err),
+	}, nil
+}
+
+// EncodeGRPCBatchGetUsersResponse is a transport/grpc.EncodeResponseFunc that converts a
+// user-domain batch get response to a gRPC batch reply. Primarily useful in a server.
+func EncodeGRPCBatchGetUsersResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(BatchGetUsersResponse)
+	return &pb.BatchResponse{
+		Results: toPBBatchResults(resp.Results),
+		Error:   errorToString(resp.Err),
+	}, nil
+}
+
+// EncodeGRPCBatchCreateUsersRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain batch create request to a gRPC batch create request. Primarily useful in a client.
+func EncodeGRPCBatchCreateUsersRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(BatchCreateUsersRequest)
+	users := make([]*pb.User, len(req.Users))
+	for i, u := range req.Users {
+		users[i] = toPBUser(u)
+	}
+	return &pb.BatchCreateRequest{
+		Users: users,
+		Mode:  pb.BatchMode(req.Mode),
+	}, nil
+}
+
+// EncodeGRPCBatchGetUsersRequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain batch get request to a gRPC batch get request. Primarily useful in a client.
+func EncodeGRPCBatchGetUsersRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(BatchGetUsersRequest)
+	return &pb.BatchGetRequest{
+		Ids:  req.Ids,
+		Mode: pb.BatchMode(req.Mode),
+	}, nil
+}
+
+// DecodeGRPCReplicationEntry converts a gRPC replication entry to the change
+// it carries and the latest seq of the leader. A heartbeat decodes to a
+// Change with a zero Seq. Primarily useful in a client.
+func DecodeGRPCReplicationEntry(e *pb.ReplicationEntry) (Change, uint64) {
+	if e.Seq == 0 {
+		return Change{}, e.HeadSeq
+	}
+	return Change{
+		Seq:  e.Seq,
+		Type: ChangeType(e.Type),
+		User: fromPBUser(e.User),
+		Time: time.Unix(0, e.Time).UTC(),
+		Hard: e.Hard,
+	}, e.HeadSeq
+}
+
+func toPBReplicationEntry(c Change, head uint64) *pb.ReplicationEntry {
+	return &pb.ReplicationEntry{
+		Seq:     c.Seq,
+		Type:    pb.ChangeType(c.Type),
+		User:    toPBUser(c.User),
+		Time:    c.Time.UnixNano(),
+		Hard:    c.Hard,
+		HeadSeq: head,
+	}
+}
+
+// toPBUser converts a user-domain user to its gRPC representation. A nil
+// user stays nil, and a zero ExpiresAt is sent as 0, meaning never. Expiry
+// times are sent with a resolution of one second.
+func toPBUser(u *User) *pb.User {
+	if u == nil {
+		return nil
+	}
+	pbUser := &pb.User{
+		Id:        u.Id,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Email:     u.Email,
+		Username:  u.Username,
+	}
+	if !u.ExpiresAt.IsZero() {
+		pbUser.ExpiresAt = u.ExpiresAt.Unix()
+	}
+	return pbUser
+}
+
+// fromPBUser is the inverse of toPBUser.
+func fromPBUser(u *pb.User) *User {
+	if u == nil {
+		return nil
+	}
+	user := &User{
+		Id:        u.Id,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Email:     u.Email,
+		Username:  u.Username,
+	}
+	if u.ExpiresAt != 0 {
+		user.ExpiresAt = time.Unix(u.ExpiresAt, 0).UTC()
+	}
+	return user
+}
+
+func toPBBatchResults(results []BatchResult) []*pb.BatchResult {
+	out := make([]*pb.BatchResult, len(results))
+	for i, r := range results {
+		out[i] = &pb.BatchResult{
+			User:  toPBUser(r.User),
+			Error: errorToString(r.Err),
+		}
+	}
+	return out
+}
+
+func fromPBBatchResults(results []*pb.BatchResult) []BatchResult {
+	out := make([]BatchResult, len(results))
+	for i, r := range results {
+		out[i] = BatchResult{
+			User: fromPBUser(r.User),
+			Err:  errorFromString(r.Error),
+		}
+	}
+	return out
+}
+
+// EncodeGRPCImportRequest converts a single record of a user-domain import to
+// a gRPC import request. Primarily useful in a client.
+func EncodeGRPCImportRequest(importID string, offset int64, user *User) *pb.ImportRequest {
+	return &pb.ImportRequest{
+		ImportId: importID,
+		Offset:   offset,
+		User:     toPBUser(user),
+	}
+}
+
+// DecodeGRPCImportStatus converts a gRPC import status to a user-domain import
+// status. Primarily useful in a client.
+func DecodeGRPCImportStatus(status *pb.ImportStatus) ImportStatus {
+	failures := make([]ImportFailure, len(status.Failures))
+	for i, f := range status.Failures {
+		failures[i] = ImportFailure{
+			Offset: f.Offset,
+			Id:     f.Id,
+			Err:    errorFromString(f.Error),
+		}
+	}
+	return ImportStatus{
+		ImportId: status.ImportId,
+		Acked:    status.Acked,
+		Applied:  status.Applied,
+		Failed:   status.Failed,
+		Failures: failures,
+	}
+}
+
+func toPBImportStatus(status ImportStatus) *pb.ImportStatus {
+	failures := make([]*pb.ImportFailure, len(status.Failures))
+	for i, f := range status.Failures {
+		failures[i] = &pb.ImportFailure{
+			Offset: f.Offset,
+			Id:     f.Id,
+			Error:  errorToString(f.Err),
+		}
+	}
+	return &pb.ImportStatus{
+		ImportId: status.ImportId,
+		Acked:    status.Acked,
+		Applied:  status.Applied,
+		Failed:   status.Failed,
+		Failures: failures,
+	}
+}
+
+// EncodeGRPCExportRequest converts a user-domain export filter to a gRPC
+// export request. Primarily useful in a client.
+func EncodeGRPCExportRequest(filter ExportFilter) *pb.ExportRequest {
+	return &pb.ExportRequest{
+		IdPrefix:    filter.IdPrefix,
+		EmailDomain: filter.EmailDomain,
+		After:       filter.After,
+	}
+}
+
+// DecodeGRPCExportRequest converts a gRPC export request to a user-domain
+// export filter. Primarily useful in a server.
+func DecodeGRPCExportRequest(req *pb.ExportRequest) ExportFilter {
+	return ExportFilter{
+		IdPrefix:    req.IdPrefix,
+		EmailDomain: req.EmailDomain,
+		After:       req.After,
+	}
+}
+
+// DecodeGRPCUser converts a gRPC user to a user-domain user. Primarily useful
+// in a client.
+func DecodeGRPCUser(user *pb.User) *User {
+	return fromPBUser(user)
+}
+
+// MakeGRPCAuthServer makes the endpoints of an AuthService available as a
+// gRPC AuthServiceServer. As with MakeAuthHTTPHandler, every RPC but
+// Authenticate, VerifyMFA and Refresh acts for the principal that auth
+// authenticates.
+func MakeGRPCAuthServer(ctx context.Context, endpoints AuthEndpoints, auth Authenticator, logger log.Logger) pb.AuthServiceServer {
+	options := []grpctransport.ServerOption{grpctransport.ServerErrorLogger(logger)}
+	loginOptions := append(options, grpctransport.ServerBefore(ClientIPToGRPCContext()))
+	authOptions := append(options, grpctransport.ServerBefore(jwt.ToGRPCContext(), APIKeyToGRPCContext(), CertificateToGRPCContext()))
+
+	return &grpcAuthServer{
+		authenticate: grpctransport.NewServer(
+			ctx,
+			endpoints.AuthenticateEndpoint,
+			DecodeGRPCAuthenticateRequest,
+			EncodeGRPCAuthResponse,
+			loginOptions...,
+		),
+		verifyMFA: grpctransport.NewServer(
+			ctx,
+			endpoints.VerifyMFAEndpoint,
+			DecodeGRPCVerifyMFARequest,
+			EncodeGRPCAuthResponse,
+			loginOptions...,
+		),
+		refresh: grpctransport.NewServer(
+			ctx,
+			endpoints.RefreshEndpoint,
+			DecodeGRPCRefreshRequest,
+			EncodeGRPCAuthResponse,
+			options...,
+		),
+		setPassword: grpctransport.NewServer(
+			ctx,
+			authenticated(auth, endpoints.SetPasswordEndpoint),
+			DecodeGRPCSetPasswordRequest,
+			EncodeGRPCAuthResponse,
+			authOptions...,
+		),
+		listSessions: grpctransport.NewServer(
+			ctx,
+			authenticated(auth, endpoints.ListSessionsEndpoint),
+			DecodeGRPCSessionsRequest,
+			EncodeGRPCAuthResponse,
+			authOptions...,
+		),
+		revokeSession: grpctransport.NewServer(
+			ctx,
+			authenticated(auth, endpoints.RevokeSessionEndpoint),
+			DecodeGRPCSessionsRequest,
+			EncodeGRPCAuthResponse,
+			authOptions...,
+		),
+		revokeSessions: grpctransport.NewServer(
+			ctx,
+			authenticated(auth, endpoints.RevokeSessionsEndpoint),
+			DecodeGRPCSessionsRequest,
+			EncodeGRPCAuthResponse,
+			authOptions...,
+		),
+		enrollMFA: grpctransport.NewServer(
+			ctx,
+			authenticated(auth, endpoints.EnrollMFAEndpoint),
+			DecodeGRPCMFARequest,
+			EncodeGRPCAuthResponse,
+			authOptions...,
+		),
+		confirmMFA: grpctransport.NewServer(
+			ctx,
+			authenticated(auth, endpoints.ConfirmMFAEndpoint),
+			DecodeGRPCMFARequest,
+			EncodeGRPCAuthResponse,
+			authOptions...,
+		),
+		disableMFA: grpctransport.NewServer(
+			ctx,
+			authenticated(auth, endpoints.DisableMFAEndpoint),
+			DecodeGRPCMFARequest,
+			EncodeGRPCAuthResponse,
+			authOptions...,
+		),
+		unlock: grpctransport.NewServer(
+			ctx,
+			authenticated(auth, endpoints.UnlockEndpoint),
+			DecodeGRPCUnlockRequest,
+			EncodeGRPCAuthResponse,
+			authOptions...,
+		),
+	}
+}
+
+type grpcAuthServer struct {
+	authenticate   grpctransport.Handler
+	verifyMFA      grpctransport.Handler
+	refresh        grpctransport.Handler
+	setPassword    grpctransport.Handler
+	listSessions   grpctransport.Handler
+	revokeSession  grpctransport.Handler
+	revokeSessions grpctransport.Handler
+	enrollMFA      grpctransport.Handler
+	confirmMFA     grpctransport.Handler
+	disableMFA     grpctransport.Handler
+	unlock         grpctransport.Handler
+}
+
+func (s *grpcAuthServer) Authenticate(ctx context.Context, req *pb.AuthenticateRequest) (*pb.AuthResponse, error) {
+	return serveGRPCAuth(ctx, s.authenticate, req)
+}
+
+func (s *grpcAuthServer) Refresh(ctx context.Context, req *pb.RefreshRequest) (*pb.AuthResponse, error) {
+	return serveGRPCAuth(ctx, s.refresh, req)
+}
+
+func (s *grpcAuthServer) SetPassword(ctx context.Context, req *pb.SetPasswordRequest) (*pb.AuthResponse, error) {
+	return serveGRPCAuth(ctx, s.setPassword, req)
+}
+
+func (s *grpcAuthServer) ListSessions(ctx context.Context, req *pb.SessionsRequest) (*pb.AuthResponse, error) {
+	return serveGRPCAuth(ctx, s.listSessions, req)
+}
+
+func (s *grpcAuthServer) RevokeSession(ctx context.Context, req *pb.SessionsRequest) (*pb.AuthResponse, error) {
+	return serveGRPCAuth(ctx, s.revokeSession, req)
+}
+
+func (s *grpcAuthServer) RevokeSessions(ctx context.Context, req *pb.SessionsRequest) (*pb.AuthResponse, error) {
+	return serveGRPCAuth(ctx, s.revokeSessions, req)
+}
+
+func (s *grpcAuthServer) VerifyMFA(ctx context.Context, req *pb.VerifyMFARequest) (*pb.AuthResponse, error) {
+	return serveGRPCAuth(ctx, s.verifyMFA, req)
+}
+
+func (s *grpcAuthServer) EnrollMFA(ctx context.Context, req *pb.MFARequest) (*pb.AuthResponse, error) {
+	return serveGRPCAuth(ctx, s.enrollMFA, req)
+}
+
+func (s *grpcAuthServer) ConfirmMFA(ctx context.Context, req *pb.MFARequest) (*pb.AuthResponse, error) {
+	return serveGRPCAuth(ctx, s.confirmMFA, req)
+}
+
+func (s *grpcAuthServer) DisableMFA(ctx context.Context, req *pb.MFARequest) (*pb.AuthResponse, error) {
+	return serveGRPCAuth(ctx, s.disableMFA, req)
+}
+
+func (s *grpcAuthServer) Unlock(ctx context.Context, req *pb.UnlockRequest) (*pb.AuthResponse, error) {
+	return serveGRPCAuth(ctx, s.unlock, req)
+}
+
+// serveGRPCAuth serves req with h, giving failures to authenticate the
+// caller their status code.
+func serveGRPCAuth(ctx context.Context, h grpctransport.Handler, req interface{}) (*pb.AuthResponse, error) {
+	_, rep, err := h.ServeGRPC(PeerToGRPCMetadata(ctx), req)
+	if err != nil {
+		if isAuthError(err) {
+			return nil, grpc.Errorf(codes.Unauthenticated, "%s", err)
+		}
+		return nil, err
+	}
+
+	return rep.(*pb.AuthResponse), nil
+}
+
+// DecodeGRPCAuthenticateRequest is a transport/grpc.DecodeRequestFunc that
+// converts a gRPC authenticate request to a user-domain one. Primarily useful
+// in a server.
+func DecodeGRPCAuthenticateRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.AuthenticateRequest)
+	return AuthenticateRequest{Username: req.Username, Password: req.Password}, nil
+}
+
+// DecodeGRPCRefreshRequest is a transport/grpc.DecodeRequestFunc that converts
+// a gRPC refresh request to a user-domain one. Primarily useful in a server.
+func DecodeGRPCRefreshRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.RefreshRequest)
+	return RefreshRequest{RefreshToken: req.RefreshToken}, nil
+}
+
+// DecodeGRPCSetPasswordRequest is a transport/grpc.DecodeRequestFunc that
+// converts a gRPC set password request to a user-domain one. Primarily useful
+// in a server.
+func DecodeGRPCSetPasswordRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.SetPasswordRequest)
+	return SetPasswordRequest{UserID: req.UserId, Password: req.Password}, nil
+}
+
+// DecodeGRPCSessionsRequest is a transport/grpc.DecodeRequestFunc that
+// converts a gRPC sessions request to a user-domain one. Primarily useful in
+// a server.
+func DecodeGRPCSessionsRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.SessionsRequest)
+	return SessionsRequest{UserID: req.UserId, SessionID: req.SessionId}, nil
+}
+
+// DecodeGRPCVerifyMFARequest is a transport/grpc.DecodeRequestFunc that
+// converts a gRPC verify MFA request to a user-domain one. Primarily useful
+// in a server.
+func DecodeGRPCVerifyMFARequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.VerifyMFARequest)
+	return VerifyMFARequest{Challenge: req.Challenge, Code: req.Code}, nil
+}
+
+// DecodeGRPCMFARequest is a transport/grpc.DecodeRequestFunc that converts a
+// gRPC MFA request to a user-domain one. Primarily useful in a server.
+func DecodeGRPCMFARequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.MFARequest)
+	return MFARequest{UserID: req.UserId, Code: req.Code}, nil
+}
+
+// DecodeGRPCUnlockRequest is a transport/grpc.DecodeRequestFunc that converts
+// a gRPC unlock request to a user-domain one. Primarily useful in a server.
+func DecodeGRPCUnlockRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.UnlockRequest)
+	return UnlockRequest{Username: req.Username, IP: req.Ip}, nil
+}
+
+// EncodeGRPCAuthenticateRequest is a transport/grpc.EncodeRequestFunc that
+// converts a user-domain authenticate request to a gRPC one. Primarily useful
+// in a client.
+func EncodeGRPCAuthenticateRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(AuthenticateRequest)
+	return &pb.AuthenticateRequest{Username: req.Username, Password: req.Password}, nil
+}
+
+// EncodeGRPCRefreshRequest is a transport/grpc.EncodeRequestFunc that converts
+// a user-domain refresh request to a gRPC one. Primarily useful in a client.
+func EncodeGRPCRefreshRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(RefreshRequest)
+	return &pb.RefreshRequest{RefreshToken: req.RefreshToken}, nil
+}
+
+// EncodeGRPCSetPasswordRequest is a transport/grpc.EncodeRequestFunc that
+// converts a user-domain set password request to a gRPC one. Primarily useful
+// in a client.
+func EncodeGRPCSetPasswordRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(SetPasswordRequest)
+	return &pb.SetPasswordRequest{UserId: req.UserID, Password: req.Password}, nil
+}
+
+// EncodeGRPCSessionsRequest is a transport/grpc.EncodeRequestFunc that
+// converts a user-domain sessions request to a gRPC one. Primarily useful in
+// a client.
+func EncodeGRPCSessionsRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(SessionsRequest)
+	return &pb.SessionsRequest{UserId: req.UserID, SessionId: req.SessionID}, nil
+}
+
+// EncodeGRPCVerifyMFARequest is a transport/grpc.EncodeRequestFunc that
+// converts a user-domain verify MFA request to a gRPC one. Primarily useful
+// in a client.
+func EncodeGRPCVerifyMFARequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(VerifyMFARequest)
+	return &pb.VerifyMFARequest{Challenge: req.Challenge, Code: req.Code}, nil
+}
+
+// EncodeGRPCMFARequest is a transport/grpc.EncodeRequestFunc that converts a
+// user-domain MFA request to a gRPC one. Primarily useful in a client.
+func EncodeGRPCMFARequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(MFARequest)
+	return &pb.MFARequest{UserId: req.UserID, Code: req.Code}, nil
+}
+
+// EncodeGRPCUnlockRequest is a transport/grpc.EncodeRequestFunc that converts
+// a user-domain unlock request to a gRPC one. Primarily useful in a client.
+func EncodeGRPCUnlockRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(UnlockRequest)
+	return &pb.UnlockRequest{Username: req.Username, Ip: req.IP}, nil
+}
+
+// EncodeGRPCAuthResponse is a transport/grpc.EncodeResponseFunc that converts
+// a user-domain auth response to a gRPC one. Times are sent with a resolution
+// of one second. Primarily useful in a server.
+func EncodeGRPCAuthResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(AuthResponse)
+	reply := &pb.AuthResponse{Error: errorToString(resp.Err), RecoveryCodes: resp.RecoveryCodes}
+	if t := resp.Tokens; t != nil {
+		reply.Tokens = &pb.Tokens{
+			AccessToken:  t.AccessToken,
+			RefreshToken: t.RefreshToken,
+			SessionId:    t.SessionID,
+			Challenge:    t.Challenge,
+			ExpiresAt:    t.Expires.Unix(),
+		}
+	}
+	if e := resp.Enrollment; e != nil {
+		reply.Enrollment = &pb.MFAEnrollment{Secret: e.Secret, Uri: e.URI}
+	}
+	for _, v := range passwordViolations(resp.Err) {
+		reply.Violations = append(reply.Violations, &pb.PasswordViolation{Rule: v.Rule, Message: v.Message})
+	}
+	for _, s := range resp.Sessions {
+		reply.Sessions = append(reply.Sessions, &pb.Session{
+			Id:        s.ID,
+			UserId:    s.UserID,
+			Created:   s.Created.Unix(),
+			LastUsed:  s.LastUsed.Unix(),
+			ExpiresAt: s.Expires.Unix(),
+		})
+	}
+	return reply, nil
+}
+
+// DecodeGRPCAuthResponse is a transport/grpc.DecodeResponseFunc that converts
+// a gRPC auth response to a user-domain one. Primarily useful in a client.
+func DecodeGRPCAuthResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.AuthResponse)
+	resp := AuthResponse{Err: errorFromString(reply.Error), RecoveryCodes: reply.RecoveryCodes}
+	if t := reply.Tokens; t != nil {
+		resp.Tokens = &Tokens{
+			AccessToken:  t.AccessToken,
+			RefreshToken: t.RefreshToken,
+			SessionID:    t.SessionId,
+			Challenge:    t.Challenge,
+			Expires:      time.Unix(t.ExpiresAt, 0).UTC(),
+		}
+	}
+	if e := reply.Enrollment; e != nil {
+		resp.Enrollment = &MFAEnrollment{Secret: e.Secret, URI: e.Uri}
+	}
+	var violations []PasswordViolation
+	for _, v := range reply.Violations {
+		violations = append(violations, PasswordViolation{Rule: v.Rule, Message: v.Message})
+	}
+	resp.Err = withPasswordViolations(resp.Err, violations)
+	for _, s := range reply.Sessions {
+		resp.Sessions = append(resp.Sessions, Session{
+			ID:       s.Id,
+			UserID:   s.UserId,
+			Created:  time.Unix(s.Created, 0).UTC(),
+			LastUsed: time.Unix(s.LastUsed, 0).UTC(),
+			Expires:  time.Unix(s.ExpiresAt, 0).UTC(),
+		})
+	}
+	return resp, nil
+}
@@ -6,10 +6,12 @@ package learn
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -19,8 +21,11 @@ import (
 )
 
 // MakeHTTPHandler returns a handler that makes a set of endpoints available
-// on predefined paths.
-func MakeHTTPHandler(ctx context.Context, endpoints Endpoints, logger log.Logger) http.Handler {
+// on predefined paths, along with a streaming export of the users in
+// exporter. Exports are only made by callers that auth authenticates, with
+// a token or an API key, and creates only by the admins and service accounts
+// among them, unless it is nil.
+func MakeHTTPHandler(ctx context.Context, endpoints Endpoints, exporter Exporter, auth Authenticator, logger log.Logger) http.Handler {
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorEncoder(errorEncoder),
 		httptransport.ServerErrorLogger(logger),
@@ -28,21 +33,500 @@ func MakeHTTPHandler(ctx context.Context, endpoints Endpoints, logger log.Logger
 	m := http.NewServeMux()
 	m.Handle("/create", httptransport.NewServer(
 		ctx,
-		endpoints.CreateUserEndpoint,
+		provisioning(auth, endpoints.CreateUserEndpoint),
 		DecodeHTTPCreateUserRequest,
 		EncodeHTTPGenericResponse,
-		append(options, httptransport.ServerBefore(jwt.ToHTTPContext()))...,
+		append(options, httptransport.ServerBefore(jwt.ToHTTPContext(), APIKeyToHTTPContext(), CertificateToHTTPContext(), IdempotencyKeyToHTTPContext()))...,
 	))
 	m.Handle("/get", httptransport.NewServer(
 		ctx,
 		endpoints.GetUserEndpoint,
 		DecodeHTTPGetUserRequest,
 		EncodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(jwt.ToHTTPContext(), APIKeyToHTTPContext(), CertificateToHTTPContext()))...,
+	))
+	m.Handle("/batch/create", httptransport.NewServer(
+		ctx,
+		provisioning(auth, endpoints.BatchCreateUsersEndpoint),
+		DecodeHTTPBatchCreateUsersRequest,
+		EncodeHTTPBatchCreateUsersResponse,
+		append(options, httptransport.ServerBefore(jwt.ToHTTPContext(), APIKeyToHTTPContext(), CertificateToHTTPContext()))...,
+	))
+	m.Handle("/batch/get", httptransport.NewServer(
+		ctx,
+		endpoints.BatchGetUsersEndpoint,
+		DecodeHTTPBatchGetUsersRequest,
+		EncodeHTTPBatchGetUsersResponse,
+		append(options, httptransport.ServerBefore(jwt.ToHTTPContext(), APIKeyToHTTPContext(), CertificateToHTTPContext()))...,
+	))
+	m.Handle("/export", exportHandler{ctx: ctx, exporter: exporter, auth: auth, logger: logger})
+	return m
+}
+
+// MakeAuthHTTPHandler returns a handler that makes the endpoints of an
+// AuthService available under /auth/. Logging in, completing MFA challenges
+// and refreshing need no credentials; the other operations act for the
+// principal that auth authenticates, and are forbidden without one.
+func MakeAuthHTTPHandler(ctx context.Context, endpoints AuthEndpoints, auth Authenticator, logger log.Logger) http.Handler {
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(errorEncoder),
+		httptransport.ServerErrorLogger(logger),
+	}
+	loginOptions := append(options, httptransport.ServerBefore(ClientIPToHTTPContext()))
+	authOptions := append(options, httptransport.ServerBefore(jwt.ToHTTPContext(), APIKeyToHTTPContext(), CertificateToHTTPContext()))
+
+	m := http.NewServeMux()
+	m.Handle("/auth/login", httptransport.NewServer(
+		ctx,
+		endpoints.AuthenticateEndpoint,
+		DecodeHTTPAuthenticateRequest,
+		EncodeHTTPAuthResponse,
+		loginOptions...,
+	))
+	m.Handle("/auth/mfa/verify", httptransport.NewServer(
+		ctx,
+		endpoints.VerifyMFAEndpoint,
+		DecodeHTTPVerifyMFARequest,
+		EncodeHTTPAuthResponse,
+		loginOptions...,
+	))
+	m.Handle("/auth/refresh", httptransport.NewServer(
+		ctx,
+		endpoints.RefreshEndpoint,
+		DecodeHTTPRefreshRequest,
+		EncodeHTTPAuthResponse,
 		options...,
 	))
+	m.Handle("/auth/password", httptransport.NewServer(
+		ctx,
+		authenticated(auth, endpoints.SetPasswordEndpoint),
+		DecodeHTTPSetPasswordRequest,
+		EncodeHTTPAuthResponse,
+		authOptions...,
+	))
+	m.Handle("/auth/sessions", httptransport.NewServer(
+		ctx,
+		authenticated(auth, endpoints.ListSessionsEndpoint),
+		DecodeHTTPSessionsRequest,
+		EncodeHTTPAuthResponse,
+		authOptions...,
+	))
+	m.Handle("/auth/sessions/revoke", httptransport.NewServer(
+		ctx,
+		authenticated(auth, endpoints.RevokeSessionEndpoint),
+		DecodeHTTPSessionsRequest,
+		EncodeHTTPAuthResponse,
+		authOptions...,
+	))
+	m.Handle("/auth/sessions/revoke-all", httptransport.NewServer(
+		ctx,
+		authenticated(auth, endpoints.RevokeSessionsEndpoint),
+		DecodeHTTPSessionsRequest,
+		EncodeHTTPAuthResponse,
+		authOptions...,
+	))
+	m.Handle("/auth/mfa/enroll", httptransport.NewServer(
+		ctx,
+		authenticated(auth, endpoints.EnrollMFAEndpoint),
+		DecodeHTTPMFARequest,
+		EncodeHTTPAuthResponse,
+		authOptions...,
+	))
+	m.Handle("/auth/mfa/confirm", httptransport.NewServer(
+		ctx,
+		authenticated(auth, endpoints.ConfirmMFAEndpoint),
+		DecodeHTTPMFARequest,
+		EncodeHTTPAuthResponse,
+		authOptions...,
+	))
+	m.Handle("/auth/mfa/disable", httptransport.NewServer(
+		ctx,
+		authenticated(auth, endpoints.DisableMFAEndpoint),
+		DecodeHTTPMFARequest,
+		EncodeHTTPAuthResponse,
+		authOptions...,
+	))
+	m.Handle("/auth/unlock", httptransport.NewServer(
+		ctx,
+		authenticated(auth, endpoints.UnlockEndpoint),
+		DecodeHTTPUnlockRequest,
+		EncodeHTTPAuthResponse,
+		authOptions...,
+	))
 	return m
 }
 
+// exportFlushInterval is the number of users written between flushes of a
+// streaming export.
+const exportFlushInterval = 100
+
+// exportHandler streams a snapshot of the users matching the query
+// parameters prefix, domain and after as newline-delimited JSON. The response
+// is chunked, and writes block while the client isn't reading, so the export
+// is never buffered in full. Only callers that auth authenticates are served,
+// unless it is nil.
+type exportHandler struct {
+	ctx      context.Context
+	exporter Exporter
+	auth     Authenticator
+	logger   log.Logger
+}
+
+func (h exportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := h.ctx
+	for _, before := range []httptransport.RequestFunc{jwt.ToHTTPContext(), APIKeyToHTTPContext(), CertificateToHTTPContext()} {
+		ctx = before(ctx, r)
+	}
+	if h.auth != nil {
+		authCtx, err := h.auth.AuthenticateContext(ctx)
+		if err != nil {
+			errorEncoder(ctx, httptransport.Error{Domain: httptransport.DomainDo, Err: err}, w)
+			return
+		}
+		ctx = authCtx
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closed := cn.CloseNotify()
+		go func() {
+			select {
+			case <-closed:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	users, err := h.exporter.ExportUsers(ctx, DecodeHTTPExportFilter(r))
+	if err != nil {
+		errorEncoder(ctx, err, w)
+		return
+	}
+	defer users.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for n := 1; ; n++ {
+		user, err := users.Read()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			// The status has already been sent, so all that can be done is
+			// to cut the response short.
+			h.logger.Log("err", err)
+			return
+		}
+
+		if err := enc.Encode(user); err != nil {
+			h.logger.Log("err", err)
+			return
+		}
+		if flusher != nil && n%exportFlushInterval == 0 {
+			flusher.Flush()
+		}
+	}
+}
+
+// adminOnly serves requests with h only if auth authenticates their caller
+// as an admin. Without auth, every caller is let through.
+func adminOnly(ctx context.Context, auth Authenticator, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth != nil {
+			authCtx := ctx
+			for _, before := range []httptransport.RequestFunc{jwt.ToHTTPContext(), APIKeyToHTTPContext(), CertificateToHTTPContext()} {
+				authCtx = before(authCtx, r)
+			}
+			authCtx, err := auth.AuthenticateContext(authCtx)
+			if err == nil {
+				if p, ok := PrincipalFromContext(authCtx); !ok || !p.HasRole(AdminRole) {
+					err = ErrForbidden
+				}
+			}
+			if err != nil {
+				errorEncoder(ctx, httptransport.Error{Domain: httptransport.DomainDo, Err: err}, w)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// MakeClusterHTTPHandler returns a handler for the membership of a cluster.
+// GET /cluster/status describes the node, and POST /cluster/join and
+// /cluster/leave add and remove the node given in the JSON body. Membership
+// can only be changed on the leader, and only by admins that auth
+// authenticates, unless it is nil.
+func MakeClusterHTTPHandler(ctx context.Context, cluster *Cluster, auth Authenticator, logger log.Logger) http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc("/cluster/status", func(w http.ResponseWriter, r *http.Request) {
+		status, err := cluster.Status()
+		if err != nil {
+			errorEncoder(ctx, err, w)
+			return
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+	m.HandleFunc("/cluster/join", adminOnly(ctx, auth, func(w http.ResponseWriter, r *http.Request) {
+		serveClusterMember(ctx, w, r, logger, func(req ClusterMemberRequest) error {
+			return cluster.Join(req.ID, req.Addr)
+		})
+	}))
+	m.HandleFunc("/cluster/leave", adminOnly(ctx, auth, func(w http.ResponseWriter, r *http.Request) {
+		serveClusterMember(ctx, w, r, logger, func(req ClusterMemberRequest) error {
+			return cluster.Leave(req.ID)
+		})
+	}))
+	return m
+}
+
+// ClusterMemberRequest is the body of a request to add or remove a member of
+// a cluster. Addr is only needed to add one.
+type ClusterMemberRequest struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr,omitempty"`
+}
+
+func serveClusterMember(ctx context.Context, w http.ResponseWriter, r *http.Request, logger log.Logger, fn func(ClusterMemberRequest) error) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ClusterMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorEncoder(ctx, httptransport.Error{Domain: httptransport.DomainDecode, Err: err}, w)
+		return
+	}
+	if err := fn(req); err != nil {
+		logger.Log("path", r.URL.Path, "id", req.ID, "err", err)
+		errorEncoder(ctx, err, w)
+		return
+	}
+
+	logger.Log("path", r.URL.Path, "id", req.ID, "addr", req.Addr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MakeAntiEntropyHTTPHandler returns a handler that runs an anti-entropy
+// repair on POST /antientropy/repair, and responds with its RepairStats once
+// it is done. Only admins that auth authenticates may run it, unless it is
+// nil.
+func MakeAntiEntropyHTTPHandler(ctx context.Context, a *AntiEntropy, auth Authenticator, logger log.Logger) http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc("/antientropy/repair", adminOnly(ctx, auth, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		stats, err := a.Repair(ctx)
+		if err != nil {
+			logger.Log("path", r.URL.Path, "err", err)
+			errorEncoder(ctx, err, w)
+			return
+		}
+		json.NewEncoder(w).Encode(stats)
+	}))
+	return m
+}
+
+// MakeOIDCHTTPHandler returns a handler for the endpoints of an OpenID
+// Connect provider, below the path of its issuer URL:
+//
+//	GET      /.well-known/openid-configuration  describes the provider
+//	GET      /oidc/jwks                         lists its signing key
+//	GET/POST /oidc/authorize                    logs a user in for a client
+//	POST     /oidc/token                        exchanges a code for tokens
+//	GET/POST /oidc/userinfo                     describes the user of a token
+//
+// Unlike the other handlers, it speaks OAuth 2.0 and HTML rather than the
+// errors of errorEncoder, as clients of any provider expect.
+func MakeOIDCHTTPHandler(ctx context.Context, p *OIDCProvider, logger log.Logger) http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc(p.path+oidcDiscoveryPath, p.serveDiscovery)
+	m.HandleFunc(p.path+oidcJWKSPath, p.serveJWKS)
+	m.HandleFunc(p.path+oidcAuthorizePath, func(w http.ResponseWriter, r *http.Request) {
+		p.serveAuthorize(ctx, w, r, logger)
+	})
+	m.HandleFunc(p.path+oidcTokenPath, func(w http.ResponseWriter, r *http.Request) {
+		p.serveToken(ctx, w, r, logger)
+	})
+	m.HandleFunc(p.path+oidcUserInfoPath, func(w http.ResponseWriter, r *http.Request) {
+		p.serveUserInfo(ctx, w, r, logger)
+	})
+	return m
+}
+
+// MakeSCIMHTTPHandler returns a handler that provisions users over SCIM 2.0,
+// as identity providers do, below /scim/v2:
+//
+//	GET                   /Users[?filter&startIndex&count]  lists users
+//	POST                  /Users                            creates a user
+//	GET/PUT/PATCH/DELETE  /Users/{id}                       reads, replaces, patches or deletes a user
+//	GET                   /ServiceProviderConfig, /Schemas, /ResourceTypes
+//
+// Users are listed and filtered through exporter. Only admins and service
+// accounts that auth authenticates may use /Users, unless it is nil; the
+// discovery endpoints are public. There are no groups.
+//
+// userNames are kept unique, ignoring case, by checking them before every
+// write, which the handler serializes. Handlers of other instances over the
+// same store don't take part, so SCIM should be served by one instance only.
+func MakeSCIMHTTPHandler(ctx context.Context, users UserService, exporter Exporter, auth Authenticator, logger log.Logger) http.Handler {
+	s := &scimServer{users: users, exporter: exporter, auth: auth, logger: logger}
+	serveUsers := func(w http.ResponseWriter, r *http.Request) {
+		s.serveUsers(ctx, w, r)
+	}
+	m := http.NewServeMux()
+	m.HandleFunc(scimPath+"/Users", serveUsers)
+	m.HandleFunc(scimPath+"/Users/", serveUsers)
+	m.HandleFunc(scimPath+"/ServiceProviderConfig", serveSCIMServiceProviderConfig)
+	m.HandleFunc(scimPath+"/Schemas", serveSCIMSchemas)
+	m.HandleFunc(scimPath+"/Schemas/", serveSCIMSchemas)
+	m.HandleFunc(scimPath+"/ResourceTypes", serveSCIMResourceTypes)
+	m.HandleFunc(scimPath+"/ResourceTypes/", serveSCIMResourceTypes)
+	return m
+}
+
+// MakeMembershipHTTPHandler returns a handler that lists the live members of
+// a gossip cluster on GET /members. Like MakeClusterHTTPHandler, it belongs
+// on an internal listener.
+func MakeMembershipHTTPHandler(membership *Membership) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(membership.Members())
+	})
+}
+
+// MakeServiceAccountsHTTPHandler returns a handler that manages service
+// accounts and their API keys:
+//
+//	GET  /serviceaccounts              lists the accounts
+//	POST /serviceaccounts              creates an account
+//	POST /serviceaccounts/delete       deletes an account and its keys
+//	GET  /serviceaccounts/keys?account lists the keys of an account
+//	POST /serviceaccounts/keys         issues a key to an account
+//	POST /serviceaccounts/keys/rotate  replaces a key, letting it overlap
+//	POST /serviceaccounts/keys/revoke  revokes a key
+//
+// New keys are only ever shown in the response that issued them, so the
+// handler should be served over TLS. Only admins that auth authenticates may
+// use it, unless it is nil.
+func MakeServiceAccountsHTTPHandler(ctx context.Context, sa *ServiceAccounts, auth Authenticator, logger log.Logger) http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc("/serviceaccounts", adminOnly(ctx, auth, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(sa.Accounts())
+			return
+		}
+		serveServiceAccounts(ctx, w, r, logger, func(req ServiceAccountRequest) (interface{}, error) {
+			return sa.CreateAccount(req.ID, req.Roles)
+		})
+	}))
+	m.HandleFunc("/serviceaccounts/delete", adminOnly(ctx, auth, func(w http.ResponseWriter, r *http.Request) {
+		serveServiceAccounts(ctx, w, r, logger, func(req ServiceAccountRequest) (interface{}, error) {
+			return nil, sa.DeleteAccount(req.ID)
+		})
+	}))
+	m.HandleFunc("/serviceaccounts/keys", adminOnly(ctx, auth, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			keys, err := sa.Keys(r.URL.Query().Get("account"))
+			if err != nil {
+				errorEncoder(ctx, err, w)
+				return
+			}
+			json.NewEncoder(w).Encode(keys)
+			return
+		}
+		serveServiceAccounts(ctx, w, r, logger, func(req ServiceAccountRequest) (interface{}, error) {
+			key, k, err := sa.IssueKey(req.Account, time.Duration(req.TTL))
+			return IssuedAPIKey{Key: key, APIKey: k}, err
+		})
+	}))
+	m.HandleFunc("/serviceaccounts/keys/rotate", adminOnly(ctx, auth, func(w http.ResponseWriter, r *http.Request) {
+		serveServiceAccounts(ctx, w, r, logger, func(req ServiceAccountRequest) (interface{}, error) {
+			key, k, err := sa.RotateKey(req.ID, time.Duration(req.TTL), time.Duration(req.Overlap))
+			return IssuedAPIKey{Key: key, APIKey: k}, err
+		})
+	}))
+	m.HandleFunc("/serviceaccounts/keys/revoke", adminOnly(ctx, auth, func(w http.ResponseWriter, r *http.Request) {
+		serveServiceAccounts(ctx, w, r, logger, func(req ServiceAccountRequest) (interface{}, error) {
+			return nil, sa.RevokeKey(req.ID)
+		})
+	}))
+	return m
+}
+
+// ServiceAccountRequest is the body of a request to manage service accounts.
+// ID is that of the account to create or delete, or of the key to rotate or
+// revoke. Account is the account to issue a key to.
+type ServiceAccountRequest struct {
+	ID      string       `json:"id,omitempty"`
+	Roles   []string     `json:"roles,omitempty"`
+	Account string       `json:"account,omitempty"`
+	TTL     JSONDuration `json:"ttl,omitempty"`
+	Overlap JSONDuration `json:"overlap,omitempty"`
+}
+
+// IssuedAPIKey is a newly issued API key, along with its description.
+type IssuedAPIKey struct {
+	Key string `json:"key"`
+	APIKey
+}
+
+// JSONDuration is a time.Duration that is written in JSON the way
+// time.ParseDuration reads it, such as "720h".
+type JSONDuration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d JSONDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *JSONDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = JSONDuration(v)
+	return nil
+}
+
+func serveServiceAccounts(ctx context.Context, w http.ResponseWriter, r *http.Request, logger log.Logger, fn func(ServiceAccountRequest) (interface{}, error)) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ServiceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorEncoder(ctx, httptransport.Error{Domain: httptransport.DomainDecode, Err: err}, w)
+		return
+	}
+	resp, err := fn(req)
+	if err != nil {
+		logger.Log("path", r.URL.Path, "id", req.ID, "account", req.Account, "err", err)
+		errorEncoder(ctx, err, w)
+		return
+	}
+
+	// Never log the keys themselves.
+	logger.Log("path", r.URL.Path, "id", req.ID, "account", req.Account)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
 func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
 	code := http.StatusInternalServerError
 	msg := err.Error()
@@ -56,20 +540,37 @@ func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
 		case httptransport.DomainDo:
 			code = http.StatusBadRequest
 		}
+		if isAuthError(e.Err) {
+			code = http.StatusUnauthorized
+		}
+		if e.Err == ErrForbidden {
+			code = http.StatusForbidden
+		}
+	}
+	switch err {
+	case ErrServiceAccountNotFound, ErrAPIKeyNotFound:
+		code = http.StatusNotFound
+	case ErrServiceAccountExists:
+		code = http.StatusConflict
 	}
 
 	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(errorWrapper{Error: msg})
 }
 
+// errorDecoder returns the error of a non-200 response, as written by
+// errorEncoder. Service errors come back as themselves, so that callers can
+// compare them, and a body that isn't an error falls back to the status.
 func errorDecoder(r *http.Response) error {
 	var w errorWrapper
-	body, _ := ioutil.ReadAll(r.Body)
-	fmt.Println(string(body))
-	if err := json.NewDecoder(r.Body).Decode(&w); err != nil {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
 		return err
 	}
-	return errors.New(w.Error)
+	if err := json.Unmarshal(body, &w); err != nil || w.Error == "" {
+		return fmt.Errorf("%s: %s", r.Status, bytes.TrimSpace(body))
+	}
+	return errorFromString(w.Error)
 }
 
 type errorWrapper struct {
@@ -94,6 +595,114 @@ func DecodeHTTPGetUserRequest(_ context.Context, r *http.Request) (interface{},
 	return req, err
 }
 
+// DecodeHTTPBatchCreateUsersRequest is a transport/http.DecodeRequestFunc that
+// decodes a JSON-encoded batch create request from the HTTP request body.
+// Primarily useful in a server.
+func DecodeHTTPBatchCreateUsersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req BatchCreateUsersRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+// DecodeHTTPBatchGetUsersRequest is a transport/http.DecodeRequestFunc that
+// decodes a JSON-encoded batch get request from the HTTP request body.
+// Primarily useful in a server.
+func DecodeHTTPBatchGetUsersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req BatchGetUsersRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+// DecodeHTTPAuthenticateRequest is a transport/http.DecodeRequestFunc that
+// decodes a JSON-encoded authenticate request from the HTTP request body.
+// Primarily useful in a server.
+func DecodeHTTPAuthenticateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req AuthenticateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+// DecodeHTTPRefreshRequest is a transport/http.DecodeRequestFunc that decodes
+// a JSON-encoded refresh request from the HTTP request body. Primarily useful
+// in a server.
+func DecodeHTTPRefreshRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req RefreshRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+// DecodeHTTPSetPasswordRequest is a transport/http.DecodeRequestFunc that
+// decodes a JSON-encoded set password request from the HTTP request body.
+// Primarily useful in a server.
+func DecodeHTTPSetPasswordRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req SetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+// DecodeHTTPSessionsRequest is a transport/http.DecodeRequestFunc that
+// decodes a JSON-encoded sessions request from the HTTP request body.
+// Primarily useful in a server.
+func DecodeHTTPSessionsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req SessionsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+// DecodeHTTPVerifyMFARequest is a transport/http.DecodeRequestFunc that
+// decodes a JSON-encoded verify MFA request from the HTTP request body.
+// Primarily useful in a server.
+func DecodeHTTPVerifyMFARequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req VerifyMFARequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+// DecodeHTTPMFARequest is a transport/http.DecodeRequestFunc that decodes a
+// JSON-encoded MFA request from the HTTP request body. Primarily useful in a
+// server.
+func DecodeHTTPMFARequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req MFARequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+// DecodeHTTPUnlockRequest is a transport/http.DecodeRequestFunc that decodes
+// a JSON-encoded unlock request from the HTTP request body. Primarily useful
+// in a server.
+func DecodeHTTPUnlockRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req UnlockRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+// DecodeHTTPExportFilter reads an export filter from the query parameters of
+// an HTTP request. Primarily useful in a server.
+func DecodeHTTPExportFilter(r *http.Request) ExportFilter {
+	q := r.URL.Query()
+	return ExportFilter{
+		IdPrefix:    q.Get("prefix"),
+		EmailDomain: q.Get("domain"),
+		After:       q.Get("after"),
+	}
+}
+
+// EncodeHTTPExportFilter writes an export filter to the query parameters of
+// an HTTP request URL. Primarily useful in a client.
+func EncodeHTTPExportFilter(u *url.URL, filter ExportFilter) {
+	q := u.Query()
+	if filter.IdPrefix != "" {
+		q.Set("prefix", filter.IdPrefix)
+	}
+	if filter.EmailDomain != "" {
+		q.Set("domain", filter.EmailDomain)
+	}
+	if filter.After != "" {
+		q.Set("after", filter.After)
+	}
+	u.RawQuery = q.Encode()
+}
+
 // DecodeHTTPSumResponse is a transport/http.DecodeResponseFunc that decodes a
 // JSON-encoded sum response from the HTTP response body. If the response has a
 // non-200 status code, we will interpret that as an error and attempt to decode
@@ -122,6 +731,40 @@ func DecodeHTTPGetUserResponse(_ context.Context, r *http.Response) (interface{}
 	return resp, err
 }
 
+// DecodeHTTPBatchCreateUsersResponse is a transport/http.DecodeResponseFunc
+// that decodes a JSON-encoded batch create response from the HTTP response
+// body. Primarily useful in a client.
+func DecodeHTTPBatchCreateUsersResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, errorDecoder(r)
+	}
+	var resp httpBatchResponse
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return BatchCreateUsersResponse{
+		Results: resp.results(),
+		Err:     errorFromString(resp.Error),
+	}, nil
+}
+
+// DecodeHTTPBatchGetUsersResponse is a transport/http.DecodeResponseFunc that
+// decodes a JSON-encoded batch get response from the HTTP response body.
+// Primarily useful in a client.
+func DecodeHTTPBatchGetUsersResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return nil, errorDecoder(r)
+	}
+	var resp httpBatchResponse
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return BatchGetUsersResponse{
+		Results: resp.results(),
+		Err:     errorFromString(resp.Error),
+	}, nil
+}
+
 // EncodeHTTPGenericRequest is a transport/http.EncodeRequestFunc that
 // JSON-encodes any request to the request body. Primarily useful in a client.
 func EncodeHTTPGenericRequest(_ context.Context, r *http.Request, request interface{}) error {
@@ -138,3 +781,117 @@ func EncodeHTTPGenericRequest(_ context.Context, r *http.Request, request interf
 func EncodeHTTPGenericResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
 	return json.NewEncoder(w).Encode(response)
 }
+
+// EncodeHTTPBatchCreateUsersResponse is a transport/http.EncodeResponseFunc
+// that encodes a batch create response as JSON, carrying per-item errors as
+// strings. Primarily useful in a server.
+func EncodeHTTPBatchCreateUsersResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(BatchCreateUsersResponse)
+	return json.NewEncoder(w).Encode(newHTTPBatchResponse(resp.Results, resp.Err))
+}
+
+// EncodeHTTPBatchGetUsersResponse is a transport/http.EncodeResponseFunc that
+// encodes a batch get response as JSON, carrying per-item errors as strings.
+// Primarily useful in a server.
+func EncodeHTTPBatchGetUsersResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(BatchGetUsersResponse)
+	return json.NewEncoder(w).Encode(newHTTPBatchResponse(resp.Results, resp.Err))
+}
+
+// EncodeHTTPAuthResponse is a transport/http.EncodeResponseFunc that encodes
+// an auth response as JSON, with a status code telling failures apart.
+// Primarily useful in a server.
+func EncodeHTTPAuthResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(AuthResponse)
+	switch resp.Err {
+	case nil:
+	case ErrInvalidCredentials, ErrRefreshTokenInvalid, ErrRefreshTokenReused, ErrMFAInvalidCode, ErrMFAChallengeInvalid:
+		w.WriteHeader(http.StatusUnauthorized)
+	case ErrLockedOut:
+		w.WriteHeader(http.StatusTooManyRequests)
+	case ErrForbidden:
+		w.WriteHeader(http.StatusForbidden)
+	case ErrNotFound, ErrSessionNotFound:
+		w.WriteHeader(http.StatusNotFound)
+	case ErrUsernameTaken, ErrMFAAlreadyEnabled:
+		w.WriteHeader(http.StatusConflict)
+	default:
+		if _, ok := resp.Err.(*PasswordPolicyError); ok {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			break
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	return json.NewEncoder(w).Encode(httpAuthResponse{
+		Tokens:        resp.Tokens,
+		Sessions:      resp.Sessions,
+		Enrollment:    resp.Enrollment,
+		RecoveryCodes: resp.RecoveryCodes,
+		Violations:    passwordViolations(resp.Err),
+		Error:         errorToString(resp.Err),
+	})
+}
+
+// DecodeHTTPAuthResponse is a transport/http.DecodeResponseFunc that decodes
+// a JSON-encoded auth response from the HTTP response body, whatever its
+// status code. Primarily useful in a client.
+func DecodeHTTPAuthResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var resp httpAuthResponse
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		if r.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", r.Status)
+		}
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK && resp.Error == "" {
+		return nil, fmt.Errorf("unexpected status %s", r.Status)
+	}
+	return AuthResponse{
+		Tokens:        resp.Tokens,
+		Sessions:      resp.Sessions,
+		Enrollment:    resp.Enrollment,
+		RecoveryCodes: resp.RecoveryCodes,
+		Err:           withPasswordViolations(errorFromString(resp.Error), resp.Violations),
+	}, nil
+}
+
+// httpAuthResponse is the wire format of auth responses.
+type httpAuthResponse struct {
+	Tokens        *Tokens             `json:"tokens,omitempty"`
+	Sessions      []Session           `json:"sessions,omitempty"`
+	Enrollment    *MFAEnrollment      `json:"enrollment,omitempty"`
+	RecoveryCodes []string            `json:"recovery_codes,omitempty"`
+	Violations    []PasswordViolation `json:"violations,omitempty"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// httpBatchResponse is the wire format of both batch responses. Errors don't
+// survive JSON encoding, so they are sent as their messages.
+type httpBatchResponse struct {
+	Results []httpBatchResult `json:"results"`
+	Error   string            `json:"error,omitempty"`
+}
+
+type httpBatchResult struct {
+	User  *User  `json:"user,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+func newHTTPBatchResponse(results []BatchResult, err error) httpBatchResponse {
+	resp := httpBatchResponse{
+		Results: make([]httpBatchResult, len(results)),
+		Error:   errorToString(err),
+	}
+	for i, r := range results {
+		resp.Results[i] = httpBatchResult{User: r.User, Error: errorToString(r.Err)}
+	}
+	return resp
+}
+
+func (resp httpBatchResponse) results() []BatchResult {
+	results := make([]BatchResult, len(resp.Results))
+	for i, r := range resp.Results {
+		results[i] = BatchResult{User: r.User, Err: errorFromString(r.Error)}
+	}
+	return results
+}
@@ -0,0 +1,94 @@
+package learn
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/log"
+	"golang.org/x/net/context"
+)
+
+// newTestEndpoints returns the endpoints of s.
+func newTestEndpoints(s UserService) Endpoints {
+	return Endpoints{
+		CreateUserEndpoint:       MakeCreateUserEndpoint(s),
+		GetUserEndpoint:          MakeGetUserEndpoint(s),
+		BatchCreateUsersEndpoint: MakeBatchCreateUsersEndpoint(s),
+		BatchGetUsersEndpoint:    MakeBatchGetUsersEndpoint(s),
+	}
+}
+
+func TestHTTPBatchCreateUsers(t *testing.T) {
+	ctx := context.Background()
+	s := NewBasicService()
+	auth := NewVerifier(SharedSecret("secret"), "", "", 0)
+	srv := httptest.NewServer(MakeHTTPHandler(ctx, newTestEndpoints(s), s.(Exporter), auth, log.NewNopLogger()))
+	defer srv.Close()
+
+	post := func(token string, req BatchCreateUsersRequest) *http.Response {
+		var body bytes.Buffer
+		json.NewEncoder(&body).Encode(req)
+		r, _ := http.NewRequest("POST", srv.URL+"/batch/create", &body)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	issuer, _ := NewIssuer(SharedSecret("secret"), "", "", "")
+	token, _, _ := issuer.Issue("admin", time.Minute, stdjwt.MapClaims{"roles": []string{AdminRole}})
+
+	resp := post(token, BatchCreateUsersRequest{Users: []*User{{Id: "a"}, {}}, Mode: BestEffort})
+	defer resp.Body.Close()
+	out, err := DecodeHTTPBatchCreateUsersResponse(ctx, resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := out.(BatchCreateUsersResponse).Results
+	if len(results) != 2 || results[0].Err != nil || results[1].Err != ErrMissingID {
+		t.Errorf("got %+v", results)
+	}
+
+	// Errors of non-200 responses are decoded from the body, not lost.
+	resp = post("", BatchCreateUsersRequest{Users: []*User{{Id: "b"}}})
+	defer resp.Body.Close()
+	if _, err := DecodeHTTPBatchCreateUsersResponse(ctx, resp); err == nil || err.Error() != jwt.ErrTokenContextMissing.Error() {
+		t.Errorf("err = %v, want %v", err, jwt.ErrTokenContextMissing)
+	}
+}
+
+func TestErrorDecoder(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		body string
+		want string
+	}{
+		{"service error", `{"error":"Could not find user"}`, ErrNotFound.Error()},
+		{"other error", `{"error":"boom"}`, "boom"},
+		{"not JSON", "upstream timed out\n", "502 Bad Gateway: upstream timed out"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{Status: "502 Bad Gateway", StatusCode: 502, Body: ioutil.NopCloser(strings.NewReader(tc.body))}
+			err := errorDecoder(resp)
+			if err == nil || err.Error() != tc.want {
+				t.Errorf("err = %v, want %q", err, tc.want)
+			}
+		})
+	}
+
+	resp := &http.Response{StatusCode: 404, Body: ioutil.NopCloser(strings.NewReader(`{"error":"Could not find user"}`))}
+	if err := errorDecoder(resp); err != ErrNotFound {
+		t.Errorf("err = %v, want ErrNotFound itself", err)
+	}
+}